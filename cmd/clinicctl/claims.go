@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/claims"
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+)
+
+// runClaimsFormatTest writes a claim batch in a batch file format, to try out a payer's format
+// before it is set with PATCH /api/v1/payers/{id}. Without -batch a made-up batch is written,
+// so no database is needed; with -batch the tenant's batch with that ID is.
+//
+//	clinicctl claims-format-test -format acme.json
+//	clinicctl claims-format-test -format acme.json -tenant northside -batch 12 -out CB-2024-000012.txt
+func runClaimsFormatTest(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("claims-format-test")
+	tenantDSN := tenantDSNFlag(fs)
+	formatPath := fs.String("format", "", "JSON file with the batch_format; the default CSV format if empty")
+	batchID := fs.Int64("batch", 0, "ID of a claim batch to write instead of a sample")
+	slug := fs.String("tenant", "default", "slug of the tenant of the batch")
+	out := fs.String("out", "", "file to write; standard output if empty")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	format := claims.DefaultFormat
+	if *formatPath != "" {
+		data, err := os.ReadFile(*formatPath)
+		if err != nil {
+			return err
+		}
+		format = &claims.Format{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(format); err != nil {
+			return fmt.Errorf("%s: %w", *formatPath, err)
+		}
+	}
+	if errs := format.Validate(); len(errs) > 0 {
+		keys := make([]string, 0, len(errs))
+		for key := range errs {
+			keys = append(keys, key+" "+errs[key])
+		}
+		sort.Strings(keys)
+		return fmt.Errorf("invalid format: %s", strings.Join(keys, "; "))
+	}
+
+	batch := sampleBatch()
+	if *batchID > 0 {
+		db, err := openTenantDB(*dsn, *tenantDSN, *slug)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		batch, _, err = model.NewModels(db).Claims.BatchFile(*batchID)
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", *batchID, err)
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := format.Write(w, batch); err != nil {
+		return err
+	}
+
+	if *out != "" {
+		logger.PrintInfo("wrote claim batch", map[string]string{
+			"batch":  batch.Number,
+			"claims": fmt.Sprint(len(batch.Claims)),
+			"file":   *out,
+		})
+	}
+	return nil
+}
+
+// sampleBatch returns a made-up batch of two claims which exercises the values of a format.
+func sampleBatch() *claims.Batch {
+	day := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	return &claims.Batch{
+		Number:    "CB-2024-000001",
+		CreatedAt: day.AddDate(0, 0, 14),
+		PayerCode: "ACME",
+		PayerName: "Acme Health Insurance",
+		Currency:  "EUR",
+		Digits:    2,
+		Claims: []*claims.Claim{
+			{
+				Number:        "CLM-2024-000001",
+				InvoiceNumber: "INV-2024-000001",
+				ServiceDate:   day,
+				PatientID:     7,
+				FirstName:     "Anna",
+				LastName:      "Müller",
+				MemberNumber:  "M-123456",
+				GroupNumber:   "G-42",
+				Total:         12000,
+				Copay:         1000,
+				Claimed:       11000,
+				Lines: []*claims.Line{
+					{Position: 1, Code: "CONS-30", Description: "Consultation", Quantity: 1, UnitPrice: 5000, Total: 5000},
+					{Position: 2, Code: "ECG", Description: "Electrocardiogram", Quantity: 1, UnitPrice: 7000, Total: 7000},
+				},
+			},
+			{
+				Number:        "CLM-2024-000002",
+				InvoiceNumber: "INV-2024-000003",
+				ServiceDate:   day.AddDate(0, 0, 2),
+				PatientID:     12,
+				FirstName:     "John",
+				LastName:      "O'Neil, Jr.",
+				MemberNumber:  "M-654321",
+				Total:         5000,
+				Copay:         500,
+				Claimed:       4500,
+				Lines: []*claims.Line{
+					{Position: 1, Code: "CONS-30", Description: "Consultation", Quantity: 1, UnitPrice: 5000, Total: 5000},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+)
+
+// runClosuresImport reads a local iCalendar file, such as a public holiday calendar, and saves
+// its events as clinic-wide closures, or as a doctor's leave with -doctor. Recurring events are
+// expanded for the given number of years. Importing a file again updates the closures imported
+// from it before. The closures belong to the tenant given with -tenant.
+//
+//	clinicctl closures-import -file holidays.ics
+//	clinicctl closures-import -file leave.ics -doctor 7 -tenant northside
+func runClosuresImport(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("closures-import")
+	tenantDSN := tenantDSNFlag(fs)
+	file := fs.String("file", "", "path to the iCalendar file")
+	doctorID := fs.Int64("doctor", 0, "ID of the doctor whose leave the file contains (clinic-wide if 0)")
+	years := fs.Int("years", 2, "how many years ahead recurring events are expanded")
+	tenant := fs.String("tenant", model.DefaultTenant, "slug of the tenant the closures belong to")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	if *years < 1 {
+		return errors.New("-years must be at least 1")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	closures, skipped, err := model.ClosuresFromCalendar(f, time.Local, time.Now().AddDate(*years, 0, 0))
+	if err != nil {
+		return err
+	}
+	for _, reason := range skipped {
+		logger.PrintInfo("skipped event", map[string]string{"file": *file, "reason": reason})
+	}
+
+	db, err := openTenantDB(*dsn, *tenantDSN, *tenant)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	models := model.NewModels(db)
+	if *doctorID != 0 {
+		if _, err := models.Doctors.Get(int(*doctorID)); err != nil {
+			return fmt.Errorf("doctor %d: %w", *doctorID, err)
+		}
+		for _, c := range closures {
+			c.DoctorID = doctorID
+		}
+	}
+
+	inserted, updated, err := models.Closures.Import(closures)
+	if err != nil {
+		return err
+	}
+
+	affected, err := models.Closures.Affected(closures...)
+	if err != nil {
+		return err
+	}
+	for _, a := range affected {
+		logger.PrintInfo("appointment needs rescheduling", map[string]string{
+			"appointment_id": a.Id,
+			"doctor_id":      fmt.Sprint(a.DoctorID),
+			"date_time":      a.DateTime,
+		})
+	}
+
+	logger.PrintInfo("imported closures", map[string]string{
+		"file":     *file,
+		"inserted": fmt.Sprintf("%d", inserted),
+		"updated":  fmt.Sprintf("%d", updated),
+		"skipped":  fmt.Sprintf("%d", len(skipped)),
+		"affected": fmt.Sprintf("%d", len(affected)),
+	})
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/storage"
+)
+
+// runDocumentsMove copies the contents of every tenant's documents from the key they were
+// stored under when tenants shared contents to the tenant's own key. Once every tenant has
+// been copied, the shared copies are removed. It can be interrupted and run again.
+//
+//	clinicctl documents-move -storage s3 -s3-endpoint http://minio:9000 -s3-bucket documents
+func runDocumentsMove(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("documents-move")
+	tenantDSN := tenantDSNFlag(fs)
+	openStorage := storageFlags(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	tenants, err := model.NewModels(db).Tenants.GetAll()
+	db.Close()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	legacy := make(map[string]bool)
+	for _, t := range tenants {
+		moved, err := moveTenantDocuments(ctx, logger, store, *dsn, *tenantDSN, t.Slug, legacy)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", t.Slug, err)
+		}
+		logger.PrintInfo("moved documents", map[string]string{
+			"tenant": t.Slug,
+			"moved":  fmt.Sprint(moved),
+		})
+	}
+
+	// Only now no tenant needs a shared copy any more.
+	for key := range legacy {
+		if err := store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	logger.PrintInfo("removed shared contents", map[string]string{"removed": fmt.Sprint(len(legacy))})
+
+	return nil
+}
+
+// moveTenantDocuments copies the contents of a tenant's documents which are only stored under
+// their legacy key, and adds the legacy keys of all the tenant's documents to legacy.
+func moveTenantDocuments(ctx context.Context, logger *jsonlog.Logger, store storage.Storage, dsn, tenantDSN, slug string,
+	legacy map[string]bool) (int, error) {
+	db, err := openTenantDB(dsn, tenantDSN, slug)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	documents, err := model.NewModels(db).Documents.Contents()
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, d := range documents {
+		key := model.LegacyDocumentStorageKey(d.SHA256)
+		legacy[key] = true
+
+		exists, err := store.Exists(ctx, d.StorageKey())
+		if err != nil {
+			return moved, err
+		}
+		if exists {
+			continue
+		}
+
+		body, err := store.Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			logger.PrintInfo("skipped document without contents", map[string]string{
+				"tenant":      slug,
+				"document_id": fmt.Sprint(d.ID),
+			})
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		err = store.Put(ctx, d.StorageKey(), body, d.Size, d.ContentType)
+		body.Close()
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+)
+
+// runDrugsImport reads a local drug catalogue and upserts it into the drugs table. The CSV file
+// must have a header row naming its columns; name and form are required, strength,
+// active_substance and atc_code are optional.
+//
+//	clinicctl drugs-import -file drugs.csv
+func runDrugsImport(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("drugs-import")
+	file := fs.String("file", "", "path to the drug catalogue CSV file")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	drugs, err := parseDrugsCSV(f)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := model.NewModels(db).Drugs.Import(drugs)
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("imported drugs", map[string]string{
+		"file":  *file,
+		"read":  fmt.Sprintf("%d", len(drugs)),
+		"saved": fmt.Sprintf("%d", n),
+	})
+
+	return nil
+}
+
+func parseDrugsCSV(r io.Reader) ([]*model.Drug, error) {
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "form"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %q column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var drugs []*model.Drug
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		drugs = append(drugs, &model.Drug{
+			Name:            field(record, "name"),
+			Form:            field(record, "form"),
+			Strength:        field(record, "strength"),
+			ActiveSubstance: field(record, "active_substance"),
+			ATCCode:         field(record, "atc_code"),
+		})
+	}
+
+	return drugs, nil
+}
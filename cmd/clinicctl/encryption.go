@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+)
+
+// runRotateKeys encrypts the sensitive patient data of a tenant under the current key: values
+// still in plaintext, such as those written before encryption was enabled, and values encrypted
+// with older keys. It also recomputes the blind indexes. It can be interrupted and run again;
+// an older key can be removed once it has run for every tenant.
+//
+//	clinicctl rotate-keys -field-keys-file keys.json -blind-index-key "$BLIND_INDEX_KEY"
+//	clinicctl rotate-keys -tenant northside -field-keys 1:...,2:... -blind-index-key ...
+func runRotateKeys(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("rotate-keys")
+	tenantDSN := tenantDSNFlag(fs)
+	slug := fs.String("tenant", "default", "slug of the tenant whose data is re-encrypted")
+	batchSize := fs.Int("batch", 500, "rows re-encrypted per transaction")
+	loadCipher := cipherFlags(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *batchSize < 1 {
+		return errors.New("-batch must be positive")
+	}
+	cipher, err := loadCipher()
+	if err != nil {
+		return err
+	}
+	if cipher == nil {
+		return errors.New("-field-keys or -field-keys-file is required")
+	}
+
+	db, err := openTenantDB(*dsn, *tenantDSN, *slug)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	encryption := model.NewModels(db).WithCipher(cipher).Encryption
+	for _, f := range model.EncryptedFields {
+		n, err := encryption.Reencrypt(f, *batchSize)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", f.Table, f.Column, err)
+		}
+		logger.PrintInfo("re-encrypted "+f.Table+"."+f.Column, map[string]string{
+			"tenant":  *slug,
+			"updated": fmt.Sprint(n),
+		})
+	}
+	return nil
+}
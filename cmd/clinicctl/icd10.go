@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+)
+
+// runICD10Import reads a local ICD-10 file and upserts it into the icd10_codes table.
+//
+//	clinicctl icd10-import -file icd10.csv
+//	clinicctl icd10-import -file icd102019en.xml
+func runICD10Import(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("icd10-import")
+	file := fs.String("file", "", "path to a CSV (code,description) or ClaML XML file")
+	format := fs.String("format", "", "csv or xml, detected from the file extension when empty")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var codes []*model.ICD10Code
+
+	switch *format {
+	case "csv":
+		codes, err = parseICD10CSV(f)
+	case "xml":
+		codes, err = parseICD10ClaML(f)
+	default:
+		return fmt.Errorf("unsupported format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := model.NewModels(db).ICD10.Import(codes)
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("imported icd-10 codes", map[string]string{
+		"file":  *file,
+		"read":  fmt.Sprintf("%d", len(codes)),
+		"saved": fmt.Sprintf("%d", n),
+	})
+
+	return nil
+}
+
+// parseICD10CSV reads "code,description" records. A header row is skipped if present.
+func parseICD10CSV(r io.Reader) ([]*model.ICD10Code, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	var codes []*model.ICD10Code
+
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "code") {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected code and description", line)
+		}
+
+		codes = append(codes, &model.ICD10Code{Code: record[0], Description: record[1]})
+	}
+
+	return codes, nil
+}
+
+// parseICD10ClaML reads the ClaML XML distribution published by the WHO. Only classes of kind
+// "category" are imported, using their preferred rubric as the description. Chapters and blocks
+// are groupings and not valid diagnosis codes.
+func parseICD10ClaML(r io.Reader) ([]*model.ICD10Code, error) {
+	type rubric struct {
+		Kind  string `xml:"kind,attr"`
+		Label string `xml:"Label"`
+	}
+	type class struct {
+		Code    string   `xml:"code,attr"`
+		Kind    string   `xml:"kind,attr"`
+		Rubrics []rubric `xml:"Rubric"`
+	}
+
+	dec := xml.NewDecoder(r)
+	var codes []*model.ICD10Code
+
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Class" {
+			continue
+		}
+
+		var c class
+		if err := dec.DecodeElement(&c, &start); err != nil {
+			return nil, err
+		}
+		if c.Kind != "category" {
+			continue
+		}
+
+		for _, rb := range c.Rubrics {
+			if rb.Kind == "preferred" {
+				codes = append(codes, &model.ICD10Code{Code: c.Code, Description: strings.Join(strings.Fields(rb.Label), " ")})
+				break
+			}
+		}
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+)
+
+// runImport imports patients or doctors from a CSV file into a tenant, the same way as
+// POST /api/v1/patients/import and /api/v1/doctors/import. The file must have a header row
+// naming its columns. With -dry-run the rows are only validated. The row errors are written to
+// the -errors file, if any.
+//
+//	clinicctl import -kind patients -file patients.csv -dry-run -errors errors.csv
+//	clinicctl import -kind doctors -tenant northside -file doctors.csv
+func runImport(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("import")
+	tenantDSN := tenantDSNFlag(fs)
+	kind := fs.String("kind", model.ImportPatients, "what the file holds: patients or doctors")
+	file := fs.String("file", "", "path to the CSV file")
+	slug := fs.String("tenant", "default", "slug of the tenant to import into")
+	dryRun := fs.Bool("dry-run", false, "only validate the rows")
+	errorsPath := fs.String("errors", "", "file to write the error report to, if there are errors")
+	loadCipher := cipherFlags(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	if *kind != model.ImportPatients && *kind != model.ImportDoctors {
+		return fmt.Errorf("-kind must be %s or %s", model.ImportPatients, model.ImportDoctors)
+	}
+	cipher, err := loadCipher()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	db, err := openTenantDB(*dsn, *tenantDSN, *slug)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	imports := model.NewModels(db).WithCipher(cipher).Imports
+	imp := &model.Import{Kind: *kind, Filename: filepath.Base(*file), DryRun: *dryRun}
+	if err := imports.Run(imp, f); err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+
+	logger.PrintInfo("imported "+*kind, map[string]string{
+		"import":   fmt.Sprint(imp.ID),
+		"file":     *file,
+		"dry_run":  fmt.Sprint(imp.DryRun),
+		"rows":     fmt.Sprint(imp.Rows),
+		"imported": fmt.Sprint(imp.Imported),
+		"invalid":  fmt.Sprint(imp.Invalid),
+	})
+
+	if imp.Invalid > 0 && *errorsPath != "" {
+		out, err := os.Create(*errorsPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		if err := imports.WriteErrorReport(imp.ID, out); err != nil {
+			return err
+		}
+		logger.PrintInfo("wrote error report", map[string]string{"file": *errorsPath})
+	}
+	return nil
+}
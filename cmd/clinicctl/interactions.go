@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// runInteractionsImport loads drug-drug interactions into the local interaction table. The CSV
+// file has the columns substance_a, substance_b, severity and description, where severity is
+// one of minor, moderate, major or contraindicated.
+//
+//	clinicctl interactions-import -file interactions.csv
+func runInteractionsImport(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("interactions-import")
+	file := fs.String("file", "", "path to the interactions CSV file")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	interactions, err := parseInteractionsCSV(f)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := model.NewModels(db).Interactions.Import(interactions)
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("imported drug interactions", map[string]string{
+		"file":  *file,
+		"read":  fmt.Sprintf("%d", len(interactions)),
+		"saved": fmt.Sprintf("%d", n),
+	})
+
+	return nil
+}
+
+func parseInteractionsCSV(r io.Reader) ([]*model.Interaction, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 4
+
+	var interactions []*model.Interaction
+
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "substance_a") {
+			continue
+		}
+
+		severity := strings.ToLower(strings.TrimSpace(record[2]))
+		if !validator.In(severity, "minor", "moderate", "major", "contraindicated") {
+			return nil, fmt.Errorf("line %d: unknown severity %q", line, record[2])
+		}
+
+		interactions = append(interactions, &model.Interaction{
+			SubstanceA:  record[0],
+			SubstanceB:  record[1],
+			Severity:    severity,
+			Description: strings.TrimSpace(record[3]),
+		})
+	}
+
+	return interactions, nil
+}
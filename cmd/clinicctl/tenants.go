@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// runTenantCreate adds a clinic to the deployment. Its users register with the slug as
+// ?tenant= or in the X-Tenant header.
+//
+//	clinicctl tenant-create -name "Northside Clinic" -slug northside
+func runTenantCreate(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("tenant-create")
+	name := fs.String("name", "", "name of the clinic")
+	slug := fs.String("slug", "", "short name of the clinic used in links, e.g. northside")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	tenant := &model.Tenant{Name: *name, Slug: *slug}
+	v := validator.New()
+	if model.ValidateTenant(v, tenant); !v.Valid() {
+		for field, msg := range v.Errors {
+			return fmt.Errorf("-%s %s", field, msg)
+		}
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := model.NewModels(db).Tenants.Insert(tenant); err != nil {
+		if errors.Is(err, model.ErrDuplicateName) {
+			return fmt.Errorf("a tenant with the slug %q already exists", tenant.Slug)
+		}
+		return err
+	}
+
+	logger.PrintInfo("created tenant", map[string]string{
+		"id":   fmt.Sprint(tenant.ID),
+		"name": tenant.Name,
+		"slug": tenant.Slug,
+	})
+
+	return nil
+}
+
+// runTenantList prints the clinics of the deployment.
+//
+//	clinicctl tenant-list
+func runTenantList(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("tenant-list")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tenants, err := model.NewModels(db).Tenants.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tenants {
+		fmt.Fprintf(os.Stdout, "%d\t%s\t%s\n", t.ID, t.Slug, t.Name)
+	}
+
+	return nil
+}
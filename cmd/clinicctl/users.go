@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"GoClinic/pkg/web/jsonlog"
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// runUserCreate adds an activated user to a tenant, such as the first administrator of a new
+// clinic, who can then create the clinic's other users with POST /api/v1/users. The password
+// is best given in the PASSWORD environment variable, which doesn't show in the process list.
+//
+//	PASSWORD=... clinicctl user-create -tenant northside -name "Aigerim Nurlanovna" \
+//		-email admin@northside.example -permissions user.create,patient.read
+func runUserCreate(logger *jsonlog.Logger, args []string) error {
+	fs, dsn := newFlagSet("user-create")
+	tenantSlug := fs.String("tenant", model.DefaultTenant, "slug of the tenant the user belongs to")
+	name := fs.String("name", "", "name of the user")
+	email := fs.String("email", "", "email address the user logs in with")
+	password := fs.String("password", "", "password of the user")
+	permissions := fs.String("permissions", "patient.read", "permission codes of the user, comma separated")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	user := &model.User{Name: *name, Email: *email, Activated: true}
+	if err := user.Password.Set(*password); err != nil {
+		return err
+	}
+	v := validator.New()
+	if model.ValidateUser(v, user); !v.Valid() {
+		for field, msg := range v.Errors {
+			return fmt.Errorf("-%s %s", field, msg)
+		}
+	}
+
+	var codes []string
+	for _, code := range strings.Split(*permissions, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			codes = append(codes, code)
+		}
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	models := model.NewModels(db)
+	tenant, err := models.Tenants.GetBySlug(*tenantSlug)
+	if err != nil {
+		return fmt.Errorf("tenant %q: %w", *tenantSlug, err)
+	}
+	user.TenantID = tenant.ID
+
+	if err := models.Users.Insert(user); err != nil {
+		if errors.Is(err, model.ErrDuplicateEmail) {
+			return fmt.Errorf("a user with the email address %q already exists", user.Email)
+		}
+		return err
+	}
+	if err := models.Permissions.AddForUser(user.ID, codes...); err != nil {
+		return err
+	}
+
+	logger.PrintInfo("created user", map[string]string{
+		"id":          fmt.Sprint(user.ID),
+		"tenant":      tenant.Slug,
+		"email":       user.Email,
+		"permissions": strings.Join(codes, ","),
+	})
+
+	return nil
+}
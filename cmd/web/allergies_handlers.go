@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// createAllergyHandler records an allergy on the patient record.
+func (app *application) createAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Substance string `json:"substance"`
+		Reaction  string `json:"reaction"`
+		Severity  string `json:"severity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	allergy := &model.Allergy{
+		PatientID: int64(patientID),
+		Substance: input.Substance,
+		Reaction:  input.Reaction,
+		Severity:  input.Severity,
+	}
+
+	v := validator.New()
+
+	if model.ValidateAllergy(v, allergy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.tenantModels(r).Patients.Get(patientID)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.tenantModels(r).Allergies.Insert(allergy)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"allergy": allergy}, nil)
+}
+
+// listAllergiesHandler returns the allergies recorded for a patient.
+func (app *application) listAllergiesHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	allergies, err := app.tenantModels(r).Allergies.GetForPatient(int64(patientID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"allergies": allergies}, nil)
+}
+
+// deleteAllergyHandler removes an allergy recorded by mistake.
+func (app *application) deleteAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.tenantModels(r).Allergies.Delete(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "allergy successfully deleted"}, nil)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// readAnalyticsFilter reads the parameters of the analytics reports: the local days ?from= and
+// ?to=, by default of the current month, ?branch_id= and the ?interval= of the reports over
+// time, by default week.
+func (app *application) readAnalyticsFilter(r *http.Request) (model.AnalyticsFilter, *validator.Validator) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	f := model.AnalyticsFilter{
+		BranchID: app.readBranchID(qs, v),
+		Interval: app.readStrings(qs, "interval", "week"),
+	}
+	f.From, f.To = app.readReportDays(r)
+	model.ValidateAnalyticsFilter(v, f)
+	return f, v
+}
+
+// utilizationReportHandler returns how much of each doctor's available time was booked.
+func (app *application) utilizationReportHandler(w http.ResponseWriter, r *http.Request) {
+	f, v := app.readAnalyticsFilter(r)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.serveCachedReport(w, r, func() (envelope, error) {
+		report, err := app.tenantModels(r).Reports.Utilization(f, app.config.openingHours, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		return envelope{"from": f.From, "to": f.To, "utilization": report}, nil
+	})
+}
+
+// attendanceReportHandler returns the cancellation and no-show rates per doctor and in total.
+func (app *application) attendanceReportHandler(w http.ResponseWriter, r *http.Request) {
+	f, v := app.readAnalyticsFilter(r)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.serveCachedReport(w, r, func() (envelope, error) {
+		report, total, err := app.tenantModels(r).Reports.Attendance(f)
+		if err != nil {
+			return nil, err
+		}
+		return envelope{"from": f.From, "to": f.To, "doctors": report, "total": total}, nil
+	})
+}
+
+// leadTimeReportHandler returns how long before their appointments patients booked, per
+// doctor and in total.
+func (app *application) leadTimeReportHandler(w http.ResponseWriter, r *http.Request) {
+	f, v := app.readAnalyticsFilter(r)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.serveCachedReport(w, r, func() (envelope, error) {
+		report, total, err := app.tenantModels(r).Reports.LeadTime(f)
+		if err != nil {
+			return nil, err
+		}
+		return envelope{"from": f.From, "to": f.To, "doctors": report, "total": total}, nil
+	})
+}
+
+// patientsReportHandler returns the new and returning patients per interval.
+func (app *application) patientsReportHandler(w http.ResponseWriter, r *http.Request) {
+	f, v := app.readAnalyticsFilter(r)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.serveCachedReport(w, r, func() (envelope, error) {
+		report, err := app.tenantModels(r).Reports.Patients(f)
+		if err != nil {
+			return nil, err
+		}
+		return envelope{"from": f.From, "to": f.To, "interval": f.Interval, "patients": report}, nil
+	})
+}
+
+// specialitiesReportHandler returns the appointments per interval and speciality.
+func (app *application) specialitiesReportHandler(w http.ResponseWriter, r *http.Request) {
+	f, v := app.readAnalyticsFilter(r)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.serveCachedReport(w, r, func() (envelope, error) {
+		report, err := app.tenantModels(r).Reports.Specialities(f)
+		if err != nil {
+			return nil, err
+		}
+		return envelope{"from": f.From, "to": f.To, "interval": f.Interval, "specialities": report}, nil
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"github.com/gorilla/mux"
+)
+
+// appointmentActions maps the actions of confirm/cancel links to the status they set.
+var appointmentActions = map[string]string{
+	"confirm": model.AppointmentConfirmed,
+	"cancel":  model.AppointmentCancelled,
+}
+
+// appointmentActionURL returns the link which lets a patient confirm or cancel an appointment.
+func (app *application) appointmentActionURL(t *tenant, token, action string) string {
+	return app.config.baseURL + "/api/v1/appointment-actions/" + token + "/" + action + tenantQuery(t)
+}
+
+// appointmentActionPage is the page shown to patients who open a confirm/cancel link in a
+// browser. When Action is set it asks them to press a button, so that link previews and mail
+// scanners which fetch the link don't use it up.
+var appointmentActionPage = template.Must(template.New("action").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>body{font-family:sans-serif;max-width:32em;margin:3em auto;padding:0 1em}button{font-size:1.1em;padding:.5em 1.5em}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .When}}<p><strong>{{.When}}</strong></p>{{end}}
+{{if .Action}}<form method="post" action="{{.Action}}"><button type="submit">{{.Button}}</button></form>{{end}}
+</body>
+</html>
+`))
+
+type appointmentActionView struct {
+	Title   string
+	Message string
+	When    string
+	Action  string
+	Button  string
+}
+
+// wantsHTML reports whether the client is a browser rather than an API client.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// appointmentActionResponse answers with a page for browsers and JSON for everything else.
+func (app *application) appointmentActionResponse(w http.ResponseWriter, r *http.Request, status int,
+	view appointmentActionView, data envelope) {
+	if !wantsHTML(r) {
+		if _, ok := data["error"]; !ok {
+			data["message"] = view.Message
+		}
+		if err := app.writeJSON(w, status, data, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := appointmentActionPage.Execute(w, view); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// appointmentActionError explains why a link can't be used.
+func (app *application) appointmentActionError(w http.ResponseWriter, r *http.Request, err error) {
+	var status int
+	var message string
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		status, message = http.StatusNotFound, "This link is not valid. Please contact the clinic."
+	case errors.Is(err, model.ErrTokenUsed):
+		status, message = http.StatusGone, "This link has already been used. Please contact the clinic to change your appointment."
+	case errors.Is(err, model.ErrTokenExpired):
+		status, message = http.StatusGone, "This link has expired. Please contact the clinic to change your appointment."
+	case errors.Is(err, model.ErrAppointmentCancelled):
+		status, message = http.StatusConflict, "This appointment has been cancelled. Please contact the clinic to book a new one."
+	case errors.Is(err, model.ErrAppointmentPassed):
+		status, message = http.StatusGone, "This appointment has already taken place."
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.appointmentActionResponse(w, r, status, appointmentActionView{Title: "Link not available", Message: message},
+		envelope{"error": message})
+}
+
+// showAppointmentActionHandler shows what a confirm/cancel link will do without doing it.
+func (app *application) showAppointmentActionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	action := vars["action"]
+
+	token, err := app.tenantModels(r).Tokens.GetForAppointmentAction(vars["token"])
+	if err != nil {
+		app.appointmentActionError(w, r, err)
+		return
+	}
+
+	appointment, err := app.tenantModels(r).Appointments.Get(int(*token.AppointmentID))
+	if err != nil {
+		app.appointmentActionError(w, r, err)
+		return
+	}
+
+	start, _ := time.Parse(time.RFC3339, appointment.DateTime)
+	switch {
+	case token.UsedAt != nil:
+		// Opening the link again after using it just shows the outcome.
+		if appointment.Status == appointmentActions[action] {
+			app.appointmentActionDone(w, r, appointment, true)
+			return
+		}
+		err = model.ErrTokenUsed
+	case time.Now().After(token.Expiry):
+		err = model.ErrTokenExpired
+	case appointment.Status == model.AppointmentCancelled:
+		err = model.ErrAppointmentCancelled
+	case time.Now().After(start):
+		err = model.ErrAppointmentPassed
+	}
+	if err != nil {
+		app.appointmentActionError(w, r, err)
+		return
+	}
+
+	view := appointmentActionView{
+		When:   app.appointmentWhen(r, appointment),
+		Action: r.URL.RequestURI(),
+	}
+	if action == "confirm" {
+		view.Title, view.Message, view.Button = "Confirm your appointment", "Please confirm that you will attend:", "Confirm"
+	} else {
+		view.Title, view.Message, view.Button = "Cancel your appointment", "Do you want to cancel this appointment?", "Cancel appointment"
+	}
+
+	app.appointmentActionResponse(w, r, http.StatusOK, view,
+		envelope{"appointment": appointment, "action": action, "method": http.MethodPost})
+}
+
+// performAppointmentActionHandler confirms or cancels the appointment of a link. A cancelled
+// appointment no longer holds its slot, so the time is offered to the waitlist.
+func (app *application) performAppointmentActionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	status := appointmentActions[vars["action"]]
+
+	appointment, err := app.tenantModels(r).Appointments.ApplyAction(vars["token"], status)
+	if errors.Is(err, model.ErrTokenUsed) {
+		// A repeated request, e.g. a double click, is answered like the first one if it asked
+		// for the same thing.
+		token, terr := app.tenantModels(r).Tokens.GetForAppointmentAction(vars["token"])
+		if terr == nil {
+			current, aerr := app.tenantModels(r).Appointments.Get(int(*token.AppointmentID))
+			if aerr == nil && current.Status == status {
+				app.appointmentActionDone(w, r, current, true)
+				return
+			}
+		}
+	}
+	if err != nil {
+		app.appointmentActionError(w, r, err)
+		return
+	}
+
+	if appointment.Status == model.AppointmentCancelled {
+		app.slotFreed(app.contextGetTenant(r), appointment.DoctorID, appointment.DateTime)
+	}
+
+	app.appointmentActionDone(w, r, appointment, false)
+}
+
+func (app *application) appointmentActionDone(w http.ResponseWriter, r *http.Request, appointment *model.Appointment,
+	repeated bool) {
+	view := appointmentActionView{When: app.appointmentWhen(r, appointment)}
+	if appointment.Status == model.AppointmentConfirmed {
+		view.Title, view.Message = "Appointment confirmed", "Thank you, we look forward to seeing you."
+	} else {
+		view.Title, view.Message = "Appointment cancelled", "Your appointment has been cancelled."
+	}
+
+	app.appointmentActionResponse(w, r, http.StatusOK, view, envelope{"appointment": appointment, "repeated": repeated})
+}
+
+// appointmentWhen describes an appointment's time and doctor for patients.
+func (app *application) appointmentWhen(r *http.Request, appointment *model.Appointment) string {
+	when := appointment.DateTime
+	if start, err := time.Parse(time.RFC3339, appointment.DateTime); err == nil {
+		when = start.In(time.Local).Format("Monday, 2 January 2006 at 15:04")
+	}
+	if doctor, err := app.tenantModels(r).Doctors.Get(appointment.DoctorID); err == nil {
+		when += " with Dr. " + doctor.FirstName + " " + doctor.LastName
+	}
+	return when
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
@@ -15,6 +17,10 @@ func (app *application) createAppointment(w http.ResponseWriter, r *http.Request
 		DateTime  string `json:"date_time"`
 		DoctorID  int    `json:"doctor_id"`
 		PatientID int    `json:"patient_id"`
+		BranchID  *int64 `json:"branch_id"`
+		RoomID    *int64 `json:"room_id"`
+		// ServiceIDs are the services booked, which determine the duration and the cost.
+		ServiceIDs []int64 `json:"service_ids"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -24,15 +30,35 @@ func (app *application) createAppointment(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !model.ValidAppointmentTime(input.DateTime) {
+		app.errorResponse(w, r, http.StatusBadRequest, "date_time must be a date and time such as 2024-06-01T10:00:00+05:00")
+		return
+	}
+
+	v := validator.New()
+	if validateServiceIDs(v, input.ServiceIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	appointment := &model.Appointment{
 		DateTime:  input.DateTime,
 		DoctorID:  input.DoctorID,
 		PatientID: input.PatientID,
+		BranchID:  input.BranchID,
+		RoomID:    input.RoomID,
 	}
 
-	err = app.models.Appointments.Insert(appointment)
+	err = app.tenantModels(r).Appointments.Insert(appointment, input.ServiceIDs...)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, model.ErrSlotTaken), errors.Is(err, model.ErrDoctorUnavailable), errors.Is(err, model.ErrRoomTaken):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, model.ErrBranchMismatch), isServicePricingError(err):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -50,7 +76,7 @@ func (app *application) getAppointment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appointment, err := app.models.Appointments.Get(id)
+	appointment, err := app.tenantModels(r).Appointments.Get(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -68,14 +94,21 @@ func (app *application) getSortedAppointments(w http.ResponseWriter, r *http.Req
 		sortDirection = "ASC"
 	}
 
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	filters := model.Filters{
 		Sort:          sortParam,
 		SortDirection: sortDirection,
 		SortSafelist:  []string{"date_time", "doctor_id", "id", "-date_time", "-doctor_id", "-id"}, // Add any safe sorting criteria
+		BranchID:      branchID,
 	}
 
 	// Call GetAllSortedByName method from the AppointmentModel instance
-	appointments, err := app.models.Appointments.GetAllSortedByName(filters)
+	appointments, err := app.tenantModels(r).Appointments.GetAllSortedByName(filters)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
@@ -99,7 +132,13 @@ func (app *application) getFilteredAppointments(w http.ResponseWriter, r *http.R
 
 	filterParam := r.URL.Query().Get("filter")
 
-	filtered_appointments, err := app.models.Appointments.GetFilteredByText(filterParam)
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filtered_appointments, err := app.tenantModels(r).Appointments.GetFilteredByText(filterParam, branchID)
 
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
@@ -124,7 +163,13 @@ func (app *application) getPaginatedAppointments(w http.ResponseWriter, r *http.
 		return
 	}
 
-	appointments, err := app.models.Appointments.GetPaginatedAppointments(limit, offset)
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	appointments, err := app.tenantModels(r).Appointments.GetPaginatedAppointments(limit, offset, branchID)
 
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
@@ -144,7 +189,13 @@ func (app *application) getAppointmentsOfDoctor(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	appointments, err := app.models.Appointments.Get_By_Doctor(id)
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	appointments, err := app.tenantModels(r).Appointments.Get_By_Doctor(id, branchID)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -163,7 +214,13 @@ func (app *application) getAppointmentsOfPatient(w http.ResponseWriter, r *http.
 		return
 	}
 
-	appointments, err := app.models.Appointments.Get_By_Patient(id)
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	appointments, err := app.tenantModels(r).Appointments.Get_By_Patient(id, branchID)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -182,7 +239,7 @@ func (app *application) updateAppointment(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	appointment, err := app.models.Appointments.Get(id)
+	appointment, err := app.tenantModels(r).Appointments.Get(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -192,6 +249,8 @@ func (app *application) updateAppointment(w http.ResponseWriter, r *http.Request
 		DateTime  *string `json:"date_time"`
 		DoctorID  *int    `json:"doctor_id"`
 		PatientID *int    `json:"patient_id"`
+		BranchID  *int64  `json:"branch_id"`
+		RoomID    *int64  `json:"room_id"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -201,6 +260,10 @@ func (app *application) updateAppointment(w http.ResponseWriter, r *http.Request
 	}
 
 	if input.DateTime != nil {
+		if !model.ValidAppointmentTime(*input.DateTime) {
+			app.respondWithError(w, http.StatusBadRequest, "date_time must be a date and time such as 2024-06-01T10:00:00+05:00")
+			return
+		}
 		appointment.DateTime = *input.DateTime
 	}
 
@@ -212,8 +275,28 @@ func (app *application) updateAppointment(w http.ResponseWriter, r *http.Request
 		appointment.PatientID = *input.PatientID
 	}
 
-	err = app.models.Appointments.Update(appointment)
+	// A new branch without a room leaves the room of the old branch.
+	if input.BranchID != nil {
+		if appointment.BranchID == nil || *appointment.BranchID != *input.BranchID {
+			appointment.RoomID = nil
+		}
+		appointment.BranchID = input.BranchID
+	}
+
+	if input.RoomID != nil {
+		appointment.RoomID = input.RoomID
+	}
+
+	err = app.tenantModels(r).Appointments.Update(appointment)
 	if err != nil {
+		if errors.Is(err, model.ErrSlotTaken) || errors.Is(err, model.ErrDoctorUnavailable) || errors.Is(err, model.ErrRoomTaken) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, model.ErrBranchMismatch) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error3")
 		return
 	}
@@ -231,11 +314,21 @@ func (app *application) deleteAppointment(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Appointments.Delete(id)
+	appointment, err := app.tenantModels(r).Appointments.Get(id)
+	if err != nil {
+		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
+		return
+	}
+
+	err = app.tenantModels(r).Appointments.Delete(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error4")
 		return
 	}
 
+	if appointment.Status != model.AppointmentCancelled {
+		app.slotFreed(app.contextGetTenant(r), appointment.DoctorID, appointment.DateTime)
+	}
+
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
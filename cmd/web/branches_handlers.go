@@ -0,0 +1,469 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// readBranchID reads the branch_id query parameter by which lists are filtered. It is zero if
+// the parameter is missing.
+func (app *application) readBranchID(qs url.Values, v *validator.Validator) int64 {
+	branchID := app.readInt(qs, "branch_id", 0, v)
+	v.Check(branchID >= 0, "branch_id", "must be a positive integer")
+	return int64(branchID)
+}
+
+// parseBranchID parses the branch_id query parameter of the older list endpoints, which
+// answer invalid parameters without a validator.
+func parseBranchID(qs url.Values) (int, error) {
+	s := qs.Get("branch_id")
+	if s == "" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		return 0, errors.New("branch_id must be a positive integer")
+	}
+	return id, nil
+}
+
+// branchLocation returns the time zone of a branch, or the server's time zone if branchID is
+// zero.
+func (app *application) branchLocation(r *http.Request, branchID int64) (*time.Location, error) {
+	if branchID == 0 {
+		return time.Local, nil
+	}
+	branch, err := app.tenantModels(r).Branches.Get(branchID)
+	if err != nil {
+		return nil, err
+	}
+	return branch.Location()
+}
+
+// doctorBranch returns the only branch a doctor works at, or zero if the doctor works at none
+// or several.
+func (app *application) doctorBranch(r *http.Request, doctorID int64) (int64, error) {
+	branches, err := app.tenantModels(r).Branches.GetAll(doctorID)
+	if err != nil {
+		return 0, err
+	}
+	if len(branches) != 1 {
+		return 0, nil
+	}
+	return branches[0].ID, nil
+}
+
+func (app *application) createBranchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string `json:"name"`
+		Address      string `json:"address"`
+		TimeZone     string `json:"time_zone"`
+		OpeningHours string `json:"opening_hours"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	branch := &model.Branch{
+		Name:         input.Name,
+		Address:      input.Address,
+		TimeZone:     input.TimeZone,
+		OpeningHours: input.OpeningHours,
+	}
+
+	v := validator.New()
+	model.ValidateBranch(v, branch)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Branches.Insert(branch); err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateName):
+			app.failedValidationResponse(w, r, map[string]string{"name": "a branch with this name already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/branches/%d", branch.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"branch": branch}, headers)
+}
+
+// listBranchesHandler returns all branches, or with ?doctor_id= the branches a doctor works
+// at.
+func (app *application) listBranchesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	doctorID := app.readInt(r.URL.Query(), "doctor_id", 0, v)
+	v.Check(doctorID >= 0, "doctor_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	branches, err := app.tenantModels(r).Branches.GetAll(int64(doctorID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"branches": branches}, nil)
+}
+
+// showBranchHandler returns a branch with its rooms.
+func (app *application) showBranchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	branch, err := app.tenantModels(r).Branches.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"branch": branch}, nil)
+}
+
+func (app *application) updateBranchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	branch, err := app.tenantModels(r).Branches.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name         *string `json:"name"`
+		Address      *string `json:"address"`
+		TimeZone     *string `json:"time_zone"`
+		OpeningHours *string `json:"opening_hours"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		branch.Name = *input.Name
+	}
+	if input.Address != nil {
+		branch.Address = *input.Address
+	}
+	if input.TimeZone != nil {
+		branch.TimeZone = *input.TimeZone
+	}
+	if input.OpeningHours != nil {
+		branch.OpeningHours = *input.OpeningHours
+	}
+
+	v := validator.New()
+	model.ValidateBranch(v, branch)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Branches.Update(branch); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateName):
+			app.failedValidationResponse(w, r, map[string]string{"name": "a branch with this name already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"branch": branch}, nil)
+}
+
+func (app *application) deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.tenantModels(r).Branches.Delete(int64(id)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrBranchInUse):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "branch successfully deleted"}, nil)
+}
+
+func (app *application) createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	branchID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+		Kind string `json:"kind"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	room := &model.Room{BranchID: int64(branchID), Name: input.Name, Kind: input.Kind}
+	if room.Kind == "" {
+		room.Kind = "room"
+	}
+
+	v := validator.New()
+	model.ValidateRoom(v, room)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Branches.InsertRoom(room); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateName):
+			app.failedValidationResponse(w, r, map[string]string{"name": "the branch already has a room with this name"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/rooms/%d", room.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"room": room}, headers)
+}
+
+func (app *application) listRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	branchID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := app.tenantModels(r).Branches.Get(int64(branchID)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	rooms, err := app.tenantModels(r).Branches.Rooms(int64(branchID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"rooms": rooms}, nil)
+}
+
+func (app *application) updateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	room, err := app.tenantModels(r).Branches.GetRoom(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+		Kind *string `json:"kind"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		room.Name = *input.Name
+	}
+	if input.Kind != nil {
+		room.Kind = *input.Kind
+	}
+
+	v := validator.New()
+	model.ValidateRoom(v, room)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Branches.UpdateRoom(room); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateName):
+			app.failedValidationResponse(w, r, map[string]string{"name": "the branch already has a room with this name"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"room": room}, nil)
+}
+
+func (app *application) deleteRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.tenantModels(r).Branches.DeleteRoom(int64(id)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "room successfully deleted"}, nil)
+}
+
+// listDoctorBranchesHandler returns the branches a doctor works at.
+func (app *application) listDoctorBranchesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := app.tenantModels(r).Doctors.Get(id); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	branches, err := app.tenantModels(r).Branches.GetAll(int64(id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"branches": branches}, nil)
+}
+
+// setDoctorBranchesHandler replaces the branches a doctor works at.
+func (app *application) setDoctorBranchesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		BranchIDs []int64 `json:"branch_ids"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.BranchIDs != nil, "branch_ids", "must be provided")
+	seen := make(map[int64]bool)
+	for _, branchID := range input.BranchIDs {
+		v.Check(branchID > 0, "branch_ids", "must be positive integers")
+		v.Check(!seen[branchID], "branch_ids", "must not contain duplicate values")
+		seen[branchID] = true
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if _, err := app.tenantModels(r).Doctors.Get(id); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.tenantModels(r).Branches.SetDoctorBranches(int64(id), input.BranchIDs); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"branch_ids": "branch does not exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	branches, err := app.tenantModels(r).Branches.GetAll(int64(id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"branches": branches}, nil)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/ical"
+	"GoClinic/pkg/web/model"
+	"github.com/gorilla/mux"
+)
+
+// calendarFeedHistory is how far back appointments are kept in calendar feeds. Older ones are
+// left out so that feeds of long-standing doctors stay small.
+const calendarFeedHistory = 90 * 24 * time.Hour
+
+// calendarFeedURL returns the public URL of a feed with the given token.
+func (app *application) calendarFeedURL(t *tenant, token string) string {
+	return app.config.baseURL + "/calendar/" + token + ".ics" + tenantQuery(t)
+}
+
+// issueDoctorCalendarFeedHandler issues a secret iCalendar feed URL for a doctor. Issuing a
+// new URL revokes the previous one.
+func (app *application) issueDoctorCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := app.tenantModels(r).Doctors.Get(id); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	doctorID := int64(id)
+	app.issueCalendarFeed(w, r, &model.CalendarFeed{DoctorID: &doctorID})
+}
+
+// issuePatientCalendarFeedHandler issues a secret iCalendar feed URL for a patient. Issuing a
+// new URL revokes the previous one.
+func (app *application) issuePatientCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := app.tenantModels(r).Patients.Get(id); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	patientID := int64(id)
+	app.issueCalendarFeed(w, r, &model.CalendarFeed{PatientID: &patientID})
+}
+
+func (app *application) issueCalendarFeed(w http.ResponseWriter, r *http.Request, feed *model.CalendarFeed) {
+	feed.CreatedBy = &app.contextGetUser(r).ID
+
+	if err := app.tenantModels(r).CalendarFeeds.Issue(feed); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusCreated, envelope{"feed": feed, "url": app.calendarFeedURL(app.contextGetTenant(r), feed.Token)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeDoctorCalendarFeedHandler revokes a doctor's feed URL.
+func (app *application) revokeDoctorCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.revokeCalendarFeed(w, r, app.tenantModels(r).CalendarFeeds.RevokeForDoctor(int64(id)))
+}
+
+// revokePatientCalendarFeedHandler revokes a patient's feed URL.
+func (app *application) revokePatientCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.revokeCalendarFeed(w, r, app.tenantModels(r).CalendarFeeds.RevokeForPatient(int64(id)))
+}
+
+func (app *application) revokeCalendarFeed(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "calendar feed successfully revoked"}, nil)
+}
+
+// calendarFeedHandler serves the iCalendar feed a token was issued for. It is public: the
+// token in the URL is the credential, since calendar clients can't send our auth headers.
+// Responses carry an ETag so that clients polling the feed get a 304 when nothing changed.
+func (app *application) calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	feed, err := app.tenantModels(r).CalendarFeeds.GetForToken(mux.Vars(r)["token"])
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var cal *ical.Calendar
+	if feed.DoctorID != nil {
+		cal, err = app.doctorCalendar(r, int(*feed.DoctorID))
+	} else {
+		cal, err = app.patientCalendar(r, int(*feed.PatientID))
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := cal.Encode(&body); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The calendar is built only from stored data, so the same appointments always give the
+	// same bytes and the hash of the body is a strong validator.
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(body.Bytes())
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches etag. Weak comparison is used,
+// as RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *application) doctorCalendar(r *http.Request, doctorID int) (*ical.Calendar, error) {
+	doctor, err := app.tenantModels(r).Doctors.Get(doctorID)
+	if err != nil {
+		return nil, err
+	}
+
+	appointments, err := app.tenantModels(r).Appointments.Get_By_Doctor(doctorID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cal := app.newCalendar(fmt.Sprintf("Dr. %s %s", doctor.FirstName, doctor.LastName))
+	patients := map[int]*model.Patient{}
+
+	for _, a := range appointments {
+		event, ok := app.appointmentEvent(a)
+		if !ok {
+			continue
+		}
+
+		patient, ok := patients[a.PatientID]
+		if !ok {
+			patient, err = app.tenantModels(r).Patients.Get(a.PatientID)
+			if err != nil {
+				return nil, err
+			}
+			patients[a.PatientID] = patient
+		}
+		event.Summary = fmt.Sprintf("%s %s", patient.FirstName, patient.LastName)
+		if patient.Phone != "" {
+			event.Description = "Phone: " + patient.Phone
+		}
+		cal.Events = append(cal.Events, event)
+	}
+
+	return cal, nil
+}
+
+func (app *application) patientCalendar(r *http.Request, patientID int) (*ical.Calendar, error) {
+	patient, err := app.tenantModels(r).Patients.Get(patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	appointments, err := app.tenantModels(r).Appointments.Get_By_Patient(patientID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cal := app.newCalendar(fmt.Sprintf("Appointments of %s %s", patient.FirstName, patient.LastName))
+	doctors := map[int]*model.Doctor{}
+
+	for _, a := range appointments {
+		event, ok := app.appointmentEvent(a)
+		if !ok {
+			continue
+		}
+
+		doctor, ok := doctors[a.DoctorID]
+		if !ok {
+			doctor, err = app.tenantModels(r).Doctors.Get(a.DoctorID)
+			if err != nil {
+				return nil, err
+			}
+			doctors[a.DoctorID] = doctor
+		}
+		event.Summary = fmt.Sprintf("Appointment with Dr. %s %s", doctor.FirstName, doctor.LastName)
+		event.Description = doctor.Speciality
+		cal.Events = append(cal.Events, event)
+	}
+
+	return cal, nil
+}
+
+func (app *application) newCalendar(name string) *ical.Calendar {
+	return &ical.Calendar{ProdID: "-//GoClinic//Appointments//EN", Name: name}
+}
+
+// appointmentEvent converts an appointment to an event without summary. It returns false for
+// appointments which are too old to be published.
+func (app *application) appointmentEvent(a *model.Appointment) (ical.Event, bool) {
+	start, err := time.Parse(time.RFC3339, a.DateTime)
+	if err != nil || time.Since(start) > calendarFeedHistory {
+		return ical.Event{}, false
+	}
+
+	// Clients use DTSTAMP and LAST-MODIFIED to tell that an event changed, and they must not
+	// change on every request, so both are the appointment's last update.
+	updated, err := time.Parse(time.RFC3339, a.UpdatedAt)
+	if err != nil {
+		updated = start
+	}
+
+	host := "goclinic"
+	if u, err := url.Parse(app.config.baseURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	event := ical.Event{
+		UID:          "appointment-" + a.Id + "@" + host,
+		Start:        start,
+		End:          start.Add(a.Duration()),
+		Status:       ical.StatusConfirmed,
+		Stamp:        updated,
+		LastModified: updated,
+	}
+	if a.Status == model.AppointmentCancelled {
+		event.Status = ical.StatusCancelled
+	}
+
+	return event, true
+}
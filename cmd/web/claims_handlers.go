@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// createClaimBatchHandler claims from a payer every issued invoice which isn't paid yet and
+// whose patient was covered by the payer, issued before now or up to the date or time ?until=.
+// The claims are submitted with the batch.
+func (app *application) createClaimBatchHandler(w http.ResponseWriter, r *http.Request) {
+	payerID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	until := time.Now()
+	if s := r.URL.Query().Get("until"); s != "" {
+		v := validator.New()
+		t, err := parseClosureTime(s, true)
+		if err != nil {
+			v.AddError("until", err.Error())
+		} else if t.Before(until) {
+			until = t
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	user := app.contextGetUser(r)
+	batch, err := app.tenantModels(r).Claims.GenerateBatch(int64(payerID), until, &user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrNothingToClaim), errors.Is(err, model.ErrPayerInactive):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/claim-batches/%d", batch.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"batch": batch}, headers)
+}
+
+// listClaimBatchesHandler returns the batches, newest first, optionally of ?payer_id=.
+func (app *application) listClaimBatchesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	payerID := app.readInt(r.URL.Query(), "payer_id", 0, v)
+	v.Check(payerID >= 0, "payer_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	batches, err := app.tenantModels(r).Claims.GetAllBatches(int64(payerID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"batches": batches}, nil)
+}
+
+// showClaimBatchHandler returns a batch with its claims.
+func (app *application) showClaimBatchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	batch, err := app.tenantModels(r).Claims.GetBatch(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"batch": batch}, nil)
+}
+
+// claimBatchFileHandler returns a batch as a file in the payer's format, to be sent to it.
+func (app *application) claimBatchFileHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	batch, format, err := app.tenantModels(r).Claims.BatchFile(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if format.FileExtension() == "csv" {
+		contentType = "text/csv; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename="+batch.Number+"."+format.FileExtension())
+
+	if err := format.Write(w, batch); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// listClaimsHandler returns claims, newest first. They can be filtered by ?payer_id=,
+// ?batch_id=, ?invoice_id= and ?status=.
+func (app *application) listClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := model.ClaimFilter{
+		PayerID:   int64(app.readInt(qs, "payer_id", 0, v)),
+		BatchID:   int64(app.readInt(qs, "batch_id", 0, v)),
+		InvoiceID: int64(app.readInt(qs, "invoice_id", 0, v)),
+		Status:    app.readStrings(qs, "status", ""),
+		Limit:     app.readInt(qs, "limit", 50, v),
+		Offset:    app.readInt(qs, "offset", 0, v),
+	}
+	v.Check(filter.PayerID >= 0, "payer_id", "must be a positive integer")
+	v.Check(filter.BatchID >= 0, "batch_id", "must be a positive integer")
+	v.Check(filter.InvoiceID >= 0, "invoice_id", "must be a positive integer")
+	v.Check(validator.In(filter.Status, "", model.ClaimSubmitted, model.ClaimAccepted, model.ClaimRejected,
+		model.ClaimPaid), "status", "must be submitted, accepted, rejected or paid")
+	v.Check(filter.Limit > 0 && filter.Limit <= 500, "limit", "must be between 1 and 500")
+	v.Check(filter.Offset >= 0, "offset", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := app.tenantModels(r).Claims.GetAll(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"claims": claims}, nil)
+}
+
+func (app *application) showClaimHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	claim, err := app.tenantModels(r).Claims.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"claim": claim}, nil)
+}
+
+// updateClaimHandler records the payer's acknowledgement of a claim: {"status": "accepted"},
+// or {"status": "rejected", "reason": ...}.
+func (app *application) updateClaimHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(input.Status, model.ClaimAccepted, model.ClaimRejected), "status", "must be accepted or rejected")
+	v.Check(input.Status != model.ClaimRejected || input.Reason != "", "reason", "must be provided")
+	v.Check(input.Status != model.ClaimAccepted || input.Reason == "", "reason", "must only be given for rejected claims")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claim, err := app.tenantModels(r).Claims.UpdateStatus(int64(id), input.Status, input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrClaimStatus):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"claim": claim}, nil)
+}
+
+// createRemittanceHandler records a payer's remittance and reconciles it with the claims. The
+// items which couldn't be applied are returned with an error.
+func (app *application) createRemittanceHandler(w http.ResponseWriter, r *http.Request) {
+	payerID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reference  string     `json:"reference"`
+		ReceivedAt *time.Time `json:"received_at"`
+		Items      []struct {
+			ClaimNumber string `json:"claim_number"`
+			Paid        int64  `json:"paid"`
+			Reason      string `json:"reason"`
+		} `json:"items"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	remittance := &model.Remittance{
+		PayerID:    int64(payerID),
+		Reference:  input.Reference,
+		ReceivedAt: time.Now(),
+		CreatedBy:  &user.ID,
+	}
+	if input.ReceivedAt != nil {
+		remittance.ReceivedAt = *input.ReceivedAt
+	}
+	for _, item := range input.Items {
+		remittance.Items = append(remittance.Items, &model.RemittanceItem{
+			ClaimNumber: item.ClaimNumber,
+			Paid:        item.Paid,
+			Reason:      item.Reason,
+		})
+	}
+
+	v := validator.New()
+	if model.ValidateRemittance(v, remittance); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Claims.InsertRemittance(remittance); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateRemittance):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/remittances/%d", remittance.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"remittance": remittance}, headers)
+}
+
+// showRemittanceHandler returns a remittance with how each of its items was applied.
+func (app *application) showRemittanceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	remittance, err := app.tenantModels(r).Claims.GetRemittance(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"remittance": remittance}, nil)
+}
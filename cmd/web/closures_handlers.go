@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// Limits of closure imports and slot searches.
+const (
+	closureImportMaxSize = 5 << 20
+	closureImportYears   = 2
+	maxSlotSearchDays    = 31
+)
+
+// parseClosureTime parses the start or end of a closure. A date without a time means the
+// start of that day in the server's time zone or, for the end, the end of that day, so that
+// leave from 2024-07-01 to 2024-07-14 includes the 14th.
+func parseClosureTime(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("must be a date such as 2024-07-01 or a time such as 2024-07-01T12:00:00+02:00")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// createClosureHandler adds a clinic-wide closure, or a doctor's leave if doctor_id is given.
+// The response lists the appointments in the period which need to be rescheduled.
+func (app *application) createClosureHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		DoctorID *int64 `json:"doctor_id"`
+		StartsAt string `json:"starts_at"`
+		EndsAt   string `json:"ends_at"`
+		Reason   string `json:"reason"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	userID := app.contextGetUser(r).ID
+	closure := &model.Closure{DoctorID: input.DoctorID, Reason: input.Reason, CreatedBy: &userID}
+
+	var err error
+	if closure.StartsAt, err = parseClosureTime(input.StartsAt, false); err != nil {
+		v.AddError("starts_at", err.Error())
+	}
+	if closure.EndsAt, err = parseClosureTime(input.EndsAt, true); err != nil {
+		v.AddError("ends_at", err.Error())
+	}
+	if v.Valid() {
+		model.ValidateClosure(v, closure)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Closures.Insert(closure); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"doctor_id": "doctor does not exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	affected, err := app.tenantModels(r).Closures.Affected(closure)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/closures/%d", closure.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"closure": closure, "affected_appointments": affected}, headers)
+}
+
+// readPeriod reads the from and to query parameters, as dates or times. Missing values are
+// zero.
+func (app *application) readPeriod(r *http.Request, v *validator.Validator) (from, to time.Time) {
+	qs := r.URL.Query()
+	var err error
+	if s := qs.Get("from"); s != "" {
+		if from, err = parseClosureTime(s, false); err != nil {
+			v.AddError("from", err.Error())
+		}
+	}
+	if s := qs.Get("to"); s != "" {
+		if to, err = parseClosureTime(s, true); err != nil {
+			v.AddError("to", err.Error())
+		}
+	}
+	if !from.IsZero() && !to.IsZero() {
+		v.Check(to.After(from), "to", "must be after from")
+	}
+	return from, to
+}
+
+// listClosuresHandler returns the closures in a period, optionally only those which apply to a
+// doctor: their leave and the clinic-wide closures. ?branch_id= limits them likewise to the
+// leave of the branch's doctors and the clinic-wide closures.
+func (app *application) listClosuresHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	doctorID := app.readInt(r.URL.Query(), "doctor_id", 0, v)
+	branchID := app.readBranchID(r.URL.Query(), v)
+	from, to := app.readPeriod(r, v)
+	v.Check(doctorID >= 0, "doctor_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	closures, err := app.tenantModels(r).Closures.GetAll(int64(doctorID), branchID, from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"closures": closures}, nil)
+}
+
+// showClosureHandler returns a closure with the appointments in it which still need to be
+// rescheduled.
+func (app *application) showClosureHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	closure, err := app.tenantModels(r).Closures.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	affected, err := app.tenantModels(r).Closures.Affected(closure)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"closure": closure, "affected_appointments": affected}, nil)
+}
+
+func (app *application) deleteClosureHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.tenantModels(r).Closures.Delete(int64(id)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "closure successfully deleted"}, nil)
+}
+
+// importClosuresHandler imports the events of an iCalendar file sent as the request body, such
+// as a public holiday calendar, as clinic-wide closures or, with ?doctor_id=, as a doctor's
+// leave. Importing a file again updates the closures imported from it before.
+func (app *application) importClosuresHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	doctorID := app.readInt(r.URL.Query(), "doctor_id", 0, v)
+	v.Check(doctorID >= 0, "doctor_id", "must be a positive integer")
+	if v.Valid() && doctorID > 0 {
+		if _, err := app.tenantModels(r).Doctors.Get(doctorID); err != nil {
+			v.AddError("doctor_id", "doctor does not exist")
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, closureImportMaxSize)
+	closures, skipped, err := model.ClosuresFromCalendar(body, time.Local, time.Now().AddDate(closureImportYears, 0, 0))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	for _, c := range closures {
+		if doctorID > 0 {
+			id := int64(doctorID)
+			c.DoctorID = &id
+		}
+		c.CreatedBy = &userID
+	}
+
+	inserted, updated, err := app.tenantModels(r).Closures.Import(closures)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	affected, err := app.tenantModels(r).Closures.Affected(closures...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{
+		"inserted":              inserted,
+		"updated":               updated,
+		"skipped":               skipped,
+		"affected_appointments": affected,
+	}, nil)
+}
+
+// listDoctorSlotsHandler returns the free appointment slots of a doctor within the opening
+// hours, by default for the next seven days. The opening hours are those of ?branch_id=, or of
+// the doctor's branch if the doctor works at only one, and the clinic's otherwise.
+func (app *application) listDoctorSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := app.tenantModels(r).Doctors.Get(id); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	branchID := app.readBranchID(r.URL.Query(), v)
+	from, to := app.readPeriod(r, v)
+	if from.IsZero() {
+		from = time.Now()
+	}
+	if to.IsZero() {
+		to = from.AddDate(0, 0, 7)
+	}
+	v.Check(to.Sub(from) <= maxSlotSearchDays*24*time.Hour, "to", fmt.Sprintf("must not be more than %d days after from",
+		maxSlotSearchDays))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if branchID == 0 {
+		if branchID, err = app.doctorBranch(r, int64(id)); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	hours, loc := app.config.openingHours, time.Local
+	if branchID != 0 {
+		branch, err := app.tenantModels(r).Branches.Get(branchID)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				app.failedValidationResponse(w, r, map[string]string{"branch_id": "branch does not exist"})
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if hours, err = branch.Hours(); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if loc, err = branch.Location(); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	slots, err := app.tenantModels(r).Appointments.FreeSlots(id, from, to, hours, loc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"slots": slots}, nil)
+}
@@ -31,3 +31,31 @@ func (app *application) contextGetUser(r *http.Request) *model.User {
 
 	return user
 }
+
+// tenantContextKey is used as a key for getting and setting the tenant of a request in the
+// request context.
+const tenantContextKey = contextKey("tenant")
+
+// contextSetTenant returns a new copy of the request with the tenant it works on added to the
+// context.
+func (app *application) contextSetTenant(r *http.Request, t *tenant) *http.Request {
+	ctx := context.WithValue(r.Context(), tenantContextKey, t)
+	return r.WithContext(ctx)
+}
+
+// contextGetTenant retrieves the tenant of the request, or nil if it has none.
+func (app *application) contextGetTenant(r *http.Request) *tenant {
+	t, _ := r.Context().Value(tenantContextKey).(*tenant)
+	return t
+}
+
+// tenantModels returns the models which work on the data of the request's tenant. Like
+// contextGetUser it panics if there is none, since the routes which use it require a user or
+// a tenant.
+func (app *application) tenantModels(r *http.Request) model.Models {
+	t := app.contextGetTenant(r)
+	if t == nil {
+		panic("missing tenant value in request context")
+	}
+	return t.models
+}
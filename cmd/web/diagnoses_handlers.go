@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+	"github.com/gorilla/mux"
+)
+
+// searchICD10Handler searches the ICD-10 catalogue by code prefix or description text, e.g.
+// /api/v1/icd10?q=J45 or /api/v1/icd10?q=asthma.
+func (app *application) searchICD10Handler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	text := app.readStrings(qs, "q", "")
+	limit := app.readInt(qs, "limit", 20, v)
+
+	v.Check(text != "", "q", "must be provided")
+	v.Check(limit > 0 && limit <= 100, "limit", "must be between 1 and 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.tenantModels(r).ICD10.Search(text, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"codes": codes}, nil)
+}
+
+// getICD10Handler returns a single catalogue entry.
+func (app *application) getICD10Handler(w http.ResponseWriter, r *http.Request) {
+	code, err := app.tenantModels(r).ICD10.Get(mux.Vars(r)["code"])
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"code": code}, nil)
+}
+
+// createDiagnosisHandler attaches a primary or secondary diagnosis to an appointment.
+func (app *application) createDiagnosisHandler(w http.ResponseWriter, r *http.Request) {
+	appointmentID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code  string `json:"code"`
+		Kind  string `json:"kind"`
+		Notes string `json:"notes"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	diagnosis := &model.Diagnosis{
+		AppointmentID: int64(appointmentID),
+		Code:          input.Code,
+		Kind:          input.Kind,
+		Notes:         input.Notes,
+	}
+
+	v := validator.New()
+
+	if model.ValidateDiagnosis(v, diagnosis); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the code against the catalogue so the client gets a validation error rather than a
+	// foreign key violation.
+	_, err = app.tenantModels(r).ICD10.Get(diagnosis.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("code", "is not a known ICD-10 code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.tenantModels(r).Diagnoses.Insert(diagnosis)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicatePrimaryDiagnosis):
+			v.AddError("kind", "the appointment already has a primary diagnosis")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"diagnosis": diagnosis}, nil)
+}
+
+// listAppointmentDiagnosesHandler lists the diagnoses recorded during an appointment.
+func (app *application) listAppointmentDiagnosesHandler(w http.ResponseWriter, r *http.Request) {
+	appointmentID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	diagnoses, err := app.tenantModels(r).Diagnoses.GetForAppointment(int64(appointmentID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"diagnoses": diagnoses}, nil)
+}
+
+// deleteDiagnosisHandler removes a diagnosis recorded by mistake.
+func (app *application) deleteDiagnosisHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.tenantModels(r).Diagnoses.Delete(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "diagnosis successfully deleted"}, nil)
+}
+
+// getProblemHistoryHandler returns a patient's diagnoses across all appointments in
+// chronological order.
+func (app *application) getProblemHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	diagnoses, err := app.tenantModels(r).Diagnoses.GetProblemHistory(int64(patientID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"problems": diagnoses}, nil)
+}
@@ -32,7 +32,7 @@ func (app *application) createDoctor(w http.ResponseWriter, r *http.Request) {
 		Phone:      input.Phone,
 	}
 
-	err = app.models.Doctors.Insert(doctor)
+	err = app.tenantModels(r).Doctors.Insert(doctor)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -52,7 +52,7 @@ func (app *application) getDoctor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	doctor, err := app.models.Doctors.Get(id)
+	doctor, err := app.tenantModels(r).Doctors.Get(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -70,14 +70,21 @@ func (app *application) getSortedDoctors(w http.ResponseWriter, r *http.Request)
 		sortDirection = "ASC"
 	}
 
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	filters := model.Filters{
 		Sort:          sortParam,
 		SortDirection: sortDirection,
 		SortSafelist:  []string{"first_name", "last_name", "id", "-first_name", "-last_name", "-id"}, // Add any safe sorting criteria
+		BranchID:      branchID,
 	}
 
 	// Call GetAllSortedByName method from the DoctorModel instance
-	doctors, err := app.models.Doctors.GetAllSortedByName(filters)
+	doctors, err := app.tenantModels(r).Doctors.GetAllSortedByName(filters)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
@@ -101,7 +108,13 @@ func (app *application) getFilteredDoctors(w http.ResponseWriter, r *http.Reques
 
 	filterParam := r.URL.Query().Get("filter")
 
-	filtered_doctors, err := app.models.Doctors.GetFilteredByText(filterParam)
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filtered_doctors, err := app.tenantModels(r).Doctors.GetFilteredByText(filterParam, branchID)
 
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
@@ -126,7 +139,13 @@ func (app *application) getPaginatedDoctors(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	doctors, err := app.models.Doctors.GetPaginatedDoctors(limit, offset)
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	doctors, err := app.tenantModels(r).Doctors.GetPaginatedDoctors(limit, offset, branchID)
 
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
@@ -146,7 +165,7 @@ func (app *application) updateDoctor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	doctor, err := app.models.Doctors.Get(id)
+	doctor, err := app.tenantModels(r).Doctors.Get(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -181,7 +200,7 @@ func (app *application) updateDoctor(w http.ResponseWriter, r *http.Request) {
 		doctor.Phone = *input.Phone
 	}
 
-	err = app.models.Doctors.Update(doctor)
+	err = app.tenantModels(r).Doctors.Update(doctor)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error3")
 		return
@@ -200,7 +219,7 @@ func (app *application) deleteDoctor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.models.Doctors.Delete(id)
+	err = app.tenantModels(r).Doctors.Delete(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error4")
 		return
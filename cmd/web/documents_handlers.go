@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"GoClinic/pkg/web/model"
+)
+
+// allowedDocumentTypes are the content types accepted for upload. The type is sniffed from the
+// file contents; whatever the client claims is ignored.
+var allowedDocumentTypes = []string{
+	"application/pdf",
+	"application/dicom",
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/bmp",
+	"image/tiff",
+	"text/plain; charset=utf-8",
+}
+
+// errUploadTooLarge is returned when an uploaded file exceeds the configured size limit.
+var errUploadTooLarge = errors.New("upload too large")
+
+// upload is a file received in a multipart request, spooled to a temporary file.
+type upload struct {
+	file        *os.File
+	filename    string
+	contentType string
+	size        int64
+	sha256      string
+}
+
+func (u *upload) Close() {
+	u.file.Close()
+	os.Remove(u.file.Name())
+}
+
+// sniffContentType detects the type of a file from its first bytes. On top of what
+// http.DetectContentType recognises, it knows TIFF images and DICOM files, which are common
+// for imaging reports.
+func sniffContentType(head []byte) string {
+	switch {
+	case len(head) >= 132 && string(head[128:132]) == "DICM":
+		return "application/dicom"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "image/tiff"
+	}
+	return http.DetectContentType(head)
+}
+
+// readUpload streams the "file" part of a multipart request into a temporary file, hashing and
+// sniffing it on the way, so that large scans are never held in memory.
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request) (*upload, error) {
+	// Uploads of large scans over slow connections take longer than the server's read timeout.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(5 * time.Minute))
+
+	maxSize := app.config.storage.maxUploadSize
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("multipart body must contain a file field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		tmp, err := os.CreateTemp("", "goclinic-upload-*")
+		if err != nil {
+			return nil, err
+		}
+		u := &upload{file: tmp, filename: filepath.Base(part.FileName())}
+
+		head := make([]byte, 512)
+		n, err := io.ReadFull(part, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			u.Close()
+			return nil, err
+		}
+		head = head[:n]
+		u.contentType = sniffContentType(head)
+
+		hash := sha256.New()
+		u.size, err = io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(io.MultiReader(bytes.NewReader(head), part), maxSize+1))
+		if err != nil {
+			u.Close()
+			return nil, err
+		}
+		if u.size > maxSize {
+			u.Close()
+			return nil, errUploadTooLarge
+		}
+		u.sha256 = hex.EncodeToString(hash.Sum(nil))
+
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			u.Close()
+			return nil, err
+		}
+
+		return u, nil
+	}
+}
+
+// uploadDocument validates the upload and stores it for the document, which must have its
+// patient and appointment set. If the same file is already attached to the same record, the
+// existing document is returned with created set to false.
+func (app *application) uploadDocument(w http.ResponseWriter, r *http.Request, doc *model.Document) (created bool, ok bool) {
+	u, err := app.readUpload(w, r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, errUploadTooLarge), errors.As(err, &maxBytesErr):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("file must not be larger than %d bytes", app.config.storage.maxUploadSize))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return false, false
+	}
+	defer u.Close()
+
+	if u.size == 0 {
+		app.failedValidationResponse(w, r, map[string]string{"file": "must not be empty"})
+		return false, false
+	}
+
+	if !allowedContentType(u.contentType) {
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType,
+			fmt.Sprintf("files of type %s are not accepted", u.contentType))
+		return false, false
+	}
+
+	doc.TenantID = app.contextGetTenant(r).ID
+	doc.Filename = u.filename
+	doc.ContentType = u.contentType
+	doc.Size = u.size
+	doc.SHA256 = u.sha256
+	doc.UploadedBy = &app.contextGetUser(r).ID
+
+	dup, err := app.tenantModels(r).Documents.FindDuplicate(doc)
+	switch {
+	case err == nil:
+		*doc = *dup
+		return false, true
+	case !errors.Is(err, model.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return false, false
+	}
+
+	// Contents already uploaded for another record of the tenant are stored once.
+	exists, err := app.storage.Exists(r.Context(), doc.StorageKey())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false, false
+	}
+	if !exists {
+		err = app.storage.Put(r.Context(), doc.StorageKey(), u.file, u.size, u.contentType)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false, false
+		}
+	}
+
+	err = app.tenantModels(r).Documents.Insert(doc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false, false
+	}
+
+	return true, true
+}
+
+func allowedContentType(contentType string) bool {
+	for _, t := range allowedDocumentTypes {
+		if contentType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDocumentCreated answers an upload with 201 for a new document, or 200 if the file was
+// already attached to the record.
+func (app *application) writeDocumentCreated(w http.ResponseWriter, doc *model.Document, created bool) {
+	status := http.StatusOK
+	headers := make(http.Header)
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/api/v1/documents/%d", doc.ID))
+	}
+
+	app.writeJSON(w, status, envelope{"document": doc}, headers)
+}
+
+// uploadPatientDocumentHandler attaches a file to a patient record. The file is sent as the
+// "file" field of a multipart/form-data body.
+func (app *application) uploadPatientDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.tenantModels(r).Patients.Get(patientID)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	doc := &model.Document{PatientID: int64(patientID)}
+
+	created, ok := app.uploadDocument(w, r, doc)
+	if !ok {
+		return
+	}
+
+	app.writeDocumentCreated(w, doc, created)
+}
+
+// uploadAppointmentDocumentHandler attaches a file to an appointment and its patient.
+func (app *application) uploadAppointmentDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	appointmentID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	appointment, err := app.tenantModels(r).Appointments.Get(appointmentID)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	id := int64(appointmentID)
+	doc := &model.Document{PatientID: int64(appointment.PatientID), AppointmentID: &id}
+
+	created, ok := app.uploadDocument(w, r, doc)
+	if !ok {
+		return
+	}
+
+	app.writeDocumentCreated(w, doc, created)
+}
+
+// listPatientDocumentsHandler returns the documents of a patient, including the ones attached
+// to the patient's appointments.
+func (app *application) listPatientDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	documents, err := app.tenantModels(r).Documents.GetForPatient(int64(patientID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"documents": documents}, nil)
+}
+
+// listAppointmentDocumentsHandler returns the documents attached to an appointment.
+func (app *application) listAppointmentDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	appointmentID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	documents, err := app.tenantModels(r).Documents.GetForAppointment(int64(appointmentID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"documents": documents}, nil)
+}
+
+// readDocument loads the document named in the URL, answering with 404 if it doesn't exist.
+func (app *application) readDocument(w http.ResponseWriter, r *http.Request) (*model.Document, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	doc, err := app.tenantModels(r).Documents.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return doc, true
+}
+
+// getDocumentHandler returns the metadata of a document.
+func (app *application) getDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	doc, ok := app.readDocument(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"document": doc}, nil)
+}
+
+// downloadDocumentHandler streams the contents of a document from the storage.
+func (app *application) downloadDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	doc, ok := app.readDocument(w, r)
+	if !ok {
+		return
+	}
+
+	etag := `"` + doc.SHA256 + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, err := app.storage.Get(r.Context(), doc.StorageKey())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer body.Close()
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute))
+
+	w.Header().Set("Content-Type", doc.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(doc.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": doc.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private")
+	w.Header().Set("ETag", etag)
+
+	if _, err := io.Copy(w, body); err != nil {
+		// The headers are already sent, so all we can do is log the error.
+		app.logError(r, err)
+	}
+}
+
+// deleteDocumentHandler removes a document. Its contents are removed from the storage once no
+// other document refers to them.
+func (app *application) deleteDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	doc, ok := app.readDocument(w, r)
+	if !ok {
+		return
+	}
+
+	orphaned, err := app.tenantModels(r).Documents.Delete(doc)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if orphaned {
+		err = app.storage.Delete(r.Context(), doc.StorageKey())
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "document successfully deleted"}, nil)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/xlsx"
+)
+
+// Export limits.
+const (
+	// exportFlushRows is how many rows are written between flushes to the client.
+	exportFlushRows = 1000
+	// exportTimeout is how long an export may run, in a request or in the background.
+	exportTimeout = time.Hour
+	// exportPollInterval is how often the worker looks for pending exports.
+	exportPollInterval = 5 * time.Second
+)
+
+// exportContentTypes are the media types of the export formats.
+var exportContentTypes = map[string]string{
+	model.ExportCSV:    "text/csv; charset=utf-8",
+	model.ExportNDJSON: "application/x-ndjson",
+	model.ExportXLSX:   xlsx.ContentType,
+}
+
+// rowWriter writes the rows of an export in one of the formats. Rows are buffered until Flush
+// or Close.
+type rowWriter interface {
+	WriteRow(row []interface{}) error
+	Flush() error
+	Close() error
+}
+
+// newRowWriter starts an export of the given columns on w. With CSV and XLSX the first row
+// names the columns; NDJSON writes every row as an object keyed by them.
+func newRowWriter(w io.Writer, format, name string, columns []string) (rowWriter, error) {
+	switch format {
+	case model.ExportCSV:
+		cw := csv.NewWriter(w)
+		return &csvRowWriter{cw: cw, record: make([]string, len(columns))}, cw.Write(columns)
+	case model.ExportNDJSON:
+		keys := make([][]byte, len(columns))
+		for i, column := range columns {
+			keys[i], _ = json.Marshal(column)
+		}
+		return &ndjsonRowWriter{bw: bufio.NewWriter(w), keys: keys}, nil
+	case model.ExportXLSX:
+		xw, err := xlsx.NewWriter(w, name)
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxRowWriter{xw: xw}, xw.WriteHeader(columns...)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// exportCell formats the cells which the formats don't write as they are: times and nulls.
+func exportCell(cell interface{}) interface{} {
+	switch c := cell.(type) {
+	case time.Time:
+		return c.Format(time.RFC3339)
+	case nil:
+		return ""
+	}
+	return cell
+}
+
+type csvRowWriter struct {
+	cw     *csv.Writer
+	record []string
+}
+
+func (w *csvRowWriter) WriteRow(row []interface{}) error {
+	for i, cell := range row {
+		if text, ok := cell.(string); ok {
+			w.record[i] = csvText(text)
+			continue
+		}
+		w.record[i] = fmt.Sprint(exportCell(cell))
+	}
+	return w.cw.Write(w.record)
+}
+
+// csvText keeps spreadsheet applications from running text as a formula when they open the
+// file. Text which starts like a formula, such as a name or a note entered as "=HYPERLINK(...)",
+// is prefixed with an apostrophe, which they read as "this cell is text".
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (w *csvRowWriter) Flush() error {
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+func (w *csvRowWriter) Close() error {
+	return w.Flush()
+}
+
+type ndjsonRowWriter struct {
+	bw   *bufio.Writer
+	keys [][]byte
+}
+
+// WriteRow writes a row as a JSON object on a line of its own. The keys are in the order of
+// the columns, which json.Marshal of a map wouldn't keep.
+func (w *ndjsonRowWriter) WriteRow(row []interface{}) error {
+	w.bw.WriteByte('{')
+	for i, cell := range row {
+		if i > 0 {
+			w.bw.WriteByte(',')
+		}
+		w.bw.Write(w.keys[i])
+		w.bw.WriteByte(':')
+		value, err := json.Marshal(cell)
+		if err != nil {
+			return err
+		}
+		w.bw.Write(value)
+	}
+	_, err := w.bw.WriteString("}\n")
+	return err
+}
+
+func (w *ndjsonRowWriter) Flush() error {
+	return w.bw.Flush()
+}
+
+func (w *ndjsonRowWriter) Close() error {
+	return w.bw.Flush()
+}
+
+type xlsxRowWriter struct {
+	xw *xlsx.Writer
+}
+
+func (w *xlsxRowWriter) WriteRow(row []interface{}) error {
+	cells := make([]interface{}, len(row))
+	for i, cell := range row {
+		if cell != nil {
+			cells[i] = exportCell(cell)
+		}
+	}
+	return w.xw.WriteRow(cells...)
+}
+
+// Flush does nothing: the workbook is compressed, so it is only written out as the compressor
+// fills its blocks.
+func (w *xlsxRowWriter) Flush() error {
+	return nil
+}
+
+func (w *xlsxRowWriter) Close() error {
+	return w.xw.Close()
+}
+
+// exportFilename is the name of the file of an export started at the given time.
+func exportFilename(entity, format string, at time.Time) string {
+	return entity + "-" + at.Format("2006-01-02") + "." + format
+}
+
+// startExports starts the export worker, which runs the pending exports of every tenant one
+// after the other until ctx is cancelled. Several replicas may run it at the same time: every
+// export is claimed in the database before it runs.
+func (app *application) startExports(ctx context.Context) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(exportPollInterval)
+		defer ticker.Stop()
+
+		for {
+			app.runPendingExports(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// runPendingExports runs the pending exports of every tenant.
+func (app *application) runPendingExports(ctx context.Context) {
+	tenants, err := app.allTenants()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, t := range tenants {
+		for ctx.Err() == nil {
+			// An export which runs for longer than exportTimeout has failed, so one that has
+			// been running for twice as long was left behind by a worker which stopped.
+			e, err := t.models.Exports.Claim(2 * exportTimeout)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"tenant": t.Slug})
+				break
+			}
+			if e == nil {
+				break
+			}
+			app.runExport(ctx, t, e)
+		}
+	}
+}
+
+// runExport writes a claimed export to a temporary file, stores it and records the outcome.
+// An export interrupted by the shutdown goes back to the queue.
+func (app *application) runExport(ctx context.Context, t *tenant, e *model.Export) {
+	properties := map[string]string{"tenant": t.Slug, "export_id": fmt.Sprint(e.ID)}
+
+	err := app.writeExport(ctx, t, e)
+	switch {
+	case err == nil:
+		e.Status, e.Error = model.ExportDone, ""
+	case ctx.Err() != nil:
+		e.Status, e.Rows, e.Error = model.ExportPending, 0, ""
+	default:
+		e.Status, e.Error = model.ExportFailed, err.Error()
+		app.logger.PrintError(err, properties)
+	}
+
+	err = t.models.Exports.Finish(e)
+	if errors.Is(err, sql.ErrNoRows) && e.StorageKey != "" {
+		// The export was deleted while it ran.
+		err = app.storage.Delete(context.Background(), e.StorageKey)
+	}
+	if err != nil {
+		app.logger.PrintError(err, properties)
+	}
+}
+
+// writeExport streams the rows of an export into a temporary file and puts it in the storage,
+// setting the export's rows, size and storage key.
+func (app *application) writeExport(ctx context.Context, t *tenant, e *model.Export) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	f, err := os.CreateTemp("", "export-*."+e.Format)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	rw, err := newRowWriter(f, e.Format, e.Entity, model.ExportColumns(e.Entity))
+	if err != nil {
+		return err
+	}
+	e.Rows = 0
+	err = t.models.Exports.Stream(ctx, e.Entity, e.Filter, func(row []interface{}) error {
+		e.Rows++
+		return rw.WriteRow(row)
+	})
+	if err != nil {
+		return err
+	}
+	if err := rw.Close(); err != nil {
+		return err
+	}
+
+	if e.Size, err = f.Seek(0, io.SeekCurrent); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	key := "exports/" + hex.EncodeToString(token) + "." + e.Format
+	if err := app.storage.Put(ctx, key, f, e.Size, exportContentTypes[e.Format]); err != nil {
+		return err
+	}
+	e.StorageKey = key
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+	"GoClinic/pkg/web/xlsx"
+)
+
+// readExport reads what to export from the query string: the ?format=, csv by default, and the
+// filters of the list endpoints, ?filter= and ?branch_id=. Appointments can also be filtered
+// by ?doctor_id=, ?patient_id=, ?from= and ?to=.
+func (app *application) readExport(r *http.Request, entity string) (*model.Export, *validator.Validator) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	e := &model.Export{
+		Entity: entity,
+		Format: app.readStrings(qs, "format", model.ExportCSV),
+		Filter: model.ExportFilter{
+			Text:     qs.Get("filter"),
+			BranchID: app.readBranchID(qs, v),
+		},
+	}
+	if entity == model.ExportAppointments {
+		e.Filter.DoctorID = int64(app.readInt(qs, "doctor_id", 0, v))
+		e.Filter.PatientID = int64(app.readInt(qs, "patient_id", 0, v))
+		if from, to := app.readPeriod(r, v); v.Valid() {
+			if !from.IsZero() {
+				e.Filter.From = &from
+			}
+			if !to.IsZero() {
+				e.Filter.To = &to
+			}
+		}
+	}
+	model.ValidateExport(v, e)
+	return e, v
+}
+
+// checkExportSize rejects an XLSX export with more rows than a worksheet holds, which would
+// otherwise fail part of the way through. It responds and returns false if the export can't
+// be written.
+func (app *application) checkExportSize(w http.ResponseWriter, r *http.Request, e *model.Export) bool {
+	if e.Format != model.ExportXLSX {
+		return true
+	}
+
+	n, err := app.tenantModels(r).Exports.Count(r.Context(), e.Entity, e.Filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if n > xlsx.MaxRows-1 {
+		v := validator.New()
+		v.AddError("format", fmt.Sprintf("xlsx holds at most %d rows but the export has %d; use csv or ndjson, or narrow it down with filters",
+			xlsx.MaxRows-1, n))
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	return true
+}
+
+// exportPatientsHandler streams the patients as a file.
+func (app *application) exportPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	app.exportHandler(w, r, model.ExportPatients)
+}
+
+// exportDoctorsHandler streams the doctors as a file.
+func (app *application) exportDoctorsHandler(w http.ResponseWriter, r *http.Request) {
+	app.exportHandler(w, r, model.ExportDoctors)
+}
+
+// exportAppointmentsHandler streams the appointments as a file.
+func (app *application) exportAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.exportHandler(w, r, model.ExportAppointments)
+}
+
+// exportHandler streams the rows of an entity to the client as they are read from the
+// database, flushing them every exportFlushRows rows. The response starts with the first row,
+// so that an error before it is still reported as such.
+func (app *application) exportHandler(w http.ResponseWriter, r *http.Request, entity string) {
+	e, v := app.readExport(r, entity)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !app.checkExportSize(w, r, e) {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(exportTimeout))
+
+	var rw rowWriter
+	start := func() (err error) {
+		w.Header().Set("Content-Type", exportContentTypes[e.Format])
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+			map[string]string{"filename": exportFilename(entity, e.Format, time.Now())}))
+		w.Header().Set("Cache-Control", "no-store")
+		rw, err = newRowWriter(w, e.Format, entity, model.ExportColumns(entity))
+		return err
+	}
+
+	var rows int64
+	err := app.tenantModels(r).Exports.Stream(r.Context(), entity, e.Filter, func(row []interface{}) error {
+		if rw == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := rw.WriteRow(row); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err == nil && rw == nil {
+		err = start()
+	}
+	if err != nil {
+		if rw == nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// The headers are already sent, so all we can do is log the error. The file is cut
+		// short, which the client notices as the response isn't terminated properly.
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if err := rw.Close(); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// createPatientsExportHandler queues an export of the patients.
+func (app *application) createPatientsExportHandler(w http.ResponseWriter, r *http.Request) {
+	app.createExportHandler(w, r, model.ExportPatients)
+}
+
+// createDoctorsExportHandler queues an export of the doctors.
+func (app *application) createDoctorsExportHandler(w http.ResponseWriter, r *http.Request) {
+	app.createExportHandler(w, r, model.ExportDoctors)
+}
+
+// createAppointmentsExportHandler queues an export of the appointments.
+func (app *application) createAppointmentsExportHandler(w http.ResponseWriter, r *http.Request) {
+	app.createExportHandler(w, r, model.ExportAppointments)
+}
+
+// createExportHandler queues an export with the same parameters as exportHandler, to be
+// written in the background. Its status is at the Location of the response.
+func (app *application) createExportHandler(w http.ResponseWriter, r *http.Request, entity string) {
+	e, v := app.readExport(r, entity)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !app.checkExportSize(w, r, e) {
+		return
+	}
+
+	user := app.contextGetUser(r)
+	e.CreatedBy = &user.ID
+
+	if err := app.tenantModels(r).Exports.Insert(e); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/exports/%d", e.ID))
+
+	app.writeJSON(w, http.StatusAccepted, envelope{"export": e}, headers)
+}
+
+// readExportParam returns the export whose ID is in the URL, or responds with an error.
+func (app *application) readExportParam(w http.ResponseWriter, r *http.Request) (*model.Export, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	e, err := app.tenantModels(r).Exports.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return e, true
+}
+
+// showExportHandler returns an export with, once it is done, the link to download it.
+func (app *application) showExportHandler(w http.ResponseWriter, r *http.Request) {
+	e, ok := app.readExportParam(w, r)
+	if !ok {
+		return
+	}
+
+	env := envelope{"export": e}
+	if e.Status == model.ExportDone {
+		env["download_url"] = fmt.Sprintf("%s/api/v1/exports/%d/download", app.config.baseURL, e.ID)
+	}
+	app.writeJSON(w, http.StatusOK, env, nil)
+}
+
+// downloadExportHandler streams the file of a finished export from the storage.
+func (app *application) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	e, ok := app.readExportParam(w, r)
+	if !ok {
+		return
+	}
+	if e.Status != model.ExportDone {
+		app.errorResponse(w, r, http.StatusConflict, "the export is "+e.Status)
+		return
+	}
+
+	body, err := app.storage.Get(r.Context(), e.StorageKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer body.Close()
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout))
+
+	w.Header().Set("Content-Type", exportContentTypes[e.Format])
+	w.Header().Set("Content-Length", strconv.FormatInt(e.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": exportFilename(e.Entity, e.Format, e.CreatedAt)}))
+	w.Header().Set("Cache-Control", "private")
+
+	if _, err := io.Copy(w, body); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// deleteExportHandler removes an export and its file. An export which is running is removed
+// when it finishes.
+func (app *application) deleteExportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	e, err := app.tenantModels(r).Exports.Delete(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if e.StorageKey != "" {
+		if err := app.storage.Delete(r.Context(), e.StorageKey); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "export successfully deleted"}, nil)
+}
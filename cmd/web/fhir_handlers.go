@@ -0,0 +1,839 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/fhir"
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// Paging limits of FHIR searches.
+const (
+	fhirDefaultCount = 50
+	fhirMaxCount     = 200
+)
+
+// writeFHIR sends a FHIR resource as application/fhir+json.
+func (app *application) writeFHIR(w http.ResponseWriter, status int, resource interface{}, headers http.Header) {
+	js, err := json.MarshalIndent(resource, "", "\t")
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", fhir.ContentType+"; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write(js); err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// fhirErrorResponse sends an OperationOutcome describing the error.
+func (app *application) fhirErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, diagnostics string) {
+	app.writeFHIR(w, status, fhir.NewOperationOutcome(code, diagnostics), nil)
+}
+
+func (app *application) fhirServerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	app.fhirErrorResponse(w, r, http.StatusInternalServerError, fhir.IssueException,
+		"the server encountered a problem and could not process your request")
+}
+
+func (app *application) fhirNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	app.fhirErrorResponse(w, r, http.StatusNotFound, fhir.IssueNotFound,
+		fmt.Sprintf("resource %s not found", strings.TrimPrefix(r.URL.Path, "/fhir/R4/")))
+}
+
+// readFHIR decodes a resource of the given type from the request body. Unlike readJSON it
+// accepts unknown fields, since partners send whole resources of which we only store a part.
+func (app *application) readFHIR(w http.ResponseWriter, r *http.Request, resourceType string, dst interface{}) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	if rt := fhir.ResourceType(body); rt != resourceType {
+		return fmt.Errorf("expected a %s resource, got %q", resourceType, rt)
+	}
+
+	return json.Unmarshal(body, dst)
+}
+
+// fhirURL is the absolute URL of a resource.
+func (app *application) fhirURL(resourceType, id string) string {
+	return app.config.baseURL + "/fhir/R4/" + resourceType + "/" + id
+}
+
+// fhirPaging reads the _count and _offset search parameters.
+func fhirPaging(qs url.Values) (count, offset int, err error) {
+	count = fhirDefaultCount
+	if s := qs.Get("_count"); s != "" {
+		count, err = strconv.Atoi(s)
+		if err != nil || count < 0 {
+			return 0, 0, errors.New("_count must be a non-negative integer")
+		}
+		if count > fhirMaxCount {
+			count = fhirMaxCount
+		}
+	}
+	if s := qs.Get("_offset"); s != "" {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("_offset must be a non-negative integer")
+		}
+	}
+	return count, offset, nil
+}
+
+// newFHIRBundle creates the searchset bundle of a search which returned n results, with a
+// next link if the page was full.
+func (app *application) newFHIRBundle(r *http.Request, count, offset, n int) *fhir.Bundle {
+	bundle := fhir.NewSearchBundle(app.config.baseURL + r.URL.RequestURI())
+	if n == count && count > 0 {
+		qs := r.URL.Query()
+		qs.Set("_count", strconv.Itoa(count))
+		qs.Set("_offset", strconv.Itoa(offset+count))
+		bundle.Link = append(bundle.Link, fhir.BundleLink{Relation: "next", URL: app.config.baseURL + r.URL.Path + "?" + qs.Encode()})
+	}
+	if offset == 0 && n < count {
+		bundle.Total = &n
+	}
+	return bundle
+}
+
+// fhirIDFilter combines the _id and identifier search parameters into a single record ID.
+// It returns none if the parameters can't match any of our records.
+func fhirIDFilter(qs url.Values, system string) (id int, none bool) {
+	var ids []string
+	if s := qs.Get("_id"); s != "" {
+		ids = append(ids, s)
+	}
+	if s := qs.Get("identifier"); s != "" {
+		sys, code, hasSystem := fhir.ParseToken(s)
+		if hasSystem && sys != "" && sys != system {
+			return 0, true
+		}
+		ids = append(ids, code)
+	}
+
+	for _, s := range ids {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || (id != 0 && id != n) {
+			return 0, true
+		}
+		id = n
+	}
+	return id, false
+}
+
+func fhirName(firstName, lastName string) []fhir.HumanName {
+	return []fhir.HumanName{{
+		Use:    "official",
+		Text:   strings.TrimSpace(firstName + " " + lastName),
+		Family: lastName,
+		Given:  []string{firstName},
+	}}
+}
+
+// nameFromFHIR picks the official name, or the first one, of a resource.
+func nameFromFHIR(names []fhir.HumanName) (firstName, lastName string) {
+	if len(names) == 0 {
+		return "", ""
+	}
+	name := names[0]
+	for _, n := range names {
+		if n.Use == "official" {
+			name = n
+			break
+		}
+	}
+	return strings.Join(name.Given, " "), name.Family
+}
+
+func fhirPhone(phone string) []fhir.ContactPoint {
+	if phone == "" {
+		return nil
+	}
+	return []fhir.ContactPoint{{System: "phone", Value: phone}}
+}
+
+func phoneFromFHIR(telecom []fhir.ContactPoint) string {
+	return contactFromFHIR(telecom, "phone")
+}
+
+func contactFromFHIR(telecom []fhir.ContactPoint, system string) string {
+	for _, t := range telecom {
+		if t.System == system {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+func patientToFHIR(p *model.Patient) *fhir.Patient {
+	patient := &fhir.Patient{
+		ResourceType: "Patient",
+		ID:           p.Id,
+		Meta:         &fhir.Meta{LastUpdated: p.UpdatedAt},
+		Identifier:   []fhir.Identifier{{Use: "usual", System: fhir.SystemPatient, Value: p.Id}},
+		Name:         fhirName(p.FirstName, p.LastName),
+		Telecom:      fhirPhone(p.Phone),
+	}
+	if p.Email != "" {
+		patient.Telecom = append(patient.Telecom, fhir.ContactPoint{System: "email", Value: p.Email})
+	}
+	return patient
+}
+
+func doctorToPractitioner(d *model.Doctor) *fhir.Practitioner {
+	active := true
+	practitioner := &fhir.Practitioner{
+		ResourceType: "Practitioner",
+		ID:           d.Id,
+		Meta:         &fhir.Meta{LastUpdated: d.UpdatedAt},
+		Identifier:   []fhir.Identifier{{Use: "usual", System: fhir.SystemDoctor, Value: d.Id}},
+		Active:       &active,
+		Name:         fhirName(d.FirstName, d.LastName),
+		Telecom:      fhirPhone(d.Phone),
+	}
+	if d.Speciality != "" {
+		practitioner.Qualification = []fhir.Qualification{{Code: fhir.CodeableConcept{Text: d.Speciality}}}
+	}
+	return practitioner
+}
+
+// doctorToPractitionerRole describes the doctor's role in the clinic. Every doctor has exactly
+// one role, which shares the doctor's ID.
+func doctorToPractitionerRole(d *model.Doctor) *fhir.PractitionerRole {
+	active := true
+	role := &fhir.PractitionerRole{
+		ResourceType: "PractitionerRole",
+		ID:           d.Id,
+		Meta:         &fhir.Meta{LastUpdated: d.UpdatedAt},
+		Identifier:   []fhir.Identifier{{Use: "usual", System: fhir.SystemDoctor, Value: d.Id}},
+		Active:       &active,
+		Practitioner: &fhir.Reference{
+			Reference: "Practitioner/" + d.Id,
+			Display:   strings.TrimSpace(d.FirstName + " " + d.LastName),
+		},
+		Telecom: fhirPhone(d.Phone),
+	}
+	if d.Speciality != "" {
+		role.Specialty = []fhir.CodeableConcept{{Text: d.Speciality}}
+	}
+	return role
+}
+
+func appointmentToFHIR(a *model.Appointment) *fhir.Appointment {
+	appointment := &fhir.Appointment{
+		ResourceType: "Appointment",
+		ID:           a.Id,
+		Meta:         &fhir.Meta{LastUpdated: a.UpdatedAt},
+		Identifier:   []fhir.Identifier{{Use: "usual", System: fhir.SystemAppointment, Value: a.Id}},
+		Status:       "booked",
+		Start:        a.DateTime,
+		Created:      a.CreatedAt,
+		Participant: []fhir.AppointmentParticipant{
+			{Actor: &fhir.Reference{Reference: fmt.Sprintf("Patient/%d", a.PatientID)}, Required: "required", Status: "accepted"},
+			{Actor: &fhir.Reference{Reference: fmt.Sprintf("Practitioner/%d", a.DoctorID)}, Required: "required", Status: "accepted"},
+		},
+	}
+
+	if a.Status == model.AppointmentCancelled {
+		appointment.Status = "cancelled"
+	}
+
+	if start, err := time.Parse(time.RFC3339, a.DateTime); err == nil {
+		appointment.End = start.Add(a.Duration()).Format(time.RFC3339)
+		appointment.MinutesDuration = int(a.Duration() / time.Minute)
+	}
+
+	return appointment
+}
+
+// appointmentFromFHIR copies the start and the patient and practitioner participants of a FHIR
+// appointment. It returns a description of the problem if the resource can't be mapped.
+func appointmentFromFHIR(fa *fhir.Appointment, a *model.Appointment) string {
+	if fa.Status != "" && fa.Status != "booked" {
+		return "only booked appointments are supported"
+	}
+	if _, err := time.Parse(time.RFC3339, fa.Start); err != nil {
+		return "Appointment.start must be an instant such as 2024-06-01T10:00:00+05:00"
+	}
+
+	var patientID, doctorID int
+	for _, p := range fa.Participant {
+		// Participants must be typed references; a bare ID could be anyone.
+		if p.Actor == nil || !strings.Contains(p.Actor.Reference, "/") {
+			continue
+		}
+		if id, ok := fhir.ParseReference(p.Actor.Reference, "Patient"); ok {
+			patientID, _ = strconv.Atoi(id)
+		}
+		if id, ok := fhir.ParseReference(p.Actor.Reference, "Practitioner", "PractitionerRole"); ok {
+			doctorID, _ = strconv.Atoi(id)
+		}
+	}
+	if patientID < 1 {
+		return "Appointment.participant must reference a Patient"
+	}
+	if doctorID < 1 {
+		return "Appointment.participant must reference a Practitioner or PractitionerRole"
+	}
+
+	a.DateTime = fa.Start
+	a.PatientID = patientID
+	a.DoctorID = doctorID
+	return ""
+}
+
+// fhirCapabilityStatementHandler describes what the FHIR facade supports.
+func (app *application) fhirCapabilityStatementHandler(w http.ResponseWriter, r *http.Request) {
+	type searchParam struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	type interaction struct {
+		Code string `json:"code"`
+	}
+	type resource struct {
+		Type        string        `json:"type"`
+		Interaction []interaction `json:"interaction"`
+		SearchParam []searchParam `json:"searchParam"`
+	}
+
+	readWrite := []interaction{{"read"}, {"search-type"}, {"create"}, {"update"}}
+	paging := []searchParam{{"_id", "token"}, {"_count", "number"}, {"_offset", "number"}}
+
+	statement := map[string]interface{}{
+		"resourceType": "CapabilityStatement",
+		"status":       "active",
+		"date":         time.Now().UTC().Format("2006-01-02"),
+		"kind":         "instance",
+		"fhirVersion":  "4.0.1",
+		"format":       []string{"json"},
+		"software":     map[string]string{"name": "GoClinic"},
+		"implementation": map[string]string{
+			"description": "GoClinic FHIR facade",
+			"url":         app.config.baseURL + "/fhir/R4",
+		},
+		"rest": []map[string]interface{}{{
+			"mode": "server",
+			"resource": []resource{
+				{"Patient", readWrite, append([]searchParam{{"name", "string"}, {"identifier", "token"}}, paging...)},
+				{"Practitioner", readWrite, append([]searchParam{{"name", "string"}, {"identifier", "token"}}, paging...)},
+				{"PractitionerRole", []interaction{{"read"}, {"search-type"}}, append([]searchParam{{"practitioner", "reference"}, {"identifier", "token"}}, paging...)},
+				{"Appointment", readWrite, append([]searchParam{{"date", "date"}, {"practitioner", "reference"}, {"patient", "reference"}, {"identifier", "token"}}, paging...)},
+			},
+		}},
+	}
+
+	app.writeFHIR(w, http.StatusOK, statement, nil)
+}
+
+func (app *application) fhirReadPatientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.fhirNotFoundResponse(w, r)
+		return
+	}
+
+	patient, err := app.tenantModels(r).Patients.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.fhirNotFoundResponse(w, r)
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeFHIR(w, http.StatusOK, patientToFHIR(patient), nil)
+}
+
+func (app *application) fhirSearchPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	count, offset, err := fhirPaging(qs)
+	if err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+
+	patients := []*model.Patient{}
+	if id, none := fhirIDFilter(qs, fhir.SystemPatient); !none && count > 0 {
+		patients, err = app.tenantModels(r).Patients.Search(qs.Get("name"), id, count, offset)
+		if err != nil {
+			app.fhirServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	bundle := app.newFHIRBundle(r, count, offset, len(patients))
+	for _, p := range patients {
+		bundle.AddMatch(app.fhirURL("Patient", p.Id), patientToFHIR(p))
+	}
+
+	app.writeFHIR(w, http.StatusOK, bundle, nil)
+}
+
+// patientFromFHIR copies the name and phone of a FHIR patient. It returns a description of the
+// problem if the resource can't be mapped.
+func patientFromFHIR(fp *fhir.Patient, p *model.Patient) string {
+	p.FirstName, p.LastName = nameFromFHIR(fp.Name)
+	p.Phone = phoneFromFHIR(fp.Telecom)
+	p.Email = contactFromFHIR(fp.Telecom, "email")
+
+	if p.FirstName == "" || p.LastName == "" {
+		return "Patient.name must have a family and a given name"
+	}
+	if p.Email != "" && !validator.Matches(p.Email, validator.EmailRX) {
+		return "Patient.telecom email must be a valid email address"
+	}
+	return ""
+}
+
+func (app *application) fhirCreatePatientHandler(w http.ResponseWriter, r *http.Request) {
+	var fp fhir.Patient
+	if err := app.readFHIR(w, r, "Patient", &fp); err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+
+	patient := &model.Patient{}
+	if problem := patientFromFHIR(&fp, patient); problem != "" {
+		app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueInvalid, problem)
+		return
+	}
+
+	if err := app.tenantModels(r).Patients.Insert(patient); err != nil {
+		app.fhirServerErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.fhirURL("Patient", patient.Id))
+	app.writeFHIR(w, http.StatusCreated, patientToFHIR(patient), headers)
+}
+
+func (app *application) fhirUpdatePatientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.fhirNotFoundResponse(w, r)
+		return
+	}
+
+	var fp fhir.Patient
+	if err := app.readFHIR(w, r, "Patient", &fp); err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+	if fp.ID != "" && fp.ID != strconv.Itoa(id) {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, "Patient.id does not match the URL")
+		return
+	}
+
+	patient, err := app.tenantModels(r).Patients.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.fhirNotFoundResponse(w, r)
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if problem := patientFromFHIR(&fp, patient); problem != "" {
+		app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueInvalid, problem)
+		return
+	}
+
+	if err := app.tenantModels(r).Patients.Update(patient); err != nil {
+		app.fhirServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeFHIR(w, http.StatusOK, patientToFHIR(patient), nil)
+}
+
+// readDoctorForFHIR loads the doctor behind a Practitioner or PractitionerRole URL, answering
+// with an OperationOutcome if it can't.
+func (app *application) readDoctorForFHIR(w http.ResponseWriter, r *http.Request) (*model.Doctor, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.fhirNotFoundResponse(w, r)
+		return nil, false
+	}
+
+	doctor, err := app.tenantModels(r).Doctors.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.fhirNotFoundResponse(w, r)
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return doctor, true
+}
+
+func (app *application) fhirReadPractitionerHandler(w http.ResponseWriter, r *http.Request) {
+	doctor, ok := app.readDoctorForFHIR(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeFHIR(w, http.StatusOK, doctorToPractitioner(doctor), nil)
+}
+
+func (app *application) fhirReadPractitionerRoleHandler(w http.ResponseWriter, r *http.Request) {
+	doctor, ok := app.readDoctorForFHIR(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeFHIR(w, http.StatusOK, doctorToPractitionerRole(doctor), nil)
+}
+
+// searchDoctorsForFHIR runs a Practitioner or PractitionerRole search. The practitioner
+// parameter only applies to roles, where it selects the role of one practitioner.
+func (app *application) searchDoctorsForFHIR(w http.ResponseWriter, r *http.Request) ([]*model.Doctor, int, int, bool) {
+	qs := r.URL.Query()
+
+	count, offset, err := fhirPaging(qs)
+	if err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return nil, 0, 0, false
+	}
+
+	id, none := fhirIDFilter(qs, fhir.SystemDoctor)
+	if s := qs.Get("practitioner"); s != "" && !none {
+		ref, ok := fhir.ParseReference(s, "Practitioner")
+		n, err := strconv.Atoi(ref)
+		if !ok || err != nil || (id != 0 && id != n) {
+			none = true
+		}
+		id = n
+	}
+
+	doctors := []*model.Doctor{}
+	if !none && count > 0 {
+		doctors, err = app.tenantModels(r).Doctors.Search(qs.Get("name"), id, count, offset)
+		if err != nil {
+			app.fhirServerErrorResponse(w, r, err)
+			return nil, 0, 0, false
+		}
+	}
+
+	return doctors, count, offset, true
+}
+
+func (app *application) fhirSearchPractitionersHandler(w http.ResponseWriter, r *http.Request) {
+	doctors, count, offset, ok := app.searchDoctorsForFHIR(w, r)
+	if !ok {
+		return
+	}
+
+	bundle := app.newFHIRBundle(r, count, offset, len(doctors))
+	for _, d := range doctors {
+		bundle.AddMatch(app.fhirURL("Practitioner", d.Id), doctorToPractitioner(d))
+	}
+
+	app.writeFHIR(w, http.StatusOK, bundle, nil)
+}
+
+func (app *application) fhirSearchPractitionerRolesHandler(w http.ResponseWriter, r *http.Request) {
+	doctors, count, offset, ok := app.searchDoctorsForFHIR(w, r)
+	if !ok {
+		return
+	}
+
+	bundle := app.newFHIRBundle(r, count, offset, len(doctors))
+	for _, d := range doctors {
+		bundle.AddMatch(app.fhirURL("PractitionerRole", d.Id), doctorToPractitionerRole(d))
+	}
+
+	app.writeFHIR(w, http.StatusOK, bundle, nil)
+}
+
+// doctorFromFHIR copies the name, phone and speciality of a FHIR practitioner. It returns a
+// description of the problem if the resource can't be mapped.
+func doctorFromFHIR(fp *fhir.Practitioner, d *model.Doctor) string {
+	d.FirstName, d.LastName = nameFromFHIR(fp.Name)
+	d.Phone = phoneFromFHIR(fp.Telecom)
+	if len(fp.Qualification) > 0 {
+		d.Speciality = fp.Qualification[0].Code.Text
+		if d.Speciality == "" && len(fp.Qualification[0].Code.Coding) > 0 {
+			d.Speciality = fp.Qualification[0].Code.Coding[0].Display
+		}
+	}
+
+	if d.FirstName == "" || d.LastName == "" {
+		return "Practitioner.name must have a family and a given name"
+	}
+	return ""
+}
+
+func (app *application) fhirCreatePractitionerHandler(w http.ResponseWriter, r *http.Request) {
+	var fp fhir.Practitioner
+	if err := app.readFHIR(w, r, "Practitioner", &fp); err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+
+	doctor := &model.Doctor{}
+	if problem := doctorFromFHIR(&fp, doctor); problem != "" {
+		app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueInvalid, problem)
+		return
+	}
+
+	if err := app.tenantModels(r).Doctors.Insert(doctor); err != nil {
+		app.fhirServerErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.fhirURL("Practitioner", doctor.Id))
+	app.writeFHIR(w, http.StatusCreated, doctorToPractitioner(doctor), headers)
+}
+
+func (app *application) fhirUpdatePractitionerHandler(w http.ResponseWriter, r *http.Request) {
+	var fp fhir.Practitioner
+	if err := app.readFHIR(w, r, "Practitioner", &fp); err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+
+	doctor, ok := app.readDoctorForFHIR(w, r)
+	if !ok {
+		return
+	}
+	if fp.ID != "" && fp.ID != doctor.Id {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, "Practitioner.id does not match the URL")
+		return
+	}
+
+	if problem := doctorFromFHIR(&fp, doctor); problem != "" {
+		app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueInvalid, problem)
+		return
+	}
+
+	if err := app.tenantModels(r).Doctors.Update(doctor); err != nil {
+		app.fhirServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeFHIR(w, http.StatusOK, doctorToPractitioner(doctor), nil)
+}
+
+func (app *application) fhirReadAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.fhirNotFoundResponse(w, r)
+		return
+	}
+
+	appointment, err := app.tenantModels(r).Appointments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.fhirNotFoundResponse(w, r)
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeFHIR(w, http.StatusOK, appointmentToFHIR(appointment), nil)
+}
+
+func (app *application) fhirSearchAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	count, offset, err := fhirPaging(qs)
+	if err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+
+	filter := model.AppointmentFilter{Limit: count, Offset: offset}
+	id, none := fhirIDFilter(qs, fhir.SystemAppointment)
+	filter.ID = id
+
+	var dates fhir.DateRange
+	for _, s := range qs["date"] {
+		if err := dates.Intersect(s); err != nil {
+			app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+			return
+		}
+	}
+	filter.From, filter.To = dates.From, dates.To
+
+	references := []struct {
+		param string
+		types []string
+		dst   *int
+	}{
+		{"patient", []string{"Patient"}, &filter.PatientID},
+		{"practitioner", []string{"Practitioner", "PractitionerRole"}, &filter.DoctorID},
+	}
+	for _, ref := range references {
+		s := qs.Get(ref.param)
+		if s == "" {
+			continue
+		}
+		id, ok := fhir.ParseReference(s, ref.types...)
+		n, err := strconv.Atoi(id)
+		if !ok || err != nil {
+			none = true
+		}
+		*ref.dst = n
+	}
+
+	appointments := []*model.Appointment{}
+	if !none && count > 0 {
+		appointments, err = app.tenantModels(r).Appointments.Search(filter)
+		if err != nil {
+			app.fhirServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	bundle := app.newFHIRBundle(r, count, offset, len(appointments))
+	for _, a := range appointments {
+		bundle.AddMatch(app.fhirURL("Appointment", a.Id), appointmentToFHIR(a))
+	}
+
+	app.writeFHIR(w, http.StatusOK, bundle, nil)
+}
+
+// checkAppointmentParticipants answers with an OperationOutcome if the patient or doctor of an
+// appointment doesn't exist.
+func (app *application) checkAppointmentParticipants(w http.ResponseWriter, r *http.Request, a *model.Appointment) bool {
+	if _, err := app.tenantModels(r).Patients.Get(a.PatientID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueProcessing, fmt.Sprintf("Patient/%d not found", a.PatientID))
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	if _, err := app.tenantModels(r).Doctors.Get(a.DoctorID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueProcessing, fmt.Sprintf("Practitioner/%d not found", a.DoctorID))
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+func (app *application) fhirCreateAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	var fa fhir.Appointment
+	if err := app.readFHIR(w, r, "Appointment", &fa); err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+
+	appointment := &model.Appointment{}
+	if problem := appointmentFromFHIR(&fa, appointment); problem != "" {
+		app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueInvalid, problem)
+		return
+	}
+	if !app.checkAppointmentParticipants(w, r, appointment) {
+		return
+	}
+
+	if err := app.tenantModels(r).Appointments.Insert(appointment); err != nil {
+		switch {
+		case errors.Is(err, model.ErrSlotTaken), errors.Is(err, model.ErrDoctorUnavailable), errors.Is(err, model.ErrRoomTaken):
+			app.fhirErrorResponse(w, r, http.StatusConflict, fhir.IssueConflict, err.Error())
+		case errors.Is(err, model.ErrBranchMismatch):
+			app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueInvalid, err.Error())
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.fhirURL("Appointment", appointment.Id))
+	app.writeFHIR(w, http.StatusCreated, appointmentToFHIR(appointment), headers)
+}
+
+func (app *application) fhirUpdateAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.fhirNotFoundResponse(w, r)
+		return
+	}
+
+	var fa fhir.Appointment
+	if err := app.readFHIR(w, r, "Appointment", &fa); err != nil {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+	if fa.ID != "" && fa.ID != strconv.Itoa(id) {
+		app.fhirErrorResponse(w, r, http.StatusBadRequest, fhir.IssueInvalid, "Appointment.id does not match the URL")
+		return
+	}
+
+	appointment, err := app.tenantModels(r).Appointments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.fhirNotFoundResponse(w, r)
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if problem := appointmentFromFHIR(&fa, appointment); problem != "" {
+		app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueInvalid, problem)
+		return
+	}
+	if !app.checkAppointmentParticipants(w, r, appointment) {
+		return
+	}
+
+	if err := app.tenantModels(r).Appointments.Update(appointment); err != nil {
+		switch {
+		case errors.Is(err, model.ErrSlotTaken), errors.Is(err, model.ErrDoctorUnavailable), errors.Is(err, model.ErrRoomTaken):
+			app.fhirErrorResponse(w, r, http.StatusConflict, fhir.IssueConflict, err.Error())
+		case errors.Is(err, model.ErrBranchMismatch):
+			app.fhirErrorResponse(w, r, http.StatusUnprocessableEntity, fhir.IssueInvalid, err.Error())
+		default:
+			app.fhirServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeFHIR(w, http.StatusOK, appointmentToFHIR(appointment), nil)
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
@@ -57,9 +59,11 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 // Create Registration function
 func (app *application) createRegistration(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
-		Phone     string `json:"phone"`
+		FirstName  string `json:"first_name"`
+		LastName   string `json:"last_name"`
+		Phone      string `json:"phone"`
+		Email      string `json:"email"`
+		NationalID string `json:"national_id"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -69,15 +73,33 @@ func (app *application) createRegistration(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// The email address is optional; appointment reminders are sent to it when given.
+	v := validator.New()
+	if input.Email != "" {
+		model.ValidateEmail(v, input.Email)
+	}
+	v.Check(len(input.NationalID) <= 50, "national_id", "must not be more than 50 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	registration := &model.Patient{
-		FirstName: input.FirstName,
-		LastName:  input.LastName,
-		Phone:     input.Phone,
+		FirstName:  input.FirstName,
+		LastName:   input.LastName,
+		Phone:      input.Phone,
+		Email:      input.Email,
+		NationalID: input.NationalID,
 	}
 
-	err = app.models.Patients.Insert(registration)
+	err = app.tenantModels(r).Patients.Insert(registration)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, model.ErrDuplicateNationalID):
+			app.failedValidationResponse(w, r, map[string]string{"national_id": "a patient with this national ID already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -95,7 +117,7 @@ func (app *application) getRegistration(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	registration, err := app.models.Patients.Get(id)
+	registration, err := app.tenantModels(r).Patients.Get(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -113,14 +135,21 @@ func (app *application) getSortedRegistrations(w http.ResponseWriter, r *http.Re
 		sortDirection = "ASC"
 	}
 
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	filters := model.Filters{
 		Sort:          sortParam,
 		SortDirection: sortDirection,
 		SortSafelist:  []string{"first_name", "last_name", "id", "-first_name", "-last_name", "-id"}, // Add any safe sorting criteria
+		BranchID:      branchID,
 	}
 
 	// Call GetAllSortedByName method from the PatientModel instance
-	registrations, err := app.models.Patients.GetAllSortedByName(filters)
+	registrations, err := app.tenantModels(r).Patients.GetAllSortedByName(filters)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
@@ -144,7 +173,13 @@ func (app *application) getFilteredRegistrations(w http.ResponseWriter, r *http.
 
 	filterParam := r.URL.Query().Get("filter")
 
-	filtered_registrations, err := app.models.Patients.GetFilteredByText(filterParam)
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filtered_registrations, err := app.tenantModels(r).Patients.GetFilteredByText(filterParam, branchID)
 
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
@@ -169,7 +204,13 @@ func (app *application) getPaginatedRegistrations(w http.ResponseWriter, r *http
 		return
 	}
 
-	patients, err := app.models.Patients.GetPaginatedPatients(limit, offset)
+	branchID, err := parseBranchID(r.URL.Query())
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	patients, err := app.tenantModels(r).Patients.GetPaginatedPatients(limit, offset, branchID)
 
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
@@ -189,16 +230,18 @@ func (app *application) updateRegistration(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	registration, err := app.models.Patients.Get(id)
+	registration, err := app.tenantModels(r).Patients.Get(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
 	}
 
 	var input struct {
-		FirstName *string `json:"first_name"`
-		LastName  *string `json:"last_name"`
-		Phone     *string `json:"phone"`
+		FirstName  *string `json:"first_name"`
+		LastName   *string `json:"last_name"`
+		Phone      *string `json:"phone"`
+		Email      *string `json:"email"`
+		NationalID *string `json:"national_id"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -219,9 +262,58 @@ func (app *application) updateRegistration(w http.ResponseWriter, r *http.Reques
 		registration.Phone = *input.Phone
 	}
 
-	err = app.models.Patients.Update(registration)
+	if input.Email != nil {
+		v := validator.New()
+		if *input.Email != "" {
+			model.ValidateEmail(v, *input.Email)
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		registration.Email = *input.Email
+	}
+
+	if input.NationalID != nil {
+		v := validator.New()
+		if v.Check(len(*input.NationalID) <= 50, "national_id", "must not be more than 50 bytes long"); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		registration.NationalID = *input.NationalID
+	}
+
+	err = app.tenantModels(r).Patients.Update(registration)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateNationalID):
+			app.failedValidationResponse(w, r, map[string]string{"national_id": "a patient with this national ID already exists"})
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error3")
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, registration)
+}
+
+// getRegistrationByNationalID finds a patient by their national ID, which must match exactly
+// apart from spaces, dashes and dots.
+func (app *application) getRegistrationByNationalID(w http.ResponseWriter, r *http.Request) {
+	nationalID := strings.TrimSpace(r.URL.Query().Get("national_id"))
+	if nationalID == "" {
+		app.failedValidationResponse(w, r, map[string]string{"national_id": "must be provided"})
+		return
+	}
+
+	registration, err := app.tenantModels(r).Patients.GetByNationalID(nationalID)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error3")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -238,7 +330,7 @@ func (app *application) deleteRegistration(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Patients.Delete(id)
+	err = app.tenantModels(r).Patients.Delete(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error4")
 		return
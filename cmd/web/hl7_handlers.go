@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"GoClinic/pkg/web/hl7"
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// listHL7MessagesHandler returns the latest received HL7 messages, optionally filtered by
+// status, e.g. ?status=failed to find the ones which need attention.
+func (app *application) listHL7MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	status := app.readStrings(qs, "status", "")
+	limit := app.readInt(qs, "limit", 100, v)
+
+	v.Check(status == "" || validator.In(status, model.HL7Received, model.HL7Processed, model.HL7Failed, model.HL7Rejected),
+		"status", "must be received, processed, failed or rejected")
+	v.Check(limit > 0 && limit <= 1000, "limit", "must be between 1 and 1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	messages, err := app.tenantModels(r).HL7Messages.GetAll(status, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"messages": messages}, nil)
+}
+
+// getHL7MessageHandler returns a single stored HL7 message.
+func (app *application) getHL7MessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	message, err := app.tenantModels(r).HL7Messages.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+}
+
+// replayHL7MessageHandler processes a stored HL7 message again, e.g. after the doctor it
+// referred to has been created. The acknowledgement that would have been sent is returned.
+func (app *application) replayHL7MessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	record, err := app.tenantModels(r).HL7Messages.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	msg, err := hl7.Parse([]byte(record.Raw))
+	if err != nil {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "stored message cannot be parsed: "+err.Error())
+		return
+	}
+
+	ack := app.applyHL7(app.contextGetTenant(r), msg, record)
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": record, "ack": string(ack)}, nil)
+}
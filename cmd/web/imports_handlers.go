@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// importMaxSize is the largest CSV file which can be imported, enough for a few hundred
+// thousand rows.
+const importMaxSize = 100 << 20
+
+// importPatientsHandler imports patients from the CSV file sent as the request body.
+func (app *application) importPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	app.importHandler(w, r, model.ImportPatients)
+}
+
+// importDoctorsHandler imports doctors from the CSV file sent as the request body.
+func (app *application) importDoctorsHandler(w http.ResponseWriter, r *http.Request) {
+	app.importHandler(w, r, model.ImportDoctors)
+}
+
+// importHandler imports the rows of a CSV file, sent as the request body, which are valid;
+// with ?dry_run=true it only validates them. The file is read as it arrives. The response
+// counts the rows and shows the first errors; all of them are in the error report at
+// /api/v1/imports/{id}/errors. ?filename= names the file in the import.
+func (app *application) importHandler(w http.ResponseWriter, r *http.Request, kind string) {
+	qs := r.URL.Query()
+	v := validator.New()
+	dryRun := app.readStrings(qs, "dry_run", "false")
+	filename := qs.Get("filename")
+	v.Check(validator.In(dryRun, "true", "false"), "dry_run", "must be true or false")
+	v.Check(len(filename) <= 255, "filename", "must not be more than 255 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	imp := &model.Import{
+		Kind:      kind,
+		Filename:  filename,
+		DryRun:    dryRun == "true",
+		CreatedBy: &user.ID,
+	}
+
+	body := http.MaxBytesReader(w, r.Body, importMaxSize)
+	if err := app.tenantModels(r).Imports.Run(imp, body); err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.Is(err, model.ErrImportHeader):
+			app.failedValidationResponse(w, r, map[string]string{"file": err.Error()})
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("the file must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/imports/%d", imp.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"import": imp}, headers)
+}
+
+// showImportHandler returns an import with its first row errors.
+func (app *application) showImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	imp, err := app.tenantModels(r).Imports.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"import": imp}, nil)
+}
+
+// importErrorsHandler downloads the error report of an import as CSV.
+func (app *application) importErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	models := app.tenantModels(r)
+	if _, err := models.Imports.Get(int64(id)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=import-%d-errors.csv", id))
+
+	if err := models.Imports.WriteErrorReport(int64(id), w); err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"GoClinic/pkg/web/claims"
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// readBatchFormat decodes the batch_format of a payer; JSON null removes it.
+func readBatchFormat(raw json.RawMessage, v *validator.Validator) *claims.Format {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var format claims.Format
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&format); err != nil {
+		v.AddError("batch_format", "must be a batch file format: "+err.Error())
+		return nil
+	}
+	return &format
+}
+
+// createPayerHandler adds a payer. Without a batch_format its claims are exported in the
+// default CSV layout.
+func (app *application) createPayerHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code        string          `json:"code"`
+		Name        string          `json:"name"`
+		Currency    string          `json:"currency"`
+		BatchFormat json.RawMessage `json:"batch_format"`
+		Active      *bool           `json:"active"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	payer := &model.Payer{
+		Code:        input.Code,
+		Name:        input.Name,
+		Currency:    input.Currency,
+		BatchFormat: readBatchFormat(input.BatchFormat, v),
+		Active:      input.Active == nil || *input.Active,
+	}
+
+	if model.ValidatePayer(v, payer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Insurance.InsertPayer(payer); err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateName):
+			app.failedValidationResponse(w, r, map[string]string{"code": "a payer with this code already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/payers/%d", payer.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"payer": payer}, headers)
+}
+
+// listPayersHandler returns the payers; ?active=true only the active ones.
+func (app *application) listPayersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	active := app.readStrings(r.URL.Query(), "active", "")
+	v.Check(validator.In(active, "", "true", "false"), "active", "must be true or false")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	payers, err := app.tenantModels(r).Insurance.GetAllPayers(active == "true")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"payers": payers}, nil)
+}
+
+func (app *application) showPayerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	payer, err := app.tenantModels(r).Insurance.GetPayer(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"payer": payer}, nil)
+}
+
+// updatePayerHandler changes a payer. "batch_format": null goes back to the default layout.
+func (app *application) updatePayerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	models := app.tenantModels(r)
+	payer, err := models.Insurance.GetPayer(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Code        *string         `json:"code"`
+		Name        *string         `json:"name"`
+		Currency    *string         `json:"currency"`
+		BatchFormat json.RawMessage `json:"batch_format"`
+		Active      *bool           `json:"active"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Code != nil {
+		payer.Code = *input.Code
+	}
+	if input.Name != nil {
+		payer.Name = *input.Name
+	}
+	if input.Currency != nil {
+		payer.Currency = *input.Currency
+	}
+	if input.BatchFormat != nil {
+		payer.BatchFormat = readBatchFormat(input.BatchFormat, v)
+	}
+	if input.Active != nil {
+		payer.Active = *input.Active
+	}
+
+	if model.ValidatePayer(v, payer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := models.Insurance.UpdatePayer(payer); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateName):
+			app.failedValidationResponse(w, r, map[string]string{"code": "a payer with this code already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"payer": payer}, nil)
+}
+
+func (app *application) deletePayerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.tenantModels(r).Insurance.DeletePayer(int64(id)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrPayerInUse):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "payer successfully deleted"}, nil)
+}
+
+// createPolicyHandler adds an insurance policy of a patient.
+func (app *application) createPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		PayerID      int64   `json:"payer_id"`
+		MemberNumber string  `json:"member_number"`
+		GroupNumber  string  `json:"group_number"`
+		CoverageFrom string  `json:"coverage_from"`
+		CoverageTo   *string `json:"coverage_to"`
+		CopayAmount  int64   `json:"copay_amount"`
+		CopayRate    int     `json:"copay_rate"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	policy := &model.InsurancePolicy{
+		PatientID:    int64(patientID),
+		PayerID:      input.PayerID,
+		MemberNumber: input.MemberNumber,
+		GroupNumber:  input.GroupNumber,
+		CoverageFrom: input.CoverageFrom,
+		CoverageTo:   input.CoverageTo,
+		CopayAmount:  input.CopayAmount,
+		CopayRate:    input.CopayRate,
+	}
+
+	v := validator.New()
+	if model.ValidateInsurancePolicy(v, policy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Insurance.InsertPolicy(policy); err != nil {
+		app.policyErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/policies/%d", policy.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"policy": policy}, headers)
+}
+
+// policyErrorResponse answers the errors of saving a policy.
+func (app *application) policyErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, model.ErrPolicyOverlap), errors.Is(err, model.ErrPolicyInUse):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listPatientPoliciesHandler returns the insurance policies of a patient.
+func (app *application) listPatientPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	policies, err := app.tenantModels(r).Insurance.PatientPolicies(int64(patientID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"policies": policies}, nil)
+}
+
+// updatePolicyHandler changes a policy, such as its end of coverage or copay.
+func (app *application) updatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	models := app.tenantModels(r)
+	policy, err := models.Insurance.GetPolicy(int64(id))
+	if err != nil {
+		app.policyErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		PayerID      *int64  `json:"payer_id"`
+		MemberNumber *string `json:"member_number"`
+		GroupNumber  *string `json:"group_number"`
+		CoverageFrom *string `json:"coverage_from"`
+		CoverageTo   *string `json:"coverage_to"`
+		CopayAmount  *int64  `json:"copay_amount"`
+		CopayRate    *int    `json:"copay_rate"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.PayerID != nil {
+		policy.PayerID = *input.PayerID
+	}
+	if input.MemberNumber != nil {
+		policy.MemberNumber = *input.MemberNumber
+	}
+	if input.GroupNumber != nil {
+		policy.GroupNumber = *input.GroupNumber
+	}
+	if input.CoverageFrom != nil {
+		policy.CoverageFrom = *input.CoverageFrom
+	}
+	if input.CoverageTo != nil {
+		policy.CoverageTo = input.CoverageTo
+	}
+	if input.CopayAmount != nil {
+		policy.CopayAmount = *input.CopayAmount
+	}
+	if input.CopayRate != nil {
+		policy.CopayRate = *input.CopayRate
+	}
+
+	v := validator.New()
+	if model.ValidateInsurancePolicy(v, policy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := models.Insurance.UpdatePolicy(policy); err != nil {
+		app.policyErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"policy": policy}, nil)
+}
+
+func (app *application) deletePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.tenantModels(r).Insurance.DeletePolicy(int64(id)); err != nil {
+		app.policyErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "policy successfully deleted"}, nil)
+}
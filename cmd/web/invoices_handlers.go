@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/pdf"
+	"GoClinic/pkg/web/validator"
+)
+
+// invoiceLineInput is a line of an invoice as it is sent. The discount is given either as an
+// amount or as discount_rate, in hundredths of a percent of the line's amount.
+type invoiceLineInput struct {
+	ServiceID    *int64 `json:"service_id"`
+	Description  string `json:"description"`
+	Quantity     int    `json:"quantity"`
+	UnitPrice    int64  `json:"unit_price"`
+	Discount     int64  `json:"discount"`
+	DiscountRate int    `json:"discount_rate"`
+	VATRate      int    `json:"vat_rate"`
+}
+
+// invoiceLines converts the lines of a request, checking the discount rates.
+func invoiceLines(v *validator.Validator, input []invoiceLineInput) []*model.InvoiceLine {
+	lines := make([]*model.InvoiceLine, len(input))
+	for i, in := range input {
+		l := &model.InvoiceLine{
+			ServiceID:   in.ServiceID,
+			Description: in.Description,
+			Quantity:    in.Quantity,
+			UnitPrice:   in.UnitPrice,
+			Discount:    in.Discount,
+			VATRate:     in.VATRate,
+		}
+		if in.DiscountRate != 0 {
+			key := fmt.Sprintf("lines[%d].discount_rate", i)
+			v.Check(in.Discount == 0, key, "must not be given with discount")
+			v.Check(in.DiscountRate > 0 && in.DiscountRate <= 10000, key, "must be between 0 and 10000 hundredths of a percent")
+			if in.Quantity > 0 && in.Quantity <= 1000 && in.UnitPrice >= 0 && in.UnitPrice <= 1_000_000_000_00 {
+				l.Discount = model.VATAmount(int64(in.Quantity)*in.UnitPrice, in.DiscountRate)
+			}
+		}
+		lines[i] = l
+	}
+	return lines
+}
+
+// invoiceErrorResponse answers the errors of the invoice model which are the client's fault.
+func (app *application) invoiceErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, model.ErrAlreadyInvoiced), errors.Is(err, model.ErrNotDraft), errors.Is(err, model.ErrCannotVoid):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, model.ErrAppointmentNotCompleted), errors.Is(err, model.ErrEmptyInvoice),
+		errors.Is(err, model.ErrNotPayable), errors.Is(err, model.ErrNotCreditable):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createInvoiceHandler makes a draft invoice. With only appointment_id it is made of the
+// services of the appointment at the prices they were booked for; otherwise of the lines
+// given, for patient_id and optionally one of the patient's appointments.
+func (app *application) createInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		AppointmentID *int64             `json:"appointment_id"`
+		PatientID     int64              `json:"patient_id"`
+		Currency      string             `json:"currency"`
+		Notes         string             `json:"notes"`
+		DueDate       *string            `json:"due_date"`
+		Lines         []invoiceLineInput `json:"lines"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	models := app.tenantModels(r)
+
+	var invoice *model.Invoice
+	var err error
+	if input.AppointmentID != nil && input.Lines == nil {
+		v := validator.New()
+		v.Check(*input.AppointmentID > 0, "appointment_id", "must be a positive integer")
+		v.Check(input.PatientID == 0, "patient_id", "must not be given without lines")
+		v.Check(input.Currency == "", "currency", "must not be given without lines")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		invoice, err = models.Invoices.InsertForAppointment(*input.AppointmentID, &user.ID)
+	} else {
+		v := validator.New()
+		invoice = &model.Invoice{
+			PatientID:     input.PatientID,
+			AppointmentID: input.AppointmentID,
+			Currency:      input.Currency,
+			Notes:         input.Notes,
+			DueDate:       input.DueDate,
+			Lines:         invoiceLines(v, input.Lines),
+			CreatedBy:     &user.ID,
+		}
+		if model.ValidateInvoice(v, invoice); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = models.Invoices.Insert(invoice)
+	}
+	if err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/invoices/%d", invoice.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"invoice": invoice}, headers)
+}
+
+// listInvoicesHandler returns invoices and credit notes, newest first. They can be filtered by
+// ?patient_id=, ?appointment_id=, ?kind=, ?status= and the period they were created in with
+// ?from= and ?to=.
+func (app *application) listInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := model.InvoiceFilter{
+		PatientID:     int64(app.readInt(qs, "patient_id", 0, v)),
+		AppointmentID: int64(app.readInt(qs, "appointment_id", 0, v)),
+		Kind:          app.readStrings(qs, "kind", ""),
+		Status:        app.readStrings(qs, "status", ""),
+		Limit:         app.readInt(qs, "limit", 50, v),
+		Offset:        app.readInt(qs, "offset", 0, v),
+	}
+	filter.From, filter.To = app.readPeriod(r, v)
+	v.Check(filter.PatientID >= 0, "patient_id", "must be a positive integer")
+	v.Check(filter.AppointmentID >= 0, "appointment_id", "must be a positive integer")
+	v.Check(validator.In(filter.Kind, "", model.KindInvoice, model.KindCreditNote), "kind", "must be invoice or credit_note")
+	v.Check(validator.In(filter.Status, "", model.InvoiceDraft, model.InvoiceIssued, model.InvoicePartiallyPaid,
+		model.InvoicePaid, model.InvoiceVoid), "status", "must be draft, issued, partially_paid, paid or void")
+	v.Check(filter.Limit > 0 && filter.Limit <= 500, "limit", "must be between 1 and 500")
+	v.Check(filter.Offset >= 0, "offset", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invoices, err := app.tenantModels(r).Invoices.GetAll(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"invoices": invoices}, nil)
+}
+
+// showInvoiceHandler returns an invoice or a credit note with its lines and payments.
+func (app *application) showInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	invoice, err := app.tenantModels(r).Invoices.Get(int64(id))
+	if err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"invoice": invoice}, nil)
+}
+
+// updateInvoiceHandler changes the lines, notes, currency or due date of a draft invoice. The
+// lines given replace all lines.
+func (app *application) updateInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	models := app.tenantModels(r)
+	invoice, err := models.Invoices.Get(int64(id))
+	if err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Currency *string            `json:"currency"`
+		Notes    *string            `json:"notes"`
+		DueDate  *string            `json:"due_date"`
+		Lines    []invoiceLineInput `json:"lines"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Currency != nil {
+		invoice.Currency = *input.Currency
+	}
+	if input.Notes != nil {
+		invoice.Notes = *input.Notes
+	}
+	if input.DueDate != nil {
+		invoice.DueDate = input.DueDate
+	}
+	if input.Lines != nil {
+		invoice.Lines = invoiceLines(v, input.Lines)
+	}
+
+	if model.ValidateInvoice(v, invoice); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := models.Invoices.UpdateDraft(invoice); err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"invoice": invoice}, nil)
+}
+
+// issueInvoiceHandler gives a draft invoice its number. It can't be changed after that.
+func (app *application) issueInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	invoice, err := app.tenantModels(r).Invoices.Issue(int64(id))
+	if err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"invoice": invoice}, nil)
+}
+
+// voidInvoiceHandler voids a draft, or an issued invoice which nothing has been paid for.
+func (app *application) voidInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invoice, err := app.tenantModels(r).Invoices.Void(int64(id), input.Reason)
+	if err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"invoice": invoice}, nil)
+}
+
+// createPaymentHandler records a payment of an issued invoice, which may be part of its
+// balance. For a credit note it records a refund.
+func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Method    string     `json:"method"`
+		Amount    int64      `json:"amount"`
+		Reference string     `json:"reference"`
+		PaidAt    *time.Time `json:"paid_at"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	payment := &model.Payment{
+		InvoiceID:  int64(id),
+		Method:     input.Method,
+		Amount:     input.Amount,
+		Reference:  input.Reference,
+		PaidAt:     time.Now(),
+		ReceivedBy: &user.ID,
+	}
+	if input.PaidAt != nil {
+		payment.PaidAt = *input.PaidAt
+	}
+
+	v := validator.New()
+	if model.ValidatePayment(v, payment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invoice, err := app.tenantModels(r).Invoices.InsertPayment(payment)
+	if err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"payment": payment, "invoice": invoice}, nil)
+}
+
+// createCreditNoteHandler issues a credit note which takes back the given quantities of lines
+// of an invoice, or all of it without lines. It settles what the patient still owes; the rest
+// is refunded with payments of the credit note.
+func (app *application) createCreditNoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Lines []model.CreditLine `json:"lines"`
+		Notes string             `json:"notes"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	seen := make(map[int64]bool)
+	for i, l := range input.Lines {
+		v.Check(l.LineID > 0, fmt.Sprintf("lines[%d].line_id", i), "must be a positive integer")
+		v.Check(!seen[l.LineID], fmt.Sprintf("lines[%d].line_id", i), "must not be given twice")
+		v.Check(l.Quantity > 0, fmt.Sprintf("lines[%d].quantity", i), "must be greater than zero")
+		seen[l.LineID] = true
+	}
+	v.Check(len(input.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	creditNote, err := app.tenantModels(r).Invoices.InsertCreditNote(int64(id), input.Lines, input.Notes, &user.ID)
+	if err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/invoices/%d", creditNote.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"credit_note": creditNote}, headers)
+}
+
+// invoicePDFHandler renders an invoice or a credit note as a printable receipt, with the
+// payments made so far. Drafts are marked as such.
+func (app *application) invoicePDFHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	models := app.tenantModels(r)
+	invoice, err := models.Invoices.Get(int64(id))
+	if err != nil {
+		app.invoiceErrorResponse(w, r, err)
+		return
+	}
+
+	patient, err := models.Patients.Get(int(invoice.PatientID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	clinic := []string{app.contextGetTenant(r).Name}
+	loc := time.Local
+	if invoice.BranchID != nil {
+		branch, err := models.Branches.Get(*invoice.BranchID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		clinic = append(clinic, branch.Name, branch.Address)
+		if l, err := branch.Location(); err == nil {
+			loc = l
+		}
+	}
+
+	title := "Invoice"
+	if invoice.Kind == model.KindCreditNote {
+		title = "Credit note"
+	}
+	number := invoice.Number
+	if number == "" {
+		number = fmt.Sprintf("draft %d", invoice.ID)
+	}
+
+	doc := pdf.New(title + " " + number)
+	page := doc.AddPage()
+	money := func(amount int64) string { return model.FormatAmount(amount, invoice.Currency) }
+
+	y := pdf.PageHeight - 72
+	line := func(size float64, bold bool, text string) {
+		if y < 72 {
+			page = doc.AddPage()
+			y = pdf.PageHeight - 72
+		}
+		page.Text(56, y, size, bold, text)
+		y -= size * 1.6
+	}
+	// row draws the columns of a table row, starting a new page if needed.
+	row := func(bold bool, cells ...string) {
+		if y < 72 {
+			page = doc.AddPage()
+			y = pdf.PageHeight - 72
+		}
+		for i, x := range []float64{56, 300, 340, 420, 470} {
+			if i < len(cells) {
+				page.Text(x, y, 9, bold, cells[i])
+			}
+		}
+		y -= 9 * 1.6
+	}
+	rule := func() {
+		page.Line(56, y+8, pdf.PageWidth-56, y+8, 0.5)
+		y -= 6
+	}
+
+	for _, s := range clinic {
+		if s != "" {
+			line(10, false, s)
+		}
+	}
+	y -= 10
+	line(20, true, title)
+	line(10, false, "No. "+number)
+	if invoice.IssuedAt != nil {
+		line(10, false, "Issued "+invoice.IssuedAt.In(loc).Format("02.01.2006"))
+	}
+	if invoice.DueDate != nil {
+		if due, err := time.Parse("2006-01-02", *invoice.DueDate); err == nil {
+			line(10, false, "Due "+due.Format("02.01.2006"))
+		}
+	}
+	if invoice.CreditedInvoiceID != nil {
+		if credited, err := models.Invoices.Get(*invoice.CreditedInvoiceID); err == nil {
+			line(10, false, "Corrects invoice "+credited.Number)
+		}
+	}
+	switch invoice.Status {
+	case model.InvoiceDraft:
+		line(12, true, "DRAFT - not valid as an invoice")
+	case model.InvoiceVoid:
+		line(12, true, "VOID - "+invoice.VoidReason)
+	}
+	y -= 6
+	line(12, true, "Patient")
+	line(11, false, patient.FirstName+" "+patient.LastName)
+	y -= 6
+
+	rule()
+	row(true, "Description", "Qty", "Unit price", "VAT", "Amount")
+	rule()
+	for _, l := range invoice.Lines {
+		row(false, l.Description, fmt.Sprint(l.Quantity), money(l.UnitPrice),
+			fmt.Sprintf("%d.%02d%%", l.VATRate/100, l.VATRate%100), money(l.Net))
+		if l.Discount > 0 {
+			row(false, "   Discount", "", "", "", "-"+money(l.Discount))
+		}
+	}
+	rule()
+	row(false, "Net", "", "", "", money(invoice.Net))
+	row(false, "VAT", "", "", "", money(invoice.VAT))
+	row(true, "Total", "", "", "", money(invoice.Total))
+
+	if len(invoice.Payments) > 0 || invoice.Settled > 0 {
+		y -= 10
+		paid := "Payments"
+		if invoice.Kind == model.KindCreditNote {
+			paid = "Refunds"
+		}
+		line(12, true, paid)
+		var payments int64
+		for _, p := range invoice.Payments {
+			row(false, p.PaidAt.In(loc).Format("02.01.2006 15:04")+"  "+strings.ToUpper(p.Method[:1])+p.Method[1:],
+				p.Reference, "", "", money(p.Amount))
+			payments += p.Amount
+		}
+		if credited := invoice.Settled - payments; credited > 0 {
+			settledBy := "Credit notes"
+			if invoice.Kind == model.KindCreditNote {
+				settledBy = "Set off against the invoice"
+			}
+			row(false, settledBy, "", "", "", money(credited))
+		}
+		rule()
+		row(true, "Balance", "", "", "", money(invoice.Balance))
+	}
+
+	// Render the whole document first, so text the font can't print becomes an error response
+	// rather than a truncated file.
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	filename := strings.ReplaceAll(strings.ToLower(title), " ", "-") + "-" + strings.ReplaceAll(number, " ", "-") + ".pdf"
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename="+filename)
+
+	w.Write(out.Bytes())
+}
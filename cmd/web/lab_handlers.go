@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// createLabOrderHandler orders laboratory tests during an appointment.
+func (app *application) createLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	appointmentID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Tests []string `json:"tests"`
+		Notes string   `json:"notes"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order := &model.LabOrder{
+		AppointmentID: int64(appointmentID),
+		Tests:         input.Tests,
+		Notes:         input.Notes,
+	}
+
+	v := validator.New()
+
+	if model.ValidateLabOrder(v, order); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.tenantModels(r).LabOrders.Insert(order)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"lab_order": order}, nil)
+}
+
+// getLabOrderHandler returns a lab order with its results.
+func (app *application) getLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	order, err := app.tenantModels(r).LabOrders.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"lab_order": order}, nil)
+}
+
+// updateLabOrderStatusHandler marks the samples of an order as collected, or its results as
+// reviewed by the current user. Orders become resulted by entering their results.
+func (app *application) updateLabOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(input.Status, model.LabOrderCollected, model.LabOrderReviewed), "status", "must be collected or reviewed")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.tenantModels(r).LabOrders.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.tenantModels(r).LabOrders.SetStatus(order, input.Status, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidStatusTransition):
+			v.AddError("status", "cannot change from "+order.Status+" to "+input.Status)
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"lab_order": order}, nil)
+}
+
+// addLabResultsHandler enters the results of a collected order. Entering a result again for
+// the same analyte corrects it, as long as the order hasn't been reviewed.
+func (app *application) addLabResultsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Results []struct {
+			Analyte string   `json:"analyte"`
+			Value   *float64 `json:"value"`
+			Unit    string   `json:"unit"`
+			RefLow  *float64 `json:"ref_low"`
+			RefHigh *float64 `json:"ref_high"`
+		} `json:"results"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Results) > 0, "results", "must contain at least one result")
+
+	results := make([]*model.LabResult, 0, len(input.Results))
+	seen := make(map[string]bool)
+	for _, in := range input.Results {
+		v.Check(in.Value != nil, "value", "must be provided")
+		if in.Value == nil {
+			continue
+		}
+
+		result := &model.LabResult{
+			Analyte: model.NormalizeAnalyte(in.Analyte),
+			Value:   *in.Value,
+			Unit:    strings.TrimSpace(in.Unit),
+			RefLow:  in.RefLow,
+			RefHigh: in.RefHigh,
+		}
+		model.ValidateLabResult(v, result)
+		v.Check(!seen[result.Analyte], "analyte", "must not be repeated")
+		seen[result.Analyte] = true
+
+		results = append(results, result)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.tenantModels(r).LabOrders.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.tenantModels(r).LabOrders.AddResults(order, results)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidStatusTransition):
+			v.AddError("status", "results cannot be entered for a "+order.Status+" order")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	order, err = app.tenantModels(r).LabOrders.Get(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"lab_order": order}, nil)
+}
+
+// listPatientLabOrdersHandler returns the lab orders of a patient, newest first.
+func (app *application) listPatientLabOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	orders, err := app.tenantModels(r).LabOrders.GetForPatient(int64(patientID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"lab_orders": orders}, nil)
+}
+
+// labSeriesPoint is a single measurement in a patient's result history. Its time is when the
+// sample was taken.
+type labSeriesPoint struct {
+	Time    time.Time `json:"time"`
+	Value   float64   `json:"value"`
+	Flag    string    `json:"flag"`
+	RefLow  *float64  `json:"ref_low,omitempty"`
+	RefHigh *float64  `json:"ref_high,omitempty"`
+	OrderID int64     `json:"order_id"`
+}
+
+// labSeries is the history of one analyte measured in one unit, ready to be plotted. Results of
+// the same analyte in another unit form a series of their own, so values on different scales
+// are never drawn as one line.
+type labSeries struct {
+	Analyte string           `json:"analyte"`
+	Unit    string           `json:"unit"`
+	Points  []labSeriesPoint `json:"points"`
+}
+
+// getLabResultHistoryHandler returns a patient's results as one time series per analyte and
+// unit. The optional analyte, from and to (YYYY-MM-DD) parameters narrow the history down.
+func (app *application) getLabResultHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	var from, to time.Time
+	if s := qs.Get("from"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		v.Check(err == nil, "from", "must be a date in YYYY-MM-DD format")
+		from = t
+	}
+	if s := qs.Get("to"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		v.Check(err == nil, "to", "must be a date in YYYY-MM-DD format")
+		to = t.AddDate(0, 0, 1)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results, err := app.tenantModels(r).LabOrders.GetResultHistory(int64(patientID), qs.Get("analyte"), from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Results come ordered by analyte and unit, so each series is a contiguous run of rows.
+	series := []*labSeries{}
+	for _, res := range results {
+		if len(series) == 0 || series[len(series)-1].Analyte != res.Analyte || series[len(series)-1].Unit != res.Unit {
+			series = append(series, &labSeries{Analyte: res.Analyte, Unit: res.Unit})
+		}
+		s := series[len(series)-1]
+		s.Points = append(s.Points, labSeriesPoint{
+			Time:    res.SampleTime(),
+			Value:   res.Value,
+			Flag:    res.Flag,
+			RefLow:  res.RefLow,
+			RefHigh: res.RefHigh,
+			OrderID: res.OrderID,
+		})
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"series": series}, nil)
+}
@@ -20,10 +20,38 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		authorizationHeader := r.Header.Get("Authorization")
 
 		// If there is no Authorization header found, use the contextSetUser() helper to add
-		// an AnonymousUser to the request context. Then we call the next handler in the chain
-		// and return without executing any of the code below.
+		// an AnonymousUser to the request context. Requests without a user, such as the links
+		// sent to patients, name their tenant with ?tenant= or the X-Tenant header, or work on
+		// the default tenant. Then we call the next handler in the chain and return without
+		// executing any of the code below.
 		if authorizationHeader == "" {
 			r = app.contextSetUser(r, model.AnonymousUser)
+
+			slug := r.URL.Query().Get("tenant")
+			if slug == "" {
+				slug = r.Header.Get("X-Tenant")
+			}
+			explicit := slug != ""
+			if !explicit {
+				slug = app.config.tenants.defaultSlug
+			}
+			if slug != "" {
+				t, err := app.tenantBySlug(slug)
+				switch {
+				case err == nil:
+					r = app.contextSetTenant(r, t)
+				case errors.Is(err, model.ErrRecordNotFound):
+					// Without a tenant only the routes which need none are found.
+					if explicit {
+						app.notFoundResponse(w, r)
+						return
+					}
+				default:
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -64,14 +92,55 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// The request works on the data of the user's tenant.
+		t, err := app.tenantByID(user.TenantID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
 		// Call the contextSetUser healer to add the user information to the request context.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetTenant(r, t)
 
 		// Call next handler in chain
 		next.ServeHTTP(w, r)
 	})
 }
 
+// requireTenant checks that the request has a tenant, for the routes which need no user. It
+// answers 404, since without a tenant the records the request refers to don't exist.
+func (app *application) requireTenant(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetTenant(r) == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireRegistration guards user registration. Anonymous clients may only sign up on the
+// default tenant; the users of any other tenant are created by one of its activated users with
+// the user.create permission, or with clinicctl user-create. An authenticated request always
+// works on the user's own tenant, so nobody can add users to another clinic.
+func (app *application) requireRegistration(next http.HandlerFunc) http.HandlerFunc {
+	return app.requireTenant(func(w http.ResponseWriter, r *http.Request) {
+		if !app.contextGetUser(r).IsAnonymous() {
+			app.requirePermissions("user.create", next)(w, r)
+			return
+		}
+
+		if app.contextGetTenant(r).Slug != app.config.tenants.defaultSlug {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // requireAuthenticatedUser checks that the user is not anonymous (i.e., they are authenticated).
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
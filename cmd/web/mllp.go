@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"GoClinic/pkg/web/hl7"
+	"GoClinic/pkg/web/model"
+)
+
+const (
+	// mllpMaxMessageSize limits the size of a single HL7 message.
+	mllpMaxMessageSize = 1 << 20
+	// mllpIdleTimeout closes connections on which nothing was received for this long.
+	mllpIdleTimeout = 10 * time.Minute
+)
+
+// hl7Error is a processing error together with the acknowledgement code it is reported with.
+type hl7Error struct {
+	code string
+	msg  string
+}
+
+func (e *hl7Error) Error() string { return e.msg }
+
+// rejectHL7 reports a message we don't support (AR); the sender shouldn't retry it.
+func rejectHL7(format string, args ...interface{}) error {
+	return &hl7Error{code: hl7.AckReject, msg: fmt.Sprintf(format, args...)}
+}
+
+// failHL7 reports a message we support but couldn't apply (AE), e.g. because it refers to an
+// unknown appointment.
+func failHL7(format string, args ...interface{}) error {
+	return &hl7Error{code: hl7.AckError, msg: fmt.Sprintf(format, args...)}
+}
+
+// serveMLLP starts the HL7 listener. It returns once the listener is bound; connections are
+// handled in the background until ctx is cancelled, and are tracked in app.wg so that serve()
+// waits for messages being processed during shutdown.
+func (app *application) serveMLLP(ctx context.Context) error {
+	ln, err := net.Listen("tcp", app.config.mllp.addr)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	conns := make(map[net.Conn]struct{})
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		<-ctx.Done()
+
+		// Stop accepting and wake up connections waiting for their next message. Messages
+		// being processed are finished and acknowledged.
+		ln.Close()
+		mu.Lock()
+		for c := range conns {
+			c.SetReadDeadline(time.Now())
+		}
+		mu.Unlock()
+	}()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				app.logger.PrintError(err, map[string]string{"listener": "mllp"})
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			mu.Lock()
+			if ctx.Err() != nil {
+				mu.Unlock()
+				conn.Close()
+				continue
+			}
+			conns[conn] = struct{}{}
+			mu.Unlock()
+
+			app.wg.Add(1)
+			go func() {
+				defer app.wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(conns, conn)
+					mu.Unlock()
+					conn.Close()
+				}()
+				app.handleMLLPConn(ctx, conn)
+			}()
+		}
+	}()
+
+	app.logger.PrintInfo("starting MLLP listener", map[string]string{
+		"addr": ln.Addr().String(),
+	})
+
+	return nil
+}
+
+// handleMLLPConn reads messages from a connection and acknowledges each of them in turn.
+// The messages are applied to the data of the tenant configured with -mllp-tenant.
+func (app *application) handleMLLPConn(ctx context.Context, conn net.Conn) {
+	t, err := app.tenantBySlug(app.config.mllp.tenant)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"tenant": app.config.mllp.tenant})
+		return
+	}
+
+	r := bufio.NewReader(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(mllpIdleTimeout))
+		// Checked after setting the deadline, so that a shutdown which reset it in between
+		// isn't missed.
+		if ctx.Err() != nil {
+			return
+		}
+
+		raw, err := hl7.ReadFrame(r, mllpMaxMessageSize)
+		if err != nil {
+			if errors.Is(err, hl7.ErrFrameTooLarge) {
+				hl7.WriteFrame(conn, hl7.NAK("message too large"))
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) && ctx.Err() == nil {
+				app.logger.PrintError(err, map[string]string{"remote": conn.RemoteAddr().String()})
+			}
+			return
+		}
+
+		ack := app.ingestHL7(t, raw)
+
+		conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if err := hl7.WriteFrame(conn, ack); err != nil {
+			app.logger.PrintError(err, map[string]string{"remote": conn.RemoteAddr().String()})
+			return
+		}
+	}
+}
+
+// ingestHL7 stores a raw message, applies it and returns the acknowledgement to send back.
+// A message whose control ID was already processed is acknowledged again without applying it
+// twice, since senders resend messages whose ACK got lost.
+func (app *application) ingestHL7(t *tenant, raw []byte) []byte {
+	record := &model.HL7Message{Raw: string(raw)}
+
+	msg, err := hl7.Parse(raw)
+	if err != nil {
+		record.Status, record.Error = model.HL7Rejected, err.Error()
+		if err := t.models.HL7Messages.Insert(record); err == nil {
+			t.models.HL7Messages.SetResult(record)
+		}
+		return hl7.NAK(err.Error())
+	}
+
+	typ, trigger := msg.Type()
+	record.Sender = msg.Sender()
+	record.ControlID = msg.ControlID()
+	record.MessageType = typ + "^" + trigger
+
+	if record.ControlID != "" {
+		previous, err := t.models.HL7Messages.GetByControlID(record.Sender, record.ControlID)
+		if err == nil && previous.Status == model.HL7Processed {
+			return msg.ACK(hl7.AckAccept, "duplicate of message "+strconv.FormatInt(previous.ID, 10))
+		}
+	}
+
+	if err := t.models.HL7Messages.Insert(record); err != nil {
+		app.logger.PrintError(err, map[string]string{"control_id": record.ControlID})
+		return msg.ACK(hl7.AckError, "message could not be stored")
+	}
+
+	return app.applyHL7(t, msg, record)
+}
+
+// applyHL7 processes a stored message, records the outcome and returns the acknowledgement.
+// It is also used to replay stored messages.
+func (app *application) applyHL7(t *tenant, msg *hl7.Message, record *model.HL7Message) []byte {
+	code, text := hl7.AckAccept, ""
+	record.Status, record.Error = model.HL7Processed, ""
+
+	if err := app.processHL7(t, msg); err != nil {
+		var herr *hl7Error
+		switch {
+		case errors.As(err, &herr):
+			code, text = herr.code, herr.msg
+		case errors.Is(err, model.ErrSlotTaken), errors.Is(err, model.ErrDoctorUnavailable),
+			errors.Is(err, model.ErrRoomTaken), errors.Is(err, model.ErrBranchMismatch):
+			code, text = hl7.AckError, err.Error()
+		default:
+			app.logger.PrintError(err, map[string]string{"hl7_message": strconv.FormatInt(record.ID, 10)})
+			code, text = hl7.AckError, "internal error"
+		}
+
+		record.Status, record.Error = model.HL7Failed, err.Error()
+		if code == hl7.AckReject {
+			record.Status = model.HL7Rejected
+		}
+	}
+
+	if err := t.models.HL7Messages.SetResult(record); err != nil {
+		app.logger.PrintError(err, map[string]string{"hl7_message": strconv.FormatInt(record.ID, 10)})
+	}
+
+	return msg.ACK(code, text)
+}
+
+// processHL7 applies a message to our records.
+func (app *application) processHL7(t *tenant, msg *hl7.Message) error {
+	typ, trigger := msg.Type()
+
+	switch typ + "^" + trigger {
+	case "ADT^A04", "ADT^A08":
+		pid := msg.Segment("PID")
+		if pid == nil {
+			return rejectHL7("%s^%s message without PID segment", typ, trigger)
+		}
+		_, err := app.hl7Patient(t, msg, pid)
+		return err
+	case "SIU^S12":
+		return app.hl7ScheduleAppointment(t, msg)
+	case "SIU^S15":
+		return app.hl7CancelAppointment(t, msg)
+	default:
+		return rejectHL7("unsupported message type %s^%s", typ, trigger)
+	}
+}
+
+// hl7Patient creates or updates the patient identified by the first identifier in PID-3 and
+// returns our ID of the patient.
+func (app *application) hl7Patient(t *tenant, msg *hl7.Message, pid *hl7.Segment) (int, error) {
+	ids := pid.Repetitions(3)
+	if len(ids) == 0 || pid.Component(ids[0], 1) == "" {
+		return 0, rejectHL7("PID-3 patient identifier is required")
+	}
+	value := pid.Component(ids[0], 1)
+	system := pid.Component(ids[0], 4)
+	if system == "" {
+		system = msg.Sender()
+	}
+
+	lastName, firstName := pid.Get(5, 1), pid.Get(5, 2)
+	phone := pid.Get(13, 1)
+	if phone == "" {
+		phone = pid.Get(13, 12)
+	}
+	if phone == "" {
+		phone = pid.Get(13, 6) + pid.Get(13, 7)
+	}
+	// Email addresses are sent as a repetition of PID-13 with use code NET.
+	email := ""
+	for _, rep := range pid.Repetitions(13) {
+		if pid.Component(rep, 2) == "NET" {
+			email = pid.Component(rep, 4)
+		}
+	}
+
+	id, err := t.models.ExternalIDs.Get(model.ExternalPatient, system, value)
+	switch {
+	case err == nil:
+		patient, err := t.models.Patients.Get(id)
+		if err != nil {
+			return 0, err
+		}
+		if firstName != "" {
+			patient.FirstName = firstName
+		}
+		if lastName != "" {
+			patient.LastName = lastName
+		}
+		if phone != "" {
+			patient.Phone = phone
+		}
+		if email != "" {
+			patient.Email = email
+		}
+		return id, t.models.Patients.Update(patient)
+
+	case errors.Is(err, model.ErrRecordNotFound):
+		if firstName == "" || lastName == "" {
+			return 0, failHL7("PID-5 patient name is required for new patients")
+		}
+		patient := &model.Patient{FirstName: firstName, LastName: lastName, Phone: phone, Email: email}
+		if err := t.models.Patients.Insert(patient); err != nil {
+			return 0, err
+		}
+		id, _ = strconv.Atoi(patient.Id)
+		return id, t.models.ExternalIDs.Set(model.ExternalPatient, system, value, id)
+
+	default:
+		return 0, err
+	}
+}
+
+// hl7Doctor finds the doctor of a scheduling message from the first AIP segment, or PV1-7. A
+// doctor the sender hasn't referred to before is matched by name and remembered.
+func (app *application) hl7Doctor(t *tenant, msg *hl7.Message) (int, error) {
+	var seg *hl7.Segment
+	field := 3
+	if seg = msg.Segment("AIP"); seg == nil {
+		if seg = msg.Segment("PV1"); seg == nil {
+			return 0, failHL7("AIP or PV1 segment with the practitioner is required")
+		}
+		field = 7
+	}
+
+	value, lastName, firstName := seg.Get(field, 1), seg.Get(field, 2), seg.Get(field, 3)
+	system := msg.Sender()
+
+	if value != "" {
+		id, err := t.models.ExternalIDs.Get(model.ExternalDoctor, system, value)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, model.ErrRecordNotFound) {
+			return 0, err
+		}
+	}
+
+	doctors, err := t.models.Doctors.GetByName(firstName, lastName)
+	if err != nil {
+		return 0, err
+	}
+	if len(doctors) != 1 {
+		return 0, failHL7("unknown practitioner %s %s (%s)", firstName, lastName, value)
+	}
+
+	id, _ := strconv.Atoi(doctors[0].Id)
+	if value != "" {
+		if err := t.models.ExternalIDs.Set(model.ExternalDoctor, system, value, id); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// hl7AppointmentID returns the sender's appointment ID from SCH-2 (filler) or SCH-1 (placer).
+func hl7AppointmentID(msg *hl7.Message, sch *hl7.Segment) (system, value string) {
+	for _, field := range []int{2, 1} {
+		if value = sch.Get(field, 1); value != "" {
+			system = sch.Get(field, 2)
+			if system == "" {
+				system = msg.Sender()
+			}
+			return system, value
+		}
+	}
+	return "", ""
+}
+
+// hl7ScheduleAppointment books the appointment of an SIU^S12 message. A message for an
+// appointment we already know updates it instead.
+func (app *application) hl7ScheduleAppointment(t *tenant, msg *hl7.Message) error {
+	sch := msg.Segment("SCH")
+	if sch == nil {
+		return rejectHL7("SIU message without SCH segment")
+	}
+	system, value := hl7AppointmentID(msg, sch)
+	if value == "" {
+		return rejectHL7("SCH-1 or SCH-2 appointment identifier is required")
+	}
+
+	startValue := sch.Get(11, 4)
+	if ais := msg.Segment("AIS"); ais != nil && ais.Get(4, 1) != "" {
+		startValue = ais.Get(4, 1)
+	}
+	start, err := hl7.ParseTime(startValue, time.Local)
+	if err != nil {
+		return failHL7("invalid appointment start %q", startValue)
+	}
+
+	pid := msg.Segment("PID")
+	if pid == nil {
+		return rejectHL7("SIU message without PID segment")
+	}
+	patientID, err := app.hl7Patient(t, msg, pid)
+	if err != nil {
+		return err
+	}
+
+	doctorID, err := app.hl7Doctor(t, msg)
+	if err != nil {
+		return err
+	}
+
+	id, err := t.models.ExternalIDs.Get(model.ExternalAppointment, system, value)
+	switch {
+	case err == nil:
+		appointment, err := t.models.Appointments.Get(id)
+		if err != nil {
+			return err
+		}
+		appointment.DateTime = start.Format(time.RFC3339)
+		appointment.PatientID = patientID
+		appointment.DoctorID = doctorID
+		return t.models.Appointments.Update(appointment)
+
+	case errors.Is(err, model.ErrRecordNotFound):
+		appointment := &model.Appointment{
+			DateTime:  start.Format(time.RFC3339),
+			PatientID: patientID,
+			DoctorID:  doctorID,
+		}
+		if err := t.models.Appointments.Insert(appointment); err != nil {
+			return err
+		}
+		id, _ = strconv.Atoi(appointment.Id)
+		return t.models.ExternalIDs.Set(model.ExternalAppointment, system, value, id)
+
+	default:
+		return err
+	}
+}
+
+// hl7CancelAppointment cancels the appointment of an SIU^S15 message.
+func (app *application) hl7CancelAppointment(t *tenant, msg *hl7.Message) error {
+	sch := msg.Segment("SCH")
+	if sch == nil {
+		return rejectHL7("SIU message without SCH segment")
+	}
+	system, value := hl7AppointmentID(msg, sch)
+	if value == "" {
+		return rejectHL7("SCH-1 or SCH-2 appointment identifier is required")
+	}
+
+	id, err := t.models.ExternalIDs.Get(model.ExternalAppointment, system, value)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			return failHL7("unknown appointment %s", value)
+		}
+		return err
+	}
+
+	appointment, err := t.models.Appointments.Get(id)
+	if err != nil {
+		return err
+	}
+	if appointment.Status == model.AppointmentCancelled {
+		return nil
+	}
+
+	if err := t.models.Appointments.Cancel(id); err != nil {
+		return err
+	}
+	app.slotFreed(t, appointment.DoctorID, appointment.DateTime)
+	return nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/pdf"
+	"GoClinic/pkg/web/qrcode"
+	"GoClinic/pkg/web/validator"
+	"github.com/gorilla/mux"
+)
+
+// searchDrugsHandler searches the drug catalogue by name or active substance prefix.
+func (app *application) searchDrugsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	text := app.readStrings(qs, "q", "")
+	limit := app.readInt(qs, "limit", 20, v)
+
+	v.Check(text != "", "q", "must be provided")
+	v.Check(limit > 0 && limit <= 100, "limit", "must be between 1 and 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	drugs, err := app.tenantModels(r).Drugs.Search(text, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"drugs": drugs}, nil)
+}
+
+// createPrescriptionHandler issues a new signed prescription.
+func (app *application) createPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PatientID      int64  `json:"patient_id"`
+		DoctorID       int64  `json:"doctor_id"`
+		AppointmentID  *int64 `json:"appointment_id"`
+		DrugID         int64  `json:"drug_id"`
+		Dose           string `json:"dose"`
+		Frequency      string `json:"frequency"`
+		DurationDays   int    `json:"duration_days"`
+		Quantity       int    `json:"quantity"`
+		OverrideReason string `json:"override_reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	prescription := &model.Prescription{
+		PatientID:     input.PatientID,
+		DoctorID:      input.DoctorID,
+		AppointmentID: input.AppointmentID,
+		DrugID:        input.DrugID,
+		Dose:          input.Dose,
+		Frequency:     input.Frequency,
+		DurationDays:  input.DurationDays,
+		Quantity:      input.Quantity,
+	}
+
+	v := validator.New()
+
+	if model.ValidatePrescription(v, prescription); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	drug, err := app.checkPrescriptionReferences(r, v, prescription)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	conflicts, err := app.prescriptionConflicts(r, prescription.PatientID, drug)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A conflicting prescription is only issued if the doctor explicitly states why, and that
+	// decision is kept for audit.
+	var override *model.PrescriptionOverride
+	if len(conflicts) > 0 {
+		if strings.TrimSpace(input.OverrideReason) == "" {
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{
+				"conflicts":       conflicts,
+				"override_reason": "must be provided to prescribe despite the conflicts",
+			})
+			return
+		}
+
+		override = &model.PrescriptionOverride{
+			UserID:    app.contextGetUser(r).ID,
+			Reason:    strings.TrimSpace(input.OverrideReason),
+			Conflicts: conflicts,
+		}
+	}
+
+	err = prescription.Sign(app.config.signingKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.tenantModels(r).Prescriptions.Insert(prescription, override)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/prescriptions/%d", prescription.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"prescription": prescription, "override": override}, headers)
+}
+
+// checkPrescriptionReferences makes sure the patient, doctor, drug and appointment referenced
+// by a prescription exist and fit together, adding validation errors if they don't. The drug
+// name and form are copied onto the prescription and the drug is returned.
+func (app *application) checkPrescriptionReferences(r *http.Request, v *validator.Validator, p *model.Prescription) (*model.Drug, error) {
+	_, err := app.tenantModels(r).Patients.Get(int(p.PatientID))
+	if err != nil {
+		if !errors.Is(err, model.ErrRecordNotFound) {
+			return nil, err
+		}
+		v.AddError("patient_id", "patient does not exist")
+	}
+
+	_, err = app.tenantModels(r).Doctors.Get(int(p.DoctorID))
+	if err != nil {
+		if !errors.Is(err, model.ErrRecordNotFound) {
+			return nil, err
+		}
+		v.AddError("doctor_id", "doctor does not exist")
+	}
+
+	drug, err := app.tenantModels(r).Drugs.Get(p.DrugID)
+	if err != nil {
+		if !errors.Is(err, model.ErrRecordNotFound) {
+			return nil, err
+		}
+		v.AddError("drug_id", "drug is not in the catalogue")
+	} else {
+		p.DrugName = drug.Name
+		if drug.Strength != "" {
+			p.DrugName += " " + drug.Strength
+		}
+		p.Form = drug.Form
+	}
+
+	if p.AppointmentID != nil {
+		appointment, err := app.tenantModels(r).Appointments.Get(int(*p.AppointmentID))
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("appointment_id", "appointment does not exist")
+		case err != nil:
+			return nil, err
+		case int64(appointment.PatientID) != p.PatientID:
+			v.AddError("appointment_id", "appointment belongs to another patient")
+		}
+	}
+
+	return drug, nil
+}
+
+// prescriptionConflicts checks a drug against the patient's recorded allergies and against the
+// prescriptions the patient is still taking, see model.PrescriptionConflicts. The interactions
+// with all of those prescriptions are loaded at once.
+func (app *application) prescriptionConflicts(r *http.Request, patientID int64, drug *model.Drug) ([]model.PrescriptionConflict, error) {
+	models := app.tenantModels(r)
+
+	allergies, err := models.Allergies.GetForPatient(patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := models.Prescriptions.GetActiveSubstances(patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var interactions []*model.Interaction
+	if len(active) > 0 {
+		var others []string
+		for _, substances := range active {
+			others = append(others, substances...)
+		}
+		interactions, err = models.Interactions.Between(drug.Substances(), others)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return model.PrescriptionConflicts(drug, allergies, active, interactions), nil
+}
+
+// listPrescriptionOverridesHandler returns the prescriptions issued despite allergy or
+// interaction warnings within a date range (default: the last 30 days), for audit.
+func (app *application) listPrescriptionOverridesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+
+	if s := qs.Get("from"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		v.Check(err == nil, "from", "must be a date in YYYY-MM-DD format")
+		from = t
+	}
+	if s := qs.Get("to"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		v.Check(err == nil, "to", "must be a date in YYYY-MM-DD format")
+		to = t.AddDate(0, 0, 1)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	overrides, err := app.tenantModels(r).Prescriptions.GetOverrides(from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"overrides": overrides}, nil)
+}
+
+// getPrescriptionHandler returns a single prescription.
+func (app *application) getPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	prescription, err := app.tenantModels(r).Prescriptions.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"prescription": prescription}, nil)
+}
+
+// listPatientPrescriptionsHandler returns all prescriptions of a patient.
+func (app *application) listPatientPrescriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	prescriptions, err := app.tenantModels(r).Prescriptions.GetForPatient(int64(patientID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"prescriptions": prescriptions}, nil)
+}
+
+// prescriptionPDFHandler renders a prescription as a printable PDF. The printout carries the
+// verification code, the beginning of the signature and a QR code linking to the public
+// verification endpoint.
+func (app *application) prescriptionPDFHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	prescription, err := app.tenantModels(r).Prescriptions.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	patient, err := app.tenantModels(r).Patients.Get(int(prescription.PatientID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	doctor, err := app.tenantModels(r).Doctors.Get(int(prescription.DoctorID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	verifyURL := app.config.baseURL + "/api/v1/prescriptions/verify/" + prescription.VerificationCode +
+		tenantQuery(app.contextGetTenant(r))
+
+	qr, err := qrcode.Encode([]byte(verifyURL))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	doc := pdf.New(fmt.Sprintf("Prescription %d", prescription.ID))
+	page := doc.AddPage()
+
+	y := pdf.PageHeight - 72
+	line := func(size float64, bold bool, text string) {
+		page.Text(56, y, size, bold, text)
+		y -= size * 1.6
+	}
+
+	line(20, true, "Prescription")
+	line(10, false, fmt.Sprintf("No. %d, issued %s", prescription.ID, prescription.CreatedAt.Format("02.01.2006")))
+	y -= 10
+	line(12, true, "Patient")
+	line(11, false, patient.FirstName+" "+patient.LastName)
+	y -= 6
+	line(12, true, "Prescribing doctor")
+	line(11, false, fmt.Sprintf("%s %s, %s", doctor.FirstName, doctor.LastName, doctor.Speciality))
+	y -= 6
+	page.Line(56, y+8, pdf.PageWidth-56, y+8, 0.5)
+	y -= 10
+	line(14, true, "Rx  "+prescription.DrugName)
+	line(11, false, "Form: "+prescription.Form)
+	line(11, false, "Dose: "+prescription.Dose)
+	line(11, false, "Frequency: "+prescription.Frequency)
+	line(11, false, fmt.Sprintf("Duration: %d days", prescription.DurationDays))
+	line(11, false, fmt.Sprintf("Quantity: %d", prescription.Quantity))
+	y -= 6
+	page.Line(56, y+8, pdf.PageWidth-56, y+8, 0.5)
+	y -= 10
+	line(10, false, "Verification code: "+prescription.VerificationCode)
+	line(10, false, "Signature: "+hex.EncodeToString(prescription.Signature)[:32])
+	line(8, false, verifyURL)
+
+	// Draw the QR code in the top right corner, one module is 3 points wide.
+	const module = 3.0
+	x0 := pdf.PageWidth - 56 - float64(qr.Size)*module
+	y0 := pdf.PageHeight - 56
+	for row := 0; row < qr.Size; row++ {
+		for col := 0; col < qr.Size; col++ {
+			if qr.Modules[row][col] {
+				page.Rect(x0+float64(col)*module, y0-float64(row+1)*module, module, module)
+			}
+		}
+	}
+
+	// Render the whole document first, so text the font can't print becomes an error response
+	// rather than a truncated file.
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename=prescription-"+strconv.FormatInt(prescription.ID, 10)+".pdf")
+
+	w.Write(out.Bytes())
+}
+
+// verifyPrescriptionHandler is the public endpoint behind the QR code on printed prescriptions.
+// It tells a pharmacist whether a prescription with the code exists and whether its contents
+// still match the signature. Only the data needed to compare against the printout is returned.
+func (app *application) verifyPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+
+	prescription, err := app.tenantModels(r).Prescriptions.GetByVerificationCode(code)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.writeJSON(w, http.StatusNotFound, envelope{"valid": false, "error": "unknown verification code"}, nil)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !prescription.Verify(app.config.signingKey) {
+		app.writeJSON(w, http.StatusOK, envelope{"valid": false, "error": "signature does not match"}, nil)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{
+		"valid": true,
+		"prescription": envelope{
+			"id":            prescription.ID,
+			"issued_at":     prescription.CreatedAt,
+			"drug_name":     prescription.DrugName,
+			"form":          prescription.Form,
+			"dose":          prescription.Dose,
+			"frequency":     prescription.Frequency,
+			"duration_days": prescription.DurationDays,
+			"quantity":      prescription.Quantity,
+		},
+	}, nil)
+}
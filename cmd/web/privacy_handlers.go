@@ -0,0 +1,304 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// patientDataFile is a file of a patient's data export.
+type patientDataFile struct {
+	name string
+	data interface{}
+}
+
+// patientDataExportHandler sends everything the clinic holds about a patient as a ZIP file:
+// their details, appointments, diagnoses, prescriptions, allergies, lab orders with their
+// results, insurance policies and invoices as JSON, and their documents as they were uploaded.
+func (app *application) patientDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	models := app.tenantModels(r)
+	patient, err := models.Patients.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	files, documents, err := app.patientData(models, int64(id), patient)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": fmt.Sprintf("patient-%d-%s.zip", id, time.Now().Format("2006-01-02"))}))
+	w.Header().Set("Cache-Control", "no-store")
+
+	zw := zip.NewWriter(w)
+	if err := writePatientData(zw, files); err != nil {
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+	for _, doc := range documents {
+		if err := app.writePatientDocument(r, zw, doc); err != nil {
+			// The headers are already sent, so the archive is cut short.
+			app.logError(r, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// patientData collects the records of a patient for their data export, and their documents.
+func (app *application) patientData(models model.Models, id int64, patient *model.Patient) ([]patientDataFile, []*model.Document, error) {
+	privacy, err := models.Patients.Privacy(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	appointments, err := models.Appointments.Get_By_Patient(int(id), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	diagnoses, err := models.Diagnoses.GetProblemHistory(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	prescriptions, err := models.Prescriptions.GetForPatient(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	allergies, err := models.Allergies.GetForPatient(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	policies, err := models.Insurance.PatientPolicies(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	documents, err := models.Documents.GetForPatient(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The lists of lab orders and invoices leave out their results and lines.
+	orders, err := models.LabOrders.GetForPatient(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	labOrders := make([]*model.LabOrder, 0, len(orders))
+	for _, order := range orders {
+		order, err := models.LabOrders.Get(order.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		labOrders = append(labOrders, order)
+	}
+
+	invoices := []*model.Invoice{}
+	for offset := 0; ; offset += 100 {
+		page, err := models.Invoices.GetAll(model.InvoiceFilter{PatientID: id, Limit: 100, Offset: offset})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, inv := range page {
+			inv, err := models.Invoices.Get(inv.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			invoices = append(invoices, inv)
+		}
+		if len(page) < 100 {
+			break
+		}
+	}
+
+	files := []patientDataFile{
+		{"patient.json", envelope{"patient": patient, "privacy": privacy, "exported_at": time.Now()}},
+		{"appointments.json", appointments},
+		{"diagnoses.json", diagnoses},
+		{"prescriptions.json", prescriptions},
+		{"allergies.json", allergies},
+		{"lab_orders.json", labOrders},
+		{"insurance_policies.json", policies},
+		{"invoices.json", invoices},
+		{"documents.json", documents},
+	}
+	return files, documents, nil
+}
+
+// writePatientData writes the JSON files of a patient's data export.
+func writePatientData(zw *zip.Writer, files []patientDataFile) error {
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		js, err := json.MarshalIndent(file.data, "", "\t")
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(js, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePatientDocument copies a document from the storage into the documents folder of a
+// patient's data export, prefixed with its ID so that names don't collide.
+func (app *application) writePatientDocument(r *http.Request, zw *zip.Writer, doc *model.Document) error {
+	body, err := app.storage.Get(r.Context(), doc.StorageKey())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	name := path.Base(strings.ReplaceAll(doc.Filename, `\`, "/"))
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     fmt.Sprintf("documents/%d-%s", doc.ID, name),
+		Method:   zip.Deflate,
+		Modified: doc.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	return err
+}
+
+// showPatientPrivacyHandler returns a patient's legal hold and whether they have been
+// anonymized.
+func (app *application) showPatientPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	privacy, err := app.tenantModels(r).Patients.Privacy(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"privacy": privacy}, nil)
+}
+
+// setLegalHoldHandler puts a patient under legal hold, until a date or until it is lifted.
+func (app *application) setLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string  `json:"reason"`
+		Until  *string `json:"until"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	privacy := &model.PatientPrivacy{PatientID: int64(id), LegalHoldReason: input.Reason, LegalHoldUntil: input.Until}
+
+	v := validator.New()
+	if model.ValidateLegalHold(v, privacy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.updateLegalHold(w, r, privacy)
+}
+
+// liftLegalHoldHandler lifts the legal hold of a patient.
+func (app *application) liftLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.updateLegalHold(w, r, &model.PatientPrivacy{PatientID: int64(id)})
+}
+
+func (app *application) updateLegalHold(w http.ResponseWriter, r *http.Request, privacy *model.PatientPrivacy) {
+	if err := app.tenantModels(r).Patients.SetLegalHold(privacy); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"privacy": privacy}, nil)
+}
+
+// anonymizePatientHandler irreversibly anonymizes a patient, and removes the contents of their
+// documents from the storage.
+func (app *application) anonymizePatientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	privacy, orphaned, err := app.tenantModels(r).Patients.Anonymize(int64(id), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrAnonymized):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, model.ErrLegalHold), errors.Is(err, model.ErrUpcomingAppointments),
+			errors.Is(err, model.ErrOutstandingBalance):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, key := range orphaned {
+		if err := app.storage.Delete(r.Context(), key); err != nil {
+			// The patient is anonymized; the contents left behind can't be found any more.
+			app.logError(r, err)
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"privacy": privacy}, nil)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+	"github.com/lib/pq"
+)
+
+// Timing of the queue event streams. The heartbeat keeps proxies from closing idle streams
+// and lets streams notice that the day has changed.
+const (
+	queueHeartbeat    = 25 * time.Second
+	queueListenerPing = 90 * time.Second
+	queueRetry        = 3 * time.Second
+)
+
+// queueSubscriber is an event stream connected to this server, following the tickets of a
+// tenant's doctor and/or branch, or all of the tenant's tickets if both are zero. resync is signalled when the stream
+// may have missed changes and should send the whole queue again.
+type queueSubscriber struct {
+	tenantID int64
+	doctorID int64
+	branchID int64
+	tickets  chan *model.QueueTicket
+	resync   chan struct{}
+}
+
+// queueHub fans the queue notifications of the database out to the event streams connected to
+// this server. Every server listens to the database itself, so displays can connect to any of
+// them and see the changes made through the others.
+type queueHub struct {
+	mu     sync.Mutex
+	subs   map[*queueSubscriber]struct{}
+	closed bool
+}
+
+func newQueueHub() *queueHub {
+	return &queueHub{subs: make(map[*queueSubscriber]struct{})}
+}
+
+// subscribe adds an event stream. The channels of the subscriber are closed when the hub is.
+func (h *queueHub) subscribe(tenantID, doctorID, branchID int64) *queueSubscriber {
+	s := &queueSubscriber{
+		tenantID: tenantID,
+		doctorID: doctorID,
+		branchID: branchID,
+		tickets:  make(chan *model.QueueTicket, 64),
+		resync:   make(chan struct{}, 1),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.tickets)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+func (h *queueHub) unsubscribe(s *queueSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, s)
+}
+
+// publish passes a changed ticket of a tenant to the streams which follow it. Streams which
+// can't keep up are told to resync instead of holding up the others.
+func (h *queueHub) publish(tenantID int64, t *model.QueueTicket) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.tenantID != tenantID {
+			continue
+		}
+		if s.doctorID != 0 && s.doctorID != t.DoctorID {
+			continue
+		}
+		if s.branchID != 0 && (t.BranchID == nil || *t.BranchID != s.branchID) {
+			continue
+		}
+		select {
+		case s.tickets <- t:
+		default:
+			s.signalResync()
+		}
+	}
+}
+
+// resyncAll tells every stream to send the whole queue again, after notifications may have
+// been lost.
+func (h *queueHub) resyncAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		s.signalResync()
+	}
+}
+
+func (s *queueSubscriber) signalResync() {
+	select {
+	case s.resync <- struct{}{}:
+	default:
+	}
+}
+
+// close ends all streams.
+func (h *queueHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		close(s.tickets)
+		delete(h.subs, s)
+	}
+}
+
+// startQueueEvents listens to the queue notifications of the database and publishes the
+// changed tickets until ctx is cancelled, when it ends all event streams.
+func (app *application) startQueueEvents(ctx context.Context) {
+	listener := pq.NewListener(app.config.db.dsn, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"channel": model.QueueChannel})
+		}
+	})
+	if err := listener.Listen(model.QueueChannel); err != nil {
+		app.logger.PrintError(err, map[string]string{"channel": model.QueueChannel})
+	}
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer listener.Close()
+		defer app.queue.close()
+
+		ping := time.NewTicker(queueListenerPing)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification means that the connection was re-established, and
+				// notifications sent in the meantime are lost.
+				if n == nil {
+					app.queue.resyncAll()
+					continue
+				}
+				app.publishQueueTicket(n.Extra)
+			case <-ping.C:
+				if err := listener.Ping(); err != nil {
+					app.logger.PrintError(err, map[string]string{"channel": model.QueueChannel})
+				}
+			}
+		}
+	}()
+}
+
+// publishQueueTicket publishes the ticket of a notification, whose payload is the ID of the
+// tenant and the ticket separated by a colon.
+func (app *application) publishQueueTicket(payload string) {
+	tenantPart, idPart, _ := strings.Cut(payload, ":")
+	tenantID, err := strconv.ParseInt(tenantPart, 10, 64)
+	if err != nil {
+		app.logger.PrintError(fmt.Errorf("invalid queue notification %q", payload), nil)
+		return
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		app.logger.PrintError(fmt.Errorf("invalid queue notification %q", payload), nil)
+		return
+	}
+
+	tn, err := app.tenantByID(tenantID)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"ticket_id": idPart, "tenant_id": tenantPart})
+		return
+	}
+
+	t, err := tn.models.Queue.Get(id)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"ticket_id": idPart, "tenant": tn.Slug})
+		return
+	}
+
+	app.queue.publish(tenantID, t)
+}
+
+// boardTicket is a ticket as the waiting room display shows it, without the patient.
+type boardTicket struct {
+	ID         int64      `json:"id"`
+	Date       string     `json:"date"`
+	BranchID   *int64     `json:"branch_id,omitempty"`
+	Number     int        `json:"number"`
+	DoctorID   int64      `json:"doctor_id"`
+	DoctorName string     `json:"doctor_name"`
+	Status     string     `json:"status"`
+	CalledAt   *time.Time `json:"called_at,omitempty"`
+}
+
+func newBoardTicket(t *model.QueueTicket) boardTicket {
+	return boardTicket{
+		ID:         t.ID,
+		Date:       t.Date,
+		BranchID:   t.BranchID,
+		Number:     t.Number,
+		DoctorID:   t.DoctorID,
+		DoctorName: t.DoctorName,
+		Status:     t.Status,
+		CalledAt:   t.CalledAt,
+	}
+}
+
+// queueEventsHandler streams the queue of today to the doctors' screens as Server-Sent
+// Events, for all doctors or with ?doctor_id= for one, and with ?branch_id= for one branch.
+func (app *application) queueEventsHandler(w http.ResponseWriter, r *http.Request) {
+	app.streamQueue(w, r, false)
+}
+
+// queueBoardEventsHandler streams the queue of today to the waiting room display of a branch.
+// The tickets carry their number and doctor but nothing about the patient, so it needs no
+// login.
+func (app *application) queueBoardEventsHandler(w http.ResponseWriter, r *http.Request) {
+	app.streamQueue(w, r, true)
+}
+
+// streamQueue sends a "snapshot" event with all tickets of the day when the stream starts and
+// whenever it may have missed changes or the day has changed, and a "ticket" event for every
+// issued or changed ticket. Since the snapshot is read from the database, clients only need to
+// reconnect after a restart.
+func (app *application) streamQueue(w http.ResponseWriter, r *http.Request, board bool) {
+	v := validator.New()
+	doctorID := int64(app.readInt(r.URL.Query(), "doctor_id", 0, v))
+	branchID := app.readBranchID(r.URL.Query(), v)
+	v.Check(doctorID >= 0, "doctor_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The day of the queue is the day at the branch.
+	loc, err := app.branchLocation(r, branchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"branch_id": "branch does not exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Event streams stay open much longer than the server's write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sub := app.queue.subscribe(app.contextGetTenant(r).ID, doctorID, branchID)
+	defer app.queue.unsubscribe(sub)
+
+	day := model.QueueDate(time.Now(), loc)
+	tickets, err := app.tenantModels(r).Queue.GetAll(day, doctorID, branchID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, data interface{}) error {
+		js, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, js); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	snapshot := func(tickets []*model.QueueTicket) error {
+		if !board {
+			return send("snapshot", envelope{"date": day, "tickets": tickets})
+		}
+		shown := make([]boardTicket, len(tickets))
+		for i, t := range tickets {
+			shown[i] = newBoardTicket(t)
+		}
+		return send("snapshot", envelope{"date": day, "tickets": shown})
+	}
+
+	resend := func() error {
+		tickets, err := app.tenantModels(r).Queue.GetAll(day, doctorID, branchID)
+		if err != nil {
+			return err
+		}
+		return snapshot(tickets)
+	}
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", queueRetry.Milliseconds()); err != nil {
+		return
+	}
+	if err := snapshot(tickets); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(queueHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case t, ok := <-sub.tickets:
+			if !ok {
+				return
+			}
+			switch {
+			case t.Date != day:
+				// Tickets of other days are only changed by mistake; a ticket of a new day
+				// means that the stream still shows the previous one.
+				if today := model.QueueDate(time.Now(), loc); today != day {
+					day = today
+					err = resend()
+				}
+			case board:
+				err = send("ticket", newBoardTicket(t))
+			default:
+				err = send("ticket", t)
+			}
+		case <-sub.resync:
+			err = resend()
+		case <-heartbeat.C:
+			if today := model.QueueDate(time.Now(), loc); today != day {
+				day = today
+				err = resend()
+			} else if _, err = fmt.Fprint(w, ": ping\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		}
+		if err != nil {
+			// Writes fail when the client has gone away, which is no error.
+			if r.Context().Err() == nil {
+				app.logger.PrintError(err, map[string]string{"stream": r.URL.Path})
+			}
+			return
+		}
+	}
+}
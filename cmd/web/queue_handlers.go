@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// createQueueTicketHandler checks a patient in at reception: a walk-in for a doctor, either a
+// known patient or just a name, or a patient arriving for today's appointment. The ticket gets
+// the next number of the day at its branch: the given one, the appointment's, or the doctor's
+// if the doctor works at only one.
+func (app *application) createQueueTicketHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		BranchID      *int64 `json:"branch_id"`
+		DoctorID      int64  `json:"doctor_id"`
+		PatientID     *int64 `json:"patient_id"`
+		AppointmentID *int64 `json:"appointment_id"`
+		Name          string `json:"name"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	ticket := &model.QueueTicket{
+		BranchID:      input.BranchID,
+		DoctorID:      input.DoctorID,
+		PatientID:     input.PatientID,
+		AppointmentID: input.AppointmentID,
+		Name:          input.Name,
+		CreatedBy:     &userID,
+	}
+
+	v := validator.New()
+	model.ValidateQueueTicket(v, ticket)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var start time.Time
+	if ticket.AppointmentID != nil {
+		appointment, err := app.tenantModels(r).Appointments.Get(int(*ticket.AppointmentID))
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				app.failedValidationResponse(w, r, map[string]string{"appointment_id": "appointment does not exist"})
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if start, err = time.Parse(time.RFC3339, appointment.DateTime); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		ticket.DoctorID = int64(appointment.DoctorID)
+		if ticket.BranchID == nil {
+			ticket.BranchID = appointment.BranchID
+		}
+	}
+	if ticket.BranchID == nil {
+		branchID, err := app.doctorBranch(r, ticket.DoctorID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if branchID != 0 {
+			ticket.BranchID = &branchID
+		}
+	}
+
+	var branchID int64
+	if ticket.BranchID != nil {
+		branchID = *ticket.BranchID
+	}
+	loc, err := app.branchLocation(r, branchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"branch_id": "branch does not exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The day of the queue is the day at the branch.
+	day := model.QueueDate(time.Now(), loc)
+	if ticket.AppointmentID != nil {
+		if model.QueueDate(start, loc) != day {
+			app.failedValidationResponse(w, r, map[string]string{"appointment_id": "appointment is not today"})
+			return
+		}
+	}
+
+	if err := app.tenantModels(r).Queue.Issue(ticket, day); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound) && ticket.AppointmentID != nil:
+			app.failedValidationResponse(w, r, map[string]string{"appointment_id": "appointment is cancelled"})
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"doctor_id": "doctor or patient does not exist"})
+		case errors.Is(err, model.ErrBranchMismatch):
+			app.failedValidationResponse(w, r, map[string]string{"branch_id": "doctor does not work at this branch"})
+		case errors.Is(err, model.ErrAlreadyCheckedIn):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/queue/tickets/%d", ticket.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"ticket": ticket}, headers)
+}
+
+// listQueueTicketsHandler returns the tickets of today, or of ?date=, optionally only those
+// of ?doctor_id= or ?branch_id=.
+func (app *application) listQueueTicketsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+	doctorID := app.readInt(qs, "doctor_id", 0, v)
+	branchID := app.readBranchID(qs, v)
+	v.Check(doctorID >= 0, "doctor_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	loc, err := app.branchLocation(r, branchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"branch_id": "branch does not exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	day := app.readStrings(qs, "date", model.QueueDate(time.Now(), loc))
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"date": "must be a date such as 2024-06-14"})
+		return
+	}
+
+	tickets, err := app.tenantModels(r).Queue.GetAll(day, int64(doctorID), branchID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"date": day, "tickets": tickets}, nil)
+}
+
+func (app *application) showQueueTicketHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ticket, err := app.tenantModels(r).Queue.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"ticket": ticket}, nil)
+}
+
+// updateQueueTicketHandler moves a ticket on: calls it, sends it back to the waiting patients,
+// finishes it or records that the patient didn't show up.
+func (app *application) updateQueueTicketHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(input.Status, model.TicketWaiting, model.TicketCalled, model.TicketDone, model.TicketNoShow),
+		"status", "must be waiting, called, done or no_show")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ticket, err := app.tenantModels(r).Queue.SetStatus(int64(id), input.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrTicketTransition):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"ticket": ticket}, nil)
+}
+
+// callNextQueueTicketHandler finishes the patient a doctor is seeing and calls the next one in
+// the doctor's queue of today, at ?branch_id= or at all branches.
+func (app *application) callNextQueueTicketHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := app.tenantModels(r).Doctors.Get(id); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	branchID := app.readBranchID(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	loc, err := app.branchLocation(r, branchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"branch_id": "branch does not exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ticket, err := app.tenantModels(r).Queue.CallNext(int64(id), branchID, model.QueueDate(time.Now(), loc))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.writeJSON(w, http.StatusOK, envelope{"ticket": nil, "message": "nobody is waiting"}, nil)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"ticket": ticket}, nil)
+}
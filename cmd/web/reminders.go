@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/notify"
+)
+
+// Reminder delivery limits.
+const (
+	reminderBatchSize   = 100
+	reminderMaxAttempts = 3
+	reminderRetryAfter  = 5 * time.Minute
+	reminderSendTimeout = 30 * time.Second
+)
+
+// startReminders starts the reminder scheduler, which runs until ctx is cancelled. Several
+// replicas may run it at the same time: every reminder is claimed in the database before it
+// is sent, so none is sent twice.
+func (app *application) startReminders(ctx context.Context) {
+	if len(app.config.reminders.offsets) == 0 {
+		return
+	}
+	if len(app.notifiers) == 0 {
+		app.logger.PrintInfo("appointment reminders are disabled, no SMTP server or SMS gateway configured", nil)
+		return
+	}
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(app.config.reminders.interval)
+		defer ticker.Stop()
+
+		for {
+			app.sendDueReminders(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// sendDueReminders sends the reminders of every tenant which are due now.
+func (app *application) sendDueReminders(ctx context.Context) {
+	tenants, err := app.allTenants()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, t := range tenants {
+		if ctx.Err() != nil {
+			return
+		}
+		app.sendTenantReminders(ctx, t)
+	}
+}
+
+// sendTenantReminders sends the reminders of a tenant which are due now.
+func (app *application) sendTenantReminders(ctx context.Context, t *tenant) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"tenant": t.Slug})
+		}
+	}()
+
+	offsets := make([]int, len(app.config.reminders.offsets))
+	for i, d := range app.config.reminders.offsets {
+		offsets[i] = int(d / time.Minute)
+	}
+	channels := make([]string, 0, len(app.notifiers))
+	for channel := range app.notifiers {
+		channels = append(channels, channel)
+	}
+
+	reminders, err := t.models.Reminders.ClaimDue(offsets, channels, reminderMaxAttempts, reminderRetryAfter,
+		reminderBatchSize)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"tenant": t.Slug})
+		return
+	}
+
+	for _, reminder := range reminders {
+		// Reminders which were claimed but can't be sent before shutdown are put back as
+		// failed, so that they are retried after the restart.
+		if ctx.Err() != nil {
+			reminder.Status, reminder.Error = model.ReminderFailed, "not sent before shutdown"
+		} else {
+			app.sendReminder(t, reminder)
+		}
+
+		if err := t.models.Reminders.Finish(reminder); err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"appointment_id": fmt.Sprint(reminder.AppointmentID),
+				"channel":        reminder.Channel,
+			})
+		}
+	}
+}
+
+// sendReminder sends a claimed reminder and sets its outcome.
+func (app *application) sendReminder(t *tenant, reminder *model.Reminder) {
+	var to string
+	switch reminder.Channel {
+	case notify.ChannelEmail:
+		to = reminder.Patient.Email
+	case notify.ChannelSMS:
+		to = reminder.Patient.Phone
+	}
+	if to == "" {
+		reminder.Status, reminder.Error = model.ReminderSkipped, "patient has no "+reminder.Channel+" address"
+		return
+	}
+
+	// The links in the reminder work until the appointment starts.
+	token, err := t.models.Tokens.NewForAppointment(reminder.AppointmentID, reminder.DateTime)
+	if err != nil {
+		reminder.Status, reminder.Error = model.ReminderFailed, err.Error()
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	msg := reminderMessage(reminder, app.appointmentActionURL(t, token.Plaintext, "confirm"),
+		app.appointmentActionURL(t, token.Plaintext, "cancel"))
+	msg.To = to
+
+	// Sending isn't tied to the scheduler's context, so that a reminder which is on its way
+	// during shutdown is still delivered and recorded as sent.
+	ctx, cancel := context.WithTimeout(context.Background(), reminderSendTimeout)
+	defer cancel()
+
+	err = app.notifiers[reminder.Channel].Send(ctx, msg)
+	if err != nil {
+		reminder.Status, reminder.Error = model.ReminderFailed, err.Error()
+		app.logger.PrintError(err, map[string]string{
+			"appointment_id": fmt.Sprint(reminder.AppointmentID),
+			"channel":        reminder.Channel,
+		})
+		return
+	}
+
+	reminder.Status, reminder.Error = model.ReminderSent, ""
+}
+
+// reminderMessage writes the text of a reminder with links to confirm and cancel the
+// appointment. Times are shown in the server's time zone.
+func reminderMessage(reminder *model.Reminder, confirmURL, cancelURL string) notify.Message {
+	at := reminder.DateTime.In(time.Local)
+	doctor := "Dr. " + reminder.Doctor.FirstName + " " + reminder.Doctor.LastName
+	if reminder.Doctor.Speciality != "" {
+		doctor += " (" + reminder.Doctor.Speciality + ")"
+	}
+
+	return notify.Message{
+		Subject: "Appointment reminder: " + at.Format("Mon 2 Jan 15:04"),
+		Body: fmt.Sprintf("Hello %s, this is a reminder of your appointment with %s on %s at %s.\n\n"+
+			"Confirm: %s\nCancel: %s",
+			reminder.Patient.FirstName, doctor, at.Format("Monday, 2 January 2006"), at.Format("15:04"),
+			confirmURL, cancelURL),
+	}
+}
+
+// listAppointmentRemindersHandler returns the reminders sent, or being sent, for an appointment.
+func (app *application) listAppointmentRemindersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := app.tenantModels(r).Appointments.Get(id); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reminders, err := app.tenantModels(r).Reminders.GetForAppointment(int64(id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"reminders": reminders}, nil)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// reportCache keeps the results of the analytics reports for a while, since they aggregate
+// many appointments and are asked for again and again. Results are kept per tenant and query.
+type reportCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cachedReport
+}
+
+type cachedReport struct {
+	data    envelope
+	expires time.Time
+}
+
+func newReportCache(ttl time.Duration) *reportCache {
+	return &reportCache{ttl: ttl, entries: make(map[string]cachedReport)}
+}
+
+func (c *reportCache) get(key string) (envelope, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, time.Time{}, false
+	}
+	return e.data, e.expires, true
+}
+
+func (c *reportCache) set(key string, data envelope) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Expired entries are dropped as new ones come in, so the cache doesn't grow without bounds.
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	expires := now.Add(c.ttl)
+	c.entries[key] = cachedReport{data: data, expires: expires}
+	return expires
+}
+
+// serveCachedReport answers a report request from the cache, or with the result of compute,
+// which is then cached. The X-Cache header tells which it was. A request with Cache-Control:
+// no-cache always computes the report anew, and a TTL of zero disables the cache.
+func (app *application) serveCachedReport(w http.ResponseWriter, r *http.Request, compute func() (envelope, error)) {
+	cache := app.reports
+	key := fmt.Sprintf("%d %s?%s", app.contextGetTenant(r).ID, r.URL.Path, r.URL.Query().Encode())
+
+	headers := make(http.Header)
+	if cache.ttl > 0 && r.Header.Get("Cache-Control") != "no-cache" {
+		if data, expires, ok := cache.get(key); ok {
+			headers.Set("X-Cache", "HIT")
+			headers.Set("Cache-Control", "private, max-age="+strconv.Itoa(int(time.Until(expires).Seconds())))
+			app.writeJSON(w, http.StatusOK, data, headers)
+			return
+		}
+	}
+
+	data, err := compute()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers.Set("X-Cache", "MISS")
+	if cache.ttl > 0 {
+		cache.set(key, data)
+		headers.Set("Cache-Control", "private, max-age="+strconv.Itoa(int(cache.ttl.Seconds())))
+	}
+	app.writeJSON(w, http.StatusOK, data, headers)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+	"GoClinic/pkg/web/xlsx"
+)
+
+// reportTable is a report as a table, for the CSV and XLSX downloads. Amounts are xlsx.Numbers,
+// so spreadsheets can sum them.
+type reportTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// readReportFormat reads ?format=, which is json, csv or xlsx.
+func (app *application) readReportFormat(r *http.Request, v *validator.Validator) string {
+	format := app.readStrings(r.URL.Query(), "format", "json")
+	v.Check(validator.In(format, "json", "csv", "xlsx"), "format", "must be json, csv or xlsx")
+	return format
+}
+
+// readReportDays reads the local days ?from= and ?to= (YYYY-MM-DD) of a report. They default
+// to the current month up to today.
+func (app *application) readReportDays(r *http.Request) (from, to string) {
+	qs := r.URL.Query()
+	now := time.Now()
+	from = app.readStrings(qs, "from", now.Format("2006-01")+"-01")
+	to = app.readStrings(qs, "to", now.Format("2006-01-02"))
+	return from, to
+}
+
+// writeTable sends a report as a CSV or XLSX attachment named after it and its period.
+func (app *application) writeTable(w http.ResponseWriter, r *http.Request, format, period string, t *reportTable) {
+	filename := t.Name + "-" + period + "." + format
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+		cw := csv.NewWriter(w)
+		cw.Write(t.Columns)
+		for _, row := range t.Rows {
+			record := make([]string, len(row))
+			for i, cell := range row {
+				if cell != nil {
+					record[i] = fmt.Sprint(cell)
+				}
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			app.logError(r, err)
+		}
+
+	case "xlsx":
+		w.Header().Set("Content-Type", xlsx.ContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+		xw, err := xlsx.NewWriter(w, t.Name)
+		if err == nil {
+			err = xw.WriteHeader(t.Columns...)
+		}
+		for _, row := range t.Rows {
+			if err != nil {
+				break
+			}
+			err = xw.WriteRow(row...)
+		}
+		if err == nil {
+			err = xw.Close()
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+// optionalAmount returns an amount as an xlsx.Number, or nil for an empty cell.
+func optionalAmount(amount *int64, currency string) interface{} {
+	if amount == nil {
+		return nil
+	}
+	return xlsx.Number(model.DecimalAmount(*amount, currency))
+}
+
+// revenueReportHandler returns the revenue of the local days ?from= to ?to=, by default of the
+// current month, grouped by ?group_by=, a comma-separated list of day, doctor, service and
+// method, and by currency. Grouped by method it is what was collected, otherwise what was
+// invoiced. ?branch_id= limits it to a branch and ?format=csv or xlsx downloads it.
+func (app *application) revenueReportHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := model.RevenueFilter{BranchID: app.readBranchID(qs, v)}
+	filter.From, filter.To = app.readReportDays(r)
+	if s := app.readStrings(qs, "group_by", ""); s != "" {
+		filter.GroupBy = strings.Split(s, ",")
+	}
+	format := app.readReportFormat(r, v)
+	if model.ValidateRevenueFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	report, err := app.tenantModels(r).Reports.Revenue(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "json" {
+		app.writeJSON(w, http.StatusOK, envelope{"from": filter.From, "to": filter.To, "revenue": report}, nil)
+		return
+	}
+
+	t := &reportTable{Name: "revenue"}
+	for _, d := range filter.GroupBy {
+		switch d {
+		case "doctor":
+			t.Columns = append(t.Columns, "doctor_id", "doctor")
+		case "service":
+			t.Columns = append(t.Columns, "service_id", "service")
+		default:
+			t.Columns = append(t.Columns, d)
+		}
+	}
+	t.Columns = append(t.Columns, "currency", "count", "net", "vat", "total")
+	for _, row := range report {
+		var cells []interface{}
+		for _, d := range filter.GroupBy {
+			switch d {
+			case "day":
+				cells = append(cells, row.Day)
+			case "doctor":
+				cells = append(cells, optionalID(row.DoctorID), row.Doctor)
+			case "service":
+				cells = append(cells, optionalID(row.ServiceID), row.Service)
+			case "method":
+				cells = append(cells, row.Method)
+			}
+		}
+		cells = append(cells, row.Currency, row.Count, optionalAmount(row.Net, row.Currency),
+			optionalAmount(row.VAT, row.Currency), optionalAmount(&row.Total, row.Currency))
+		t.Rows = append(t.Rows, cells)
+	}
+
+	app.writeTable(w, r, format, filter.From+"-"+filter.To, t)
+}
+
+// optionalID returns an ID, or nil for an empty cell.
+func optionalID(id *int64) interface{} {
+	if id == nil {
+		return nil
+	}
+	return *id
+}
+
+// shiftDiscrepanciesReportHandler returns the totals of the shifts closed in the local days
+// ?from= to ?to=, by default of the current month, which weren't counted as expected. They can
+// be filtered by ?branch_id= and ?user_id=, and ?format=csv or xlsx downloads them.
+func (app *application) shiftDiscrepanciesReportHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	branchID := app.readBranchID(qs, v)
+	userID := app.readInt(qs, "user_id", 0, v)
+	from, to := app.readReportDays(r)
+	format := app.readReportFormat(r, v)
+	v.Check(userID >= 0, "user_id", "must be a positive integer")
+	model.ValidateReportDays(v, from, to)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	discrepancies, err := app.tenantModels(r).Shifts.Discrepancies(from, to, branchID, int64(userID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "json" {
+		app.writeJSON(w, http.StatusOK, envelope{"from": from, "to": to, "discrepancies": discrepancies}, nil)
+		return
+	}
+
+	t := &reportTable{
+		Name: "shift-discrepancies",
+		Columns: []string{"shift_id", "user_id", "user", "branch_id", "branch", "opened_at", "closed_at", "method",
+			"currency", "expected", "counted", "difference"},
+	}
+	for _, d := range discrepancies {
+		t.Rows = append(t.Rows, []interface{}{d.ShiftID, d.UserID, d.UserName, optionalID(d.BranchID), d.Branch,
+			d.OpenedAt.Format(time.RFC3339), d.ClosedAt.Format(time.RFC3339), d.Method, d.Currency,
+			optionalAmount(&d.Expected, d.Currency), optionalAmount(d.Counted, d.Currency),
+			optionalAmount(d.Difference, d.Currency)})
+	}
+
+	app.writeTable(w, r, format, from+"-"+to, t)
+}
@@ -33,6 +33,8 @@ func (app *application) routes() http.Handler {
 	v1.HandleFunc("/registrations", app.requireActivatedUser(app.getFilteredRegistrations)).Methods("GET")
 	// Get paginated patients list
 	v1.HandleFunc("/registrations/paginated", app.requireActivatedUser(app.getPaginatedRegistrations)).Methods("GET")
+	// Find a patient by national ID
+	v1.HandleFunc("/registrations/by-national-id", app.requireActivatedUser(app.getRegistrationByNationalID)).Methods("GET")
 	//Get patient's appointments
 	v1.HandleFunc("/patient/{patientId:[0-9]+}/appointments", app.requireActivatedUser(app.getAppointmentsOfPatient)).Methods("GET")
 	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -66,15 +68,383 @@ func (app *application) routes() http.Handler {
 	// Update a specific appointment
 	v3.HandleFunc("/appointments/{appointmentId:[0-9]+}", app.requireActivatedUser(app.updateAppointment)).Methods("PUT")
 	// Delete a specific appointment
-	v3.HandleFunc("/appointments/{appointmentsId:[0-9]+}", app.requireActivatedUser(app.deleteAppointment)).Methods("DELETE")
+	v3.HandleFunc("/appointments/{appointmentId:[0-9]+}", app.requireActivatedUser(app.deleteAppointment)).Methods("DELETE")
 	// Get sorted doctors list
 	v3.HandleFunc("/appointments/sorting", app.requireActivatedUser(app.getSortedAppointments)).Methods("GET")
 	// Get paginated appointments list
 	v3.HandleFunc("/appointments/paginated", app.requireActivatedUser(app.getPaginatedAppointments)).Methods("GET")
 	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	diagnoses := r.PathPrefix("/api/v1").Subrouter()
+
+	// Search the ICD-10 catalogue by code prefix or description
+	diagnoses.HandleFunc("/icd10", app.requireActivatedUser(app.searchICD10Handler)).Methods("GET")
+	// Get a specific ICD-10 code
+	diagnoses.HandleFunc("/icd10/{code}", app.requireActivatedUser(app.getICD10Handler)).Methods("GET")
+	// Attach a diagnosis to an appointment
+	diagnoses.HandleFunc("/appointments/{id:[0-9]+}/diagnoses", app.requireActivatedUser(app.createDiagnosisHandler)).Methods("POST")
+	// Get the diagnoses of an appointment
+	diagnoses.HandleFunc("/appointments/{id:[0-9]+}/diagnoses", app.requireActivatedUser(app.listAppointmentDiagnosesHandler)).Methods("GET")
+	// Delete a specific diagnosis
+	diagnoses.HandleFunc("/diagnoses/{id:[0-9]+}", app.requireActivatedUser(app.deleteDiagnosisHandler)).Methods("DELETE")
+	// Get patient's problem history
+	diagnoses.HandleFunc("/patient/{id:[0-9]+}/problems", app.requireActivatedUser(app.getProblemHistoryHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	prescriptions := r.PathPrefix("/api/v1").Subrouter()
+
+	// Search the drug catalogue
+	prescriptions.HandleFunc("/drugs", app.requireActivatedUser(app.searchDrugsHandler)).Methods("GET")
+	// Issue a new prescription
+	prescriptions.HandleFunc("/prescriptions", app.requireActivatedUser(app.createPrescriptionHandler)).Methods("POST")
+	// Get a specific prescription
+	prescriptions.HandleFunc("/prescriptions/{id:[0-9]+}", app.requireActivatedUser(app.getPrescriptionHandler)).Methods("GET")
+	// Render a prescription as PDF
+	prescriptions.HandleFunc("/prescriptions/{id:[0-9]+}/pdf", app.requireActivatedUser(app.prescriptionPDFHandler)).Methods("GET")
+	// Get patient's prescriptions
+	prescriptions.HandleFunc("/patient/{id:[0-9]+}/prescriptions", app.requireActivatedUser(app.listPatientPrescriptionsHandler)).Methods("GET")
+	// Get prescriptions issued despite allergy or interaction warnings
+	prescriptions.HandleFunc("/prescriptions/overrides", app.requireActivatedUser(app.listPrescriptionOverridesHandler)).Methods("GET")
+	// Record a patient's allergy
+	prescriptions.HandleFunc("/patient/{id:[0-9]+}/allergies", app.requireActivatedUser(app.createAllergyHandler)).Methods("POST")
+	// Get patient's allergies
+	prescriptions.HandleFunc("/patient/{id:[0-9]+}/allergies", app.requireActivatedUser(app.listAllergiesHandler)).Methods("GET")
+	// Delete a specific allergy
+	prescriptions.HandleFunc("/allergies/{id:[0-9]+}", app.requireActivatedUser(app.deleteAllergyHandler)).Methods("DELETE")
+	// Verify a printed prescription, public so pharmacies can scan the QR code
+	prescriptions.HandleFunc("/prescriptions/verify/{code}", app.requireTenant(app.verifyPrescriptionHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	labs := r.PathPrefix("/api/v1").Subrouter()
+
+	// Order lab tests during an appointment
+	labs.HandleFunc("/appointments/{id:[0-9]+}/lab-orders", app.requireActivatedUser(app.createLabOrderHandler)).Methods("POST")
+	// Get a specific lab order with its results
+	labs.HandleFunc("/lab-orders/{id:[0-9]+}", app.requireActivatedUser(app.getLabOrderHandler)).Methods("GET")
+	// Mark a lab order as collected or reviewed
+	labs.HandleFunc("/lab-orders/{id:[0-9]+}/status", app.requireActivatedUser(app.updateLabOrderStatusHandler)).Methods("PUT")
+	// Enter the results of a lab order
+	labs.HandleFunc("/lab-orders/{id:[0-9]+}/results", app.requireActivatedUser(app.addLabResultsHandler)).Methods("POST")
+	// Get patient's lab orders
+	labs.HandleFunc("/patient/{id:[0-9]+}/lab-orders", app.requireActivatedUser(app.listPatientLabOrdersHandler)).Methods("GET")
+	// Get patient's lab result history per analyte
+	labs.HandleFunc("/patient/{id:[0-9]+}/lab-results", app.requireActivatedUser(app.getLabResultHistoryHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	documents := r.PathPrefix("/api/v1").Subrouter()
+
+	// Documents are reached through their patient, so they are protected like patient records.
+	// Upload a document to a patient record
+	documents.HandleFunc("/patient/{id:[0-9]+}/documents", app.requireActivatedUser(app.uploadPatientDocumentHandler)).Methods("POST")
+	// Get patient's documents
+	documents.HandleFunc("/patient/{id:[0-9]+}/documents", app.requireActivatedUser(app.listPatientDocumentsHandler)).Methods("GET")
+	// Upload a document to an appointment
+	documents.HandleFunc("/appointments/{id:[0-9]+}/documents", app.requireActivatedUser(app.uploadAppointmentDocumentHandler)).Methods("POST")
+	// Get the documents of an appointment
+	documents.HandleFunc("/appointments/{id:[0-9]+}/documents", app.requireActivatedUser(app.listAppointmentDocumentsHandler)).Methods("GET")
+	// Get a specific document
+	documents.HandleFunc("/documents/{id:[0-9]+}", app.requireActivatedUser(app.getDocumentHandler)).Methods("GET")
+	// Download the contents of a document
+	documents.HandleFunc("/documents/{id:[0-9]+}/content", app.requireActivatedUser(app.downloadDocumentHandler)).Methods("GET")
+	// Delete a specific document
+	documents.HandleFunc("/documents/{id:[0-9]+}", app.requireActivatedUser(app.deleteDocumentHandler)).Methods("DELETE")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	hl7Messages := r.PathPrefix("/api/v1").Subrouter()
+
+	// Get received HL7 messages
+	hl7Messages.HandleFunc("/hl7/messages", app.requireActivatedUser(app.listHL7MessagesHandler)).Methods("GET")
+	// Get a specific HL7 message
+	hl7Messages.HandleFunc("/hl7/messages/{id:[0-9]+}", app.requireActivatedUser(app.getHL7MessageHandler)).Methods("GET")
+	// Process a stored HL7 message again
+	hl7Messages.HandleFunc("/hl7/messages/{id:[0-9]+}/replay", app.requireActivatedUser(app.replayHL7MessageHandler)).Methods("POST")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	calendars := r.PathPrefix("/api/v1").Subrouter()
+
+	// Issue a new secret calendar feed URL for a doctor, revoking the previous one
+	calendars.HandleFunc("/doctors/{id:[0-9]+}/calendar-feed", app.requireActivatedUser(app.issueDoctorCalendarFeedHandler)).Methods("POST")
+	// Revoke a doctor's calendar feed URL
+	calendars.HandleFunc("/doctors/{id:[0-9]+}/calendar-feed", app.requireActivatedUser(app.revokeDoctorCalendarFeedHandler)).Methods("DELETE")
+	// Issue a new secret calendar feed URL for a patient, revoking the previous one
+	calendars.HandleFunc("/patient/{id:[0-9]+}/calendar-feed", app.requireActivatedUser(app.issuePatientCalendarFeedHandler)).Methods("POST")
+	// Revoke a patient's calendar feed URL
+	calendars.HandleFunc("/patient/{id:[0-9]+}/calendar-feed", app.requireActivatedUser(app.revokePatientCalendarFeedHandler)).Methods("DELETE")
+	// iCalendar feed, public since the secret token in the URL authenticates calendar clients
+	r.HandleFunc("/calendar/{token:[A-Z2-7]{26}}.ics", app.requireTenant(app.calendarFeedHandler)).Methods("GET", "HEAD")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	reminders := r.PathPrefix("/api/v1").Subrouter()
+
+	// Get the reminders sent for an appointment
+	reminders.HandleFunc("/appointments/{id:[0-9]+}/reminders", app.requireActivatedUser(app.listAppointmentRemindersHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	actions := r.PathPrefix("/api/v1").Subrouter()
+
+	// Confirm/cancel links sent to patients are public, the single-use token authenticates them.
+	// Show what a link will do
+	actions.HandleFunc("/appointment-actions/{token:[A-Z2-7]{26}}/{action:confirm|cancel}", app.requireTenant(app.showAppointmentActionHandler)).Methods("GET")
+	// Confirm or cancel the appointment of a link
+	actions.HandleFunc("/appointment-actions/{token:[A-Z2-7]{26}}/{action:confirm|cancel}", app.requireTenant(app.performAppointmentActionHandler)).Methods("POST")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	waitlist := r.PathPrefix("/api/v1").Subrouter()
+
+	// Put a patient on the waitlist for a doctor or a speciality
+	waitlist.HandleFunc("/waitlist", app.requireActivatedUser(app.createWaitlistEntryHandler)).Methods("POST")
+	// Get the waitlist in offer order, optionally filtered by status and doctor
+	waitlist.HandleFunc("/waitlist", app.requireActivatedUser(app.listWaitlistHandler)).Methods("GET")
+	// Get a waitlist entry with the offers made to it
+	waitlist.HandleFunc("/waitlist/{id:[0-9]+}", app.requireActivatedUser(app.showWaitlistEntryHandler)).Methods("GET")
+	// Take a patient off the waitlist
+	waitlist.HandleFunc("/waitlist/{id:[0-9]+}", app.requireActivatedUser(app.removeWaitlistEntryHandler)).Methods("DELETE")
+	// Cancel an appointment, or with ?scope=following|all more of its series, and offer the slots to the waitlist
+	waitlist.HandleFunc("/appointments/{id:[0-9]+}/cancel", app.requireActivatedUser(app.cancelAppointmentHandler)).Methods("POST")
+	// Offer links sent to patients are public, the secret token authenticates them.
+	// Show the offered slot
+	waitlist.HandleFunc("/waitlist-offers/{token:[A-Z2-7]{26}}/{action:accept|decline}", app.requireTenant(app.showWaitlistOfferHandler)).Methods("GET")
+	// Book or decline the offered slot
+	waitlist.HandleFunc("/waitlist-offers/{token:[A-Z2-7]{26}}/{action:accept|decline}", app.requireTenant(app.answerWaitlistOfferHandler)).Methods("POST")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	series := r.PathPrefix("/api/v1").Subrouter()
+
+	// Book a recurring appointment series from an RRULE, reporting occurrences which clash
+	series.HandleFunc("/appointment-series", app.requireActivatedUser(app.createAppointmentSeriesHandler)).Methods("POST")
+	// Get a series with its appointments
+	series.HandleFunc("/appointment-series/{id:[0-9]+}", app.requireActivatedUser(app.showAppointmentSeriesHandler)).Methods("GET")
+	// Move or reassign this appointment, this and the following ones, or the whole series
+	series.HandleFunc("/appointments/{id:[0-9]+}/series", app.requireActivatedUser(app.updateSeriesAppointmentsHandler)).Methods("PATCH")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	closures := r.PathPrefix("/api/v1").Subrouter()
+
+	// Add a clinic closure or a doctor's leave, reporting the appointments to reschedule
+	closures.HandleFunc("/closures", app.requireActivatedUser(app.createClosureHandler)).Methods("POST")
+	// Get the closures in a period, optionally those of a doctor
+	closures.HandleFunc("/closures", app.requireActivatedUser(app.listClosuresHandler)).Methods("GET")
+	// Import closures from an iCalendar file, e.g. public holidays
+	closures.HandleFunc("/closures/import", app.requireActivatedUser(app.importClosuresHandler)).Methods("POST")
+	// Get a closure with the appointments to reschedule
+	closures.HandleFunc("/closures/{id:[0-9]+}", app.requireActivatedUser(app.showClosureHandler)).Methods("GET")
+	// Delete a closure
+	closures.HandleFunc("/closures/{id:[0-9]+}", app.requireActivatedUser(app.deleteClosureHandler)).Methods("DELETE")
+	// Get the free appointment slots of a doctor
+	closures.HandleFunc("/doctors/{id:[0-9]+}/slots", app.requireActivatedUser(app.listDoctorSlotsHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	queue := r.PathPrefix("/api/v1").Subrouter()
+
+	// Check a walk-in or a patient with an appointment in and issue a ticket
+	queue.HandleFunc("/queue/tickets", app.requireActivatedUser(app.createQueueTicketHandler)).Methods("POST")
+	// Get the tickets of a day, optionally those of a doctor
+	queue.HandleFunc("/queue/tickets", app.requireActivatedUser(app.listQueueTicketsHandler)).Methods("GET")
+	// Get a ticket
+	queue.HandleFunc("/queue/tickets/{id:[0-9]+}", app.requireActivatedUser(app.showQueueTicketHandler)).Methods("GET")
+	// Call, finish or requeue a ticket
+	queue.HandleFunc("/queue/tickets/{id:[0-9]+}", app.requireActivatedUser(app.updateQueueTicketHandler)).Methods("PATCH")
+	// Finish the current patient of a doctor and call the next one
+	queue.HandleFunc("/doctors/{id:[0-9]+}/queue/next", app.requireActivatedUser(app.callNextQueueTicketHandler)).Methods("POST")
+	// Live updates of the queue for the doctors' screens (Server-Sent Events)
+	queue.HandleFunc("/queue/events", app.requireActivatedUser(app.queueEventsHandler)).Methods("GET")
+	// Live updates of the queue for the waiting room display, without patient details
+	queue.HandleFunc("/queue/board/events", app.requireTenant(app.queueBoardEventsHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	branches := r.PathPrefix("/api/v1").Subrouter()
+
+	// Add a branch of the clinic
+	branches.HandleFunc("/branches", app.requireActivatedUser(app.createBranchHandler)).Methods("POST")
+	// Get the branches, optionally those a doctor works at
+	branches.HandleFunc("/branches", app.requireActivatedUser(app.listBranchesHandler)).Methods("GET")
+	// Get a branch with its rooms
+	branches.HandleFunc("/branches/{id:[0-9]+}", app.requireActivatedUser(app.showBranchHandler)).Methods("GET")
+	// Update a branch
+	branches.HandleFunc("/branches/{id:[0-9]+}", app.requireActivatedUser(app.updateBranchHandler)).Methods("PATCH")
+	// Delete a branch which has no appointments
+	branches.HandleFunc("/branches/{id:[0-9]+}", app.requireActivatedUser(app.deleteBranchHandler)).Methods("DELETE")
+	// Add a room or another resource to a branch
+	branches.HandleFunc("/branches/{id:[0-9]+}/rooms", app.requireActivatedUser(app.createRoomHandler)).Methods("POST")
+	// Get the rooms of a branch
+	branches.HandleFunc("/branches/{id:[0-9]+}/rooms", app.requireActivatedUser(app.listRoomsHandler)).Methods("GET")
+	// Update a room
+	branches.HandleFunc("/rooms/{id:[0-9]+}", app.requireActivatedUser(app.updateRoomHandler)).Methods("PATCH")
+	// Delete a room
+	branches.HandleFunc("/rooms/{id:[0-9]+}", app.requireActivatedUser(app.deleteRoomHandler)).Methods("DELETE")
+	// Get the branches a doctor works at
+	branches.HandleFunc("/doctors/{id:[0-9]+}/branches", app.requireActivatedUser(app.listDoctorBranchesHandler)).Methods("GET")
+	// Set the branches a doctor works at
+	branches.HandleFunc("/doctors/{id:[0-9]+}/branches", app.requireActivatedUser(app.setDoctorBranchesHandler)).Methods("PUT")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	services := r.PathPrefix("/api/v1").Subrouter()
+
+	// Add a service to the catalogue with its list price
+	services.HandleFunc("/services", app.requireActivatedUser(app.createServiceHandler)).Methods("POST")
+	// Get the catalogue, optionally the active services of a speciality or a doctor
+	services.HandleFunc("/services", app.requireActivatedUser(app.listServicesHandler)).Methods("GET")
+	// Get a service with its price history
+	services.HandleFunc("/services/{id:[0-9]+}", app.requireActivatedUser(app.showServiceHandler)).Methods("GET")
+	// Update a service
+	services.HandleFunc("/services/{id:[0-9]+}", app.requireActivatedUser(app.updateServiceHandler)).Methods("PATCH")
+	// Delete a service which has never been booked
+	services.HandleFunc("/services/{id:[0-9]+}", app.requireActivatedUser(app.deleteServiceHandler)).Methods("DELETE")
+	// Add a list price or a price for a branch or a doctor from a day
+	services.HandleFunc("/services/{id:[0-9]+}/prices", app.requireActivatedUser(app.createServicePriceHandler)).Methods("POST")
+	// Get the price of a service for a doctor at a branch on a day
+	services.HandleFunc("/services/{id:[0-9]+}/price", app.requireActivatedUser(app.quoteServiceHandler)).Methods("GET")
+	// Delete a price which hasn't taken effect yet
+	services.HandleFunc("/service-prices/{id:[0-9]+}", app.requireActivatedUser(app.deleteServicePriceHandler)).Methods("DELETE")
+	// Get the services of an appointment and what it costs
+	services.HandleFunc("/appointments/{id:[0-9]+}/services", app.requireActivatedUser(app.showAppointmentServicesHandler)).Methods("GET")
+	// Replace the services of an appointment, which sets its duration
+	services.HandleFunc("/appointments/{id:[0-9]+}/services", app.requireActivatedUser(app.setAppointmentServicesHandler)).Methods("PUT")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	invoices := r.PathPrefix("/api/v1").Subrouter()
+
+	// Make a draft invoice for an appointment or from lines
+	invoices.HandleFunc("/invoices", app.requireActivatedUser(app.createInvoiceHandler)).Methods("POST")
+	// List invoices and credit notes
+	invoices.HandleFunc("/invoices", app.requireActivatedUser(app.listInvoicesHandler)).Methods("GET")
+	// Get an invoice with its lines, payments and credit notes
+	invoices.HandleFunc("/invoices/{id:[0-9]+}", app.requireActivatedUser(app.showInvoiceHandler)).Methods("GET")
+	// Change a draft invoice
+	invoices.HandleFunc("/invoices/{id:[0-9]+}", app.requireActivatedUser(app.updateInvoiceHandler)).Methods("PATCH")
+	// Issue a draft invoice, which numbers it
+	invoices.HandleFunc("/invoices/{id:[0-9]+}/issue", app.requireActivatedUser(app.issueInvoiceHandler)).Methods("POST")
+	// Void an invoice nothing has been paid for
+	invoices.HandleFunc("/invoices/{id:[0-9]+}/void", app.requireActivatedUser(app.voidInvoiceHandler)).Methods("POST")
+	// Record a payment, or a refund of a credit note
+	invoices.HandleFunc("/invoices/{id:[0-9]+}/payments", app.requireActivatedUser(app.createPaymentHandler)).Methods("POST")
+	// Issue a credit note for an invoice
+	invoices.HandleFunc("/invoices/{id:[0-9]+}/credit-notes", app.requireActivatedUser(app.createCreditNoteHandler)).Methods("POST")
+	// Get an invoice as a PDF receipt
+	invoices.HandleFunc("/invoices/{id:[0-9]+}/pdf", app.requireActivatedUser(app.invoicePDFHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	insurance := r.PathPrefix("/api/v1").Subrouter()
+
+	// Add a payer
+	insurance.HandleFunc("/payers", app.requireActivatedUser(app.createPayerHandler)).Methods("POST")
+	// List payers
+	insurance.HandleFunc("/payers", app.requireActivatedUser(app.listPayersHandler)).Methods("GET")
+	// Get a payer
+	insurance.HandleFunc("/payers/{id:[0-9]+}", app.requireActivatedUser(app.showPayerHandler)).Methods("GET")
+	// Change a payer or the format of its batch files
+	insurance.HandleFunc("/payers/{id:[0-9]+}", app.requireActivatedUser(app.updatePayerHandler)).Methods("PATCH")
+	// Delete a payer without policies or claims
+	insurance.HandleFunc("/payers/{id:[0-9]+}", app.requireActivatedUser(app.deletePayerHandler)).Methods("DELETE")
+	// Add an insurance policy of a patient
+	insurance.HandleFunc("/patient/{id:[0-9]+}/policies", app.requireActivatedUser(app.createPolicyHandler)).Methods("POST")
+	// List the insurance policies of a patient
+	insurance.HandleFunc("/patient/{id:[0-9]+}/policies", app.requireActivatedUser(app.listPatientPoliciesHandler)).Methods("GET")
+	// Change an insurance policy
+	insurance.HandleFunc("/policies/{id:[0-9]+}", app.requireActivatedUser(app.updatePolicyHandler)).Methods("PATCH")
+	// Delete an insurance policy without claims
+	insurance.HandleFunc("/policies/{id:[0-9]+}", app.requireActivatedUser(app.deletePolicyHandler)).Methods("DELETE")
+	// Claim the unpaid invoices covered by a payer in a new batch
+	insurance.HandleFunc("/payers/{id:[0-9]+}/claim-batches", app.requireActivatedUser(app.createClaimBatchHandler)).Methods("POST")
+	// List claim batches
+	insurance.HandleFunc("/claim-batches", app.requireActivatedUser(app.listClaimBatchesHandler)).Methods("GET")
+	// Get a claim batch with its claims
+	insurance.HandleFunc("/claim-batches/{id:[0-9]+}", app.requireActivatedUser(app.showClaimBatchHandler)).Methods("GET")
+	// Download a claim batch in the payer's file format
+	insurance.HandleFunc("/claim-batches/{id:[0-9]+}/file", app.requireActivatedUser(app.claimBatchFileHandler)).Methods("GET")
+	// List claims
+	insurance.HandleFunc("/claims", app.requireActivatedUser(app.listClaimsHandler)).Methods("GET")
+	// Get a claim
+	insurance.HandleFunc("/claims/{id:[0-9]+}", app.requireActivatedUser(app.showClaimHandler)).Methods("GET")
+	// Record that the payer accepted or rejected a claim
+	insurance.HandleFunc("/claims/{id:[0-9]+}", app.requireActivatedUser(app.updateClaimHandler)).Methods("PATCH")
+	// Record and reconcile a payer's remittance
+	insurance.HandleFunc("/payers/{id:[0-9]+}/remittances", app.requireActivatedUser(app.createRemittanceHandler)).Methods("POST")
+	// Get a remittance and how it was applied
+	insurance.HandleFunc("/remittances/{id:[0-9]+}", app.requireActivatedUser(app.showRemittanceHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	shifts := r.PathPrefix("/api/v1").Subrouter()
+
+	// Open a cashier shift for the current user
+	shifts.HandleFunc("/shifts", app.requireActivatedUser(app.openShiftHandler)).Methods("POST")
+	// List cashier shifts
+	shifts.HandleFunc("/shifts", app.requireActivatedUser(app.listShiftsHandler)).Methods("GET")
+	// Get the current user's open shift
+	shifts.HandleFunc("/shifts/current", app.requireActivatedUser(app.currentShiftHandler)).Methods("GET")
+	// Get a shift with its expected and counted totals
+	shifts.HandleFunc("/shifts/{id:[0-9]+}", app.requireActivatedUser(app.showShiftHandler)).Methods("GET")
+	// Close a shift with the counted amounts
+	shifts.HandleFunc("/shifts/{id:[0-9]+}/close", app.requireActivatedUser(app.closeShiftHandler)).Methods("POST")
+	// Revenue by day, doctor, service or payment method, as JSON, CSV or XLSX
+	shifts.HandleFunc("/reports/revenue", app.requireActivatedUser(app.revenueReportHandler)).Methods("GET")
+	// Shift totals which weren't counted as expected, as JSON, CSV or XLSX
+	shifts.HandleFunc("/reports/shift-discrepancies", app.requireActivatedUser(app.shiftDiscrepanciesReportHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	reports := r.PathPrefix("/api/v1/reports").Subrouter()
+
+	// Booked against available minutes per doctor
+	reports.HandleFunc("/utilization", app.requireActivatedUser(app.utilizationReportHandler)).Methods("GET")
+	// Cancellation and no-show rates per doctor
+	reports.HandleFunc("/attendance", app.requireActivatedUser(app.attendanceReportHandler)).Methods("GET")
+	// Booking lead time per doctor
+	reports.HandleFunc("/lead-time", app.requireActivatedUser(app.leadTimeReportHandler)).Methods("GET")
+	// New and returning patients over time
+	reports.HandleFunc("/patients", app.requireActivatedUser(app.patientsReportHandler)).Methods("GET")
+	// Appointments per speciality over time
+	reports.HandleFunc("/specialities", app.requireActivatedUser(app.specialitiesReportHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	imports := r.PathPrefix("/api/v1").Subrouter()
+
+	// Import patients from a CSV file, or validate it with ?dry_run=true
+	imports.HandleFunc("/patients/import", app.requireActivatedUser(app.importPatientsHandler)).Methods("POST")
+	// Import doctors from a CSV file, or validate it with ?dry_run=true
+	imports.HandleFunc("/doctors/import", app.requireActivatedUser(app.importDoctorsHandler)).Methods("POST")
+	// Get an import with its first row errors
+	imports.HandleFunc("/imports/{id:[0-9]+}", app.requireActivatedUser(app.showImportHandler)).Methods("GET")
+	// Download the error report of an import as CSV
+	imports.HandleFunc("/imports/{id:[0-9]+}/errors", app.requireActivatedUser(app.importErrorsHandler)).Methods("GET")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	exports := r.PathPrefix("/api/v1").Subrouter()
+
+	// Download patients, doctors or appointments as CSV, NDJSON or XLSX, streamed as they are read
+	exports.HandleFunc("/patients/export", app.requireActivatedUser(app.exportPatientsHandler)).Methods("GET")
+	exports.HandleFunc("/doctors/export", app.requireActivatedUser(app.exportDoctorsHandler)).Methods("GET")
+	exports.HandleFunc("/appointments/export", app.requireActivatedUser(app.exportAppointmentsHandler)).Methods("GET")
+	// Queue the same exports to be written in the background
+	exports.HandleFunc("/patients/export", app.requireActivatedUser(app.createPatientsExportHandler)).Methods("POST")
+	exports.HandleFunc("/doctors/export", app.requireActivatedUser(app.createDoctorsExportHandler)).Methods("POST")
+	exports.HandleFunc("/appointments/export", app.requireActivatedUser(app.createAppointmentsExportHandler)).Methods("POST")
+	// Get the status of a queued export, with its download link once it is done
+	exports.HandleFunc("/exports/{id:[0-9]+}", app.requireActivatedUser(app.showExportHandler)).Methods("GET")
+	// Download the file of a finished export
+	exports.HandleFunc("/exports/{id:[0-9]+}/download", app.requireActivatedUser(app.downloadExportHandler)).Methods("GET")
+	// Delete an export and its file
+	exports.HandleFunc("/exports/{id:[0-9]+}", app.requireActivatedUser(app.deleteExportHandler)).Methods("DELETE")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	privacy := r.PathPrefix("/api/v1").Subrouter()
+
+	// Download everything held about a patient as a ZIP of JSON files and documents
+	privacy.HandleFunc("/patient/{id:[0-9]+}/data-export", app.requireActivatedUser(app.patientDataExportHandler)).Methods("GET")
+	// Get a patient's legal hold and whether they have been anonymized
+	privacy.HandleFunc("/patient/{id:[0-9]+}/privacy", app.requireActivatedUser(app.showPatientPrivacyHandler)).Methods("GET")
+	// Put a patient under legal hold, or lift it
+	privacy.HandleFunc("/patient/{id:[0-9]+}/legal-hold", app.requireActivatedUser(app.setLegalHoldHandler)).Methods("PUT")
+	privacy.HandleFunc("/patient/{id:[0-9]+}/legal-hold", app.requireActivatedUser(app.liftLegalHoldHandler)).Methods("DELETE")
+	// Irreversibly anonymize a patient
+	privacy.HandleFunc("/patient/{id:[0-9]+}/anonymize", app.requireActivatedUser(app.anonymizePatientHandler)).Methods("POST")
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+	fhirR4 := r.PathPrefix("/fhir/R4").Subrouter()
+
+	// FHIR capability statement, public so partners can discover what we support
+	fhirR4.HandleFunc("/metadata", app.fhirCapabilityStatementHandler).Methods("GET")
+	// FHIR Patient read, search, create and update
+	fhirR4.HandleFunc("/Patient", app.requireActivatedUser(app.fhirSearchPatientsHandler)).Methods("GET")
+	fhirR4.HandleFunc("/Patient", app.requireActivatedUser(app.fhirCreatePatientHandler)).Methods("POST")
+	fhirR4.HandleFunc("/Patient/{id:[0-9]+}", app.requireActivatedUser(app.fhirReadPatientHandler)).Methods("GET")
+	fhirR4.HandleFunc("/Patient/{id:[0-9]+}", app.requireActivatedUser(app.fhirUpdatePatientHandler)).Methods("PUT")
+	// FHIR Practitioner read, search, create and update
+	fhirR4.HandleFunc("/Practitioner", app.requireActivatedUser(app.fhirSearchPractitionersHandler)).Methods("GET")
+	fhirR4.HandleFunc("/Practitioner", app.requireActivatedUser(app.fhirCreatePractitionerHandler)).Methods("POST")
+	fhirR4.HandleFunc("/Practitioner/{id:[0-9]+}", app.requireActivatedUser(app.fhirReadPractitionerHandler)).Methods("GET")
+	fhirR4.HandleFunc("/Practitioner/{id:[0-9]+}", app.requireActivatedUser(app.fhirUpdatePractitionerHandler)).Methods("PUT")
+	// FHIR PractitionerRole read and search
+	fhirR4.HandleFunc("/PractitionerRole", app.requireActivatedUser(app.fhirSearchPractitionerRolesHandler)).Methods("GET")
+	fhirR4.HandleFunc("/PractitionerRole/{id:[0-9]+}", app.requireActivatedUser(app.fhirReadPractitionerRoleHandler)).Methods("GET")
+	// FHIR Appointment read, search, create and update
+	fhirR4.HandleFunc("/Appointment", app.requireActivatedUser(app.fhirSearchAppointmentsHandler)).Methods("GET")
+	fhirR4.HandleFunc("/Appointment", app.requireActivatedUser(app.fhirCreateAppointmentHandler)).Methods("POST")
+	fhirR4.HandleFunc("/Appointment/{id:[0-9]+}", app.requireActivatedUser(app.fhirReadAppointmentHandler)).Methods("GET")
+	fhirR4.HandleFunc("/Appointment/{id:[0-9]+}", app.requireActivatedUser(app.fhirUpdateAppointmentHandler)).Methods("PUT")
+	// Anything else under /fhir/R4 is answered with an OperationOutcome
+	fhirR4.PathPrefix("/").HandlerFunc(app.fhirNotFoundResponse)
+	////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 	users1 := r.PathPrefix("/api/v1").Subrouter()
 	// User handlers with Authentication
-	users1.HandleFunc("/users", app.registerUserHandler).Methods("POST")
+	users1.HandleFunc("/users", app.requireRegistration(app.registerUserHandler)).Methods("POST")
 	users1.HandleFunc("/users/activated", app.activateUserHandler).Methods("PUT")
 	users1.HandleFunc("/users/login", app.createAuthenticationTokenHandler).Methods("POST")
 
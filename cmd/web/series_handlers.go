@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/rrule"
+	"GoClinic/pkg/web/validator"
+)
+
+// parseSeriesTime parses a start time of a series. Times without an offset are wall clock
+// times in loc.
+func parseSeriesTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(loc), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("must be a date and time such as 2024-06-03T10:00:00+02:00")
+}
+
+// createAppointmentSeriesHandler books the occurrences of a recurring appointment. Occurrences
+// which clash with other appointments are reported rather than failing the whole series.
+func (app *application) createAppointmentSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		DoctorID  int64  `json:"doctor_id"`
+		PatientID int64  `json:"patient_id"`
+		Start     string `json:"start"`
+		TimeZone  string `json:"time_zone"`
+		RRule     string `json:"rrule"`
+		BranchID  *int64 `json:"branch_id"`
+		RoomID    *int64 `json:"room_id"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.DoctorID > 0, "doctor_id", "must be provided")
+	v.Check(input.PatientID > 0, "patient_id", "must be provided")
+
+	// Without a time zone, the occurrences keep the wall clock time of the branch.
+	if input.TimeZone == "" {
+		input.TimeZone = time.Local.String()
+		if input.BranchID != nil {
+			if branch, err := app.tenantModels(r).Branches.Get(*input.BranchID); err == nil {
+				input.TimeZone = branch.TimeZone
+			}
+		}
+	}
+	loc, err := time.LoadLocation(input.TimeZone)
+	if err != nil {
+		v.AddError("time_zone", "must be an IANA time zone such as Europe/Berlin")
+		loc = time.Local
+	}
+
+	start, err := parseSeriesTime(input.Start, loc)
+	if err != nil {
+		v.AddError("start", err.Error())
+	}
+
+	rule, err := rrule.Parse(input.RRule, loc)
+	if err != nil {
+		v.AddError("rrule", err.Error())
+	} else {
+		v.Check(rule.Bounded(), "rrule", "must end, with COUNT or UNTIL")
+	}
+
+	var starts []time.Time
+	if v.Valid() {
+		starts = rule.All(start, model.MaxSeriesOccurrences+1)
+		v.Check(len(starts) > 0, "rrule", "has no occurrences after start")
+		v.Check(len(starts) <= model.MaxSeriesOccurrences, "rrule",
+			fmt.Sprintf("must not have more than %d occurrences", model.MaxSeriesOccurrences))
+	}
+	if v.Valid() {
+		if _, err := app.tenantModels(r).Doctors.Get(int(input.DoctorID)); err != nil {
+			v.AddError("doctor_id", "doctor does not exist")
+		}
+		if _, err := app.tenantModels(r).Patients.Get(int(input.PatientID)); err != nil {
+			v.AddError("patient_id", "patient does not exist")
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	series := &model.AppointmentSeries{
+		DoctorID:  input.DoctorID,
+		PatientID: input.PatientID,
+		Start:     start,
+		TimeZone:  input.TimeZone,
+		RRule:     input.RRule,
+		BranchID:  input.BranchID,
+		RoomID:    input.RoomID,
+		CreatedBy: &userID,
+	}
+
+	results, err := app.tenantModels(r).Series.Insert(series, starts)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNoOccurrences):
+			app.writeJSON(w, http.StatusConflict, envelope{"error": err.Error(), "occurrences": results}, nil)
+		case errors.Is(err, model.ErrBranchMismatch):
+			app.failedValidationResponse(w, r, map[string]string{"branch_id": err.Error()})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/appointment-series/%d", series.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"series": series, "occurrences": results}, headers)
+}
+
+// showAppointmentSeriesHandler returns a series with all of its appointments.
+func (app *application) showAppointmentSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	series, err := app.tenantModels(r).Series.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"series": series}, nil)
+}
+
+// seriesAppointment reads the appointment of the URL and the scope of a change to it. Scopes
+// other than "this" need an appointment of a series. It sends the error response itself and
+// returns nil if the request can't be served.
+func (app *application) seriesAppointment(w http.ResponseWriter, r *http.Request, scope string) *model.Appointment {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	appointment, err := app.tenantModels(r).Appointments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	v := validator.New()
+	v.Check(validator.In(scope, model.SeriesScopeThis, model.SeriesScopeFollowing, model.SeriesScopeAll), "scope",
+		"must be this, following or all")
+	v.Check(scope == model.SeriesScopeThis || appointment.SeriesID != nil, "scope",
+		"must be this for an appointment which is not part of a series")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+
+	return appointment
+}
+
+// updateSeriesAppointmentsHandler moves and/or reassigns an appointment of a series, the
+// appointments following it or the whole series. Appointments which would clash with others
+// are left unchanged and reported.
+func (app *application) updateSeriesAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Scope    string  `json:"scope"`
+		DateTime *string `json:"date_time"`
+		DoctorID *int    `json:"doctor_id"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Scope == "" {
+		input.Scope = model.SeriesScopeThis
+	}
+
+	appointment := app.seriesAppointment(w, r, input.Scope)
+	if appointment == nil {
+		return
+	}
+	if appointment.SeriesID == nil {
+		app.failedValidationResponse(w, r, map[string]string{"id": "appointment is not part of a series"})
+		return
+	}
+
+	series, err := app.tenantModels(r).Series.Get(*appointment.SeriesID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	loc, err := time.LoadLocation(series.TimeZone)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	var change model.OccurrenceChange
+	if input.DateTime != nil {
+		t, err := parseSeriesTime(*input.DateTime, loc)
+		if err != nil {
+			v.AddError("date_time", err.Error())
+		}
+		change.DateTime = &t
+	}
+	if input.DoctorID != nil {
+		if _, err := app.tenantModels(r).Doctors.Get(*input.DoctorID); err != nil {
+			v.AddError("doctor_id", "doctor does not exist")
+		}
+		change.DoctorID = input.DoctorID
+	}
+	v.Check(input.DateTime != nil || input.DoctorID != nil, "date_time", "date_time or doctor_id must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results, err := app.tenantModels(r).Series.Change(appointment, input.Scope, change)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"occurrences": results}, nil)
+}
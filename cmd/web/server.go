@@ -23,6 +23,20 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Background workers run until workers is cancelled during the graceful shutdown below.
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	if app.config.mllp.addr != "" {
+		if err := app.serveMLLP(workers); err != nil {
+			return err
+		}
+	}
+	app.startReminders(workers)
+	app.startWaitlist(workers)
+	app.startQueueEvents(workers)
+	app.startExports(workers)
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -52,6 +66,9 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		// Tell the background workers to stop picking up new work.
+		stopWorkers()
+
 		// call Shutdown on the server, and only send on the shutdownError channel if it returns
 		// an error
 		err := srv.Shutdown(ctx)
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// validateServiceIDs checks the services booked for an appointment.
+func validateServiceIDs(v *validator.Validator, serviceIDs []int64) {
+	seen := make(map[int64]bool)
+	for _, id := range serviceIDs {
+		v.Check(id > 0, "service_ids", "must be positive integers")
+		v.Check(!seen[id], "service_ids", "must not contain duplicate values")
+		seen[id] = true
+	}
+	v.Check(len(serviceIDs) <= 20, "service_ids", "must not contain more than 20 services")
+}
+
+// isServicePricingError reports whether err means that the services of an appointment can't be
+// booked with its doctor or on its day.
+func isServicePricingError(err error) bool {
+	return errors.Is(err, model.ErrServiceUnavailable) || errors.Is(err, model.ErrDuplicateService) ||
+		errors.Is(err, model.ErrNoPrice) || errors.Is(err, model.ErrCurrencyMismatch)
+}
+
+// createServiceHandler adds a service to the catalogue with its list price, which applies
+// from effective_from or today.
+func (app *application) createServiceHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code            string `json:"code"`
+		Name            string `json:"name"`
+		Speciality      string `json:"speciality"`
+		DurationMinutes int    `json:"duration_minutes"`
+		VATRate         int    `json:"vat_rate"`
+		Active          *bool  `json:"active"`
+		Price           int64  `json:"price"`
+		Currency        string `json:"currency"`
+		EffectiveFrom   string `json:"effective_from"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	service := &model.Service{
+		Code:            input.Code,
+		Name:            input.Name,
+		Speciality:      input.Speciality,
+		DurationMinutes: input.DurationMinutes,
+		VATRate:         input.VATRate,
+		Active:          input.Active == nil || *input.Active,
+	}
+	price := &model.ServicePrice{
+		Amount:        input.Price,
+		Currency:      input.Currency,
+		EffectiveFrom: input.EffectiveFrom,
+	}
+	if price.EffectiveFrom == "" {
+		price.EffectiveFrom = time.Now().Format("2006-01-02")
+	}
+
+	v := validator.New()
+	model.ValidateService(v, service)
+	model.ValidateServicePrice(v, price)
+	// The list price is given as price.
+	if msg, ok := v.Errors["amount"]; ok {
+		v.Errors["price"] = msg
+		delete(v.Errors, "amount")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Services.Insert(service, price); err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateName):
+			app.failedValidationResponse(w, r, map[string]string{"code": "a service with this code already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/services/%d", service.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"service": service}, headers)
+}
+
+// listServicesHandler returns the catalogue. ?speciality= and ?doctor_id= limit it to the
+// services of a speciality or a doctor's speciality, and ?active=true to the active services.
+func (app *application) listServicesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := model.ServiceFilter{
+		Speciality: app.readStrings(qs, "speciality", ""),
+		DoctorID:   int64(app.readInt(qs, "doctor_id", 0, v)),
+	}
+	v.Check(filter.DoctorID >= 0, "doctor_id", "must be a positive integer")
+	active := app.readStrings(qs, "active", "")
+	v.Check(validator.In(active, "", "true", "false"), "active", "must be true or false")
+	filter.ActiveOnly = active == "true"
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	services, err := app.tenantModels(r).Services.GetAll(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"services": services}, nil)
+}
+
+// showServiceHandler returns a service with its price history.
+func (app *application) showServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	service, err := app.tenantModels(r).Services.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"service": service}, nil)
+}
+
+// updateServiceHandler changes a service. Prices are changed by adding prices, so that the
+// price history is kept.
+func (app *application) updateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	service, err := app.tenantModels(r).Services.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Code            *string `json:"code"`
+		Name            *string `json:"name"`
+		Speciality      *string `json:"speciality"`
+		DurationMinutes *int    `json:"duration_minutes"`
+		VATRate         *int    `json:"vat_rate"`
+		Active          *bool   `json:"active"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Code != nil {
+		service.Code = *input.Code
+	}
+	if input.Name != nil {
+		service.Name = *input.Name
+	}
+	if input.Speciality != nil {
+		service.Speciality = *input.Speciality
+	}
+	if input.DurationMinutes != nil {
+		service.DurationMinutes = *input.DurationMinutes
+	}
+	if input.VATRate != nil {
+		service.VATRate = *input.VATRate
+	}
+	if input.Active != nil {
+		service.Active = *input.Active
+	}
+
+	v := validator.New()
+	model.ValidateService(v, service)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Services.Update(service); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateName):
+			app.failedValidationResponse(w, r, map[string]string{"code": "a service with this code already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"service": service}, nil)
+}
+
+func (app *application) deleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.tenantModels(r).Services.Delete(int64(id)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrServiceInUse):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "service successfully deleted"}, nil)
+}
+
+// createServicePriceHandler adds a price to the history of a service: a new list price, or an
+// override at a branch, for a doctor or both.
+func (app *application) createServicePriceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		BranchID      *int64 `json:"branch_id"`
+		DoctorID      *int64 `json:"doctor_id"`
+		Amount        int64  `json:"amount"`
+		Currency      string `json:"currency"`
+		EffectiveFrom string `json:"effective_from"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	price := &model.ServicePrice{
+		ServiceID:     int64(serviceID),
+		BranchID:      input.BranchID,
+		DoctorID:      input.DoctorID,
+		Amount:        input.Amount,
+		Currency:      input.Currency,
+		EffectiveFrom: input.EffectiveFrom,
+	}
+
+	v := validator.New()
+	if model.ValidateServicePrice(v, price); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Services.InsertPrice(price); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicatePrice):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/services/%d", serviceID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"price": price}, headers)
+}
+
+// deleteServicePriceHandler removes a price which hasn't taken effect yet.
+func (app *application) deleteServicePriceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.tenantModels(r).Services.DeletePrice(int64(id)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrPriceInEffect):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "price successfully deleted"}, nil)
+}
+
+// quoteServiceHandler returns the price of a service for ?doctor_id= at ?branch_id= on ?date=,
+// which defaults to today in the branch's time zone.
+func (app *application) quoteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+	v := validator.New()
+	doctorID := app.readInt(qs, "doctor_id", 0, v)
+	v.Check(doctorID >= 0, "doctor_id", "must be a positive integer")
+	branchID := app.readBranchID(qs, v)
+	day := app.readStrings(qs, "date", "")
+	if day != "" {
+		_, err := time.Parse("2006-01-02", day)
+		v.Check(err == nil, "date", "must be a date such as 2024-07-01")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if day == "" {
+		loc, err := app.branchLocation(r, branchID)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		day = time.Now().In(loc).Format("2006-01-02")
+	}
+
+	if _, err := app.tenantModels(r).Services.Get(int64(id)); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	price, err := app.tenantModels(r).Services.Quote(int64(id), int64(doctorID), branchID, day)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNoPrice):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"price": price}, nil)
+}
+
+// showAppointmentServicesHandler returns the services of an appointment and what it costs.
+func (app *application) showAppointmentServicesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	appointment, err := app.tenantModels(r).Appointments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	cost, err := app.tenantModels(r).Appointments.Cost(appointment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"cost": cost}, nil)
+}
+
+// setAppointmentServicesHandler replaces the services of an appointment, which changes its
+// duration and cost.
+func (app *application) setAppointmentServicesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ServiceIDs []int64 `json:"service_ids"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.ServiceIDs != nil, "service_ids", "must be provided")
+	if validateServiceIDs(v, input.ServiceIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	models := app.tenantModels(r)
+	appointment, err := models.Appointments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := models.Appointments.SetServices(appointment, input.ServiceIDs); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrSlotTaken), errors.Is(err, model.ErrDoctorUnavailable), errors.Is(err, model.ErrRoomTaken),
+			errors.Is(err, model.ErrAlreadyInvoiced):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, model.ErrBranchMismatch), isServicePricingError(err):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	cost, err := models.Appointments.Cost(appointment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"cost": cost}, nil)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/validator"
+)
+
+// openShiftHandler opens a shift for the cashier. Until it is closed the cashier's payments
+// are taken in it.
+func (app *application) openShiftHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		BranchID     *int64 `json:"branch_id"`
+		Currency     string `json:"currency"`
+		OpeningFloat int64  `json:"opening_float"`
+		Notes        string `json:"notes"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	shift := &model.CashierShift{
+		UserID:       app.contextGetUser(r).ID,
+		BranchID:     input.BranchID,
+		Currency:     input.Currency,
+		OpeningFloat: input.OpeningFloat,
+		Notes:        input.Notes,
+	}
+
+	v := validator.New()
+	if model.ValidateShift(v, shift); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Shifts.Open(shift); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"branch_id": "must be an existing branch"})
+		case errors.Is(err, model.ErrShiftOpen):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/shifts/%d", shift.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"shift": shift}, headers)
+}
+
+// currentShiftHandler returns the cashier's open shift with what it is expected to hold so far.
+func (app *application) currentShiftHandler(w http.ResponseWriter, r *http.Request) {
+	shift, err := app.tenantModels(r).Shifts.Current(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusNotFound, "you have no open shift")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"shift": shift}, nil)
+}
+
+// listShiftsHandler returns shifts, latest first. They can be filtered by ?user_id=,
+// ?branch_id=, ?open=true and the period they were opened in, ?from= and ?to=.
+func (app *application) listShiftsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := model.ShiftFilter{
+		UserID:   int64(app.readInt(qs, "user_id", 0, v)),
+		BranchID: app.readBranchID(qs, v),
+		Limit:    app.readInt(qs, "limit", 50, v),
+		Offset:   app.readInt(qs, "offset", 0, v),
+	}
+	filter.From, filter.To = app.readPeriod(r, v)
+	open := app.readStrings(qs, "open", "")
+	filter.OpenOnly = open == "true"
+	v.Check(filter.UserID >= 0, "user_id", "must be a positive integer")
+	v.Check(validator.In(open, "", "true", "false"), "open", "must be true or false")
+	v.Check(filter.Limit > 0 && filter.Limit <= 500, "limit", "must be between 1 and 500")
+	v.Check(filter.Offset >= 0, "offset", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	shifts, err := app.tenantModels(r).Shifts.GetAll(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"shifts": shifts}, nil)
+}
+
+func (app *application) showShiftHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	shift, err := app.tenantModels(r).Shifts.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"shift": shift}, nil)
+}
+
+// closeShiftHandler closes a shift with the amounts the cashier counted per payment method and
+// currency. The response shows where they differ from what was expected.
+func (app *application) closeShiftHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Counts []model.ShiftCount `json:"counts"`
+		Notes  string             `json:"notes"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Counts) > 0, "counts", "must be provided")
+	if model.ValidateShiftCounts(v, input.Counts); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	shift, err := app.tenantModels(r).Shifts.Close(int64(id), input.Counts, input.Notes, &user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrShiftClosed):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"shift": shift}, nil)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"sync"
+
+	"GoClinic/pkg/web/model"
+)
+
+// tenant is a clinic hosted by the server, with the models which work on its data. They use a
+// connection pool of their own whose connections only see the tenant's rows, so a query which
+// forgets to filter by tenant can't return the data of another clinic. The pools log in with
+// the role of -tenant-dsn, which the server checks doesn't bypass row-level security.
+type tenant struct {
+	*model.Tenant
+	db     *sql.DB
+	models model.Models
+}
+
+// tenantPools keeps the tenants which have been used so far, each with its connection pool.
+type tenantPools struct {
+	mu   sync.Mutex
+	byID map[int64]*tenant
+}
+
+func newTenantPools() *tenantPools {
+	return &tenantPools{byID: make(map[int64]*tenant)}
+}
+
+// tenantByID returns a tenant, opening its connection pool when it's first used.
+func (app *application) tenantByID(id int64) (*tenant, error) {
+	app.tenants.mu.Lock()
+	t, ok := app.tenants.byID[id]
+	app.tenants.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	mt, err := app.models.Tenants.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return app.openTenant(mt)
+}
+
+// tenantBySlug returns the tenant with the given slug. Slugs are looked up every time, since
+// they are only used by requests without a user.
+func (app *application) tenantBySlug(slug string) (*tenant, error) {
+	mt, err := app.models.Tenants.GetBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	return app.tenantByID(mt.ID)
+}
+
+// allTenants returns every tenant, for the background jobs which work on all of them.
+func (app *application) allTenants() ([]*tenant, error) {
+	mts, err := app.models.Tenants.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]*tenant, 0, len(mts))
+	for _, mt := range mts {
+		t, err := app.tenantByID(mt.ID)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+func (app *application) openTenant(mt *model.Tenant) (*tenant, error) {
+	app.tenants.mu.Lock()
+	defer app.tenants.mu.Unlock()
+
+	// Another request may have opened it in the meantime.
+	if t, ok := app.tenants.byID[mt.ID]; ok {
+		return t, nil
+	}
+
+	dsn, err := model.TenantDSN(app.config.db.tenantDSN, mt.ID)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tenant{Tenant: mt, db: db, models: model.NewModels(db).WithCipher(app.config.fields)}
+	app.tenants.byID[mt.ID] = t
+	return t, nil
+}
+
+// closeTenants closes the connection pools of the tenants.
+func (app *application) closeTenants() {
+	app.tenants.mu.Lock()
+	defer app.tenants.mu.Unlock()
+	for id, t := range app.tenants.byID {
+		if err := t.db.Close(); err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		delete(app.tenants.byID, id)
+	}
+}
+
+// tenantQuery returns the query string which makes the links sent to patients, who have no
+// user, work on the tenant's data.
+func tenantQuery(t *tenant) string {
+	return "?tenant=" + url.QueryEscape(t.Slug)
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/notify"
 	"GoClinic/pkg/web/validator"
 )
 
@@ -28,9 +31,12 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// we set the Activated field to false, which isn't strictly necessary because
 	// the Activated field will have the zero-value of false by default. But setting
 	// this explicitly helps to make our intentions clear to anyone reading the code.
+	// The user joins the tenant the request was made for, which requireRegistration has
+	// checked the client may add users to.
 	user := &model.User{
-		Name:  input.Name,
-		Email: input.Email,
+		TenantID: app.contextGetTenant(r).ID,
+		Name:     input.Name,
+		Email:    input.Email,
 	}
 
 	// Use the Password.Set() method to generate and store the hashed and plaintext
@@ -81,15 +87,39 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var res struct {
-		Token *string     `json:"token"`
-		User  *model.User `json:"user"`
+	// The token only reaches the owner of the email address, so that nobody can activate an
+	// account for an address they don't control.
+	app.background(func() {
+		app.sendActivationToken(user, token.Plaintext)
+	})
+
+	app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+}
+
+// sendActivationToken emails a new user the token which activates their account. Without an
+// email channel the user is activated with clinicctl user-create instead.
+func (app *application) sendActivationToken(user *model.User, token string) {
+	notifier, ok := app.notifiers[notify.ChannelEmail]
+	if !ok {
+		app.logger.PrintInfo("no email channel to send the activation token", map[string]string{
+			"user_id": fmt.Sprint(user.ID),
+		})
+		return
 	}
 
-	res.Token = &token.Plaintext
-	res.User = user
+	ctx, cancel := context.WithTimeout(context.Background(), reminderSendTimeout)
+	defer cancel()
 
-	app.writeJSON(w, http.StatusCreated, envelope{"user": res}, nil)
+	err := notifier.Send(ctx, notify.Message{
+		To:      user.Email,
+		Subject: "Activate your GoClinic account",
+		Body: fmt.Sprintf("Hello %s, your GoClinic account has been created.\n\n"+
+			"Activate it with PUT /api/v1/users/activated and the body {\"token\": %q} within 3 days.",
+			user.Name, token),
+	})
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"user_id": fmt.Sprint(user.ID)})
+	}
 }
 
 // activateUserHandler activates a user by setting 'activation = true' using the provided
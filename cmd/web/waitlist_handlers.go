@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"GoClinic/pkg/web/model"
+	"GoClinic/pkg/web/notify"
+	"GoClinic/pkg/web/validator"
+	"github.com/gorilla/mux"
+)
+
+// waitlistExpiryInterval is how often expired offers are passed on to the next patient.
+const waitlistExpiryInterval = time.Minute
+
+// waitlistOfferURL returns the link which lets a patient accept or decline an offered slot.
+func (app *application) waitlistOfferURL(t *tenant, token, action string) string {
+	return app.config.baseURL + "/api/v1/waitlist-offers/" + token + "/" + action + tenantQuery(t)
+}
+
+// background runs fn in a goroutine which the graceful shutdown waits for.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// slotFreed offers the slot of an appointment which has just been cancelled or deleted to the
+// waitlist.
+func (app *application) slotFreed(t *tenant, doctorID int, dateTime string) {
+	start, err := time.Parse(time.RFC3339, dateTime)
+	if err != nil {
+		return
+	}
+
+	app.background(func() {
+		app.offerSlot(t, int64(doctorID), start)
+	})
+}
+
+// offerSlot holds a free slot for the next matching waitlisted patient and tells them about it.
+func (app *application) offerSlot(t *tenant, doctorID int64, start time.Time) {
+	offer, err := t.models.Waitlist.Offer(doctorID, start, app.config.waitlist.hold)
+	if err != nil {
+		// Nobody is waiting for the slot, or it has been booked again or closed already.
+		if !errors.Is(err, model.ErrRecordNotFound) && !errors.Is(err, model.ErrSlotTaken) &&
+			!errors.Is(err, model.ErrDoctorUnavailable) {
+			app.logger.PrintError(err, map[string]string{"tenant": t.Slug, "doctor_id": fmt.Sprint(doctorID)})
+		}
+		return
+	}
+
+	app.logger.PrintInfo("offered freed slot to waitlist", map[string]string{
+		"offer_id": fmt.Sprint(offer.ID),
+		"entry_id": fmt.Sprint(offer.EntryID),
+	})
+	app.notifyWaitlistOffer(t, offer)
+}
+
+// notifyWaitlistOffer sends the accept/decline links of an offer over every channel the
+// patient can be reached on. A patient who can't be reached keeps the offer until it expires,
+// so that staff can still book the slot for them over the phone.
+func (app *application) notifyWaitlistOffer(t *tenant, offer *model.WaitlistOffer) {
+	patient, err := t.models.Patients.Get(int(offer.PatientID))
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"offer_id": fmt.Sprint(offer.ID)})
+		return
+	}
+	doctor, err := t.models.Doctors.Get(int(offer.DoctorID))
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"offer_id": fmt.Sprint(offer.ID)})
+		return
+	}
+
+	msg := waitlistOfferMessage(offer, patient, doctor, app.waitlistOfferURL(t, offer.Token, "accept"),
+		app.waitlistOfferURL(t, offer.Token, "decline"))
+
+	for channel, notifier := range app.notifiers {
+		switch channel {
+		case notify.ChannelEmail:
+			msg.To = patient.Email
+		case notify.ChannelSMS:
+			msg.To = patient.Phone
+		}
+		if msg.To == "" {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), reminderSendTimeout)
+		err := notifier.Send(ctx, msg)
+		cancel()
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"offer_id": fmt.Sprint(offer.ID),
+				"channel":  channel,
+			})
+		}
+	}
+}
+
+// waitlistOfferMessage writes the text of an offer. Times are shown in the server's time zone.
+func waitlistOfferMessage(offer *model.WaitlistOffer, patient *model.Patient, doctor *model.Doctor,
+	acceptURL, declineURL string) notify.Message {
+	at := offer.DateTime.In(time.Local)
+	name := "Dr. " + doctor.FirstName + " " + doctor.LastName
+	if doctor.Speciality != "" {
+		name += " (" + doctor.Speciality + ")"
+	}
+
+	return notify.Message{
+		Subject: "Appointment available: " + at.Format("Mon 2 Jan 15:04"),
+		Body: fmt.Sprintf("Hello %s, an appointment with %s has become available on %s at %s.\n\n"+
+			"It is held for you until %s.\n\nBook it: %s\nNo, thanks: %s",
+			patient.FirstName, name, at.Format("Monday, 2 January 2006"), at.Format("15:04"),
+			offer.ExpiresAt.In(time.Local).Format("Mon 2 Jan 15:04"), acceptURL, declineURL),
+	}
+}
+
+// startWaitlist starts passing the slots of expired offers on to the next patient on the
+// waitlist, until ctx is cancelled. Expired offers are claimed in the database, so several
+// replicas may run it at the same time.
+func (app *application) startWaitlist(ctx context.Context) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(waitlistExpiryInterval)
+		defer ticker.Stop()
+
+		for {
+			app.cascadeExpiredOffers()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (app *application) cascadeExpiredOffers() {
+	tenants, err := app.allTenants()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, t := range tenants {
+		app.cascadeTenantExpiredOffers(t)
+	}
+}
+
+func (app *application) cascadeTenantExpiredOffers(t *tenant) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"tenant": t.Slug})
+		}
+	}()
+
+	offers, err := t.models.Waitlist.ExpireOffers()
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"tenant": t.Slug})
+		return
+	}
+
+	for _, offer := range offers {
+		app.offerSlot(t, offer.DoctorID, offer.DateTime)
+	}
+}
+
+func (app *application) createWaitlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PatientID  int64     `json:"patient_id"`
+		DoctorID   *int64    `json:"doctor_id"`
+		Speciality string    `json:"speciality"`
+		Earliest   time.Time `json:"earliest"`
+		Latest     time.Time `json:"latest"`
+		Priority   int       `json:"priority"`
+		Notes      string    `json:"notes"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	entry := &model.WaitlistEntry{
+		PatientID:  input.PatientID,
+		DoctorID:   input.DoctorID,
+		Speciality: input.Speciality,
+		Earliest:   input.Earliest,
+		Latest:     input.Latest,
+		Priority:   input.Priority,
+		Notes:      input.Notes,
+		CreatedBy:  &userID,
+	}
+
+	v := validator.New()
+	if model.ValidateWaitlistEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.tenantModels(r).Waitlist.Insert(entry); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"patient_id": "patient or doctor does not exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/waitlist/%d", entry.ID))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"waitlist_entry": entry}, headers)
+}
+
+// listWaitlistHandler returns the waitlist in the order in which patients are offered slots,
+// optionally only the entries a doctor's slots, or those of a branch, could be offered to.
+func (app *application) listWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	status := app.readStrings(qs, "status", "")
+	doctorID := app.readInt(qs, "doctor_id", 0, v)
+	branchID := app.readBranchID(qs, v)
+
+	v.Check(status == "" || validator.In(status, model.WaitlistWaiting, model.WaitlistOffered, model.WaitlistBooked,
+		model.WaitlistRemoved), "status", "must be waiting, offered, booked or removed")
+	v.Check(doctorID >= 0, "doctor_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, err := app.tenantModels(r).Waitlist.GetAll(status, int64(doctorID), branchID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"waitlist": entries}, nil)
+}
+
+// showWaitlistEntryHandler returns an entry with the offers made to it.
+func (app *application) showWaitlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	entry, err := app.tenantModels(r).Waitlist.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"waitlist_entry": entry}, nil)
+}
+
+// removeWaitlistEntryHandler takes a patient off the waitlist. A slot they were being offered
+// goes to the next patient.
+func (app *application) removeWaitlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	offer, err := app.tenantModels(r).Waitlist.Remove(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if offer != nil {
+		app.background(func() {
+			app.offerSlot(app.contextGetTenant(r), offer.DoctorID, offer.DateTime)
+		})
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "waitlist entry successfully removed"}, nil)
+}
+
+// waitlistOfferError explains why an offer link can't be used.
+func (app *application) waitlistOfferError(w http.ResponseWriter, r *http.Request, err error) {
+	var status int
+	var message string
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		status, message = http.StatusNotFound, "This link is not valid. Please contact the clinic."
+	case errors.Is(err, model.ErrTokenUsed):
+		status, message = http.StatusGone, "You have already answered this offer."
+	case errors.Is(err, model.ErrTokenExpired):
+		status, message = http.StatusGone, "Sorry, this offer has expired. You are still on the waitlist."
+	case errors.Is(err, model.ErrSlotTaken), errors.Is(err, model.ErrDoctorUnavailable):
+		status, message = http.StatusConflict, "Sorry, this appointment is no longer available. You are still on the waitlist."
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.appointmentActionResponse(w, r, status, appointmentActionView{Title: "Offer not available", Message: message},
+		envelope{"error": message})
+}
+
+// showWaitlistOfferHandler shows the slot of an offer link without answering the offer.
+func (app *application) showWaitlistOfferHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	action := vars["action"]
+
+	offer, err := app.tenantModels(r).Waitlist.GetOfferForToken(vars["token"])
+	if err != nil {
+		app.waitlistOfferError(w, r, err)
+		return
+	}
+
+	switch {
+	case offer.Status == model.OfferAccepted && action == "accept", offer.Status == model.OfferDeclined && action == "decline":
+		// Opening the link again after using it just shows the outcome.
+		app.waitlistOfferDone(w, r, offer, true)
+		return
+	case offer.Status == model.OfferExpired, offer.Status == model.OfferPending && !time.Now().Before(offer.ExpiresAt):
+		err = model.ErrTokenExpired
+	case offer.Status == model.OfferWithdrawn:
+		err = model.ErrSlotTaken
+	case offer.Status != model.OfferPending:
+		err = model.ErrTokenUsed
+	}
+	if err != nil {
+		app.waitlistOfferError(w, r, err)
+		return
+	}
+
+	view := appointmentActionView{
+		When:   app.waitlistOfferWhen(r, offer),
+		Action: r.URL.RequestURI(),
+	}
+	if action == "accept" {
+		view.Title, view.Message, view.Button = "Book this appointment", "This appointment is being held for you:", "Book appointment"
+	} else {
+		view.Title, view.Message, view.Button = "Decline this appointment", "Do you want to let someone else have this appointment?", "Decline"
+	}
+
+	app.appointmentActionResponse(w, r, http.StatusOK, view,
+		envelope{"offer": offer, "action": action, "method": http.MethodPost})
+}
+
+// answerWaitlistOfferHandler books or declines the slot of an offer link. A declined slot is
+// offered to the next patient straight away.
+func (app *application) answerWaitlistOfferHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	action := vars["action"]
+
+	var offer *model.WaitlistOffer
+	var err error
+	if action == "accept" {
+		offer, _, err = app.tenantModels(r).Waitlist.Accept(vars["token"])
+	} else {
+		offer, err = app.tenantModels(r).Waitlist.Decline(vars["token"])
+	}
+	if errors.Is(err, model.ErrTokenUsed) {
+		// A repeated request is answered like the first one if it asked for the same thing.
+		current, oerr := app.tenantModels(r).Waitlist.GetOfferForToken(vars["token"])
+		if oerr == nil && (current.Status == model.OfferAccepted && action == "accept" ||
+			current.Status == model.OfferDeclined && action == "decline") {
+			app.waitlistOfferDone(w, r, current, true)
+			return
+		}
+	}
+	if err != nil {
+		app.waitlistOfferError(w, r, err)
+		return
+	}
+
+	if offer.Status == model.OfferDeclined {
+		app.background(func() {
+			app.offerSlot(app.contextGetTenant(r), offer.DoctorID, offer.DateTime)
+		})
+	}
+
+	app.waitlistOfferDone(w, r, offer, false)
+}
+
+func (app *application) waitlistOfferDone(w http.ResponseWriter, r *http.Request, offer *model.WaitlistOffer,
+	repeated bool) {
+	view := appointmentActionView{When: app.waitlistOfferWhen(r, offer)}
+	if offer.Status == model.OfferAccepted {
+		view.Title, view.Message = "Appointment booked", "Thank you, we look forward to seeing you."
+	} else {
+		view.Title, view.Message = "Offer declined", "Thank you for letting us know. You are still on the waitlist."
+	}
+
+	app.appointmentActionResponse(w, r, http.StatusOK, view, envelope{"offer": offer, "repeated": repeated})
+}
+
+// waitlistOfferWhen describes the slot of an offer for patients.
+func (app *application) waitlistOfferWhen(r *http.Request, offer *model.WaitlistOffer) string {
+	return app.appointmentWhen(r, &model.Appointment{
+		DateTime: offer.DateTime.Format(time.RFC3339),
+		DoctorID: int(offer.DoctorID),
+	})
+}
+
+// cancelAppointmentHandler cancels an appointment, keeping it for the record, and offers its
+// slot to the waitlist. With ?scope=following or ?scope=all the appointments of its series
+// which follow it, or all that haven't started, are cancelled too.
+func (app *application) cancelAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	scope := app.readStrings(r.URL.Query(), "scope", model.SeriesScopeThis)
+
+	appointment := app.seriesAppointment(w, r, scope)
+	if appointment == nil {
+		return
+	}
+
+	cancelled := []*model.Appointment{}
+	if scope == model.SeriesScopeThis {
+		if appointment.Status != model.AppointmentCancelled {
+			id, _ := app.readIDParam(r)
+			if err := app.tenantModels(r).Appointments.Cancel(id); err != nil {
+				switch {
+				case errors.Is(err, model.ErrRecordNotFound):
+					app.notFoundResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			appointment.Status = model.AppointmentCancelled
+			cancelled = append(cancelled, appointment)
+		}
+	} else {
+		var err error
+		cancelled, err = app.tenantModels(r).Series.Cancel(appointment, scope)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, c := range cancelled {
+			if c.Id == appointment.Id {
+				appointment = c
+			}
+		}
+	}
+
+	for _, c := range cancelled {
+		app.slotFreed(app.contextGetTenant(r), c.DoctorID, c.DateTime)
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"appointment": appointment, "cancelled": cancelled}, nil)
+}
@@ -0,0 +1,10 @@
+-- The role the server's tenant connections log in as. Row-level security is what keeps the
+-- tenants apart, and superusers and roles with BYPASSRLS skip it, so this role has neither.
+-- Migrations still run as the owner of the tables, POSTGRES_USER.
+CREATE ROLE goclinic_app LOGIN PASSWORD 'goclinic_app' NOSUPERUSER NOBYPASSRLS NOCREATEDB NOCREATEROLE;
+
+GRANT USAGE ON SCHEMA public TO goclinic_app;
+
+-- The tables are created later, by the migrations.
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO goclinic_app;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO goclinic_app;
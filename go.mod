@@ -10,12 +10,10 @@ require (
 require golang.org/x/crypto v0.22.0
 
 require (
+	github.com/golang-migrate/migrate/v4 v4.17.1
 	github.com/hashicorp/errwrap v1.1.0
 	github.com/hashicorp/go-multierror v1.1.1
+	github.com/peterbourgon/ff/v3 v3.4.0
 )
 
-require (
-	github.com/golang-migrate/migrate/v4 v4.17.1 // indirect
-	github.com/peterbourgon/ff/v3 v3.4.0 // indirect
-	go.uber.org/atomic v1.7.0 // indirect
-)
+require go.uber.org/atomic v1.7.0 // indirect
@@ -0,0 +1,388 @@
+// Package claims writes batches of insurance claims as files for payers. Payers each want
+// their own layout, so the layout is described by a Format, which can be kept with the payer
+// and tried out locally with clinicctl claims-format-test, instead of being written in code.
+package claims
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Batch is a set of claims sent to a payer together.
+type Batch struct {
+	Number    string
+	CreatedAt time.Time
+	PayerCode string
+	PayerName string
+	Currency  string
+	Digits    int // of the minor unit of Currency, e.g. 2 for EUR
+	Claims    []*Claim
+}
+
+// Claim asks the payer for its part of an invoice. Total is what was billed, Copay the part
+// the patient pays, and Claimed what remains for the payer.
+type Claim struct {
+	Number        string
+	InvoiceNumber string
+	ServiceDate   time.Time
+	PatientID     int64
+	FirstName     string
+	LastName      string
+	MemberNumber  string
+	GroupNumber   string
+	Total         int64
+	Copay         int64
+	Claimed       int64
+	Lines         []*Line
+}
+
+// Line is a service billed on the invoice of a claim.
+type Line struct {
+	Position    int
+	Code        string
+	Description string
+	Quantity    int
+	UnitPrice   int64
+	Total       int64
+}
+
+// Values are the values fields can take. batch.* values can be used everywhere, claim.* values
+// in claim and line records, and line.* values only in line records.
+var Values = []string{
+	"batch.number", "batch.date", "batch.payer_code", "batch.payer_name", "batch.currency",
+	"batch.claims", "batch.lines", "batch.claimed",
+	"claim.number", "claim.invoice_number", "claim.service_date", "claim.patient_id",
+	"claim.first_name", "claim.last_name", "claim.member_number", "claim.group_number",
+	"claim.total", "claim.copay", "claim.claimed", "claim.lines",
+	"line.position", "line.code", "line.description", "line.quantity", "line.unit_price", "line.total",
+}
+
+// Format describes a batch file. Files are delimited, such as CSV, if Delimiter is set, and
+// fixed-width otherwise. There is a record for every claim, or for every line of every claim
+// if Records is "line", between an optional header and an optional trailer record.
+type Format struct {
+	Extension  string  `json:"extension,omitempty"`
+	Delimiter  string  `json:"delimiter,omitempty"`
+	Records    string  `json:"records,omitempty"`
+	Header     bool    `json:"header,omitempty"` // a record of the field names
+	DateLayout string  `json:"date_layout,omitempty"`
+	Decimal    bool    `json:"decimal_amounts,omitempty"` // 12.34 instead of 1234 minor units
+	CRLF       bool    `json:"crlf,omitempty"`
+	Fields     []Field `json:"fields"`
+	Trailer    []Field `json:"trailer,omitempty"`
+}
+
+// Field is a field of a record: a value of the batch, claim or line, or the constant Text. In
+// fixed-width files it is padded with Pad, a space by default, or cut to Width; Align "right"
+// pads on the left. Constants may leave out the width.
+type Field struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Width int    `json:"width,omitempty"`
+	Align string `json:"align,omitempty"`
+	Pad   string `json:"pad,omitempty"`
+}
+
+// DefaultFormat is used for payers which haven't been given a format: a CSV file with a line
+// for every service.
+var DefaultFormat = &Format{
+	Extension: "csv",
+	Delimiter: ",",
+	Records:   "line",
+	Header:    true,
+	Decimal:   true,
+	Fields: []Field{
+		{Name: "batch", Value: "batch.number"},
+		{Name: "payer", Value: "batch.payer_code"},
+		{Name: "claim", Value: "claim.number"},
+		{Name: "invoice", Value: "claim.invoice_number"},
+		{Name: "service_date", Value: "claim.service_date"},
+		{Name: "member_number", Value: "claim.member_number"},
+		{Name: "group_number", Value: "claim.group_number"},
+		{Name: "last_name", Value: "claim.last_name"},
+		{Name: "first_name", Value: "claim.first_name"},
+		{Name: "line", Value: "line.position"},
+		{Name: "code", Value: "line.code"},
+		{Name: "description", Value: "line.description"},
+		{Name: "quantity", Value: "line.quantity"},
+		{Name: "amount", Value: "line.total"},
+		{Name: "copay", Value: "claim.copay"},
+		{Name: "claimed", Value: "claim.claimed"},
+		{Name: "currency", Value: "batch.currency"},
+	},
+}
+
+// Validate returns the problems of the format, keyed like validator errors.
+func (f *Format) Validate() map[string]string {
+	errs := make(map[string]string)
+	check := func(ok bool, key, message string) {
+		if _, exists := errs[key]; !ok && !exists {
+			errs[key] = message
+		}
+	}
+
+	check(f.Records == "" || f.Records == "claim" || f.Records == "line", "records", "must be claim or line")
+	check(utf8.RuneCountInString(f.Delimiter) <= 1, "delimiter", "must be a single character")
+	check(f.Delimiter != "\n" && f.Delimiter != "\r" && f.Delimiter != `"`, "delimiter", "must not be a quote or a line break")
+	check(len(f.Extension) <= 10 && !strings.ContainsAny(f.Extension, `./\ `), "extension", "must be a file extension such as csv")
+	if f.DateLayout != "" {
+		check(time.Date(2024, 7, 9, 0, 0, 0, 0, time.UTC).Format(f.DateLayout) != f.DateLayout, "date_layout",
+			"must be a Go time layout such as 2006-01-02 or 20060102")
+	}
+	check(len(f.Fields) > 0, "fields", "must contain at least one field")
+	check(len(f.Fields) <= 100, "fields", "must not contain more than 100 fields")
+
+	validate := func(key string, fields []Field, lines bool) {
+		for i, field := range fields {
+			prefix := fmt.Sprintf("%s[%d].", key, i)
+			switch {
+			case field.Value == "":
+				check(field.Text != "" || field.Width > 0, prefix+"value", "must be provided")
+			case !lines && strings.HasPrefix(field.Value, "line."):
+				check(false, prefix+"value", "must not be a line value without line records")
+			case key == "trailer" && strings.HasPrefix(field.Value, "claim."):
+				check(false, prefix+"value", "must be a batch value")
+			default:
+				check(isValue(field.Value), prefix+"value", "must be one of "+strings.Join(Values, ", "))
+			}
+			check(field.Value == "" || field.Text == "", prefix+"text", "must not be given with value")
+			check(field.Width >= 0 && field.Width <= 1000, prefix+"width", "must be between 0 and 1000")
+			check(f.Delimiter != "" || field.Width > 0 || field.Text != "", prefix+"width", "must be given for fixed-width files")
+			check(field.Align == "" || field.Align == "left" || field.Align == "right", prefix+"align", "must be left or right")
+			check(utf8.RuneCountInString(field.Pad) <= 1, prefix+"pad", "must be a single character")
+		}
+	}
+	validate("fields", f.Fields, f.Records == "line")
+	check(len(f.Trailer) <= 100, "trailer", "must not contain more than 100 fields")
+	validate("trailer", f.Trailer, false)
+
+	return errs
+}
+
+func isValue(value string) bool {
+	for _, v := range Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// FileExtension returns the extension of the files of the format.
+func (f *Format) FileExtension() string {
+	switch {
+	case f.Extension != "":
+		return f.Extension
+	case f.Delimiter == ",":
+		return "csv"
+	default:
+		return "txt"
+	}
+}
+
+// Write writes the batch in the format. The format must be valid.
+func (f *Format) Write(w io.Writer, b *Batch) error {
+	if errs := f.Validate(); len(errs) > 0 {
+		return errors.New("claims: invalid format")
+	}
+
+	bw := bufio.NewWriter(w)
+	var cw *csv.Writer
+	if f.Delimiter != "" {
+		cw = csv.NewWriter(bw)
+		cw.Comma, _ = utf8.DecodeRuneInString(f.Delimiter)
+		cw.UseCRLF = f.CRLF
+	}
+
+	write := func(fields []Field, c *Claim, l *Line, header bool) error {
+		record := make([]string, len(fields))
+		for i, field := range fields {
+			switch {
+			case header:
+				record[i] = field.Name
+			case field.Value == "":
+				record[i] = field.Text
+			default:
+				record[i] = f.value(field.Value, b, c, l)
+			}
+		}
+		if cw != nil {
+			return cw.Write(record)
+		}
+
+		var sb strings.Builder
+		for i, field := range fields {
+			sb.WriteString(fixedWidth(record[i], field))
+		}
+		if f.CRLF {
+			sb.WriteString("\r\n")
+		} else {
+			sb.WriteString("\n")
+		}
+		_, err := bw.WriteString(sb.String())
+		return err
+	}
+
+	if f.Header {
+		if err := write(f.Fields, nil, nil, true); err != nil {
+			return err
+		}
+	}
+	for _, c := range b.Claims {
+		if f.Records != "line" {
+			if err := write(f.Fields, c, nil, false); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, l := range c.Lines {
+			if err := write(f.Fields, c, l, false); err != nil {
+				return err
+			}
+		}
+	}
+	if len(f.Trailer) > 0 {
+		if err := write(f.Trailer, nil, nil, false); err != nil {
+			return err
+		}
+	}
+
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// value returns a value of the batch, or of the claim c and the line l of the record.
+func (f *Format) value(name string, b *Batch, c *Claim, l *Line) string {
+	date := func(t time.Time) string {
+		if f.DateLayout != "" {
+			return t.Format(f.DateLayout)
+		}
+		return t.Format("2006-01-02")
+	}
+	amount := func(a int64) string {
+		if !f.Decimal || b.Digits == 0 {
+			return strconv.FormatInt(a, 10)
+		}
+		sign := ""
+		if a < 0 {
+			sign, a = "-", -a
+		}
+		unit := int64(1)
+		for i := 0; i < b.Digits; i++ {
+			unit *= 10
+		}
+		return fmt.Sprintf("%s%d.%0*d", sign, a/unit, b.Digits, a%unit)
+	}
+
+	switch name {
+	case "batch.number":
+		return b.Number
+	case "batch.date":
+		return date(b.CreatedAt)
+	case "batch.payer_code":
+		return b.PayerCode
+	case "batch.payer_name":
+		return b.PayerName
+	case "batch.currency":
+		return b.Currency
+	case "batch.claims":
+		return strconv.Itoa(len(b.Claims))
+	case "batch.lines":
+		n := 0
+		for _, c := range b.Claims {
+			n += len(c.Lines)
+		}
+		return strconv.Itoa(n)
+	case "batch.claimed":
+		var total int64
+		for _, c := range b.Claims {
+			total += c.Claimed
+		}
+		return amount(total)
+	}
+
+	if c == nil {
+		return ""
+	}
+	switch name {
+	case "claim.number":
+		return c.Number
+	case "claim.invoice_number":
+		return c.InvoiceNumber
+	case "claim.service_date":
+		return date(c.ServiceDate)
+	case "claim.patient_id":
+		return strconv.FormatInt(c.PatientID, 10)
+	case "claim.first_name":
+		return c.FirstName
+	case "claim.last_name":
+		return c.LastName
+	case "claim.member_number":
+		return c.MemberNumber
+	case "claim.group_number":
+		return c.GroupNumber
+	case "claim.total":
+		return amount(c.Total)
+	case "claim.copay":
+		return amount(c.Copay)
+	case "claim.claimed":
+		return amount(c.Claimed)
+	case "claim.lines":
+		return strconv.Itoa(len(c.Lines))
+	}
+
+	if l == nil {
+		return ""
+	}
+	switch name {
+	case "line.position":
+		return strconv.Itoa(l.Position)
+	case "line.code":
+		return l.Code
+	case "line.description":
+		return l.Description
+	case "line.quantity":
+		return strconv.Itoa(l.Quantity)
+	case "line.unit_price":
+		return amount(l.UnitPrice)
+	case "line.total":
+		return amount(l.Total)
+	}
+	return ""
+}
+
+// fixedWidth pads s to the width of the field, or cuts it; constants without a width are kept
+// as they are. Line breaks are replaced by spaces so that a value can't break the record.
+func fixedWidth(s string, field Field) string {
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	if field.Width == 0 {
+		return s
+	}
+	n := utf8.RuneCountInString(s)
+	if n >= field.Width {
+		return string([]rune(s)[:field.Width])
+	}
+
+	pad := field.Pad
+	if pad == "" {
+		pad = " "
+	}
+	padding := strings.Repeat(pad, field.Width-n)
+	if field.Align == "right" {
+		return padding + s
+	}
+	return s + padding
+}
@@ -0,0 +1,101 @@
+package fhir
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseToken splits a token search parameter of the form [system|]code. hasSystem reports
+// whether a system part (possibly empty) was given.
+func ParseToken(s string) (system, code string, hasSystem bool) {
+	if i := strings.IndexByte(s, '|'); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return "", s, false
+}
+
+// ParseReference returns the ID from a reference search parameter, which may be given as a
+// bare ID, as Type/ID or as an absolute URL ending in Type/ID. The type must be one of types.
+func ParseReference(s string, types ...string) (string, bool) {
+	parts := strings.Split(strings.TrimSuffix(s, "/"), "/")
+	if len(parts) == 1 {
+		return parts[0], parts[0] != ""
+	}
+	typ, id := parts[len(parts)-2], parts[len(parts)-1]
+	for _, t := range types {
+		if typ == t {
+			return id, id != ""
+		}
+	}
+	return "", false
+}
+
+// DateRange is a half-open time range [From, To). A zero bound is open.
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// Intersect narrows the range by a date search parameter such as ge2024-06-01, lt2024-07 or
+// 2024-06-01T10:00:00Z. Without a prefix, eq is assumed, which matches the whole period the
+// value's precision covers.
+func (r *DateRange) Intersect(param string) error {
+	prefix := "eq"
+	if len(param) > 2 && param[0] >= 'a' && param[0] <= 'z' {
+		prefix, param = param[:2], param[2:]
+	}
+
+	lo, hi, err := parseDateValue(param)
+	if err != nil {
+		return err
+	}
+
+	var from, to time.Time
+	switch prefix {
+	case "eq":
+		from, to = lo, hi
+	case "ge":
+		from = lo
+	case "gt", "sa":
+		from = hi
+	case "lt", "eb":
+		to = lo
+	case "le":
+		to = hi
+	default:
+		return fmt.Errorf("unsupported date prefix %q", prefix)
+	}
+
+	if !from.IsZero() && (r.From.IsZero() || from.After(r.From)) {
+		r.From = from
+	}
+	if !to.IsZero() && (r.To.IsZero() || to.Before(r.To)) {
+		r.To = to
+	}
+	return nil
+}
+
+// parseDateValue returns the period covered by a FHIR date or dateTime value.
+func parseDateValue(s string) (lo, hi time.Time, err error) {
+	layouts := []struct {
+		layout string
+		next   func(time.Time) time.Time
+	}{
+		{time.RFC3339, func(t time.Time) time.Time { return t.Add(time.Second) }},
+		{"2006-01-02T15:04Z07:00", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+		{"2006-01-02T15:04:05", func(t time.Time) time.Time { return t.Add(time.Second) }},
+		{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	}
+
+	for _, l := range layouts {
+		t, err := time.Parse(l.layout, s)
+		if err == nil {
+			return t, l.next(t), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q", s)
+}
@@ -0,0 +1,232 @@
+// Package fieldcrypt encrypts single database fields with envelope encryption. Every value is
+// encrypted with AES-256-GCM under a data key of its own, and the data key is wrapped by a
+// versioned key-encryption key held by a KMS. Encrypted values are text, so they fit in the
+// existing columns:
+//
+//	enc:v1:<base64 of key version | wrapped data key length | wrapped data key | nonce | ciphertext>
+//
+// Values without the prefix are plaintext written before encryption was enabled; they are
+// returned as they are, and Reencrypt encrypts them. Blind indexes are keyed hashes of a value
+// which allow exact-match lookups of encrypted fields without decrypting them.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks encrypted values and the version of their format.
+const prefix = "enc:v1:"
+
+// KeySize is the size of the key-encryption keys, data keys and blind index keys.
+const KeySize = 32
+
+var (
+	// ErrNoKey is returned when a value was encrypted with a key version which isn't available,
+	// or when an encrypted value is read without keys.
+	ErrNoKey = errors.New("fieldcrypt: key version not available")
+	// ErrMalformed is returned when an encrypted value can't be decoded or has been tampered
+	// with.
+	ErrMalformed = errors.New("fieldcrypt: malformed encrypted value")
+)
+
+// KMS wraps and unwraps data keys with versioned key-encryption keys, which never leave it.
+type KMS interface {
+	// CurrentVersion is the version of the key new data keys are wrapped with.
+	CurrentVersion() uint32
+	// Wrap encrypts a data key with the key of the given version.
+	Wrap(version uint32, dataKey []byte) ([]byte, error)
+	// Unwrap decrypts a data key wrapped with the key of the given version.
+	Unwrap(version uint32, wrapped []byte) ([]byte, error)
+}
+
+// Cipher encrypts and decrypts fields and computes their blind indexes. A nil Cipher leaves
+// values in plaintext, so that encryption can be disabled in development.
+type Cipher struct {
+	kms      KMS
+	indexKey []byte
+}
+
+// New returns a Cipher which wraps its data keys with kms and computes blind indexes with
+// indexKey. The index key isn't rotated with the key-encryption keys: changing it changes
+// every index.
+func New(kms KMS, indexKey []byte) (*Cipher, error) {
+	if len(indexKey) < KeySize {
+		return nil, fmt.Errorf("fieldcrypt: blind index key must be at least %d bytes", KeySize)
+	}
+	return &Cipher{kms: kms, indexKey: indexKey}, nil
+}
+
+// IsEncrypted reports whether a value is encrypted.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyVersion returns the version of the key an encrypted value's data key is wrapped with.
+func KeyVersion(value string) (uint32, error) {
+	blob, err := decode(value)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(blob), nil
+}
+
+// Encrypt encrypts a value under a new data key wrapped with the current key. The empty string
+// stays empty, so that a missing value is still recognisable.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	if c == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	version := c.kms.CurrentVersion()
+	wrapped, err := c.kms.Wrap(version, dataKey)
+	if err != nil {
+		return "", err
+	}
+	if len(wrapped) > 0xffff {
+		return "", errors.New("fieldcrypt: wrapped data key too long")
+	}
+
+	header := make([]byte, 6, 6+len(wrapped))
+	binary.BigEndian.PutUint32(header, version)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	sealed, err := seal(dataKey, []byte(plaintext), header)
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawStdEncoding.EncodeToString(append(header, sealed...)), nil
+}
+
+// Decrypt returns the plaintext of an encrypted value, or the value itself if it isn't
+// encrypted.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", ErrNoKey
+	}
+
+	blob, err := decode(value)
+	if err != nil {
+		return "", err
+	}
+	version := binary.BigEndian.Uint32(blob)
+	n := int(binary.BigEndian.Uint16(blob[4:]))
+	if len(blob) < 6+n {
+		return "", ErrMalformed
+	}
+	header, sealed := blob[:6+n], blob[6+n:]
+
+	dataKey, err := c.kms.Unwrap(version, header[6:])
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, sealed, header)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Reencrypt encrypts a value under the current key if it is in plaintext or under an older
+// key. It reports whether the value changed.
+func (c *Cipher) Reencrypt(value string) (string, bool, error) {
+	if c == nil || value == "" {
+		return value, false, nil
+	}
+	if IsEncrypted(value) {
+		version, err := KeyVersion(value)
+		if err != nil {
+			return "", false, err
+		}
+		if version == c.kms.CurrentVersion() {
+			return value, false, nil
+		}
+	}
+
+	plaintext, err := c.Decrypt(value)
+	if err != nil {
+		return "", false, err
+	}
+	value, err = c.Encrypt(plaintext)
+	return value, err == nil, err
+}
+
+// BlindIndex returns a keyed hash of a field's value, the same every time, for exact-match
+// lookups. The field name keeps equal values of different fields from having the same index.
+// Values should be normalized first. Without a Cipher the value itself is returned, as it is
+// stored in plaintext anyway.
+func (c *Cipher) BlindIndex(field, value string) string {
+	if c == nil {
+		return value
+	}
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func decode(value string) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return nil, ErrMalformed
+	}
+	blob, err := base64.RawStdEncoding.DecodeString(value[len(prefix):])
+	if err != nil || len(blob) < 6 {
+		return nil, ErrMalformed
+	}
+	return blob, nil
+}
+
+// seal encrypts plaintext with AES-GCM under key, authenticating additional, and returns the
+// nonce followed by the ciphertext.
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open decrypts what seal returned.
+func open(key, sealed, additional []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,271 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	key1     = bytes.Repeat([]byte{1}, KeySize)
+	key2     = bytes.Repeat([]byte{2}, KeySize)
+	indexKey = bytes.Repeat([]byte{9}, KeySize)
+)
+
+// newCipher returns a Cipher with keys 1 and 2, wrapping new data keys with current.
+func newCipher(t *testing.T, current uint32) *Cipher {
+	t.Helper()
+	kms, err := NewKeyring(map[uint32][]byte{1: key1, 2: key2}, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(kms, indexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := newCipher(t, 2)
+
+	for _, plaintext := range []string{"", "+7 701 234 5678", "Әлия Қасымова", strings.Repeat("x", 10000)} {
+		encrypted, err := c.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext == "" {
+			if encrypted != "" {
+				t.Errorf("Encrypt(\"\") = %q, want the empty string", encrypted)
+			}
+			continue
+		}
+
+		if !IsEncrypted(encrypted) || strings.Contains(encrypted, plaintext) {
+			t.Errorf("Encrypt(%.20q) = %.40q, want an encrypted value", plaintext, encrypted)
+		}
+		if version, err := KeyVersion(encrypted); err != nil || version != 2 {
+			t.Errorf("KeyVersion() = %d, %v, want 2", version, err)
+		}
+		if again, _ := c.Encrypt(plaintext); again == encrypted {
+			t.Error("encrypting the same value twice gave the same result")
+		}
+
+		got, err := c.Decrypt(encrypted)
+		if err != nil || got != plaintext {
+			t.Errorf("Decrypt() = %.20q, %v, want %.20q", got, err, plaintext)
+		}
+	}
+
+	// Values written before encryption was enabled are read as they are.
+	if got, err := c.Decrypt("+7 701 234 5678"); err != nil || got != "+7 701 234 5678" {
+		t.Errorf("Decrypt(plaintext) = %q, %v", got, err)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	c := newCipher(t, 1)
+	encrypted, err := c.Encrypt("850101300123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(encrypted, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappedLen := int(blob[4])<<8 | int(blob[5])
+
+	reencode := func(b []byte) string { return prefix + base64.RawStdEncoding.EncodeToString(b) }
+	flip := func(i int, mask byte) string {
+		b := bytes.Clone(blob)
+		b[i] ^= mask
+		return reencode(b)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"wrapped data key", flip(6, 1)},
+		{"nonce", flip(6+wrappedLen, 1)},
+		{"ciphertext", flip(len(blob)-20, 1)},
+		{"authentication tag", flip(len(blob)-1, 1)},
+		// Version 1 becomes 2, which is in the keyring but didn't wrap the data key.
+		{"version of another key", flip(3, 0x03)},
+		{"wrapped key length", flip(5, 1)},
+		{"truncated", reencode(blob[:len(blob)-1])},
+		{"only the header", reencode(blob[:6+wrappedLen])},
+		{"shorter than the header", reencode(blob[:4])},
+		{"not base64", prefix + "***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := c.Decrypt(tt.value); !errors.Is(err, ErrMalformed) {
+				t.Errorf("Decrypt() = %q, %v, want ErrMalformed", got, err)
+			}
+		})
+	}
+}
+
+func TestDecryptUnknownKey(t *testing.T) {
+	c := newCipher(t, 2)
+	encrypted, err := c.Encrypt("850101300123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A keyring which lost version 2, e.g. a server started with an outdated configuration.
+	kms, err := NewKeyring(map[uint32][]byte{1: key1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := New(kms, indexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Decrypt(encrypted); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Decrypt() error = %v, want ErrNoKey", err)
+	}
+	if _, _, err := old.Reencrypt(encrypted); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Reencrypt() error = %v, want ErrNoKey", err)
+	}
+
+	// Without a Cipher, encrypted values can't be read at all.
+	var none *Cipher
+	if _, err := none.Decrypt(encrypted); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Decrypt() without keys error = %v, want ErrNoKey", err)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	v1 := newCipher(t, 1)
+	v2 := newCipher(t, 2)
+
+	old, err := v1.Encrypt("+7 701 234 5678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := v2.Encrypt("+7 701 234 5678")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		value       string
+		wantChanged bool
+	}{
+		{"older key", old, true},
+		{"plaintext", "+7 701 234 5678", true},
+		{"current key", current, false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := v2.Reencrypt(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("Reencrypt() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !changed {
+				if got != tt.value {
+					t.Errorf("Reencrypt() = %q, want the value unchanged", got)
+				}
+				return
+			}
+
+			if version, err := KeyVersion(got); err != nil || version != 2 {
+				t.Errorf("KeyVersion() = %d, %v, want 2", version, err)
+			}
+			if plaintext, err := v2.Decrypt(got); err != nil || plaintext != "+7 701 234 5678" {
+				t.Errorf("Decrypt() = %q, %v", plaintext, err)
+			}
+		})
+	}
+
+	var none *Cipher
+	if got, changed, err := none.Reencrypt("+7 701 234 5678"); got != "+7 701 234 5678" || changed || err != nil {
+		t.Errorf("Reencrypt() without keys = %q, %v, %v, want the value unchanged", got, changed, err)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	c := newCipher(t, 1)
+
+	a := c.BlindIndex("patients.national_id", "850101300123")
+	if a == "850101300123" || a != c.BlindIndex("patients.national_id", "850101300123") {
+		t.Errorf("BlindIndex() = %q, want a stable keyed hash", a)
+	}
+	if a == c.BlindIndex("patients.phone", "850101300123") {
+		t.Error("equal values of different fields have the same index")
+	}
+	if a == c.BlindIndex("patients.national_id", "850101300124") {
+		t.Error("different values have the same index")
+	}
+	// The index key doesn't rotate with the key-encryption keys.
+	if a != newCipher(t, 2).BlindIndex("patients.national_id", "850101300123") {
+		t.Error("the index changed with the current key version")
+	}
+
+	var none *Cipher
+	if got := none.BlindIndex("patients.national_id", "850101300123"); got != "850101300123" {
+		t.Errorf("BlindIndex() without keys = %q, want the value", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString
+	index := b64(indexKey)
+
+	keyFile := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(keyFile, []byte(`{"current": 2, "keys": {"1": "`+b64(key1)+`", "2": "`+b64(key2)+`"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		keys, file  string
+		index       string
+		wantCurrent uint32
+		wantErr     bool
+	}{
+		{name: "disabled", wantCurrent: 0},
+		{name: "keys", keys: "1:" + b64(key1) + ", 2:" + b64(key2), index: index, wantCurrent: 2},
+		{name: "key file", file: keyFile, index: index, wantCurrent: 2},
+		{name: "keys and key file", keys: "1:" + b64(key1), file: keyFile, index: index, wantErr: true},
+		{name: "version 0", keys: "0:" + b64(key1), index: index, wantErr: true},
+		{name: "short key", keys: "1:" + b64(key1[:16]), index: index, wantErr: true},
+		{name: "missing version", keys: b64(key1), index: index, wantErr: true},
+		{name: "short index key", keys: "1:" + b64(key1), index: b64(indexKey[:16]), wantErr: true},
+		{name: "missing key file", file: keyFile + ".missing", index: index, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load(tt.keys, tt.file, tt.index)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantCurrent == 0 {
+				if c != nil {
+					t.Error("Load() without keys returned a Cipher")
+				}
+				return
+			}
+			if got := c.kms.CurrentVersion(); got != tt.wantCurrent {
+				t.Errorf("current version = %d, want %d", got, tt.wantCurrent)
+			}
+		})
+	}
+}
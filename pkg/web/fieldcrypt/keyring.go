@@ -0,0 +1,143 @@
+package fieldcrypt
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Keyring is a KMS which keeps its key-encryption keys in memory. It stands in for a real KMS:
+// the keys come from the configuration or from a local key file.
+type Keyring struct {
+	keys    map[uint32][]byte
+	current uint32
+}
+
+// NewKeyring returns a keyring with the given keys by version, which wraps new data keys with
+// the key of the current version.
+func NewKeyring(keys map[uint32][]byte, current uint32) (*Keyring, error) {
+	for version, key := range keys {
+		if version == 0 {
+			return nil, fmt.Errorf("fieldcrypt: key versions start at 1")
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("fieldcrypt: key version %d must be %d bytes", version, KeySize)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("fieldcrypt: current key version %d not in the keyring", current)
+	}
+	return &Keyring{keys: keys, current: current}, nil
+}
+
+// ParseKeyring parses keys given as a comma-separated list of version:key pairs, with the keys
+// in base64, such as "1:q83v...,2:3q2+...". The highest version is the current one.
+func ParseKeyring(s string) (*Keyring, error) {
+	keys := make(map[uint32][]byte)
+	var current uint32
+	for _, pair := range strings.Split(s, ",") {
+		v, k, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("fieldcrypt: key %q must be version:base64", pair)
+		}
+		version, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: invalid key version %q", v)
+		}
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: key version %d is not valid base64", version)
+		}
+		keys[uint32(version)] = key
+		current = max(current, uint32(version))
+	}
+	return NewKeyring(keys, current)
+}
+
+// keyFile is the format of a local key file.
+type keyFile struct {
+	Current uint32            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads a local key file, a JSON object with the current version and the keys by
+// version in base64:
+//
+//	{"current": 2, "keys": {"1": "q83v...", "2": "3q2+..."}}
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %s: %w", path, err)
+	}
+
+	keys := make(map[uint32][]byte, len(f.Keys))
+	for v, k := range f.Keys {
+		version, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: %s: invalid key version %q", path, v)
+		}
+		if keys[uint32(version)], err = base64.StdEncoding.DecodeString(k); err != nil {
+			return nil, fmt.Errorf("fieldcrypt: %s: key version %d is not valid base64", path, version)
+		}
+	}
+	return NewKeyring(keys, f.Current)
+}
+
+func (k *Keyring) CurrentVersion() uint32 {
+	return k.current
+}
+
+// Wrap encrypts a data key with AES-GCM under the key of the given version, authenticating the
+// version so that a wrapped key can't be passed off as one of another version.
+func (k *Keyring) Wrap(version uint32, dataKey []byte) ([]byte, error) {
+	key, ok := k.keys[version]
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return seal(key, dataKey, binary.BigEndian.AppendUint32(nil, version))
+}
+
+func (k *Keyring) Unwrap(version uint32, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[version]
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return open(key, wrapped, binary.BigEndian.AppendUint32(nil, version))
+}
+
+// Load returns the Cipher for the keys given in the configuration: a comma-separated list of
+// version:key pairs, or the path of a local key file, and the blind index key in base64. It
+// returns nil if neither keys nor a key file are given, which leaves fields in plaintext.
+func Load(keys, keyFile, indexKey string) (*Cipher, error) {
+	var (
+		kms *Keyring
+		err error
+	)
+	switch {
+	case keys != "" && keyFile != "":
+		return nil, fmt.Errorf("fieldcrypt: give either keys or a key file, not both")
+	case keys != "":
+		kms, err = ParseKeyring(keys)
+	case keyFile != "":
+		kms, err = LoadKeyring(keyFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := base64.StdEncoding.DecodeString(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: blind index key is not valid base64")
+	}
+	return New(kms, index)
+}
@@ -0,0 +1,275 @@
+// Package hl7 parses and builds HL7 version 2 messages and frames them for the Minimal Lower
+// Layer Protocol (MLLP). Only the parts needed to ingest ADT and SIU events are implemented.
+package hl7
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotHL7 is returned when data doesn't start with an MSH segment.
+var ErrNotHL7 = errors.New("hl7: message must start with an MSH segment")
+
+// delimiters are the separators declared in MSH-1 and MSH-2.
+type delimiters struct {
+	field, component, repetition, escape, subcomponent byte
+}
+
+// Message is a parsed HL7 v2 message.
+type Message struct {
+	Segments []*Segment
+	delims   delimiters
+}
+
+// Segment is a single segment. Fields keeps the raw, still escaped, field values with the
+// segment name at index 0.
+type Segment struct {
+	Name   string
+	Fields []string
+	delims *delimiters
+}
+
+// Parse parses a message. Segments may be terminated by CR, LF or CRLF.
+func Parse(data []byte) (*Message, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) < 8 || !bytes.HasPrefix(data, []byte("MSH")) {
+		return nil, ErrNotHL7
+	}
+
+	m := &Message{delims: delimiters{
+		field:        data[3],
+		component:    data[4],
+		repetition:   data[5],
+		escape:       data[6],
+		subcomponent: data[7],
+	}}
+
+	lines := strings.FieldsFunc(string(data), func(r rune) bool { return r == '\r' || r == '\n' })
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, string(m.delims.field))
+		if len(fields[0]) != 3 {
+			return nil, fmt.Errorf("hl7: invalid segment %q", fields[0])
+		}
+		seg := &Segment{Name: fields[0], Fields: fields, delims: &m.delims}
+		if seg.Name == "MSH" {
+			// MSH-1 is the field separator itself, so the fields after the name are shifted
+			// by one compared to other segments.
+			seg.Fields = append([]string{"MSH", string(m.delims.field)}, fields[1:]...)
+		}
+		m.Segments = append(m.Segments, seg)
+	}
+
+	return m, nil
+}
+
+// Segment returns the first segment with the given name, or nil.
+func (m *Message) Segment(name string) *Segment {
+	for _, s := range m.Segments {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Type returns the message type and trigger event from MSH-9, e.g. "ADT", "A04".
+func (m *Message) Type() (string, string) {
+	msh := m.Segments[0]
+	return msh.Get(9, 1), msh.Get(9, 2)
+}
+
+// ControlID returns MSH-10, which the receiver echoes in its acknowledgement.
+func (m *Message) ControlID() string { return m.Segments[0].Get(10, 1) }
+
+// Sender returns the sending application and facility from MSH-3 and MSH-4.
+func (m *Message) Sender() string {
+	msh := m.Segments[0]
+	return strings.Trim(msh.Get(3, 1)+"^"+msh.Get(4, 1), "^")
+}
+
+// Field returns the raw value of field n, or "" if the segment is shorter.
+func (s *Segment) Field(n int) string {
+	if n < 0 || n >= len(s.Fields) {
+		return ""
+	}
+	return s.Fields[n]
+}
+
+// Repetitions returns the raw repetitions of field n.
+func (s *Segment) Repetitions(n int) []string {
+	f := s.Field(n)
+	if f == "" {
+		return nil
+	}
+	if s.Name == "MSH" && n <= 2 {
+		return []string{f}
+	}
+	return strings.Split(f, string(s.delims.repetition))
+}
+
+// Get returns component c (1-based) of the first repetition of field n, unescaped.
+func (s *Segment) Get(n, c int) string {
+	if s.Name == "MSH" && n <= 2 {
+		return s.Field(n)
+	}
+	reps := s.Repetitions(n)
+	if len(reps) == 0 {
+		return ""
+	}
+	return s.Component(reps[0], c)
+}
+
+// Component returns component c (1-based) of a raw field value, unescaped.
+func (s *Segment) Component(value string, c int) string {
+	comps := strings.Split(value, string(s.delims.component))
+	if c < 1 || c > len(comps) {
+		return ""
+	}
+	comp := comps[c-1]
+	if i := strings.IndexByte(comp, s.delims.subcomponent); i >= 0 {
+		comp = comp[:i]
+	}
+	return s.delims.unescape(comp)
+}
+
+func (d *delimiters) unescape(s string) string {
+	if strings.IndexByte(s, d.escape) < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != d.escape {
+			b.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i+1:], d.escape)
+		if end < 0 {
+			b.WriteString(s[i:])
+			break
+		}
+		seq := s[i+1 : i+1+end]
+		i += end + 1
+		switch {
+		case seq == "F":
+			b.WriteByte(d.field)
+		case seq == "S":
+			b.WriteByte(d.component)
+		case seq == "T":
+			b.WriteByte(d.subcomponent)
+		case seq == "R":
+			b.WriteByte(d.repetition)
+		case seq == "E":
+			b.WriteByte(d.escape)
+		case strings.HasPrefix(seq, "X"):
+			for j := 1; j+1 < len(seq); j += 2 {
+				if v, err := strconv.ParseUint(seq[j:j+2], 16, 8); err == nil {
+					b.WriteByte(byte(v))
+				}
+			}
+		}
+		// Formatting sequences such as \.br\ are dropped.
+	}
+	return b.String()
+}
+
+func (d *delimiters) escapeText(s string) string {
+	e := string(d.escape)
+	r := strings.NewReplacer(
+		e, e+"E"+e,
+		string(d.field), e+"F"+e,
+		string(d.component), e+"S"+e,
+		string(d.subcomponent), e+"T"+e,
+		string(d.repetition), e+"R"+e,
+		"\r", " ", "\n", " ",
+	)
+	return r.Replace(s)
+}
+
+// Acknowledgement codes used in MSA-1.
+const (
+	AckAccept = "AA" // message processed
+	AckError  = "AE" // message could not be processed
+	AckReject = "AR" // message type or structure not supported
+)
+
+// ACK builds the acknowledgement of m with the given code. text is sent in MSA-3 and should
+// explain errors.
+func (m *Message) ACK(code, text string) []byte {
+	msh := m.Segments[0]
+	d := &m.delims
+	_, trigger := m.Type()
+	now := time.Now()
+
+	fields := []string{
+		"MSH",
+		string([]byte{d.component, d.repetition, d.escape, d.subcomponent}),
+		"GOCLINIC",
+		"GOCLINIC",
+		msh.Field(3),
+		msh.Field(4),
+		FormatTime(now),
+		"",
+		"ACK" + string(d.component) + trigger + string(d.component) + "ACK",
+		"ACK" + strconv.FormatInt(now.UnixNano(), 36),
+		msh.Field(11),
+		msh.Field(12),
+	}
+	if fields[10] == "" {
+		fields[10] = "P"
+	}
+	if fields[11] == "" {
+		fields[11] = "2.5"
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Join(fields, string(d.field)))
+	b.WriteByte('\r')
+	b.WriteString(strings.Join([]string{"MSA", code, msh.Field(10), d.escapeText(text)}, string(d.field)))
+	b.WriteByte('\r')
+	return []byte(b.String())
+}
+
+// NAK builds a reject acknowledgement for data which couldn't be parsed at all, so there is no
+// message to take the delimiters and control ID from.
+func NAK(text string) []byte {
+	now := time.Now()
+	d := &delimiters{'|', '^', '~', '\\', '&'}
+	return []byte("MSH|^~\\&|GOCLINIC|GOCLINIC|||" + FormatTime(now) + "||ACK|ACK" +
+		strconv.FormatInt(now.UnixNano(), 36) + "|P|2.5\rMSA|" + AckReject + "||" + d.escapeText(text) + "\r")
+}
+
+// FormatTime formats t as an HL7 timestamp.
+func FormatTime(t time.Time) string {
+	return t.Format("20060102150405-0700")
+}
+
+// ParseTime parses an HL7 timestamp of the form YYYY[MM[DD[HH[MM[SS[.S...]]]]]][+/-ZZZZ].
+// Timestamps without an offset are in loc.
+func ParseTime(s string, loc *time.Location) (time.Time, error) {
+	offset := ""
+	if i := strings.IndexAny(s, "+-"); i >= 0 {
+		s, offset = s[:i], s[i:]
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[:i]
+	}
+
+	layouts := map[int]string{4: "2006", 6: "200601", 8: "20060102", 10: "2006010215", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(s)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("hl7: invalid timestamp %q", s+offset)
+	}
+
+	if offset != "" {
+		return time.Parse(layout+"-0700", s+offset)
+	}
+	return time.ParseInLocation(layout, s, loc)
+}
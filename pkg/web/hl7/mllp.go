@@ -0,0 +1,67 @@
+package hl7
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// MLLP frame delimiters.
+const (
+	startBlock     = 0x0b
+	endBlock       = 0x1c
+	carriageReturn = 0x0d
+)
+
+// ErrFrameTooLarge is returned by ReadFrame when a message exceeds the size limit.
+var ErrFrameTooLarge = errors.New("hl7: MLLP frame too large")
+
+// ReadFrame reads the next MLLP frame and returns the message inside it. Bytes before the start
+// block are skipped. Messages larger than max bytes are rejected.
+func ReadFrame(r *bufio.Reader, max int) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+
+	var msg []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if b == endBlock {
+			next, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if next == carriageReturn {
+				return msg, nil
+			}
+			msg = append(msg, b, next)
+		} else {
+			msg = append(msg, b)
+		}
+		if len(msg) > max {
+			return nil, ErrFrameTooLarge
+		}
+	}
+}
+
+// WriteFrame writes msg wrapped in an MLLP frame.
+func WriteFrame(w io.Writer, msg []byte) error {
+	frame := make([]byte, 0, len(msg)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, msg...)
+	frame = append(frame, endBlock, carriageReturn)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,177 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Decode reads the VEVENTs of an iCalendar document, as exported by calendar applications and
+// public holiday calendars. Besides the fields written by Encode it sets AllDay for events
+// whose DTSTART is a date, and RRule for recurring events. Floating and all-day times are
+// taken to be in loc, and times with an unknown TZID as well.
+func Decode(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var e *Event
+	var duration time.Duration
+	var hasEnd bool
+	for n, l := range lines {
+		name, params, value, ok := splitLine(l)
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid content line", n+1)
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			e, duration, hasEnd = &Event{}, 0, false
+		case name == "END" && strings.EqualFold(value, "VEVENT") && e != nil:
+			if e.Start.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q without DTSTART", n+1, e.UID)
+			}
+			if !hasEnd {
+				switch {
+				case duration > 0:
+					e.End = e.Start.Add(duration)
+				case e.AllDay:
+					// An all-day event without an end lasts one day.
+					e.End = e.Start.AddDate(0, 0, 1)
+				default:
+					e.End = e.Start
+				}
+			}
+			events = append(events, *e)
+			e = nil
+		case e == nil:
+			// Properties of the calendar and of other components are ignored.
+		case name == "UID":
+			e.UID = value
+		case name == "SUMMARY":
+			e.Summary = unescape(value)
+		case name == "DESCRIPTION":
+			e.Description = unescape(value)
+		case name == "LOCATION":
+			e.Location = unescape(value)
+		case name == "STATUS":
+			e.Status = strings.ToUpper(value)
+		case name == "RRULE":
+			e.RRule = value
+		case name == "DTSTART", name == "DTEND":
+			t, allDay, err := parseTime(value, params, loc)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %w", n+1, name, err)
+			}
+			if name == "DTSTART" {
+				e.Start, e.AllDay = t, allDay
+			} else {
+				e.End, hasEnd = t, true
+			}
+		case name == "DURATION":
+			duration, err = parseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid DURATION: %w", n+1, err)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// unfold joins folded content lines.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		l := strings.TrimRight(sc.Text(), "\r")
+		if l == "" {
+			continue
+		}
+		if (l[0] == ' ' || l[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines, sc.Err()
+}
+
+// splitLine splits "NAME;PARAM=VALUE:value" into its parts. Parameter values may be quoted.
+func splitLine(l string) (name string, params map[string]string, value string, ok bool) {
+	params = make(map[string]string)
+	inQuotes := false
+	for i := 0; i < len(l); i++ {
+		switch l[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ':':
+			if inQuotes {
+				continue
+			}
+			head := strings.Split(l[:i], ";")
+			name = strings.ToUpper(head[0])
+			for _, p := range head[1:] {
+				k, v, _ := strings.Cut(p, "=")
+				params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+			return name, params, l[i+1:], name != ""
+		}
+	}
+	return "", nil, "", false
+}
+
+func parseTime(value string, params map[string]string, loc *time.Location) (time.Time, bool, error) {
+	if strings.EqualFold(params["VALUE"], "DATE") || len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+var durationRX = regexp.MustCompile(`^\+?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration parses a positive DURATION value such as P1D or PT1H30M.
+func parseDuration(value string) (time.Duration, error) {
+	m := durationRX.FindStringSubmatch(value)
+	if m == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, errors.New("unsupported duration " + value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// unescape decodes a TEXT value.
+func unescape(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
@@ -0,0 +1,123 @@
+// Package ical writes iCalendar (RFC 5545) calendars with the subset of properties calendar
+// clients need to show appointments, and reads the events of calendars such as public
+// holiday calendars.
+package ical
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentType is the media type of an iCalendar document.
+const ContentType = "text/calendar; charset=utf-8"
+
+// Event statuses.
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+	StatusCancelled = "CANCELLED"
+)
+
+// Calendar is a VCALENDAR object.
+type Calendar struct {
+	ProdID string
+	Name   string // shown by clients as the calendar's name (X-WR-CALNAME)
+	Events []Event
+}
+
+// Event is a VEVENT. UID must be globally unique and stable so that clients update the event
+// instead of adding a copy of it.
+type Event struct {
+	UID          string
+	Start        time.Time
+	End          time.Time
+	Summary      string
+	Description  string
+	Location     string
+	Status       string
+	Stamp        time.Time
+	LastModified time.Time
+	// AllDay and RRule are only set by Decode.
+	AllDay bool
+	RRule  string
+}
+
+// Encode writes c to w.
+func (c *Calendar) Encode(w io.Writer) error {
+	var b bytes.Buffer
+
+	line(&b, "BEGIN", "VCALENDAR")
+	line(&b, "VERSION", "2.0")
+	line(&b, "PRODID", c.ProdID)
+	line(&b, "CALSCALE", "GREGORIAN")
+	if c.Name != "" {
+		line(&b, "X-WR-CALNAME", Escape(c.Name))
+	}
+
+	for _, e := range c.Events {
+		line(&b, "BEGIN", "VEVENT")
+		line(&b, "UID", e.UID)
+		line(&b, "DTSTAMP", FormatTime(e.Stamp))
+		line(&b, "DTSTART", FormatTime(e.Start))
+		line(&b, "DTEND", FormatTime(e.End))
+		if !e.LastModified.IsZero() {
+			line(&b, "LAST-MODIFIED", FormatTime(e.LastModified))
+		}
+		line(&b, "SUMMARY", Escape(e.Summary))
+		if e.Description != "" {
+			line(&b, "DESCRIPTION", Escape(e.Description))
+		}
+		if e.Location != "" {
+			line(&b, "LOCATION", Escape(e.Location))
+		}
+		if e.Status != "" {
+			line(&b, "STATUS", e.Status)
+		}
+		line(&b, "END", "VEVENT")
+	}
+
+	line(&b, "END", "VCALENDAR")
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// FormatTime formats t as a UTC date-time, e.g. 20240615T053000Z.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Escape escapes a TEXT value.
+func Escape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", "",
+	).Replace(s)
+}
+
+// line writes a content line, folding it so that no line is longer than 75 octets without
+// splitting a UTF-8 sequence.
+func line(b *bytes.Buffer, name, value string) {
+	s := name + ":" + value
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space, which counts towards their length.
+		limit = 74
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
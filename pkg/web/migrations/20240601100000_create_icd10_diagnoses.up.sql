@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS icd10_codes
+(
+    code        text PRIMARY KEY,
+    description text NOT NULL,
+    search      tsvector GENERATED ALWAYS AS (to_tsvector('english', code || ' ' || description)) STORED
+);
+
+CREATE INDEX IF NOT EXISTS icd10_codes_code_prefix_idx ON icd10_codes (code text_pattern_ops);
+CREATE INDEX IF NOT EXISTS icd10_codes_search_idx ON icd10_codes USING GIN (search);
+
+CREATE TABLE IF NOT EXISTS diagnoses
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    appointment_id bigint                      NOT NULL REFERENCES appointments ON DELETE CASCADE,
+    patient_id     bigint                      NOT NULL REFERENCES patients ON DELETE CASCADE,
+    code           text                        NOT NULL REFERENCES icd10_codes,
+    kind           text                        NOT NULL CHECK (kind IN ('primary', 'secondary')),
+    notes          text                        NOT NULL DEFAULT ''
+);
+
+-- An appointment can only carry one primary diagnosis, secondary ones are unlimited.
+CREATE UNIQUE INDEX IF NOT EXISTS diagnoses_one_primary_idx ON diagnoses (appointment_id) WHERE kind = 'primary';
+CREATE INDEX IF NOT EXISTS diagnoses_patient_idx ON diagnoses (patient_id, created_at);
+
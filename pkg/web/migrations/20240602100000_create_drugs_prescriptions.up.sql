@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS drugs
+(
+    id               bigserial PRIMARY KEY,
+    name             text NOT NULL,
+    form             text NOT NULL,
+    strength         text NOT NULL DEFAULT '',
+    active_substance text NOT NULL DEFAULT '',
+    atc_code         text NOT NULL DEFAULT '',
+    UNIQUE (name, form, strength)
+);
+
+CREATE INDEX IF NOT EXISTS drugs_name_idx ON drugs (lower(name) text_pattern_ops);
+
+CREATE TABLE IF NOT EXISTS prescriptions
+(
+    id                bigserial PRIMARY KEY,
+    created_at        timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    patient_id        bigint                      NOT NULL REFERENCES patients ON DELETE CASCADE,
+    doctor_id         bigint                      NOT NULL REFERENCES doctors,
+    appointment_id    bigint                      REFERENCES appointments ON DELETE SET NULL,
+    drug_id           bigint                      NOT NULL REFERENCES drugs,
+    drug_name         text                        NOT NULL,
+    form              text                        NOT NULL,
+    dose              text                        NOT NULL,
+    frequency         text                        NOT NULL,
+    duration_days     integer                     NOT NULL CHECK (duration_days > 0),
+    quantity          integer                     NOT NULL CHECK (quantity > 0),
+    verification_code text                        NOT NULL UNIQUE,
+    signature         bytea                       NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS prescriptions_patient_idx ON prescriptions (patient_id, created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS prescription_overrides;
+DROP TABLE IF EXISTS drug_interactions;
+DROP TABLE IF EXISTS patient_allergies;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS patient_allergies
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    patient_id bigint                      NOT NULL REFERENCES patients ON DELETE CASCADE,
+    substance  text                        NOT NULL,
+    reaction   text                        NOT NULL DEFAULT '',
+    severity   text                        NOT NULL CHECK (severity IN ('mild', 'moderate', 'severe', 'life_threatening'))
+);
+
+CREATE INDEX IF NOT EXISTS patient_allergies_patient_idx ON patient_allergies (patient_id);
+
+-- Substances are stored lower-cased with substance_a < substance_b, so every pair has exactly
+-- one row regardless of the order it is looked up in.
+CREATE TABLE IF NOT EXISTS drug_interactions
+(
+    id          bigserial PRIMARY KEY,
+    substance_a text NOT NULL,
+    substance_b text NOT NULL,
+    severity    text NOT NULL CHECK (severity IN ('minor', 'moderate', 'major', 'contraindicated')),
+    description text NOT NULL DEFAULT '',
+    UNIQUE (substance_a, substance_b),
+    CHECK (substance_a < substance_b)
+);
+
+CREATE TABLE IF NOT EXISTS prescription_overrides
+(
+    id              bigserial PRIMARY KEY,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    prescription_id bigint                      NOT NULL REFERENCES prescriptions ON DELETE CASCADE,
+    user_id         bigint                      NOT NULL REFERENCES users,
+    reason          text                        NOT NULL,
+    conflicts       jsonb                       NOT NULL
+);
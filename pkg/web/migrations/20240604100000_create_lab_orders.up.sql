@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS lab_orders
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    appointment_id bigint                      NOT NULL REFERENCES appointments ON DELETE CASCADE,
+    patient_id     bigint                      NOT NULL REFERENCES patients ON DELETE CASCADE,
+    doctor_id      bigint                      NOT NULL REFERENCES doctors,
+    tests          text[]                      NOT NULL,
+    notes          text                        NOT NULL DEFAULT '',
+    status         text                        NOT NULL DEFAULT 'ordered'
+        CHECK (status IN ('ordered', 'collected', 'resulted', 'reviewed')),
+    collected_at   timestamp(0) with time zone,
+    resulted_at    timestamp(0) with time zone,
+    reviewed_at    timestamp(0) with time zone,
+    reviewed_by    bigint REFERENCES users
+);
+
+CREATE INDEX IF NOT EXISTS lab_orders_patient_idx ON lab_orders (patient_id);
+
+CREATE TABLE IF NOT EXISTS lab_results
+(
+    id          bigserial PRIMARY KEY,
+    order_id    bigint                      NOT NULL REFERENCES lab_orders ON DELETE CASCADE,
+    analyte     text                        NOT NULL,
+    value       numeric                     NOT NULL,
+    unit        text                        NOT NULL DEFAULT '',
+    ref_low     numeric,
+    ref_high    numeric,
+    flag        text                        NOT NULL CHECK (flag IN ('low', 'normal', 'high')),
+    resulted_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (order_id, analyte)
+);
+
+CREATE INDEX IF NOT EXISTS lab_results_analyte_idx ON lab_results (analyte, resulted_at);
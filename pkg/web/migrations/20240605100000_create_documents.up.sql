@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS documents
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    patient_id     bigint                      NOT NULL REFERENCES patients ON DELETE CASCADE,
+    appointment_id bigint REFERENCES appointments ON DELETE CASCADE,
+    filename       text                        NOT NULL,
+    content_type   text                        NOT NULL,
+    size           bigint                      NOT NULL,
+    sha256         text                        NOT NULL,
+    uploaded_by    bigint REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS documents_patient_idx ON documents (patient_id);
+CREATE INDEX IF NOT EXISTS documents_appointment_idx ON documents (appointment_id);
+CREATE INDEX IF NOT EXISTS documents_sha256_idx ON documents (sha256);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS appointments_patient_date_time_idx;
+DROP INDEX IF EXISTS appointments_doctor_date_time_idx;
+DROP INDEX IF EXISTS appointments_date_time_idx;
+
+ALTER TABLE appointments
+    ALTER COLUMN date_time TYPE text USING date_time::text;
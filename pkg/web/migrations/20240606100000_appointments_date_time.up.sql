@@ -0,0 +1,7 @@
+-- Appointment times were free text; store them as timestamps so they can be searched by range.
+ALTER TABLE appointments
+    ALTER COLUMN date_time TYPE timestamp(0) with time zone USING date_time::timestamptz;
+
+CREATE INDEX IF NOT EXISTS appointments_date_time_idx ON appointments (date_time);
+CREATE INDEX IF NOT EXISTS appointments_doctor_date_time_idx ON appointments (doctor_id, date_time);
+CREATE INDEX IF NOT EXISTS appointments_patient_date_time_idx ON appointments (patient_id, date_time);
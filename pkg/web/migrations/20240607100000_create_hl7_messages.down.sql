@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS hl7_messages;
+DROP TABLE IF EXISTS external_ids;
+
+ALTER TABLE appointments
+    DROP CONSTRAINT IF EXISTS appointments_status_check;
+ALTER TABLE appointments
+    DROP COLUMN IF EXISTS status;
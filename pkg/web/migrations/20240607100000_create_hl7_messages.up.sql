@@ -0,0 +1,32 @@
+ALTER TABLE appointments
+    ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'scheduled';
+ALTER TABLE appointments
+    ADD CONSTRAINT appointments_status_check CHECK (status IN ('scheduled', 'cancelled'));
+
+-- Identifiers other systems use for our records, e.g. the hospital's MRN of a patient.
+CREATE TABLE IF NOT EXISTS external_ids
+(
+    resource text   NOT NULL CHECK (resource IN ('patient', 'doctor', 'appointment')),
+    system   text   NOT NULL,
+    value    text   NOT NULL,
+    local_id bigint NOT NULL,
+    PRIMARY KEY (resource, system, value)
+);
+
+CREATE TABLE IF NOT EXISTS hl7_messages
+(
+    id           bigserial PRIMARY KEY,
+    received_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    sender       text                        NOT NULL DEFAULT '',
+    control_id   text                        NOT NULL DEFAULT '',
+    message_type text                        NOT NULL DEFAULT '',
+    raw          text                        NOT NULL,
+    status       text                        NOT NULL DEFAULT 'received'
+        CHECK (status IN ('received', 'processed', 'failed', 'rejected')),
+    error        text                        NOT NULL DEFAULT '',
+    processed_at timestamp(0) with time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS hl7_messages_control_id_idx ON hl7_messages (sender, control_id)
+    WHERE control_id <> '';
+CREATE INDEX IF NOT EXISTS hl7_messages_status_idx ON hl7_messages (status, received_at);
@@ -0,0 +1,15 @@
+-- Secret iCalendar feed URLs. Only the SHA-256 hash of a feed's token is stored; a doctor or
+-- patient has at most one feed, and issuing a new one revokes the previous URL.
+CREATE TABLE IF NOT EXISTS calendar_feeds
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    token_hash bytea                       NOT NULL UNIQUE,
+    doctor_id  bigint REFERENCES doctors ON DELETE CASCADE,
+    patient_id bigint REFERENCES patients ON DELETE CASCADE,
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    CHECK ((doctor_id IS NULL) <> (patient_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS calendar_feeds_doctor_idx ON calendar_feeds (doctor_id);
+CREATE UNIQUE INDEX IF NOT EXISTS calendar_feeds_patient_idx ON calendar_feeds (patient_id);
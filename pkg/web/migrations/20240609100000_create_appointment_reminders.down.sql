@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS appointment_reminders;
+
+ALTER TABLE patients
+    DROP COLUMN IF EXISTS email;
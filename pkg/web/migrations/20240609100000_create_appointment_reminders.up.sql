@@ -0,0 +1,24 @@
+ALTER TABLE patients
+    ADD COLUMN IF NOT EXISTS email text NOT NULL DEFAULT '';
+
+-- One row per reminder that was or is being sent. A row is inserted before the reminder goes
+-- out, so the primary key guarantees that no two replicas send the same reminder. date_time is
+-- the appointment time the reminder was for, so that rescheduled appointments are reminded of
+-- again.
+CREATE TABLE IF NOT EXISTS appointment_reminders
+(
+    appointment_id bigint                      NOT NULL REFERENCES appointments ON DELETE CASCADE,
+    date_time      timestamp(0) with time zone NOT NULL,
+    offset_minutes integer                     NOT NULL,
+    channel        text                        NOT NULL,
+    status         text                        NOT NULL DEFAULT 'sending'
+        CHECK (status IN ('sending', 'sent', 'failed', 'skipped')),
+    attempts       integer                     NOT NULL DEFAULT 1,
+    error          text                        NOT NULL DEFAULT '',
+    claimed_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    sent_at        timestamp(0) with time zone,
+    PRIMARY KEY (appointment_id, date_time, offset_minutes, channel)
+);
+
+CREATE INDEX IF NOT EXISTS appointment_reminders_failed_idx ON appointment_reminders (claimed_at)
+    WHERE status = 'failed';
@@ -0,0 +1,15 @@
+DELETE FROM tokens WHERE user_id IS NULL;
+ALTER TABLE tokens
+    DROP CONSTRAINT IF EXISTS tokens_owner_check;
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS used_at;
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS appointment_id;
+ALTER TABLE tokens
+    ALTER COLUMN user_id SET NOT NULL;
+
+UPDATE appointments SET status = 'scheduled' WHERE status = 'confirmed';
+ALTER TABLE appointments
+    DROP CONSTRAINT IF EXISTS appointments_status_check;
+ALTER TABLE appointments
+    ADD CONSTRAINT appointments_status_check CHECK (status IN ('scheduled', 'cancelled'));
@@ -0,0 +1,15 @@
+ALTER TABLE appointments
+    DROP CONSTRAINT IF EXISTS appointments_status_check;
+ALTER TABLE appointments
+    ADD CONSTRAINT appointments_status_check CHECK (status IN ('scheduled', 'confirmed', 'cancelled'));
+
+-- Tokens of confirm/cancel links belong to an appointment instead of a user, and can only be
+-- used once.
+ALTER TABLE tokens
+    ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS appointment_id bigint REFERENCES appointments ON DELETE CASCADE;
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+ALTER TABLE tokens
+    ADD CONSTRAINT tokens_owner_check CHECK (user_id IS NOT NULL OR appointment_id IS NOT NULL);
@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS waitlist_entries
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    patient_id     bigint                      NOT NULL REFERENCES patients ON DELETE CASCADE,
+    doctor_id      bigint REFERENCES doctors ON DELETE CASCADE,
+    speciality     text                        NOT NULL DEFAULT '',
+    earliest       timestamp(0) with time zone NOT NULL,
+    latest         timestamp(0) with time zone NOT NULL,
+    priority       integer                     NOT NULL DEFAULT 0,
+    notes          text                        NOT NULL DEFAULT '',
+    status         text                        NOT NULL DEFAULT 'waiting'
+        CHECK (status IN ('waiting', 'offered', 'booked', 'removed')),
+    appointment_id bigint REFERENCES appointments ON DELETE SET NULL,
+    created_by     bigint REFERENCES users ON DELETE SET NULL,
+    CHECK (doctor_id IS NOT NULL OR speciality <> ''),
+    CHECK (earliest < latest)
+);
+
+CREATE INDEX IF NOT EXISTS waitlist_entries_waiting_idx ON waitlist_entries (priority DESC, created_at)
+    WHERE status = 'waiting';
+
+-- A slot freed by a cancellation, held for one waitlisted patient until expires_at. Only the
+-- SHA-256 hash of the token in the patient's accept/decline links is stored.
+CREATE TABLE IF NOT EXISTS waitlist_offers
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    entry_id       bigint                      NOT NULL REFERENCES waitlist_entries ON DELETE CASCADE,
+    doctor_id      bigint                      NOT NULL REFERENCES doctors ON DELETE CASCADE,
+    date_time      timestamp(0) with time zone NOT NULL,
+    expires_at     timestamp(0) with time zone NOT NULL,
+    token_hash     bytea                       NOT NULL UNIQUE,
+    status         text                        NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'declined', 'expired', 'withdrawn')),
+    responded_at   timestamp(0) with time zone,
+    appointment_id bigint REFERENCES appointments ON DELETE SET NULL
+);
+
+-- A slot is offered to one patient at a time, and to each patient at most once.
+CREATE UNIQUE INDEX IF NOT EXISTS waitlist_offers_pending_slot_idx ON waitlist_offers (doctor_id, date_time)
+    WHERE status = 'pending';
+CREATE UNIQUE INDEX IF NOT EXISTS waitlist_offers_entry_slot_idx ON waitlist_offers (entry_id, doctor_id, date_time);
+CREATE INDEX IF NOT EXISTS waitlist_offers_expiry_idx ON waitlist_offers (expires_at)
+    WHERE status = 'pending';
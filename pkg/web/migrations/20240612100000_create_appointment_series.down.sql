@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS appointments_series_idx;
+
+ALTER TABLE appointments
+    DROP COLUMN IF EXISTS occurrence,
+    DROP COLUMN IF EXISTS series_id;
+
+DROP TABLE IF EXISTS appointment_series;
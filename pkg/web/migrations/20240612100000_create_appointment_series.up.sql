@@ -0,0 +1,21 @@
+-- A recurring series of appointments. The occurrences are stored as ordinary appointments,
+-- numbered in the order the rule produced them; dtstart and rrule are kept for reference.
+CREATE TABLE IF NOT EXISTS appointment_series
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    doctor_id  bigint                      NOT NULL REFERENCES doctors ON DELETE CASCADE,
+    patient_id bigint                      NOT NULL REFERENCES patients ON DELETE CASCADE,
+    dtstart    timestamp(0) with time zone NOT NULL,
+    time_zone  text                        NOT NULL,
+    rrule      text                        NOT NULL,
+    created_by bigint REFERENCES users ON DELETE SET NULL
+);
+
+ALTER TABLE appointments
+    ADD COLUMN IF NOT EXISTS series_id  bigint REFERENCES appointment_series ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS occurrence integer;
+
+CREATE INDEX IF NOT EXISTS appointments_series_idx ON appointments (series_id, occurrence)
+    WHERE series_id IS NOT NULL;
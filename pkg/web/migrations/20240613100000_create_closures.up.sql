@@ -0,0 +1,18 @@
+-- Periods in which no appointments can be booked: clinic-wide closures such as public holidays
+-- when doctor_id is NULL, a doctor's leave otherwise. Closures imported from an iCalendar file
+-- keep the UID of their event, so that importing the file again updates them.
+CREATE TABLE IF NOT EXISTS closures
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    doctor_id  bigint REFERENCES doctors ON DELETE CASCADE,
+    starts_at  timestamp(0) with time zone NOT NULL,
+    ends_at    timestamp(0) with time zone NOT NULL,
+    reason     text                        NOT NULL DEFAULT '',
+    source_uid text UNIQUE,
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    CHECK (starts_at < ends_at)
+);
+
+CREATE INDEX IF NOT EXISTS closures_period_idx ON closures (ends_at, starts_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS queue_tickets;
+DROP FUNCTION IF EXISTS notify_queue_ticket();
@@ -0,0 +1,40 @@
+-- The reception queue: every walk-in and every patient checking in for an appointment draws a
+-- ticket, numbered from 1 each day, and waits for a doctor to call them. The waiting room
+-- display and the doctors' screens follow the queue through the queue_tickets notifications.
+CREATE TABLE IF NOT EXISTS queue_tickets
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    queue_date     date                        NOT NULL,
+    number         integer                     NOT NULL,
+    doctor_id      bigint                      NOT NULL REFERENCES doctors ON DELETE CASCADE,
+    patient_id     bigint REFERENCES patients ON DELETE SET NULL,
+    appointment_id bigint REFERENCES appointments ON DELETE SET NULL,
+    name           text                        NOT NULL DEFAULT '',
+    status         text                        NOT NULL DEFAULT 'waiting'
+        CHECK (status IN ('waiting', 'called', 'done', 'no_show')),
+    called_at      timestamp(0) with time zone,
+    done_at        timestamp(0) with time zone,
+    created_by     bigint REFERENCES users ON DELETE SET NULL,
+    UNIQUE (queue_date, number)
+);
+
+CREATE INDEX IF NOT EXISTS queue_tickets_doctor_idx ON queue_tickets (queue_date, doctor_id, status);
+
+-- A patient checks in for an appointment only once.
+CREATE UNIQUE INDEX IF NOT EXISTS queue_tickets_appointment_idx ON queue_tickets (appointment_id);
+
+CREATE OR REPLACE FUNCTION notify_queue_ticket() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('queue_tickets', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER queue_tickets_notify
+    AFTER INSERT OR UPDATE
+    ON queue_tickets
+    FOR EACH ROW
+EXECUTE FUNCTION notify_queue_ticket();
@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS queue_tickets_number_idx;
+ALTER TABLE queue_tickets
+    DROP COLUMN IF EXISTS branch_id;
+ALTER TABLE queue_tickets
+    ADD CONSTRAINT queue_tickets_queue_date_number_key UNIQUE (queue_date, number);
+
+DROP INDEX IF EXISTS appointments_room_idx;
+DROP INDEX IF EXISTS appointments_branch_idx;
+ALTER TABLE appointments
+    DROP CONSTRAINT IF EXISTS appointments_room_branch_fkey,
+    DROP COLUMN IF EXISTS room_id,
+    DROP COLUMN IF EXISTS branch_id;
+
+ALTER TABLE appointment_series
+    DROP COLUMN IF EXISTS room_id,
+    DROP COLUMN IF EXISTS branch_id;
+
+DROP TABLE IF EXISTS doctor_branches;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS branches;
@@ -0,0 +1,56 @@
+-- The locations of the clinic. opening_hours are in the format of the -opening-hours flag, in
+-- the branch's time zone.
+CREATE TABLE IF NOT EXISTS branches
+(
+    id            bigserial PRIMARY KEY,
+    created_at    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name          text                        NOT NULL UNIQUE,
+    address       text                        NOT NULL DEFAULT '',
+    time_zone     text                        NOT NULL,
+    opening_hours text                        NOT NULL
+);
+
+-- Rooms and other resources of a branch which can only be used by one appointment at a time,
+-- such as an ultrasound machine.
+CREATE TABLE IF NOT EXISTS rooms
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    branch_id  bigint                      NOT NULL REFERENCES branches ON DELETE CASCADE,
+    name       text                        NOT NULL,
+    kind       text                        NOT NULL DEFAULT 'room',
+    UNIQUE (branch_id, name),
+    UNIQUE (id, branch_id)
+);
+
+CREATE TABLE IF NOT EXISTS doctor_branches
+(
+    doctor_id bigint NOT NULL REFERENCES doctors ON DELETE CASCADE,
+    branch_id bigint NOT NULL REFERENCES branches ON DELETE CASCADE,
+    PRIMARY KEY (doctor_id, branch_id)
+);
+
+CREATE INDEX IF NOT EXISTS doctor_branches_branch_idx ON doctor_branches (branch_id);
+
+-- Appointments made before there were branches, or for doctors who work at several branches
+-- without saying which, have no branch. The room must be one of the branch.
+ALTER TABLE appointments
+    ADD COLUMN IF NOT EXISTS branch_id bigint REFERENCES branches ON DELETE RESTRICT,
+    ADD COLUMN IF NOT EXISTS room_id   bigint REFERENCES rooms ON DELETE SET NULL,
+    ADD CONSTRAINT appointments_room_branch_fkey FOREIGN KEY (room_id, branch_id) REFERENCES rooms (id, branch_id);
+
+ALTER TABLE appointment_series
+    ADD COLUMN IF NOT EXISTS branch_id bigint REFERENCES branches ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS room_id   bigint REFERENCES rooms ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS appointments_branch_idx ON appointments (branch_id, date_time);
+CREATE INDEX IF NOT EXISTS appointments_room_idx ON appointments (room_id, date_time);
+
+-- Walk-ins are checked in at a branch, and each branch numbers its tickets from 1 every day.
+ALTER TABLE queue_tickets
+    ADD COLUMN IF NOT EXISTS branch_id bigint REFERENCES branches ON DELETE CASCADE,
+    DROP CONSTRAINT IF EXISTS queue_tickets_queue_date_number_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS queue_tickets_number_idx ON queue_tickets (queue_date, COALESCE(branch_id, 0), number);
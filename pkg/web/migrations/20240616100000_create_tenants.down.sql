@@ -0,0 +1,54 @@
+CREATE OR REPLACE FUNCTION notify_queue_ticket() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('queue_tickets', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS queue_tickets_number_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS queue_tickets_number_idx ON queue_tickets (queue_date, COALESCE(branch_id, 0), number);
+
+DROP INDEX IF EXISTS hl7_messages_control_id_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS hl7_messages_control_id_idx ON hl7_messages (sender, control_id)
+    WHERE control_id <> '';
+
+ALTER TABLE external_ids
+    DROP CONSTRAINT IF EXISTS external_ids_pkey,
+    ADD PRIMARY KEY (resource, system, value);
+
+ALTER TABLE closures
+    DROP CONSTRAINT IF EXISTS closures_tenant_source_uid_key,
+    ADD CONSTRAINT closures_source_uid_key UNIQUE (source_uid);
+
+ALTER TABLE branches
+    DROP CONSTRAINT IF EXISTS branches_tenant_name_key,
+    ADD CONSTRAINT branches_name_key UNIQUE (name);
+
+DO
+$$
+    DECLARE
+        t text;
+    BEGIN
+        FOREACH t IN ARRAY ARRAY ['doctors', 'patients', 'branches', 'rooms', 'doctor_branches',
+            'appointment_series', 'appointments', 'diagnoses', 'prescriptions', 'prescription_overrides',
+            'patient_allergies', 'lab_orders', 'lab_results', 'documents', 'external_ids', 'hl7_messages',
+            'calendar_feeds', 'appointment_reminders', 'waitlist_entries', 'waitlist_offers', 'closures',
+            'queue_tickets']
+            LOOP
+                EXECUTE format('DROP TRIGGER IF EXISTS %I ON %I', t || '_tenant_references', t);
+                EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %I', t);
+                EXECUTE format('ALTER TABLE %I NO FORCE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I DISABLE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I DROP COLUMN IF EXISTS tenant_id', t);
+            END LOOP;
+    END
+$$;
+
+DROP FUNCTION IF EXISTS check_tenant_references();
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS tenant_id;
+
+DROP FUNCTION IF EXISTS current_tenant_id();
+DROP TABLE IF EXISTS tenants;
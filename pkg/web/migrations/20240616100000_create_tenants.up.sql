@@ -0,0 +1,143 @@
+-- Clinics hosted by the deployment. The data which existed before belongs to the default one.
+CREATE TABLE IF NOT EXISTS tenants
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name       text                        NOT NULL,
+    slug       text                        NOT NULL UNIQUE CHECK (slug ~ '^[a-z0-9][a-z0-9-]*$')
+);
+
+INSERT INTO tenants (id, name, slug)
+VALUES (1, 'Default', 'default')
+ON CONFLICT DO NOTHING;
+SELECT setval('tenants_id_seq', GREATEST((SELECT MAX(id) FROM tenants), 1));
+
+-- The server connects once per tenant and sets app.tenant_id on those connections. Connections
+-- without it see no rows of the tenant tables and can't insert into them.
+CREATE OR REPLACE FUNCTION current_tenant_id() RETURNS bigint AS
+$$
+SELECT NULLIF(current_setting('app.tenant_id', true), '')::bigint;
+$$ LANGUAGE sql STABLE;
+
+-- Users log in before their tenant is known, so users are not subject to row-level security.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants ON DELETE CASCADE;
+ALTER TABLE users
+    ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- check_tenant_references raises a foreign key violation if a referenced row is not visible,
+-- i.e. belongs to another tenant. Its arguments are pairs of a column and the table it
+-- references. Foreign keys alone don't do this, since they bypass row-level security.
+CREATE OR REPLACE FUNCTION check_tenant_references() RETURNS trigger AS
+$$
+DECLARE
+    i       integer;
+    ref     bigint;
+    visible boolean;
+BEGIN
+    FOR i IN 0 .. TG_NARGS - 1 BY 2
+        LOOP
+            EXECUTE format('SELECT ($1).%I', TG_ARGV[i]) INTO ref USING NEW;
+            CONTINUE WHEN ref IS NULL;
+            EXECUTE format('SELECT EXISTS (SELECT 1 FROM %I WHERE id = $1)', TG_ARGV[i + 1]) INTO visible USING ref;
+            IF NOT visible THEN
+                RAISE foreign_key_violation USING
+                    MESSAGE = format('%s %s is not present in table %s', TG_ARGV[i], ref, TG_ARGV[i + 1]),
+                    TABLE = TG_TABLE_NAME;
+            END IF;
+        END LOOP;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DO
+$$
+    DECLARE
+        t    text;
+        refs text[];
+        cols text;
+        args text;
+    BEGIN
+        FOR t, refs IN
+            SELECT *
+            FROM (VALUES ('doctors', ARRAY []::text[]),
+                         ('patients', ARRAY []::text[]),
+                         ('branches', ARRAY []::text[]),
+                         ('rooms', ARRAY ['branch_id', 'branches']),
+                         ('doctor_branches', ARRAY ['doctor_id', 'doctors', 'branch_id', 'branches']),
+                         ('appointment_series', ARRAY ['doctor_id', 'doctors', 'patient_id', 'patients',
+                             'branch_id', 'branches', 'room_id', 'rooms']),
+                         ('appointments', ARRAY ['doctor_id', 'doctors', 'patient_id', 'patients',
+                             'branch_id', 'branches', 'room_id', 'rooms', 'series_id', 'appointment_series']),
+                         ('diagnoses', ARRAY ['appointment_id', 'appointments', 'patient_id', 'patients']),
+                         ('prescriptions', ARRAY ['patient_id', 'patients', 'doctor_id', 'doctors',
+                             'appointment_id', 'appointments']),
+                         ('prescription_overrides', ARRAY ['prescription_id', 'prescriptions']),
+                         ('patient_allergies', ARRAY ['patient_id', 'patients']),
+                         ('lab_orders', ARRAY ['appointment_id', 'appointments', 'patient_id', 'patients',
+                             'doctor_id', 'doctors']),
+                         ('lab_results', ARRAY ['order_id', 'lab_orders']),
+                         ('documents', ARRAY ['patient_id', 'patients', 'appointment_id', 'appointments']),
+                         ('external_ids', ARRAY []::text[]),
+                         ('hl7_messages', ARRAY []::text[]),
+                         ('calendar_feeds', ARRAY ['doctor_id', 'doctors', 'patient_id', 'patients']),
+                         ('appointment_reminders', ARRAY ['appointment_id', 'appointments']),
+                         ('waitlist_entries', ARRAY ['patient_id', 'patients', 'doctor_id', 'doctors',
+                             'appointment_id', 'appointments']),
+                         ('waitlist_offers', ARRAY ['entry_id', 'waitlist_entries', 'doctor_id', 'doctors',
+                             'appointment_id', 'appointments']),
+                         ('closures', ARRAY ['doctor_id', 'doctors']),
+                         ('queue_tickets', ARRAY ['doctor_id', 'doctors', 'patient_id', 'patients',
+                             'appointment_id', 'appointments', 'branch_id', 'branches'])) AS tables (t, refs)
+            LOOP
+                -- Existing rows go to the default tenant, new ones to the tenant of the connection.
+                EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 '
+                                   'REFERENCES tenants ON DELETE CASCADE', t);
+                EXECUTE format('ALTER TABLE %I ALTER COLUMN tenant_id SET DEFAULT current_tenant_id()', t);
+                EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON %I (tenant_id)', t || '_tenant_idx', t);
+
+                EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+                EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = current_tenant_id())', t);
+
+                CONTINUE WHEN cardinality(refs) = 0;
+                SELECT string_agg(quote_ident(refs[i]), ', '),
+                       string_agg(quote_literal(refs[i]) || ', ' || quote_literal(refs[i + 1]), ', ')
+                INTO cols, args
+                FROM generate_series(1, cardinality(refs), 2) AS i;
+                EXECUTE format('CREATE TRIGGER %I BEFORE INSERT OR UPDATE OF %s ON %I '
+                                   'FOR EACH ROW EXECUTE FUNCTION check_tenant_references(%s)',
+                               t || '_tenant_references', cols, t, args);
+            END LOOP;
+    END
+$$;
+
+-- Names and identifiers only need to be unique within a tenant.
+ALTER TABLE branches
+    DROP CONSTRAINT IF EXISTS branches_name_key,
+    ADD CONSTRAINT branches_tenant_name_key UNIQUE (tenant_id, name);
+
+ALTER TABLE closures
+    DROP CONSTRAINT IF EXISTS closures_source_uid_key,
+    ADD CONSTRAINT closures_tenant_source_uid_key UNIQUE (tenant_id, source_uid);
+
+ALTER TABLE external_ids
+    DROP CONSTRAINT IF EXISTS external_ids_pkey,
+    ADD PRIMARY KEY (tenant_id, resource, system, value);
+
+DROP INDEX IF EXISTS hl7_messages_control_id_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS hl7_messages_control_id_idx ON hl7_messages (tenant_id, sender, control_id)
+    WHERE control_id <> '';
+
+DROP INDEX IF EXISTS queue_tickets_number_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS queue_tickets_number_idx
+    ON queue_tickets (tenant_id, queue_date, COALESCE(branch_id, 0), number);
+
+-- Notifications carry the tenant, since the listener sees the tickets of all tenants.
+CREATE OR REPLACE FUNCTION notify_queue_ticket() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('queue_tickets', NEW.tenant_id || ':' || NEW.id);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
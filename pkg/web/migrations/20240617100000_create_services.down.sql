@@ -0,0 +1,6 @@
+ALTER TABLE appointments
+    DROP COLUMN IF EXISTS duration_minutes;
+
+DROP TABLE IF EXISTS appointment_services;
+DROP TABLE IF EXISTS service_prices;
+DROP TABLE IF EXISTS services;
@@ -0,0 +1,83 @@
+-- The services the clinic offers. vat_rate is in hundredths of a percent, so 2000 is 20%.
+-- Services limited to a speciality can only be booked with doctors of that speciality.
+CREATE TABLE IF NOT EXISTS services
+(
+    id               bigserial PRIMARY KEY,
+    created_at       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id        bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    code             text                        NOT NULL,
+    name             text                        NOT NULL,
+    speciality       text                        NOT NULL DEFAULT '',
+    duration_minutes integer                     NOT NULL CHECK (duration_minutes > 0),
+    vat_rate         integer                     NOT NULL DEFAULT 0 CHECK (vat_rate BETWEEN 0 AND 10000),
+    active           boolean                     NOT NULL DEFAULT true,
+    CONSTRAINT services_tenant_code_key UNIQUE (tenant_id, code)
+);
+
+-- The price history of the services. Prices without a branch and a doctor are the list
+-- prices; the others override them at a branch, for a doctor or both. A price applies from
+-- effective_from until the next price of the same kind. Amounts are in minor units.
+CREATE TABLE IF NOT EXISTS service_prices
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id      bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    service_id     bigint                      NOT NULL REFERENCES services ON DELETE CASCADE,
+    branch_id      bigint REFERENCES branches ON DELETE CASCADE,
+    doctor_id      bigint REFERENCES doctors ON DELETE CASCADE,
+    amount         bigint                      NOT NULL CHECK (amount >= 0),
+    currency       char(3)                     NOT NULL CHECK (currency ~ '^[A-Z]{3}$'),
+    effective_from date                        NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS service_prices_key_idx
+    ON service_prices (service_id, COALESCE(branch_id, 0), COALESCE(doctor_id, 0), effective_from);
+
+-- The services of an appointment with the price, currency and VAT rate they had when they
+-- were booked, so that later price changes don't change what the appointment costs.
+CREATE TABLE IF NOT EXISTS appointment_services
+(
+    appointment_id   bigint  NOT NULL REFERENCES appointments ON DELETE CASCADE,
+    service_id       bigint  NOT NULL REFERENCES services ON DELETE RESTRICT,
+    tenant_id        bigint  NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    position         integer NOT NULL,
+    duration_minutes integer NOT NULL,
+    amount           bigint  NOT NULL,
+    currency         char(3) NOT NULL,
+    vat_rate         integer NOT NULL,
+    PRIMARY KEY (appointment_id, service_id)
+);
+
+CREATE INDEX IF NOT EXISTS appointment_services_service_idx ON appointment_services (service_id);
+
+-- Appointments booked before there were services last the default 30 minutes.
+ALTER TABLE appointments
+    ADD COLUMN IF NOT EXISTS duration_minutes integer NOT NULL DEFAULT 30 CHECK (duration_minutes > 0);
+
+DO
+$$
+    DECLARE
+        t text;
+    BEGIN
+        FOREACH t IN ARRAY ARRAY ['services', 'service_prices', 'appointment_services']
+            LOOP
+                EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON %I (tenant_id)', t || '_tenant_idx', t);
+                EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+                EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = current_tenant_id())', t);
+            END LOOP;
+    END
+$$;
+
+CREATE TRIGGER service_prices_tenant_references
+    BEFORE INSERT OR UPDATE OF service_id, branch_id, doctor_id
+    ON service_prices
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('service_id', 'services', 'branch_id', 'branches', 'doctor_id', 'doctors');
+
+CREATE TRIGGER appointment_services_tenant_references
+    BEFORE INSERT OR UPDATE OF appointment_id, service_id
+    ON appointment_services
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('appointment_id', 'appointments', 'service_id', 'services');
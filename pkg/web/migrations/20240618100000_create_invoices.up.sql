@@ -0,0 +1,117 @@
+-- Invoices and the credit notes which correct them. All amounts are in minor units of the
+-- currency. settled is the part of the total which has been settled: for invoices by payments
+-- and credit notes, for credit notes by reducing the invoice's balance and by refunds. Numbers
+-- are given when a document is issued; drafts have none.
+CREATE TABLE IF NOT EXISTS invoices
+(
+    id                  bigserial PRIMARY KEY,
+    created_at          timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at          timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id           bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    kind                text                        NOT NULL DEFAULT 'invoice' CHECK (kind IN ('invoice', 'credit_note')),
+    number              text,
+    status              text                        NOT NULL DEFAULT 'draft'
+        CHECK (status IN ('draft', 'issued', 'partially_paid', 'paid', 'void')),
+    patient_id          bigint                      NOT NULL REFERENCES patients ON DELETE RESTRICT,
+    appointment_id      bigint REFERENCES appointments ON DELETE RESTRICT,
+    branch_id           bigint REFERENCES branches ON DELETE SET NULL,
+    credited_invoice_id bigint REFERENCES invoices ON DELETE RESTRICT,
+    currency            char(3)                     NOT NULL CHECK (currency ~ '^[A-Z]{3}$'),
+    discount            bigint                      NOT NULL DEFAULT 0,
+    net                 bigint                      NOT NULL DEFAULT 0,
+    vat                 bigint                      NOT NULL DEFAULT 0,
+    total               bigint                      NOT NULL DEFAULT 0 CHECK (total >= 0),
+    settled             bigint                      NOT NULL DEFAULT 0 CHECK (settled BETWEEN 0 AND total),
+    notes               text                        NOT NULL DEFAULT '',
+    issued_at           timestamp(0) with time zone,
+    due_date            date,
+    voided_at           timestamp(0) with time zone,
+    void_reason         text                        NOT NULL DEFAULT '',
+    created_by          bigint REFERENCES users ON DELETE SET NULL,
+    CHECK ((kind = 'credit_note') = (credited_invoice_id IS NOT NULL)),
+    CHECK ((number IS NULL) = (status = 'draft' OR (status = 'void' AND issued_at IS NULL)))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS invoices_number_idx ON invoices (tenant_id, number);
+CREATE INDEX IF NOT EXISTS invoices_patient_idx ON invoices (patient_id, created_at);
+CREATE INDEX IF NOT EXISTS invoices_credited_invoice_idx ON invoices (credited_invoice_id);
+
+-- An appointment is invoiced once, unless the invoice was voided.
+CREATE UNIQUE INDEX IF NOT EXISTS invoices_appointment_idx ON invoices (appointment_id)
+    WHERE kind = 'invoice' AND status <> 'void';
+
+-- discount is the amount taken off quantity * unit_price; net is what remains, and vat the tax
+-- on it at vat_rate, in hundredths of a percent. The lines of credit notes refer to the lines
+-- they credit.
+CREATE TABLE IF NOT EXISTS invoice_lines
+(
+    id               bigserial PRIMARY KEY,
+    tenant_id        bigint  NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    invoice_id       bigint  NOT NULL REFERENCES invoices ON DELETE CASCADE,
+    position         integer NOT NULL,
+    service_id       bigint REFERENCES services ON DELETE SET NULL,
+    credited_line_id bigint REFERENCES invoice_lines ON DELETE RESTRICT,
+    description      text    NOT NULL,
+    quantity         integer NOT NULL CHECK (quantity > 0),
+    unit_price       bigint  NOT NULL CHECK (unit_price >= 0),
+    discount         bigint  NOT NULL DEFAULT 0 CHECK (discount >= 0),
+    vat_rate         integer NOT NULL CHECK (vat_rate BETWEEN 0 AND 10000),
+    net              bigint  NOT NULL CHECK (net >= 0),
+    vat              bigint  NOT NULL,
+    total            bigint  NOT NULL,
+    UNIQUE (invoice_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS invoice_lines_credited_line_idx ON invoice_lines (credited_line_id);
+
+-- Payments of invoices, and refunds of credit notes.
+CREATE TABLE IF NOT EXISTS payments
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id   bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    invoice_id  bigint                      NOT NULL REFERENCES invoices ON DELETE RESTRICT,
+    method      text                        NOT NULL CHECK (method IN ('cash', 'card', 'transfer')),
+    amount      bigint                      NOT NULL CHECK (amount > 0),
+    reference   text                        NOT NULL DEFAULT '',
+    paid_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    received_by bigint REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS payments_invoice_idx ON payments (invoice_id);
+CREATE INDEX IF NOT EXISTS payments_paid_at_idx ON payments (paid_at);
+
+DO
+$$
+    DECLARE
+        t text;
+    BEGIN
+        FOREACH t IN ARRAY ARRAY ['invoices', 'invoice_lines', 'payments']
+            LOOP
+                EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON %I (tenant_id)', t || '_tenant_idx', t);
+                EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+                EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = current_tenant_id())', t);
+            END LOOP;
+    END
+$$;
+
+CREATE TRIGGER invoices_tenant_references
+    BEFORE INSERT OR UPDATE OF patient_id, appointment_id, branch_id, credited_invoice_id
+    ON invoices
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('patient_id', 'patients', 'appointment_id', 'appointments',
+                                         'branch_id', 'branches', 'credited_invoice_id', 'invoices');
+
+CREATE TRIGGER invoice_lines_tenant_references
+    BEFORE INSERT OR UPDATE OF invoice_id, service_id, credited_line_id
+    ON invoice_lines
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('invoice_id', 'invoices', 'service_id', 'services',
+                                         'credited_line_id', 'invoice_lines');
+
+CREATE TRIGGER payments_tenant_references
+    BEFORE INSERT OR UPDATE OF invoice_id
+    ON payments
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('invoice_id', 'invoices');
@@ -0,0 +1,10 @@
+ALTER TABLE payments
+    DROP CONSTRAINT IF EXISTS payments_method_check,
+    ADD CONSTRAINT payments_method_check CHECK (method IN ('cash', 'card', 'transfer'));
+
+DROP TABLE IF EXISTS remittance_items;
+DROP TABLE IF EXISTS remittances;
+DROP TABLE IF EXISTS claims;
+DROP TABLE IF EXISTS claim_batches;
+DROP TABLE IF EXISTS insurance_policies;
+DROP TABLE IF EXISTS payers;
@@ -0,0 +1,166 @@
+-- Insurance payers. batch_format describes the payer's claim batch file (see package claims);
+-- without one the default CSV layout is used. Claims are made in the payer's currency.
+CREATE TABLE IF NOT EXISTS payers
+(
+    id           bigserial PRIMARY KEY,
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id    bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    code         text                        NOT NULL,
+    name         text                        NOT NULL,
+    currency     char(3)                     NOT NULL CHECK (currency ~ '^[A-Z]{3}$'),
+    batch_format jsonb,
+    active       boolean                     NOT NULL DEFAULT true,
+    UNIQUE (tenant_id, code)
+);
+
+-- A patient's cover by a payer. The patient pays copay_amount of every claim and copay_rate,
+-- in hundredths of a percent, of the rest; the payer is claimed for what remains.
+CREATE TABLE IF NOT EXISTS insurance_policies
+(
+    id            bigserial PRIMARY KEY,
+    created_at    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id     bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    patient_id    bigint                      NOT NULL REFERENCES patients ON DELETE CASCADE,
+    payer_id      bigint                      NOT NULL REFERENCES payers ON DELETE RESTRICT,
+    member_number text                        NOT NULL,
+    group_number  text                        NOT NULL DEFAULT '',
+    coverage_from date                        NOT NULL,
+    coverage_to   date CHECK (coverage_to >= coverage_from),
+    copay_amount  bigint                      NOT NULL DEFAULT 0 CHECK (copay_amount >= 0),
+    copay_rate    integer                     NOT NULL DEFAULT 0 CHECK (copay_rate BETWEEN 0 AND 10000)
+);
+
+CREATE INDEX IF NOT EXISTS insurance_policies_patient_idx ON insurance_policies (patient_id, payer_id);
+CREATE INDEX IF NOT EXISTS insurance_policies_payer_idx ON insurance_policies (payer_id);
+
+-- Claims are generated and sent to a payer in batches, one claim per invoice.
+CREATE TABLE IF NOT EXISTS claim_batches
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id  bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    number     text                        NOT NULL,
+    payer_id   bigint                      NOT NULL REFERENCES payers ON DELETE RESTRICT,
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    UNIQUE (tenant_id, number)
+);
+
+CREATE INDEX IF NOT EXISTS claim_batches_payer_idx ON claim_batches (payer_id, created_at);
+
+-- total is what the invoice came to after credit notes, copay the patient's part of it and
+-- claimed the payer's. paid is what the payer's remittances paid.
+CREATE TABLE IF NOT EXISTS claims
+(
+    id               bigserial PRIMARY KEY,
+    created_at       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id        bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    number           text                        NOT NULL,
+    batch_id         bigint                      NOT NULL REFERENCES claim_batches ON DELETE RESTRICT,
+    payer_id         bigint                      NOT NULL REFERENCES payers ON DELETE RESTRICT,
+    policy_id        bigint                      NOT NULL REFERENCES insurance_policies ON DELETE RESTRICT,
+    invoice_id       bigint                      NOT NULL REFERENCES invoices ON DELETE RESTRICT,
+    status           text                        NOT NULL DEFAULT 'submitted'
+        CHECK (status IN ('submitted', 'accepted', 'rejected', 'paid')),
+    service_date     date                        NOT NULL,
+    currency         char(3)                     NOT NULL,
+    total            bigint                      NOT NULL CHECK (total >= 0),
+    copay            bigint                      NOT NULL CHECK (copay >= 0),
+    claimed          bigint                      NOT NULL CHECK (claimed > 0),
+    paid             bigint                      NOT NULL DEFAULT 0 CHECK (paid BETWEEN 0 AND claimed),
+    rejection_reason text                        NOT NULL DEFAULT '',
+    UNIQUE (tenant_id, number)
+);
+
+CREATE INDEX IF NOT EXISTS claims_batch_idx ON claims (batch_id);
+CREATE INDEX IF NOT EXISTS claims_payer_idx ON claims (payer_id, status);
+
+-- An invoice is claimed once, unless the payer rejected the claim.
+CREATE UNIQUE INDEX IF NOT EXISTS claims_invoice_idx ON claims (invoice_id) WHERE status <> 'rejected';
+
+-- What a payer paid for a batch of claims. total is the sum of the items.
+CREATE TABLE IF NOT EXISTS remittances
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id   bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    payer_id    bigint                      NOT NULL REFERENCES payers ON DELETE RESTRICT,
+    reference   text                        NOT NULL,
+    received_at timestamp(0) with time zone NOT NULL,
+    total       bigint                      NOT NULL,
+    created_by  bigint REFERENCES users ON DELETE SET NULL,
+    UNIQUE (tenant_id, payer_id, reference)
+);
+
+-- An item pays or rejects a claim. Items which couldn't be matched to a claim, or not
+-- applied to it, keep the claim number the payer sent and say why in error.
+CREATE TABLE IF NOT EXISTS remittance_items
+(
+    id            bigserial PRIMARY KEY,
+    tenant_id     bigint NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    remittance_id bigint NOT NULL REFERENCES remittances ON DELETE CASCADE,
+    claim_number  text   NOT NULL,
+    claim_id      bigint REFERENCES claims ON DELETE RESTRICT,
+    paid          bigint NOT NULL CHECK (paid >= 0),
+    reason        text   NOT NULL DEFAULT '',
+    payment_id    bigint REFERENCES payments ON DELETE RESTRICT,
+    error         text   NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS remittance_items_remittance_idx ON remittance_items (remittance_id);
+CREATE INDEX IF NOT EXISTS remittance_items_claim_idx ON remittance_items (claim_id);
+
+-- Payers' payments are recorded against the invoices they settle.
+ALTER TABLE payments
+    DROP CONSTRAINT IF EXISTS payments_method_check,
+    ADD CONSTRAINT payments_method_check CHECK (method IN ('cash', 'card', 'transfer', 'insurance'));
+
+DO
+$$
+    DECLARE
+        t text;
+    BEGIN
+        FOREACH t IN ARRAY ARRAY ['payers', 'insurance_policies', 'claim_batches', 'claims', 'remittances',
+            'remittance_items']
+            LOOP
+                EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON %I (tenant_id)', t || '_tenant_idx', t);
+                EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+                EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = current_tenant_id())', t);
+            END LOOP;
+    END
+$$;
+
+CREATE TRIGGER insurance_policies_tenant_references
+    BEFORE INSERT OR UPDATE OF patient_id, payer_id
+    ON insurance_policies
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('patient_id', 'patients', 'payer_id', 'payers');
+
+CREATE TRIGGER claim_batches_tenant_references
+    BEFORE INSERT OR UPDATE OF payer_id
+    ON claim_batches
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('payer_id', 'payers');
+
+CREATE TRIGGER claims_tenant_references
+    BEFORE INSERT OR UPDATE OF batch_id, payer_id, policy_id, invoice_id
+    ON claims
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('batch_id', 'claim_batches', 'payer_id', 'payers',
+                                         'policy_id', 'insurance_policies', 'invoice_id', 'invoices');
+
+CREATE TRIGGER remittances_tenant_references
+    BEFORE INSERT OR UPDATE OF payer_id
+    ON remittances
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('payer_id', 'payers');
+
+CREATE TRIGGER remittance_items_tenant_references
+    BEFORE INSERT OR UPDATE OF remittance_id, claim_id, payment_id
+    ON remittance_items
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('remittance_id', 'remittances', 'claim_id', 'claims',
+                                         'payment_id', 'payments');
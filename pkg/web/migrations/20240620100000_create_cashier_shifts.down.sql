@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS payments_tenant_references ON payments;
+
+CREATE TRIGGER payments_tenant_references
+    BEFORE INSERT OR UPDATE OF invoice_id
+    ON payments
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('invoice_id', 'invoices');
+
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS shift_id;
+
+DROP TABLE IF EXISTS cashier_shift_totals;
+DROP TABLE IF EXISTS cashier_shifts;
@@ -0,0 +1,74 @@
+-- A cashier's shift at a till. Payments the cashier receives while the shift is open belong to
+-- it. On closing, the cashier counts what the till holds per payment method and currency; the
+-- totals keep what was expected beside what was counted.
+CREATE TABLE IF NOT EXISTS cashier_shifts
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id      bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    user_id        bigint                      NOT NULL REFERENCES users ON DELETE RESTRICT,
+    branch_id      bigint REFERENCES branches ON DELETE SET NULL,
+    currency       char(3)                     NOT NULL CHECK (currency ~ '^[A-Z]{3}$'),
+    opening_float  bigint                      NOT NULL DEFAULT 0 CHECK (opening_float >= 0),
+    opened_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    closed_at      timestamp(0) with time zone CHECK (closed_at >= opened_at),
+    closed_by      bigint REFERENCES users ON DELETE SET NULL,
+    notes          text                        NOT NULL DEFAULT ''
+);
+
+-- A cashier has one open shift at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS cashier_shifts_open_idx ON cashier_shifts (tenant_id, user_id) WHERE closed_at IS NULL;
+CREATE INDEX IF NOT EXISTS cashier_shifts_opened_at_idx ON cashier_shifts (opened_at);
+
+-- expected is the opening float, for cash in the shift's currency, plus the payments less the
+-- refunds of the shift; counted is null for what wasn't counted.
+CREATE TABLE IF NOT EXISTS cashier_shift_totals
+(
+    tenant_id bigint  NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    shift_id  bigint  NOT NULL REFERENCES cashier_shifts ON DELETE CASCADE,
+    method    text    NOT NULL,
+    currency  char(3) NOT NULL,
+    expected  bigint  NOT NULL,
+    counted   bigint,
+    PRIMARY KEY (shift_id, method, currency)
+);
+
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS shift_id bigint REFERENCES cashier_shifts ON DELETE RESTRICT;
+
+CREATE INDEX IF NOT EXISTS payments_shift_idx ON payments (shift_id);
+
+DO
+$$
+    DECLARE
+        t text;
+    BEGIN
+        FOREACH t IN ARRAY ARRAY ['cashier_shifts', 'cashier_shift_totals']
+            LOOP
+                EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON %I (tenant_id)', t || '_tenant_idx', t);
+                EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+                EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = current_tenant_id())', t);
+            END LOOP;
+    END
+$$;
+
+CREATE TRIGGER cashier_shifts_tenant_references
+    BEFORE INSERT OR UPDATE OF branch_id
+    ON cashier_shifts
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('branch_id', 'branches');
+
+CREATE TRIGGER cashier_shift_totals_tenant_references
+    BEFORE INSERT OR UPDATE OF shift_id
+    ON cashier_shift_totals
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('shift_id', 'cashier_shifts');
+
+DROP TRIGGER IF EXISTS payments_tenant_references ON payments;
+
+CREATE TRIGGER payments_tenant_references
+    BEFORE INSERT OR UPDATE OF invoice_id, shift_id
+    ON payments
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('invoice_id', 'invoices', 'shift_id', 'cashier_shifts');
@@ -0,0 +1,48 @@
+-- Bulk imports of patients or doctors from CSV files. Dry runs only validate the rows. Rows
+-- counts the data rows read, imported those inserted; the rows which failed validation are
+-- kept in import_errors with their line in the file, for the error report.
+CREATE TABLE IF NOT EXISTS imports
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id  bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    kind       text                        NOT NULL CHECK (kind IN ('patients', 'doctors')),
+    filename   text                        NOT NULL DEFAULT '',
+    dry_run    boolean                     NOT NULL,
+    rows       integer                     NOT NULL DEFAULT 0,
+    imported   integer                     NOT NULL DEFAULT 0,
+    invalid    integer                     NOT NULL DEFAULT 0,
+    created_by bigint REFERENCES users ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS import_errors
+(
+    tenant_id bigint  NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    import_id bigint  NOT NULL REFERENCES imports ON DELETE CASCADE,
+    line      integer NOT NULL,
+    field     text    NOT NULL,
+    message   text    NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS import_errors_import_idx ON import_errors (import_id, line);
+
+DO
+$$
+    DECLARE
+        t text;
+    BEGIN
+        FOREACH t IN ARRAY ARRAY ['imports', 'import_errors']
+            LOOP
+                EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON %I (tenant_id)', t || '_tenant_idx', t);
+                EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+                EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = current_tenant_id())', t);
+            END LOOP;
+    END
+$$;
+
+CREATE TRIGGER import_errors_tenant_references
+    BEFORE INSERT OR UPDATE OF import_id
+    ON import_errors
+    FOR EACH ROW
+EXECUTE FUNCTION check_tenant_references('import_id', 'imports');
@@ -0,0 +1,37 @@
+-- Asynchronous exports of patients, doctors or appointments. A pending export is claimed by a
+-- worker, which streams the rows into a file in the document storage under storage_key and
+-- records how many rows and bytes it wrote. Failed exports keep the error.
+CREATE TABLE IF NOT EXISTS exports
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id   bigint                      NOT NULL DEFAULT current_tenant_id() REFERENCES tenants ON DELETE CASCADE,
+    entity      text                        NOT NULL CHECK (entity IN ('patients', 'doctors', 'appointments')),
+    format      text                        NOT NULL CHECK (format IN ('csv', 'ndjson', 'xlsx')),
+    filter      jsonb                       NOT NULL DEFAULT '{}',
+    status      text                        NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'failed')),
+    started_at  timestamp(0) with time zone,
+    finished_at timestamp(0) with time zone,
+    rows        bigint                      NOT NULL DEFAULT 0,
+    size        bigint                      NOT NULL DEFAULT 0,
+    storage_key text                        NOT NULL DEFAULT '',
+    error       text                        NOT NULL DEFAULT '',
+    created_by  bigint REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS exports_pending_idx ON exports (created_at) WHERE status IN ('pending', 'running');
+
+DO
+$$
+    DECLARE
+        t text;
+    BEGIN
+        FOREACH t IN ARRAY ARRAY ['exports']
+            LOOP
+                EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON %I (tenant_id)', t || '_tenant_idx', t);
+                EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+                EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+                EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = current_tenant_id())', t);
+            END LOOP;
+    END
+$$;
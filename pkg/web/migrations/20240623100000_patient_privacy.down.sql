@@ -0,0 +1,5 @@
+ALTER TABLE patients
+    DROP COLUMN IF EXISTS anonymized_by,
+    DROP COLUMN IF EXISTS anonymized_at,
+    DROP COLUMN IF EXISTS legal_hold_until,
+    DROP COLUMN IF EXISTS legal_hold_reason;
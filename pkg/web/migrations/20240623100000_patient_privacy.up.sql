@@ -0,0 +1,8 @@
+-- A legal hold keeps a patient's records from being anonymized, until legal_hold_until or,
+-- without it, until it is lifted. Anonymized patients keep their row, so that the statistics
+-- over their appointments, diagnoses and invoices still add up, but not who they were.
+ALTER TABLE patients
+    ADD COLUMN IF NOT EXISTS legal_hold_reason text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS legal_hold_until  date,
+    ADD COLUMN IF NOT EXISTS anonymized_at     timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS anonymized_by     bigint REFERENCES users ON DELETE SET NULL;
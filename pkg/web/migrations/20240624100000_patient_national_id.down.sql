@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS patients_national_id_idx;
+ALTER TABLE patients
+    DROP COLUMN IF EXISTS national_id_index,
+    DROP COLUMN IF EXISTS national_id;
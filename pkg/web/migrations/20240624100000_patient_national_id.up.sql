@@ -0,0 +1,8 @@
+-- A patient's national ID is encrypted like their phone number and the notes of diagnoses and
+-- lab orders, so it is looked up by its blind index, a keyed hash of the normalized ID.
+ALTER TABLE patients
+    ADD COLUMN IF NOT EXISTS national_id       text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS national_id_index text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS patients_national_id_idx ON patients (tenant_id, national_id_index)
+    WHERE national_id_index IS NOT NULL;
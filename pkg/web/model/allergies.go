@@ -0,0 +1,204 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// Allergy is a substance a patient is known to react to.
+type Allergy struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	PatientID int64     `json:"patient_id"`
+	Substance string    `json:"substance"`
+	Reaction  string    `json:"reaction"`
+	Severity  string    `json:"severity"`
+}
+
+type AllergyModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// NormalizeSubstance lower-cases a substance name and collapses its whitespace so substances
+// can be compared by equality.
+func NormalizeSubstance(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// SplitSubstances splits the active substance of a combination product, such as
+// "Amoxicillin + Clavulanic acid", into normalized single substances.
+func SplitSubstances(s string) []string {
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '+' || r == ',' || r == ';' || r == '/'
+	})
+
+	var substances []string
+	for _, p := range parts {
+		if p = NormalizeSubstance(p); p != "" {
+			substances = append(substances, p)
+		}
+	}
+	return substances
+}
+
+// allergyClasses are the ATC classes of the drug groups allergies are commonly recorded by,
+// such as "penicillin" for every penicillin, amoxicillin included.
+var allergyClasses = map[string][]string{
+	"penicillin":         {"J01C"},
+	"penicillins":        {"J01C"},
+	"beta-lactam":        {"J01C", "J01D"},
+	"beta-lactams":       {"J01C", "J01D"},
+	"cephalosporin":      {"J01DB", "J01DC", "J01DD", "J01DE", "J01DI"},
+	"cephalosporins":     {"J01DB", "J01DC", "J01DD", "J01DE", "J01DI"},
+	"carbapenem":         {"J01DH"},
+	"carbapenems":        {"J01DH"},
+	"sulfonamide":        {"J01E"},
+	"sulfonamides":       {"J01E"},
+	"sulfa":              {"J01E"},
+	"macrolide":          {"J01FA"},
+	"macrolides":         {"J01FA"},
+	"tetracycline":       {"J01AA"},
+	"tetracyclines":      {"J01AA"},
+	"fluoroquinolone":    {"J01MA"},
+	"fluoroquinolones":   {"J01MA"},
+	"quinolone":          {"J01M"},
+	"quinolones":         {"J01M"},
+	"aminoglycoside":     {"J01G"},
+	"aminoglycosides":    {"J01G"},
+	"nsaid":              {"M01A"},
+	"nsaids":             {"M01A"},
+	"aspirin":            {"B01AC06", "N02BA01"},
+	"salicylates":        {"N02BA"},
+	"opioid":             {"N02A"},
+	"opioids":            {"N02A"},
+	"statin":             {"C10AA"},
+	"statins":            {"C10AA"},
+	"ace inhibitor":      {"C09A", "C09B"},
+	"ace inhibitors":     {"C09A", "C09B"},
+	"iodinated contrast": {"V08A"},
+	"local anaesthetic":  {"N01B"},
+	"local anaesthetics": {"N01B"},
+	"local anesthetic":   {"N01B"},
+	"local anesthetics":  {"N01B"},
+	"benzodiazepine":     {"N05BA", "N05CD"},
+	"benzodiazepines":    {"N05BA", "N05CD"},
+	"anticonvulsant":     {"N03A"},
+	"anticonvulsants":    {"N03A"},
+	"antiepileptic":      {"N03A"},
+	"antiepileptics":     {"N03A"},
+}
+
+// Matches reports whether a patient with the allergy may react to a drug: the allergy names
+// the drug or one of its active substances, an ATC class code such as "J01C" the drug belongs
+// to, or a drug group such as "penicillin" whose ATC class the drug belongs to.
+func (a *Allergy) Matches(drug *Drug) bool {
+	substance := NormalizeSubstance(a.Substance)
+	if substance == NormalizeSubstance(drug.Name) || validator.In(substance, drug.Substances()...) {
+		return true
+	}
+
+	atc := strings.ToUpper(drug.ATCCode)
+	if atc == "" {
+		return false
+	}
+	if len(substance) >= 3 && strings.HasPrefix(atc, strings.ToUpper(substance)) {
+		return true
+	}
+	for _, class := range allergyClasses[substance] {
+		if strings.HasPrefix(atc, class) {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateAllergy(v *validator.Validator, allergy *Allergy) {
+	v.Check(allergy.Substance != "", "substance", "must be provided")
+	v.Check(len(allergy.Substance) <= 200, "substance", "must not be more than 200 bytes long")
+	v.Check(len(allergy.Reaction) <= 500, "reaction", "must not be more than 500 bytes long")
+	v.Check(validator.In(allergy.Severity, "mild", "moderate", "severe", "life_threatening"), "severity",
+		"must be one of mild, moderate, severe or life_threatening")
+}
+
+// Insert records a new allergy for a patient.
+func (m AllergyModel) Insert(allergy *Allergy) error {
+	query := `
+		INSERT INTO patient_allergies (patient_id, substance, reaction, severity)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+		`
+
+	allergy.Substance = NormalizeSubstance(allergy.Substance)
+	args := []interface{}{allergy.PatientID, allergy.Substance, allergy.Reaction, allergy.Severity}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&allergy.ID, &allergy.CreatedAt)
+}
+
+// Delete removes an allergy recorded by mistake.
+func (m AllergyModel) Delete(id int64) error {
+	query := `
+		DELETE FROM patient_allergies
+		WHERE id = $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForPatient returns the allergies recorded for a patient.
+func (m AllergyModel) GetForPatient(patientID int64) ([]*Allergy, error) {
+	query := `
+		SELECT id, created_at, patient_id, substance, reaction, severity
+		FROM patient_allergies
+		WHERE patient_id = $1
+		ORDER BY substance
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allergies := []*Allergy{}
+	for rows.Next() {
+		var a Allergy
+		if err := rows.Scan(&a.ID, &a.CreatedAt, &a.PatientID, &a.Substance, &a.Reaction, &a.Severity); err != nil {
+			return nil, err
+		}
+		allergies = append(allergies, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return allergies, nil
+}
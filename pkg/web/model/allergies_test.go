@@ -0,0 +1,73 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllergyMatches(t *testing.T) {
+	amoxicillin := &Drug{Name: "Amoxil", ActiveSubstance: "Amoxicillin", ATCCode: "J01CA04"}
+	augmentin := &Drug{Name: "Augmentin", ActiveSubstance: "Amoxicillin + Clavulanic acid", ATCCode: "J01CR02"}
+	cefuroxime := &Drug{Name: "Zinnat", ActiveSubstance: "Cefuroxime", ATCCode: "J01DC02"}
+	ibuprofen := &Drug{Name: "Nurofen", ActiveSubstance: "Ibuprofen", ATCCode: "M01AE01"}
+	unclassified := &Drug{Name: "Herbal tea", ActiveSubstance: "Chamomile"}
+
+	tests := []struct {
+		name      string
+		substance string
+		drug      *Drug
+		want      bool
+	}{
+		{"penicillin allergy against amoxicillin", "penicillin", amoxicillin, true},
+		{"penicillin allergy against a combination", "Penicillin", augmentin, true},
+		{"penicillin allergy against a cephalosporin", "penicillin", cefuroxime, false},
+		{"cephalosporin allergy", "cephalosporins", cefuroxime, true},
+		{"active substance", "amoxicillin", amoxicillin, true},
+		{"one substance of a combination", "clavulanic  acid", augmentin, true},
+		{"drug name", "nurofen", ibuprofen, true},
+		{"ATC class code", "j01c", amoxicillin, true},
+		{"ATC code too short to be a class", "j0", amoxicillin, false},
+		{"NSAID allergy", "NSAIDs", ibuprofen, true},
+		{"unrelated substance", "latex", amoxicillin, false},
+		{"drug group against a drug without ATC code", "penicillin", unclassified, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allergy := &Allergy{Substance: tt.substance}
+			if got := allergy.Matches(tt.drug); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.drug.Name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrescriptionConflicts(t *testing.T) {
+	drug := &Drug{Name: "Amoxil", ActiveSubstance: "Amoxicillin", ATCCode: "J01CA04"}
+	allergies := []*Allergy{
+		{Substance: "penicillin", Severity: "severe", Reaction: "hives"},
+		{Substance: "latex", Severity: "mild"},
+	}
+	active := map[int64][]string{
+		7: {"methotrexate"},
+		3: {"warfarin"},
+		9: {"paracetamol"},
+	}
+	interactions := []*Interaction{
+		{SubstanceA: "amoxicillin", SubstanceB: "warfarin", Severity: "moderate", Description: "raised INR"},
+		{SubstanceA: "amoxicillin", SubstanceB: "methotrexate", Severity: "major", Description: "methotrexate toxicity"},
+	}
+
+	want := []PrescriptionConflict{
+		{Kind: ConflictAllergy, Substance: "penicillin", Severity: "severe", Description: "hives"},
+		{Kind: ConflictInteraction, Substance: "amoxicillin / warfarin", Severity: "moderate", Description: "raised INR", PrescriptionID: 3},
+		{Kind: ConflictInteraction, Substance: "amoxicillin / methotrexate", Severity: "major", Description: "methotrexate toxicity", PrescriptionID: 7},
+	}
+	if got := PrescriptionConflicts(drug, allergies, active, interactions); !reflect.DeepEqual(got, want) {
+		t.Errorf("PrescriptionConflicts() = %+v, want %+v", got, want)
+	}
+
+	if got := PrescriptionConflicts(drug, nil, nil, nil); got == nil || len(got) != 0 {
+		t.Errorf("PrescriptionConflicts() without allergies or prescriptions = %#v, want an empty list", got)
+	}
+}
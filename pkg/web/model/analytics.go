@@ -0,0 +1,465 @@
+package model
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// ReportIntervals are the periods the reports over time are grouped by.
+var ReportIntervals = []string{"day", "week", "month"}
+
+// AnalyticsFilter selects the appointments of the local days From to To (YYYY-MM-DD,
+// inclusive), optionally of one branch. The days are those of the time zone of each
+// appointment's branch, or of the database for appointments without one. The reports over time
+// are grouped by Interval, where weeks start on Monday.
+type AnalyticsFilter struct {
+	From     string
+	To       string
+	BranchID int64
+	Interval string
+}
+
+// ValidateReportDays checks the period of a report: two dates, at most a year apart.
+func ValidateReportDays(v *validator.Validator, from, to string) {
+	fromDay, errFrom := time.Parse("2006-01-02", from)
+	toDay, errTo := time.Parse("2006-01-02", to)
+	v.Check(errFrom == nil, "from", "must be a date such as 2024-07-01")
+	v.Check(errTo == nil, "to", "must be a date such as 2024-07-31")
+	if errFrom == nil && errTo == nil {
+		v.Check(!toDay.Before(fromDay), "to", "must not be before from")
+		v.Check(toDay.Sub(fromDay) <= 366*24*time.Hour, "to", "must be at most a year after from")
+	}
+}
+
+func ValidateAnalyticsFilter(v *validator.Validator, f AnalyticsFilter) {
+	ValidateReportDays(v, f.From, f.To)
+	v.Check(f.BranchID >= 0, "branch_id", "must be a positive integer")
+	v.Check(validator.In(f.Interval, ReportIntervals...), "interval", "must be day, week or month")
+}
+
+// appointmentLocalTime is the time of appointment a in the time zone of its branch b.
+const appointmentLocalTime = `(a.date_time AT TIME ZONE COALESCE(b.time_zone, current_setting('TimeZone')))`
+
+// appointmentsInPeriod limits appointments a to the local days $1 to $2 and the branch $3.
+const appointmentsInPeriod = appointmentLocalTime + `::date BETWEEN $1 AND $2 AND ($3 = 0 OR a.branch_id = $3)`
+
+// DoctorUtilization is how much of a doctor's time was booked: the minutes of the appointments
+// which weren't cancelled, against the minutes within the opening hours which weren't closed.
+// Utilization is the percentage booked, if the doctor had any time available. The opening
+// hours are those of BranchID, or the default ones without a branch.
+type DoctorUtilization struct {
+	DoctorID         int64    `json:"doctor_id"`
+	Doctor           string   `json:"doctor"`
+	Speciality       string   `json:"speciality"`
+	BranchID         *int64   `json:"branch_id,omitempty"`
+	Appointments     int64    `json:"appointments"`
+	BookedMinutes    int64    `json:"booked_minutes"`
+	AvailableMinutes int64    `json:"available_minutes"`
+	Utilization      *float64 `json:"utilization"`
+}
+
+// Utilization returns the utilization of the doctors in the filter's period: of every doctor,
+// or with a branch of the branch's doctors and those with appointments there. A doctor's
+// available time follows the opening hours of the branch, of the only branch the doctor works
+// at without one, or else hours in loc.
+func (m ReportModel) Utilization(f AnalyticsFilter, hours OpeningHours, loc *time.Location) ([]*DoctorUtilization, error) {
+	query := `
+		SELECT d.id, d.first_name || ' ' || d.last_name, d.speciality,
+			CASE WHEN $3 <> 0 THEN $3 ELSE (
+				SELECT min(db.branch_id) FROM doctor_branches db WHERE db.doctor_id = d.id HAVING count(*) = 1
+			) END,
+			count(a.id), COALESCE(sum(a.duration_minutes), 0)
+		FROM doctors d
+			LEFT JOIN (appointments a LEFT JOIN branches b ON b.id = a.branch_id)
+				ON a.doctor_id = d.id AND a.status <> 'cancelled' AND ` + appointmentsInPeriod + `
+		WHERE $3 = 0 OR a.id IS NOT NULL
+			OR EXISTS (SELECT 1 FROM doctor_branches db WHERE db.doctor_id = d.id AND db.branch_id = $3)
+		GROUP BY d.id
+		ORDER BY d.last_name, d.first_name, d.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.From, f.To, f.BranchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []*DoctorUtilization{}
+	var branchIDs []int64
+	for rows.Next() {
+		var u DoctorUtilization
+		err := rows.Scan(&u.DoctorID, &u.Doctor, &u.Speciality, &u.BranchID, &u.Appointments, &u.BookedMinutes)
+		if err != nil {
+			return nil, err
+		}
+		if u.BranchID != nil {
+			branchIDs = append(branchIDs, *u.BranchID)
+		}
+		report = append(report, &u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	type branchHours struct {
+		hours OpeningHours
+		loc   *time.Location
+	}
+	branches := make(map[int64]branchHours)
+	rows, err = m.DB.QueryContext(ctx, `SELECT id, time_zone, opening_hours FROM branches WHERE id = ANY($1)`,
+		pq.Array(branchIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b Branch
+		if err := rows.Scan(&b.ID, &b.TimeZone, &b.OpeningHours); err != nil {
+			return nil, err
+		}
+		var bh branchHours
+		if bh.hours, err = b.Hours(); err != nil {
+			return nil, err
+		}
+		if bh.loc, err = b.Location(); err != nil {
+			return nil, err
+		}
+		branches[b.ID] = bh
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The closures of the period, with a day to spare for the time zones.
+	from, _ := time.Parse("2006-01-02", f.From)
+	to, _ := time.Parse("2006-01-02", f.To)
+	rows, err = m.DB.QueryContext(ctx, `
+		SELECT COALESCE(doctor_id, 0), starts_at, ends_at
+		FROM closures
+		WHERE starts_at < $2 AND ends_at > $1
+		`, from.AddDate(0, 0, -1), to.AddDate(0, 0, 2))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	closures := make(map[int64][]Slot)
+	for rows.Next() {
+		var doctorID int64
+		var c Slot
+		if err := rows.Scan(&doctorID, &c.Start, &c.End); err != nil {
+			return nil, err
+		}
+		closures[doctorID] = append(closures[doctorID], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, u := range report {
+		h, l := hours, loc
+		if u.BranchID != nil {
+			if bh, ok := branches[*u.BranchID]; ok {
+				h, l = bh.hours, bh.loc
+			}
+		}
+		closed := append(append([]Slot{}, closures[0]...), closures[u.DoctorID]...)
+		u.AvailableMinutes = availableMinutes(f.From, f.To, h, l, closed)
+		if u.AvailableMinutes > 0 {
+			utilization := math.Round(1000*float64(u.BookedMinutes)/float64(u.AvailableMinutes)) / 10
+			u.Utilization = &utilization
+		}
+	}
+
+	return report, nil
+}
+
+// availableMinutes returns the minutes within the opening hours in loc of the days from to to
+// (YYYY-MM-DD, inclusive) which aren't closed.
+func availableMinutes(from, to string, hours OpeningHours, loc *time.Location, closures []Slot) int64 {
+	first, err1 := time.ParseInLocation("2006-01-02", from, loc)
+	last, err2 := time.ParseInLocation("2006-01-02", to, loc)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+
+	open := make(map[time.Weekday]bool)
+	for _, d := range hours.Days {
+		open[d] = true
+	}
+
+	sort.Slice(closures, func(i, j int) bool { return closures[i].Start.Before(closures[j].Start) })
+
+	var available time.Duration
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if !open[day.Weekday()] {
+			continue
+		}
+		opening := time.Date(day.Year(), day.Month(), day.Day(), 0, int(hours.Open/time.Minute), 0, 0, loc)
+		closing := time.Date(day.Year(), day.Month(), day.Day(), 0, int(hours.Close/time.Minute), 0, 0, loc)
+
+		// Closures may overlap, so the opening hours are walked through from start to end.
+		at := opening
+		for _, c := range closures {
+			if !c.End.After(at) || !c.Start.Before(closing) {
+				continue
+			}
+			if c.Start.After(at) {
+				available += c.Start.Sub(at)
+			}
+			at = c.End
+			if !at.Before(closing) {
+				break
+			}
+		}
+		if at.Before(closing) {
+			available += closing.Sub(at)
+		}
+	}
+
+	return int64(available / time.Minute)
+}
+
+// AttendanceRow is how a doctor's appointments, or all of them, turned out. Past counts the
+// appointments which have taken place or should have, and NoShows those of them whose patient
+// was marked as a no-show at check-in. CheckedIn counts the patients who were called or seen.
+// The rates are percentages, of all appointments and of Past.
+type AttendanceRow struct {
+	DoctorID         *int64   `json:"doctor_id,omitempty"`
+	Doctor           string   `json:"doctor,omitempty"`
+	Appointments     int64    `json:"appointments"`
+	Cancelled        int64    `json:"cancelled"`
+	Past             int64    `json:"past"`
+	CheckedIn        int64    `json:"checked_in"`
+	NoShows          int64    `json:"no_shows"`
+	CancellationRate *float64 `json:"cancellation_rate"`
+	NoShowRate       *float64 `json:"no_show_rate"`
+}
+
+// Attendance returns the cancellations and no-shows of the filter's period per doctor, and of
+// every doctor together.
+func (m ReportModel) Attendance(f AnalyticsFilter) ([]*AttendanceRow, *AttendanceRow, error) {
+	query := `
+		SELECT d.id, COALESCE(d.first_name || ' ' || d.last_name, ''), appointments, cancelled, past, checked_in,
+			no_shows, round(100.0 * cancelled / NULLIF(appointments, 0), 1)::float8,
+			round(100.0 * no_shows / NULLIF(past, 0), 1)::float8
+		FROM (
+			SELECT d.id, d.first_name, d.last_name, count(*) AS appointments,
+				count(*) FILTER (WHERE a.status = 'cancelled') AS cancelled,
+				count(*) FILTER (WHERE a.status <> 'cancelled' AND a.date_time < NOW()) AS past,
+				count(*) FILTER (WHERE a.status <> 'cancelled' AND q.status IN ('called', 'done')) AS checked_in,
+				count(*) FILTER (WHERE a.status <> 'cancelled' AND q.status = 'no_show') AS no_shows
+			FROM appointments a
+				JOIN doctors d ON d.id = a.doctor_id
+				LEFT JOIN branches b ON b.id = a.branch_id
+				LEFT JOIN queue_tickets q ON q.appointment_id = a.id
+			WHERE ` + appointmentsInPeriod + `
+			GROUP BY GROUPING SETS ((d.id, d.first_name, d.last_name), ())
+		) d
+		ORDER BY d.id IS NULL, d.last_name, d.first_name, d.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.From, f.To, f.BranchID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	report := []*AttendanceRow{}
+	total := &AttendanceRow{}
+	for rows.Next() {
+		var row AttendanceRow
+		err := rows.Scan(&row.DoctorID, &row.Doctor, &row.Appointments, &row.Cancelled, &row.Past, &row.CheckedIn,
+			&row.NoShows, &row.CancellationRate, &row.NoShowRate)
+		if err != nil {
+			return nil, nil, err
+		}
+		if row.DoctorID == nil {
+			total = &row
+			continue
+		}
+		report = append(report, &row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return report, total, nil
+}
+
+// LeadTimeRow is how long before their appointments a doctor's patients, or all of them,
+// booked, in days. SameDay counts the appointments booked on the day. Cancelled appointments
+// don't count, and neither do those entered after they took place.
+type LeadTimeRow struct {
+	DoctorID     *int64   `json:"doctor_id,omitempty"`
+	Doctor       string   `json:"doctor,omitempty"`
+	Appointments int64    `json:"appointments"`
+	SameDay      int64    `json:"same_day"`
+	AverageDays  *float64 `json:"average_days"`
+	MedianDays   *float64 `json:"median_days"`
+	P90Days      *float64 `json:"p90_days"`
+}
+
+// LeadTime returns the booking lead time of the appointments of the filter's period per
+// doctor, and of every doctor together.
+func (m ReportModel) LeadTime(f AnalyticsFilter) ([]*LeadTimeRow, *LeadTimeRow, error) {
+	query := `
+		SELECT d.id, COALESCE(d.first_name || ' ' || d.last_name, ''), count(*),
+			count(*) FILTER (WHERE ` + appointmentLocalTime + `::date =
+				(a.created_at AT TIME ZONE COALESCE(b.time_zone, current_setting('TimeZone')))::date),
+			round(avg(l.days)::numeric, 1)::float8,
+			round(percentile_cont(0.5) WITHIN GROUP (ORDER BY l.days)::numeric, 1)::float8,
+			round(percentile_cont(0.9) WITHIN GROUP (ORDER BY l.days)::numeric, 1)::float8
+		FROM appointments a
+			JOIN doctors d ON d.id = a.doctor_id
+			LEFT JOIN branches b ON b.id = a.branch_id,
+			LATERAL (SELECT extract(EPOCH FROM a.date_time - a.created_at) / 86400 AS days) l
+		WHERE ` + appointmentsInPeriod + `
+			AND a.status <> 'cancelled' AND a.date_time >= a.created_at
+		GROUP BY GROUPING SETS ((d.id, d.first_name, d.last_name), ())
+		ORDER BY d.id IS NULL, d.last_name, d.first_name, d.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.From, f.To, f.BranchID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	report := []*LeadTimeRow{}
+	total := &LeadTimeRow{}
+	for rows.Next() {
+		var row LeadTimeRow
+		err := rows.Scan(&row.DoctorID, &row.Doctor, &row.Appointments, &row.SameDay, &row.AverageDays,
+			&row.MedianDays, &row.P90Days)
+		if err != nil {
+			return nil, nil, err
+		}
+		if row.DoctorID == nil {
+			total = &row
+			continue
+		}
+		report = append(report, &row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return report, total, nil
+}
+
+// PatientsRow counts the patients who had appointments in a period: New those whose first
+// appointment with the clinic, at any branch, was in the period, and Returning those who had
+// been before.
+type PatientsRow struct {
+	Period    string `json:"period"`
+	New       int64  `json:"new"`
+	Returning int64  `json:"returning"`
+}
+
+// Patients returns the new and returning patients of the filter's period per interval.
+// Cancelled appointments don't count.
+func (m ReportModel) Patients(f AnalyticsFilter) ([]*PatientsRow, error) {
+	query := `
+		WITH firsts AS (
+			SELECT DISTINCT ON (a.patient_id) a.patient_id, ` + appointmentLocalTime + ` AS first
+			FROM appointments a
+				LEFT JOIN branches b ON b.id = a.branch_id
+			WHERE a.status <> 'cancelled'
+			ORDER BY a.patient_id, a.date_time
+		)
+		SELECT date_trunc($4, ` + appointmentLocalTime + `)::date::text AS period,
+			count(DISTINCT a.patient_id) FILTER (WHERE date_trunc($4, f.first) = date_trunc($4, ` + appointmentLocalTime + `)),
+			count(DISTINCT a.patient_id) FILTER (WHERE date_trunc($4, f.first) < date_trunc($4, ` + appointmentLocalTime + `))
+		FROM appointments a
+			LEFT JOIN branches b ON b.id = a.branch_id
+			JOIN firsts f ON f.patient_id = a.patient_id
+		WHERE ` + appointmentsInPeriod + ` AND a.status <> 'cancelled'
+		GROUP BY period
+		ORDER BY period
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.From, f.To, f.BranchID, f.Interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []*PatientsRow{}
+	for rows.Next() {
+		var row PatientsRow
+		if err := rows.Scan(&row.Period, &row.New, &row.Returning); err != nil {
+			return nil, err
+		}
+		report = append(report, &row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// SpecialityRow counts the appointments with the doctors of a speciality in a period.
+type SpecialityRow struct {
+	Period       string `json:"period"`
+	Speciality   string `json:"speciality"`
+	Appointments int64  `json:"appointments"`
+	Cancelled    int64  `json:"cancelled"`
+}
+
+// Specialities returns the appointments of the filter's period per interval and speciality.
+// Appointments counts those which weren't cancelled.
+func (m ReportModel) Specialities(f AnalyticsFilter) ([]*SpecialityRow, error) {
+	query := `
+		SELECT date_trunc($4, ` + appointmentLocalTime + `)::date::text AS period, d.speciality,
+			count(*) FILTER (WHERE a.status <> 'cancelled'),
+			count(*) FILTER (WHERE a.status = 'cancelled')
+		FROM appointments a
+			JOIN doctors d ON d.id = a.doctor_id
+			LEFT JOIN branches b ON b.id = a.branch_id
+		WHERE ` + appointmentsInPeriod + `
+		GROUP BY period, d.speciality
+		ORDER BY period, d.speciality
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.From, f.To, f.BranchID, f.Interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []*SpecialityRow{}
+	for rows.Next() {
+		var row SpecialityRow
+		if err := rows.Scan(&row.Period, &row.Speciality, &row.Appointments, &row.Cancelled); err != nil {
+			return nil, err
+		}
+		report = append(report, &row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTokenUsed is returned when a single-use token has already been used.
+	ErrTokenUsed = errors.New("token has already been used")
+	// ErrTokenExpired is returned when a token has expired.
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrAppointmentCancelled is returned when an action needs an appointment which isn't
+	// cancelled.
+	ErrAppointmentCancelled = errors.New("appointment has been cancelled")
+	// ErrAppointmentPassed is returned when an action needs an appointment in the future.
+	ErrAppointmentPassed = errors.New("appointment has already taken place")
+)
+
+// ApplyAction uses an appointment action token to set the status of its appointment to
+// AppointmentConfirmed or AppointmentCancelled. The token is only used up if the status was
+// changed. It returns the updated appointment, or ErrRecordNotFound, ErrTokenUsed,
+// ErrTokenExpired, ErrAppointmentCancelled or ErrAppointmentPassed.
+func (m AppointmentModel) ApplyAction(tokenPlaintext, status string) (*Appointment, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the token, so that a double click can't use it twice.
+	var appointmentID int64
+	var expiry time.Time
+	var usedAt *time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT appointment_id, expiry, used_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2
+		FOR UPDATE
+		`, tokenHash[:], ScopeAppointmentAction).Scan(&appointmentID, &expiry, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	switch {
+	case usedAt != nil:
+		return nil, ErrTokenUsed
+	case time.Now().After(expiry):
+		return nil, ErrTokenExpired
+	}
+
+	var appointment Appointment
+	var start time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, created_at, updated_at, doctor_id, patient_id, date_time, status, date_time
+		FROM appointments
+		WHERE id = $1
+		FOR UPDATE
+		`, appointmentID).Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DoctorID,
+		&appointment.PatientID, &appointment.DateTime, &appointment.Status, &start)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	switch {
+	case appointment.Status == AppointmentCancelled:
+		return nil, ErrAppointmentCancelled
+	case time.Now().After(start):
+		return nil, ErrAppointmentPassed
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE appointments
+		SET status = $1, updated_at = NOW()
+		WHERE id = $2
+		RETURNING status, updated_at
+		`, status, appointmentID).Scan(&appointment.Status, &appointment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE hash = $1`, tokenHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &appointment, nil
+}
@@ -0,0 +1,405 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MaxSeriesOccurrences is the largest number of appointments a series may have.
+const MaxSeriesOccurrences = 200
+
+// Scopes of a change to an appointment of a series.
+const (
+	SeriesScopeThis      = "this"
+	SeriesScopeFollowing = "following"
+	SeriesScopeAll       = "all"
+)
+
+// ErrNoOccurrences is returned when none of the occurrences of a series could be booked.
+var ErrNoOccurrences = errors.New("none of the occurrences could be booked")
+
+// AppointmentSeries is a recurring appointment described by an RFC 5545 RRULE. Its occurrences
+// are stored as ordinary appointments; Start and RRule record how they were produced, and
+// TimeZone the zone whose wall clock time the occurrences keep.
+type AppointmentSeries struct {
+	ID           int64          `json:"id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DoctorID     int64          `json:"doctor_id"`
+	PatientID    int64          `json:"patient_id"`
+	Start        time.Time      `json:"start"`
+	TimeZone     string         `json:"time_zone"`
+	RRule        string         `json:"rrule"`
+	BranchID     *int64         `json:"branch_id,omitempty"`
+	RoomID       *int64         `json:"room_id,omitempty"`
+	CreatedBy    *int64         `json:"created_by,omitempty"`
+	Appointments []*Appointment `json:"appointments,omitempty"`
+}
+
+// OccurrenceResult is the outcome of booking or changing one occurrence of a series. Error is
+// set if the occurrence could not be booked or changed.
+type OccurrenceResult struct {
+	Occurrence    int       `json:"occurrence"`
+	DateTime      time.Time `json:"date_time"`
+	AppointmentID int64     `json:"appointment_id,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// OccurrenceChange describes how the appointments of a series are changed. DateTime is the new
+// start of the selected appointment; the others move by the same number of days to the same
+// wall clock time. Either field may be nil to keep the current value.
+type OccurrenceChange struct {
+	DateTime *time.Time
+	DoctorID *int
+}
+
+type AppointmentSeriesModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert creates a series with an appointment at each of starts. Occurrences which are in the
+// past, clash with another appointment of the doctor or the patient, or fall into a closure
+// are skipped and reported in the results. If none can be booked nothing is stored and ErrNoOccurrences is returned
+// together with the results.
+func (m AppointmentSeriesModel) Insert(series *AppointmentSeries, starts []time.Time) ([]*OccurrenceResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO appointment_series (doctor_id, patient_id, dtstart, time_zone, rrule, branch_id, room_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at
+		`, series.DoctorID, series.PatientID, series.Start, series.TimeZone, series.RRule, series.BranchID, series.RoomID,
+		series.CreatedBy).Scan(
+		&series.ID, &series.CreatedAt, &series.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*OccurrenceResult, len(starts))
+	booked := 0
+	for i, start := range starts {
+		result := &OccurrenceResult{Occurrence: i + 1, DateTime: start}
+		results[i] = result
+
+		a := &Appointment{DateTime: start.Format(time.RFC3339), DoctorID: int(series.DoctorID),
+			PatientID: int(series.PatientID), BranchID: series.BranchID, RoomID: series.RoomID}
+		if err := checkOccurrence(ctx, tx, a); err != nil {
+			if isOccurrenceConflict(err) {
+				result.Error = err.Error()
+				continue
+			}
+			return nil, err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO appointments (date_time, doctor_id, patient_id, series_id, occurrence, branch_id, room_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+			`, start, series.DoctorID, series.PatientID, series.ID, result.Occurrence, a.BranchID, a.RoomID).Scan(
+			&result.AppointmentID)
+		if err != nil {
+			return nil, err
+		}
+		booked++
+	}
+
+	if booked == 0 {
+		return results, ErrNoOccurrences
+	}
+
+	return results, tx.Commit()
+}
+
+// errPatientBusy is reported for occurrences at which the patient has another appointment.
+var errPatientBusy = errors.New("patient already has an appointment at this time")
+
+// isOccurrenceConflict reports whether err means that an occurrence can't be booked, rather
+// than that the database failed.
+func isOccurrenceConflict(err error) bool {
+	return errors.Is(err, ErrSlotTaken) || errors.Is(err, ErrDoctorUnavailable) || errors.Is(err, errPatientBusy) ||
+		errors.Is(err, ErrAppointmentPassed) || errors.Is(err, ErrRoomTaken)
+}
+
+// checkOccurrence returns ErrAppointmentPassed, ErrSlotTaken, ErrDoctorUnavailable,
+// ErrRoomTaken or errPatientBusy if appointment a, new or with its ID set, can't be booked at
+// its time. The bookings of the doctor and the room stay locked for the rest of tx, and the
+// branch of a is filled in as by placeAppointment.
+func checkOccurrence(ctx context.Context, tx *sql.Tx, a *Appointment) error {
+	start, err := time.Parse(time.RFC3339, a.DateTime)
+	if err != nil {
+		return err
+	}
+	if !start.After(time.Now()) {
+		return ErrAppointmentPassed
+	}
+	excludeID := a.Id
+	if excludeID == "" {
+		excludeID = "0"
+	}
+	if err := lockDoctorSlot(ctx, tx, a.DoctorID, a.DateTime, excludeID, 0, int(a.Duration()/time.Minute)); err != nil {
+		return err
+	}
+	if err := placeAppointment(ctx, tx, a); err != nil {
+		return err
+	}
+
+	var busy bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM appointments
+			WHERE patient_id = $1 AND id <> $3 AND status <> 'cancelled'
+				AND date_time < $2::timestamptz + make_interval(mins => $4)
+				AND date_time + make_interval(mins => duration_minutes) > $2::timestamptz
+		)
+		`, a.PatientID, start, excludeID, int(a.Duration()/time.Minute)).Scan(&busy)
+	if err != nil {
+		return err
+	}
+	if busy {
+		return errPatientBusy
+	}
+	return nil
+}
+
+// Get returns a series with its appointments in occurrence order.
+func (m AppointmentSeriesModel) Get(id int64) (*AppointmentSeries, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var series AppointmentSeries
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT id, created_at, updated_at, doctor_id, patient_id, dtstart, time_zone, rrule, branch_id, room_id, created_by
+		FROM appointment_series
+		WHERE id = $1
+		`, id).Scan(&series.ID, &series.CreatedAt, &series.UpdatedAt, &series.DoctorID, &series.PatientID,
+		&series.Start, &series.TimeZone, &series.RRule, &series.BranchID, &series.RoomID, &series.CreatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
+		FROM appointments
+		WHERE series_id = $1
+		ORDER BY occurrence, id
+		`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Appointment
+		if err := rows.Scan(&a.Id, &a.CreatedAt, &a.UpdatedAt, &a.DateTime, &a.DoctorID, &a.PatientID, &a.Status,
+			&a.SeriesID, &a.Occurrence, &a.BranchID, &a.RoomID, &a.DurationMinutes); err != nil {
+			return nil, err
+		}
+		series.Appointments = append(series.Appointments, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &series, nil
+}
+
+// seriesScopeCondition selects the appointments affected by a change to an appointment ($3),
+// occurrence $2 of series $1, with scope $4. Besides the selected appointment, "following" and
+// "all" only affect appointments which aren't cancelled and haven't started.
+const seriesScopeCondition = `(id = $3 OR $4 <> 'this' AND series_id = $1 AND ($4 = 'all' OR occurrence >= $2)
+	AND status <> 'cancelled' AND date_time > NOW())`
+
+// Change moves the appointments selected by scope relative to appointment a, which must belong
+// to a series, and/or gives them another doctor. Each appointment is checked like a new
+// occurrence; those which can't be changed keep their time and are reported in the results.
+func (m AppointmentSeriesModel) Change(a *Appointment, scope string, change OccurrenceChange) ([]*OccurrenceResult, error) {
+	series, err := m.Get(*a.SeriesID)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(series.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, err := time.Parse(time.RFC3339, a.DateTime)
+	if err != nil {
+		return nil, err
+	}
+	selected = selected.In(loc)
+
+	// The new start of an appointment is its date moved by days, at the clock time of target.
+	days := 0
+	target := selected
+	if change.DateTime != nil {
+		target = change.DateTime.In(loc)
+		days = civilDays(target) - civilDays(selected)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, date_time, doctor_id, patient_id, status, occurrence, branch_id, room_id, duration_minutes
+		FROM appointments
+		WHERE `+seriesScopeCondition+`
+		FOR UPDATE
+		`, series.ID, a.Occurrence, a.Id, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	type occurrence struct {
+		id         string
+		start      time.Time
+		doctorID   int
+		patientID  int64
+		status     string
+		occurrence int
+		branchID   *int64
+		roomID     *int64
+		minutes    int
+	}
+	var occurrences []occurrence
+	for rows.Next() {
+		var o occurrence
+		var number *int
+		if err := rows.Scan(&o.id, &o.start, &o.doctorID, &o.patientID, &o.status, &number, &o.branchID,
+			&o.roomID, &o.minutes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if number != nil {
+			o.occurrence = *number
+		}
+		occurrences = append(occurrences, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Moving later, the last appointment is moved first, so that appointments of the series
+	// don't clash with the ones they are moving into.
+	later := target.After(selected)
+	sort.Slice(occurrences, func(i, j int) bool {
+		if later {
+			return occurrences[i].start.After(occurrences[j].start)
+		}
+		return occurrences[i].start.Before(occurrences[j].start)
+	})
+
+	results := make([]*OccurrenceResult, 0, len(occurrences))
+	for _, o := range occurrences {
+		start := o.start.In(loc)
+		newStart := time.Date(start.Year(), start.Month(), start.Day()+days, target.Hour(), target.Minute(),
+			target.Second(), 0, loc)
+		doctorID := o.doctorID
+		if change.DoctorID != nil {
+			doctorID = *change.DoctorID
+		}
+
+		result := &OccurrenceResult{Occurrence: o.occurrence, DateTime: newStart}
+		result.AppointmentID, _ = strconv.ParseInt(o.id, 10, 64)
+		results = append(results, result)
+
+		// A cancelled appointment, which is only changed if it was selected, doesn't hold its slot.
+		a := &Appointment{Id: o.id, DateTime: newStart.Format(time.RFC3339), DoctorID: doctorID,
+			PatientID: int(o.patientID), Status: o.status, BranchID: o.branchID, RoomID: o.roomID,
+			DurationMinutes: o.minutes}
+		if o.status != AppointmentCancelled {
+			if err := checkOccurrence(ctx, tx, a); err != nil {
+				if isOccurrenceConflict(err) || errors.Is(err, ErrBranchMismatch) {
+					result.Error = err.Error()
+					continue
+				}
+				return nil, err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE appointments
+			SET date_time = $2, doctor_id = $3, branch_id = $4, updated_at = NOW()
+			WHERE id = $1
+			`, o.id, newStart, doctorID, a.BranchID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if scope == SeriesScopeAll && change.DoctorID != nil {
+		_, err = tx.ExecContext(ctx, `UPDATE appointment_series SET doctor_id = $2, updated_at = NOW() WHERE id = $1`,
+			series.ID, *change.DoctorID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Occurrence < results[j].Occurrence })
+	return results, tx.Commit()
+}
+
+// Cancel cancels the appointments selected by scope relative to appointment a, which must
+// belong to a series, and returns the ones which were cancelled.
+func (m AppointmentSeriesModel) Cancel(a *Appointment, scope string) ([]*Appointment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		UPDATE appointments
+		SET status = 'cancelled', updated_at = NOW()
+		WHERE `+seriesScopeCondition+` AND status <> 'cancelled'
+		RETURNING id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
+		`, *a.SeriesID, a.Occurrence, a.Id, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cancelled := []*Appointment{}
+	for rows.Next() {
+		var c Appointment
+		if err := rows.Scan(&c.Id, &c.CreatedAt, &c.UpdatedAt, &c.DateTime, &c.DoctorID, &c.PatientID, &c.Status,
+			&c.SeriesID, &c.Occurrence, &c.BranchID, &c.RoomID, &c.DurationMinutes); err != nil {
+			return nil, err
+		}
+		cancelled = append(cancelled, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(cancelled, func(i, j int) bool { return cancelled[i].DateTime < cancelled[j].DateTime })
+	return cancelled, nil
+}
+
+// civilDays numbers the calendar day of t in its location.
+func civilDays(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
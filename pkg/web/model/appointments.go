@@ -16,6 +16,75 @@ type Appointment struct {
 	DateTime  string `json:"date_time"`
 	DoctorID  int    `json:"doctor_id"`
 	PatientID int    `json:"patient_id"`
+	Status    string `json:"status"`
+	// SeriesID and Occurrence are set for the appointments of a recurring series.
+	SeriesID   *int64 `json:"series_id,omitempty"`
+	Occurrence *int   `json:"occurrence,omitempty"`
+	// BranchID is where the appointment takes place and RoomID the room there, if any.
+	BranchID *int64 `json:"branch_id,omitempty"`
+	RoomID   *int64 `json:"room_id,omitempty"`
+	// DurationMinutes is the sum of the durations of the appointment's services, or
+	// DefaultAppointmentDuration if it has none.
+	DurationMinutes int `json:"duration_minutes"`
+}
+
+// Appointment statuses.
+const (
+	AppointmentScheduled = "scheduled"
+	AppointmentConfirmed = "confirmed"
+	AppointmentCancelled = "cancelled"
+)
+
+// DefaultAppointmentDuration is how long an appointment is assumed to last.
+const DefaultAppointmentDuration = 30 * time.Minute
+
+// Duration returns how long the appointment lasts.
+func (a *Appointment) Duration() time.Duration {
+	if a.DurationMinutes <= 0 {
+		return DefaultAppointmentDuration
+	}
+	return time.Duration(a.DurationMinutes) * time.Minute
+}
+
+// ErrSlotTaken is returned when a doctor already has an appointment at the requested time.
+var ErrSlotTaken = errors.New("doctor already has an appointment at this time")
+
+// ErrDoctorUnavailable is returned when the clinic is closed or the doctor is on leave at the
+// requested time.
+var ErrDoctorUnavailable = errors.New("the clinic is closed or the doctor is on leave at this time")
+
+// ErrRoomTaken is returned when the room of an appointment is already booked at the requested
+// time.
+var ErrRoomTaken = errors.New("room is already booked at this time")
+
+// ErrBranchMismatch is returned when the branch or room of an appointment doesn't exist, the
+// room isn't in the branch or the doctor doesn't work there.
+var ErrBranchMismatch = errors.New("room or branch doesn't exist, or the doctor doesn't work there")
+
+// slotLockNamespace is the first key of the advisory locks which serialize the bookings of a
+// doctor; the second key is the doctor's ID. roomLockNamespace does the same for rooms.
+const (
+	slotLockNamespace = 1
+	roomLockNamespace = 3
+)
+
+// appointmentTimeLayouts are the formats accepted for date_time. Times without an offset are
+// interpreted by the database in its time zone.
+var appointmentTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// ValidAppointmentTime reports whether s is a date_time the database will accept.
+func ValidAppointmentTime(s string) bool {
+	for _, layout := range appointmentTimeLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
 }
 
 type AppointmentModel struct {
@@ -24,23 +93,194 @@ type AppointmentModel struct {
 	ErrorLog *log.Logger
 }
 
-func (m AppointmentModel) Insert(appointment *Appointment) error {
+// Insert books an appointment. With serviceIDs its duration is the sum of the services'
+// durations and their prices are recorded with it, otherwise it lasts
+// DefaultAppointmentDuration.
+func (m AppointmentModel) Insert(appointment *Appointment, serviceIDs ...int64) error {
 	// Insert a new appointment into the database.
 	query := `
-		INSERT INTO appointments (date_time, doctor_id, patient_id) 
-		VALUES ($1, $2, $3) 
-		RETURNING id, created_at, updated_at
+		INSERT INTO appointments (date_time, doctor_id, patient_id, branch_id, room_id, duration_minutes) 
+		VALUES ($1, $2, $3, $4, $5, $6) 
+		RETURNING id, created_at, updated_at, date_time, status
 		`
-	args := []interface{}{appointment.DateTime, appointment.DoctorID, appointment.PatientID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	appointment.DurationMinutes = int(DefaultAppointmentDuration / time.Minute)
+	if len(serviceIDs) > 0 {
+		if appointment.DurationMinutes, err = servicesDuration(ctx, tx, serviceIDs); err != nil {
+			return err
+		}
+	}
+
+	if err := lockDoctorSlot(ctx, tx, appointment.DoctorID, appointment.DateTime, "0", 0, appointment.DurationMinutes); err != nil {
+		return err
+	}
+	if err := placeAppointment(ctx, tx, appointment); err != nil {
+		return err
+	}
+
+	args := []interface{}{appointment.DateTime, appointment.DoctorID, appointment.PatientID, appointment.BranchID,
+		appointment.RoomID, appointment.DurationMinutes}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.Status)
+	if err != nil {
+		return err
+	}
+
+	if err := insertAppointmentServices(ctx, tx, appointment, serviceIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// placeAppointment checks the branch and room of an appointment which is about to be saved in
+// tx. The branch defaults to the room's branch or, if the doctor works at only one branch, to
+// that one. Unless the appointment is cancelled, the room's bookings stay locked for the rest
+// of tx and ErrRoomTaken is returned if another appointment has the room at the same time.
+func placeAppointment(ctx context.Context, tx *sql.Tx, a *Appointment) error {
+	if a.RoomID != nil {
+		var branchID int64
+		err := tx.QueryRowContext(ctx, `SELECT branch_id FROM rooms WHERE id = $1`, *a.RoomID).Scan(&branchID)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrBranchMismatch
+			default:
+				return err
+			}
+		}
+		if a.BranchID == nil {
+			a.BranchID = &branchID
+		}
+		if *a.BranchID != branchID {
+			return ErrBranchMismatch
+		}
+	}
+
+	if a.BranchID == nil {
+		var branchID *int64
+		err := tx.QueryRowContext(ctx, `
+			SELECT min(branch_id) FROM doctor_branches WHERE doctor_id = $1 HAVING count(*) = 1
+			`, a.DoctorID).Scan(&branchID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		a.BranchID = branchID
+	} else {
+		var works bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM doctor_branches WHERE doctor_id = $1 AND branch_id = $2)
+			`, a.DoctorID, *a.BranchID).Scan(&works)
+		if err != nil {
+			return err
+		}
+		if !works {
+			return ErrBranchMismatch
+		}
+	}
+
+	if a.RoomID == nil || a.Status == AppointmentCancelled {
+		return nil
+	}
+	excludeID := a.Id
+	if excludeID == "" {
+		excludeID = "0"
+	}
+	return lockRoomSlot(ctx, tx, *a.RoomID, a.DateTime, excludeID, int(a.Duration()/time.Minute))
+}
+
+// lockRoomSlot serializes bookings of a room for the rest of tx and returns ErrRoomTaken if an
+// appointment other than excludeID, which isn't cancelled, has the room during the minutes
+// from dateTime.
+func lockRoomSlot(ctx context.Context, tx *sql.Tx, roomID int64, dateTime, excludeID string, minutes int) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, roomLockNamespace, roomID); err != nil {
+		return err
+	}
+
+	var taken bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM appointments
+			WHERE room_id = $1 AND id <> $3 AND status <> 'cancelled'
+				AND date_time < $2::timestamptz + make_interval(mins => $4)
+				AND date_time + make_interval(mins => duration_minutes) > $2::timestamptz
+		)
+		`, roomID, dateTime, excludeID, minutes).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrRoomTaken
+	}
+	return nil
+}
+
+// lockDoctorSlot serializes bookings of a doctor for the rest of tx and returns ErrSlotTaken if
+// an appointment other than excludeID, which isn't cancelled, or a slot held by a pending
+// waitlist offer other than offerID overlaps the one lasting minutes from dateTime, or
+// ErrDoctorUnavailable if a closure does. Offers are created under the same lock, so a held
+// slot can only be booked by accepting its offer.
+func lockDoctorSlot(ctx context.Context, tx *sql.Tx, doctorID int, dateTime, excludeID string, offerID int64, minutes int) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, slotLockNamespace, doctorID); err != nil {
+		return err
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM appointments
+			WHERE doctor_id = $1 AND id <> $3 AND status <> 'cancelled'
+				AND date_time < $2::timestamptz + make_interval(mins => $4)
+				AND date_time + make_interval(mins => duration_minutes) > $2::timestamptz
+		) OR EXISTS (
+			SELECT 1
+			FROM waitlist_offers
+			WHERE doctor_id = $1 AND id <> $5 AND status = 'pending' AND expires_at > NOW()
+				AND date_time < $2::timestamptz + make_interval(mins => $4)
+				AND date_time + make_interval(mins => $6) > $2::timestamptz
+		)
+		`
+
+	var taken bool
+	err := tx.QueryRowContext(ctx, query, doctorID, dateTime, excludeID, minutes, offerID,
+		int(DefaultAppointmentDuration/time.Minute)).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSlotTaken
+	}
+
+	var closed bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM closures
+			WHERE (doctor_id IS NULL OR doctor_id = $1)
+				AND starts_at < $2::timestamptz + make_interval(mins => $3)
+				AND ends_at > $2::timestamptz
+		)
+		`, doctorID, dateTime, minutes).Scan(&closed)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return ErrDoctorUnavailable
+	}
+	return nil
 }
 
 func (m AppointmentModel) Get(id int) (*Appointment, error) {
 	query := `
-        SELECT id, created_at, updated_at, doctor_id, patient_id, date_time
+        SELECT id, created_at, updated_at, doctor_id, patient_id, date_time, status, series_id, occurrence, branch_id, room_id, duration_minutes
         FROM appointments
         WHERE id = $1
     `
@@ -49,10 +289,10 @@ func (m AppointmentModel) Get(id int) (*Appointment, error) {
 	defer cancel()
 
 	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DoctorID, &appointment.PatientID, &appointment.DateTime)
+	err := row.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DoctorID, &appointment.PatientID, &appointment.DateTime, &appointment.Status, &appointment.SeriesID, &appointment.Occurrence, &appointment.BranchID, &appointment.RoomID, &appointment.DurationMinutes)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("appointment not found for ID %d", id)
+			return nil, ErrRecordNotFound
 		}
 		return nil, err
 	}
@@ -63,15 +303,38 @@ func (m AppointmentModel) Update(appointment *Appointment) error {
 	// Update a specific appointment in the database.
 	query := `
 		UPDATE appointments
-		SET date_time = $1, doctor_id = $2, patient_id = $3
+		SET date_time = $1, doctor_id = $2, patient_id = $3, branch_id = $5, room_id = $6, updated_at = NOW()
 		WHERE id = $4
-		RETURNING updated_at
+		RETURNING updated_at, date_time
 		`
-	args := []interface{}{appointment.DateTime, appointment.DoctorID, appointment.PatientID, appointment.Id}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&appointment.UpdatedAt)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// A cancelled appointment doesn't hold its slot, so it can be moved anywhere.
+	if appointment.Status != AppointmentCancelled {
+		if err := lockDoctorSlot(ctx, tx, appointment.DoctorID, appointment.DateTime, appointment.Id, 0,
+			int(appointment.Duration()/time.Minute)); err != nil {
+			return err
+		}
+	}
+	if err := placeAppointment(ctx, tx, appointment); err != nil {
+		return err
+	}
+
+	args := []interface{}{appointment.DateTime, appointment.DoctorID, appointment.PatientID, appointment.Id,
+		appointment.BranchID, appointment.RoomID}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&appointment.UpdatedAt, &appointment.DateTime)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m AppointmentModel) Delete(id int) error {
@@ -87,11 +350,38 @@ func (m AppointmentModel) Delete(id int) error {
 	return err
 }
 
+// Cancel marks an appointment as cancelled. Cancelled appointments are kept for the record.
+func (m AppointmentModel) Cancel(id int) error {
+	query := `
+		UPDATE appointments
+		SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 func (m AppointmentModel) GetAllSortedByName(filters Filters) ([]*Appointment, error) {
 	query := fmt.Sprintf(
 		`
-       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id
+       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
        FROM appointments
+       WHERE $1 = 0 OR branch_id = $1
        ORDER BY %s %s`,
 		filters.sortColumn(),
 		filters.sortDirection(),
@@ -99,7 +389,7 @@ func (m AppointmentModel) GetAllSortedByName(filters Filters) ([]*Appointment, e
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, filters.BranchID)
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +398,7 @@ func (m AppointmentModel) GetAllSortedByName(filters Filters) ([]*Appointment, e
 	var appointments []*Appointment
 	for rows.Next() {
 		var appointment Appointment
-		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID); err != nil {
+		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID, &appointment.Status, &appointment.SeriesID, &appointment.Occurrence, &appointment.BranchID, &appointment.RoomID, &appointment.DurationMinutes); err != nil {
 			return nil, err
 		}
 		appointments = append(appointments, &appointment)
@@ -120,18 +410,21 @@ func (m AppointmentModel) GetAllSortedByName(filters Filters) ([]*Appointment, e
 	return appointments, nil
 }
 
-func (m AppointmentModel) GetFilteredByText(filterText string) ([]*Appointment, error) {
+// GetFilteredByText returns the appointments whose time contains filterText. A non-zero
+// branchID limits them to the appointments at the branch.
+func (m AppointmentModel) GetFilteredByText(filterText string, branchID int) ([]*Appointment, error) {
 	query := `
-       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id
+       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
        FROM appointments
-       WHERE date_time LIKE '%' || $1 || '%' OR date_time LIKE '%' || $1 || '%'
+       WHERE date_time::text LIKE '%' || $1 || '%'
+           AND ($2 = 0 OR branch_id = $2)
        ORDER BY date_time
    `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, filterText)
+	rows, err := m.DB.QueryContext(ctx, query, filterText, branchID)
 	if err != nil {
 		return nil, err
 	}
@@ -140,7 +433,7 @@ func (m AppointmentModel) GetFilteredByText(filterText string) ([]*Appointment,
 	var appointments []*Appointment
 	for rows.Next() {
 		var appointment Appointment
-		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID); err != nil {
+		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID, &appointment.Status, &appointment.SeriesID, &appointment.Occurrence, &appointment.BranchID, &appointment.RoomID, &appointment.DurationMinutes); err != nil {
 			return nil, err
 		}
 		appointments = append(appointments, &appointment)
@@ -152,10 +445,13 @@ func (m AppointmentModel) GetFilteredByText(filterText string) ([]*Appointment,
 	return appointments, nil
 }
 
-func (m AppointmentModel) GetPaginatedAppointments(limit, offset int) ([]*Appointment, error) {
+// GetPaginatedAppointments returns a page of appointments ordered by ID. A non-zero branchID
+// limits them to the appointments at the branch.
+func (m AppointmentModel) GetPaginatedAppointments(limit, offset, branchID int) ([]*Appointment, error) {
 	query := `
-       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id
+       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
        FROM appointments
+       WHERE $3 = 0 OR branch_id = $3
        ORDER BY id
        LIMIT $1
        OFFSET $2
@@ -164,7 +460,7 @@ func (m AppointmentModel) GetPaginatedAppointments(limit, offset int) ([]*Appoin
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, limit, offset)
+	rows, err := m.DB.QueryContext(ctx, query, limit, offset, branchID)
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +470,7 @@ func (m AppointmentModel) GetPaginatedAppointments(limit, offset int) ([]*Appoin
 
 	for rows.Next() {
 		var appointment Appointment
-		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID); err != nil {
+		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID, &appointment.Status, &appointment.SeriesID, &appointment.Occurrence, &appointment.BranchID, &appointment.RoomID, &appointment.DurationMinutes); err != nil {
 			return nil, err
 		}
 		appointments = append(appointments, &appointment)
@@ -187,18 +483,20 @@ func (m AppointmentModel) GetPaginatedAppointments(limit, offset int) ([]*Appoin
 	return appointments, nil
 }
 
-func (m AppointmentModel) Get_By_Doctor(id int) ([]*Appointment, error) {
+// Get_By_Doctor returns the appointments of a doctor. A non-zero branchID limits them to the
+// appointments at the branch.
+func (m AppointmentModel) Get_By_Doctor(id, branchID int) ([]*Appointment, error) {
 	query := `
-       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id
+       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
        FROM appointments
-       WHERE doctor_id = $1
+       WHERE doctor_id = $1 AND ($2 = 0 OR branch_id = $2)
        ORDER BY date_time
    `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	rows, err := m.DB.QueryContext(ctx, query, id, branchID)
 	if err != nil {
 		return nil, err
 	}
@@ -207,7 +505,7 @@ func (m AppointmentModel) Get_By_Doctor(id int) ([]*Appointment, error) {
 	var appointments []*Appointment
 	for rows.Next() {
 		var appointment Appointment
-		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID); err != nil {
+		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID, &appointment.Status, &appointment.SeriesID, &appointment.Occurrence, &appointment.BranchID, &appointment.RoomID, &appointment.DurationMinutes); err != nil {
 			return nil, err
 		}
 		appointments = append(appointments, &appointment)
@@ -219,18 +517,20 @@ func (m AppointmentModel) Get_By_Doctor(id int) ([]*Appointment, error) {
 	return appointments, nil
 }
 
-func (m AppointmentModel) Get_By_Patient(id int) ([]*Appointment, error) {
+// Get_By_Patient returns the appointments of a patient. A non-zero branchID limits them to the
+// appointments at the branch.
+func (m AppointmentModel) Get_By_Patient(id, branchID int) ([]*Appointment, error) {
 	query := `
-       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id
+       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
        FROM appointments
-       WHERE patient_id = $1
+       WHERE patient_id = $1 AND ($2 = 0 OR branch_id = $2)
        ORDER BY date_time
    `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	rows, err := m.DB.QueryContext(ctx, query, id, branchID)
 	if err != nil {
 		return nil, err
 	}
@@ -239,7 +539,67 @@ func (m AppointmentModel) Get_By_Patient(id int) ([]*Appointment, error) {
 	var appointments []*Appointment
 	for rows.Next() {
 		var appointment Appointment
-		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID); err != nil {
+		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID, &appointment.Status, &appointment.SeriesID, &appointment.Occurrence, &appointment.BranchID, &appointment.RoomID, &appointment.DurationMinutes); err != nil {
+			return nil, err
+		}
+		appointments = append(appointments, &appointment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return appointments, nil
+}
+
+// AppointmentFilter narrows down an appointment search. Zero values match everything.
+type AppointmentFilter struct {
+	ID        int
+	PatientID int
+	DoctorID  int
+	BranchID  int
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Offset    int
+}
+
+// Search returns the appointments matching the filter, ordered by time.
+func (m AppointmentModel) Search(f AppointmentFilter) ([]*Appointment, error) {
+	query := `
+       SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
+       FROM appointments
+       WHERE ($1 = 0 OR id = $1)
+           AND ($2 = 0 OR patient_id = $2)
+           AND ($3 = 0 OR doctor_id = $3)
+           AND ($4::timestamptz IS NULL OR date_time >= $4)
+           AND ($5::timestamptz IS NULL OR date_time < $5)
+           AND ($8 = 0 OR branch_id = $8)
+       ORDER BY date_time, id
+       LIMIT $6
+       OFFSET $7
+   `
+
+	nullTime := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.ID, f.PatientID, f.DoctorID, nullTime(f.From), nullTime(f.To), f.Limit, f.Offset,
+		f.BranchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appointments := []*Appointment{}
+	for rows.Next() {
+		var appointment Appointment
+		if err := rows.Scan(&appointment.Id, &appointment.CreatedAt, &appointment.UpdatedAt, &appointment.DateTime, &appointment.DoctorID, &appointment.PatientID, &appointment.Status, &appointment.SeriesID, &appointment.Occurrence, &appointment.BranchID, &appointment.RoomID, &appointment.DurationMinutes); err != nil {
 			return nil, err
 		}
 		appointments = append(appointments, &appointment)
@@ -0,0 +1,377 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// ErrBranchInUse is returned when a branch which still has appointments is deleted.
+var ErrBranchInUse = errors.New("branch still has appointments")
+
+// ErrDuplicateName is returned when a branch or a room of a branch is given the name of
+// another one.
+var ErrDuplicateName = errors.New("name is already taken")
+
+// Branch is a location of the clinic. Its opening hours are wall clock times in TimeZone.
+type Branch struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Name         string    `json:"name"`
+	Address      string    `json:"address"`
+	TimeZone     string    `json:"time_zone"`
+	OpeningHours string    `json:"opening_hours"`
+	Rooms        []*Room   `json:"rooms,omitempty"`
+}
+
+// Room is a room or another resource of a branch which only one appointment can use at a
+// time. Kind says what it is, e.g. "room" or "ultrasound".
+type Room struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	BranchID  int64     `json:"branch_id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+}
+
+// Location returns the time zone of the branch.
+func (b *Branch) Location() (*time.Location, error) {
+	return time.LoadLocation(b.TimeZone)
+}
+
+// Hours returns the parsed opening hours of the branch.
+func (b *Branch) Hours() (OpeningHours, error) {
+	return ParseOpeningHours(b.OpeningHours)
+}
+
+type BranchModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateBranch(v *validator.Validator, b *Branch) {
+	v.Check(b.Name != "", "name", "must be provided")
+	v.Check(len(b.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(b.Address) <= 500, "address", "must not be more than 500 bytes long")
+	if _, err := b.Location(); err != nil || b.TimeZone == "" {
+		v.AddError("time_zone", "must be an IANA time zone such as Europe/Berlin")
+	}
+	if _, err := b.Hours(); err != nil {
+		v.AddError("opening_hours", "must be like Mon-Fri 08:00-18:00")
+	}
+}
+
+func ValidateRoom(v *validator.Validator, r *Room) {
+	v.Check(r.Name != "", "name", "must be provided")
+	v.Check(len(r.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(r.Kind != "", "kind", "must be provided")
+	v.Check(len(r.Kind) <= 50, "kind", "must not be more than 50 bytes long")
+}
+
+const branchColumns = `id, created_at, updated_at, name, address, time_zone, opening_hours`
+
+func scanBranch(row interface{ Scan(...interface{}) error }, b *Branch) error {
+	return row.Scan(&b.ID, &b.CreatedAt, &b.UpdatedAt, &b.Name, &b.Address, &b.TimeZone, &b.OpeningHours)
+}
+
+const roomColumns = `id, created_at, updated_at, branch_id, name, kind`
+
+func scanRoom(row interface{ Scan(...interface{}) error }, r *Room) error {
+	return row.Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.BranchID, &r.Name, &r.Kind)
+}
+
+// isUniqueViolation reports whether err is the violation of a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (m BranchModel) Insert(b *Branch) error {
+	query := `
+		INSERT INTO branches (name, address, time_zone, opening_hours)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, b.Name, b.Address, b.TimeZone, b.OpeningHours).Scan(&b.ID, &b.CreatedAt,
+		&b.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+// Get returns a branch with its rooms.
+func (m BranchModel) Get(id int64) (*Branch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b Branch
+	err := scanBranch(m.DB.QueryRowContext(ctx, `SELECT `+branchColumns+` FROM branches WHERE id = $1`, id), &b)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	b.Rooms, err = m.Rooms(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// GetAll returns the branches ordered by name. A non-zero doctorID limits them to the branches
+// the doctor works at.
+func (m BranchModel) GetAll(doctorID int64) ([]*Branch, error) {
+	query := `
+		SELECT ` + branchColumns + `
+		FROM branches
+		WHERE $1 = 0 OR id IN (SELECT branch_id FROM doctor_branches WHERE doctor_id = $1)
+		ORDER BY name, id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, doctorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	branches := []*Branch{}
+	for rows.Next() {
+		var b Branch
+		if err := scanBranch(rows, &b); err != nil {
+			return nil, err
+		}
+		branches = append(branches, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return branches, nil
+}
+
+func (m BranchModel) Update(b *Branch) error {
+	query := `
+		UPDATE branches
+		SET name = $2, address = $3, time_zone = $4, opening_hours = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, b.ID, b.Name, b.Address, b.TimeZone, b.OpeningHours).Scan(&b.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordNotFound
+	case isUniqueViolation(err):
+		return ErrDuplicateName
+	default:
+		return err
+	}
+}
+
+// Delete removes a branch with its rooms. ErrBranchInUse is returned if appointments are still
+// booked at the branch.
+func (m BranchModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM branches WHERE id = $1`, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrBranchInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Rooms returns the rooms of a branch ordered by name.
+func (m BranchModel) Rooms(branchID int64) ([]*Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+roomColumns+` FROM rooms WHERE branch_id = $1 ORDER BY name, id`,
+		branchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []*Room{}
+	for rows.Next() {
+		var r Room
+		if err := scanRoom(rows, &r); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rooms, nil
+}
+
+// InsertRoom adds a room to its branch. If the branch doesn't exist ErrRecordNotFound is
+// returned.
+func (m BranchModel) InsertRoom(r *Room) error {
+	query := `
+		INSERT INTO rooms (branch_id, name, kind)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT 1 FROM branches WHERE id = $1)
+		RETURNING id, created_at, updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, r.BranchID, r.Name, r.Kind).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordNotFound
+	case isUniqueViolation(err):
+		return ErrDuplicateName
+	default:
+		return err
+	}
+}
+
+func (m BranchModel) GetRoom(id int64) (*Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var r Room
+	err := scanRoom(m.DB.QueryRowContext(ctx, `SELECT `+roomColumns+` FROM rooms WHERE id = $1`, id), &r)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
+
+func (m BranchModel) UpdateRoom(r *Room) error {
+	query := `
+		UPDATE rooms
+		SET name = $2, kind = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, r.ID, r.Name, r.Kind).Scan(&r.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordNotFound
+	case isUniqueViolation(err):
+		return ErrDuplicateName
+	default:
+		return err
+	}
+}
+
+// DeleteRoom removes a room. The appointments booked in it keep their branch but no longer
+// have a room.
+func (m BranchModel) DeleteRoom(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM rooms WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetDoctorBranches replaces the branches a doctor works at. ErrRecordNotFound is returned if
+// the doctor or one of the branches doesn't exist.
+func (m BranchModel) SetDoctorBranches(doctorID int64, branchIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM doctors WHERE id = $1)`, doctorID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM doctor_branches WHERE doctor_id = $1`, doctorID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO doctor_branches (doctor_id, branch_id)
+		SELECT $1, id FROM branches WHERE id = ANY($2)
+		`, doctorID, pq.Array(branchIDs))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(rowsAffected) != len(branchIDs) {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// CalendarFeed is a secret URL at which a doctor's or a patient's appointments are published
+// as an iCalendar feed. Exactly one of DoctorID and PatientID is set.
+type CalendarFeed struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	DoctorID  *int64    `json:"doctor_id,omitempty"`
+	PatientID *int64    `json:"patient_id,omitempty"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	// Token is only known right after the feed has been issued.
+	Token string `json:"token,omitempty"`
+}
+
+type CalendarFeedModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Issue creates a new feed for the doctor or the patient of feed, replacing any earlier one so
+// that its URL stops working. The plaintext token is set on feed.
+func (m CalendarFeedModel) Issue(feed *CalendarFeed) error {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	feed.Token = token
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM calendar_feeds WHERE doctor_id = $1 OR patient_id = $2`,
+		feed.DoctorID, feed.PatientID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO calendar_feeds (token_hash, doctor_id, patient_id, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+		`
+
+	err = tx.QueryRowContext(ctx, query, hash, feed.DoctorID, feed.PatientID, feed.CreatedBy).Scan(
+		&feed.ID, &feed.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeForDoctor deletes the doctor's feed, or returns ErrRecordNotFound if there is none.
+func (m CalendarFeedModel) RevokeForDoctor(doctorID int64) error {
+	return m.revoke(`doctor_id = $1`, doctorID)
+}
+
+// RevokeForPatient deletes the patient's feed, or returns ErrRecordNotFound if there is none.
+func (m CalendarFeedModel) RevokeForPatient(patientID int64) error {
+	return m.revoke(`patient_id = $1`, patientID)
+}
+
+func (m CalendarFeedModel) revoke(where string, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM calendar_feeds WHERE `+where, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForToken returns the feed with the given plaintext token, or ErrRecordNotFound.
+func (m CalendarFeedModel) GetForToken(token string) (*CalendarFeed, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	query := `
+		SELECT id, created_at, doctor_id, patient_id, created_by
+		FROM calendar_feeds
+		WHERE token_hash = $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var feed CalendarFeed
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&feed.ID, &feed.CreatedAt, &feed.DoctorID, &feed.PatientID, &feed.CreatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &feed, nil
+}
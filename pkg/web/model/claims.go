@@ -0,0 +1,681 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/claims"
+	"GoClinic/pkg/web/validator"
+)
+
+// Claim statuses. Claims are submitted when their batch is generated. The payer accepts or
+// rejects them, and its remittance pays or rejects them. A rejected claim's invoice can be
+// claimed again.
+const (
+	ClaimSubmitted = "submitted"
+	ClaimAccepted  = "accepted"
+	ClaimRejected  = "rejected"
+	ClaimPaid      = "paid"
+)
+
+// claimLockNamespace is the first key of the advisory lock which serializes the numbering of
+// a tenant's claims and batches; the second key is the tenant's ID.
+const claimLockNamespace = 5
+
+// ErrNothingToClaim is returned when a batch is generated for a payer which no issued and
+// unpaid invoice can be claimed from.
+var ErrNothingToClaim = errors.New("no invoices can be claimed from this payer")
+
+// ErrPayerInactive is returned when claims are generated for a deactivated payer.
+var ErrPayerInactive = errors.New("payer has been deactivated")
+
+// ErrClaimStatus is returned when a claim is moved to a status it can't have next.
+var ErrClaimStatus = errors.New("claim can't change to this status")
+
+// ErrDuplicateRemittance is returned when a payer's remittance is recorded twice.
+var ErrDuplicateRemittance = errors.New("remittance with this reference has already been recorded for the payer")
+
+// ClaimBatch is a set of claims generated for a payer at once and sent in one file.
+type ClaimBatch struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Number    string    `json:"number"`
+	PayerID   int64     `json:"payer_id"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	Claims    []*Claim  `json:"claims,omitempty"`
+}
+
+// Claim asks a payer for its part of an invoice. Total is what the invoice came to after credit
+// notes, Copay the patient's part and Claimed the payer's, in minor units of Currency.
+type Claim struct {
+	ID              int64     `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Number          string    `json:"number"`
+	BatchID         int64     `json:"batch_id"`
+	PayerID         int64     `json:"payer_id"`
+	PolicyID        int64     `json:"policy_id"`
+	InvoiceID       int64     `json:"invoice_id"`
+	Status          string    `json:"status"`
+	ServiceDate     string    `json:"service_date"`
+	Currency        string    `json:"currency"`
+	Total           int64     `json:"total"`
+	Copay           int64     `json:"copay"`
+	Claimed         int64     `json:"claimed"`
+	Paid            int64     `json:"paid"`
+	RejectionReason string    `json:"rejection_reason,omitempty"`
+}
+
+// Remittance is what a payer paid for claims. Total is the sum of the items and Applied the
+// part of it which was applied to claims.
+type Remittance struct {
+	ID         int64             `json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	PayerID    int64             `json:"payer_id"`
+	Reference  string            `json:"reference"`
+	ReceivedAt time.Time         `json:"received_at"`
+	Total      int64             `json:"total"`
+	Applied    int64             `json:"applied"`
+	CreatedBy  *int64            `json:"created_by,omitempty"`
+	Items      []*RemittanceItem `json:"items,omitempty"`
+}
+
+// RemittanceItem is what a payer paid for a claim; nothing means it rejected the claim, for
+// Reason. Error says why an item couldn't be applied to the claim; such items are left for
+// staff to follow up.
+type RemittanceItem struct {
+	ID          int64  `json:"id"`
+	ClaimNumber string `json:"claim_number"`
+	ClaimID     *int64 `json:"claim_id,omitempty"`
+	Paid        int64  `json:"paid"`
+	Reason      string `json:"reason,omitempty"`
+	PaymentID   *int64 `json:"payment_id,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type ClaimModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateRemittance(v *validator.Validator, r *Remittance) {
+	v.Check(r.Reference != "", "reference", "must be provided")
+	v.Check(len(r.Reference) <= 200, "reference", "must not be more than 200 bytes long")
+	v.Check(!r.ReceivedAt.After(time.Now().Add(time.Minute)), "received_at", "must not be in the future")
+	v.Check(len(r.Items) > 0, "items", "must contain at least one item")
+	v.Check(len(r.Items) <= 1000, "items", "must not contain more than 1000 items")
+	for i, item := range r.Items {
+		prefix := fmt.Sprintf("items[%d].", i)
+		v.Check(item.ClaimNumber != "", prefix+"claim_number", "must be provided")
+		v.Check(len(item.ClaimNumber) <= 100, prefix+"claim_number", "must not be more than 100 bytes long")
+		v.Check(item.Paid >= 0, prefix+"paid", "must not be negative")
+		v.Check(len(item.Reason) <= 500, prefix+"reason", "must not be more than 500 bytes long")
+	}
+}
+
+const claimColumns = `
+	id, created_at, updated_at, number, batch_id, payer_id, policy_id, invoice_id, status,
+	to_char(service_date, 'YYYY-MM-DD'), currency, total, copay, claimed, paid, rejection_reason`
+
+func scanClaim(row interface{ Scan(...interface{}) error }, c *Claim) error {
+	return row.Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt, &c.Number, &c.BatchID, &c.PayerID, &c.PolicyID, &c.InvoiceID,
+		&c.Status, &c.ServiceDate, &c.Currency, &c.Total, &c.Copay, &c.Claimed, &c.Paid, &c.RejectionReason)
+}
+
+// GenerateBatch claims every invoice issued before until which isn't paid yet, isn't claimed
+// yet and whose patient had a policy with the payer on the day of the service. The day is
+// that of the appointment, or of the invoice, in the branch's time zone.
+func (m ClaimModel) GenerateBatch(payerID int64, until time.Time, createdBy *int64) (*ClaimBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var currency string
+	var active bool
+	err = tx.QueryRowContext(ctx, `SELECT currency, active FROM payers WHERE id = $1`, payerID).Scan(&currency, &active)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if !active {
+		return nil, ErrPayerInactive
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT i.id, i.total - COALESCE(cn.total, 0), i.total - i.settled, d.service_date,
+			p.id, p.copay_amount, p.copay_rate
+		FROM invoices i
+			LEFT JOIN appointments a ON a.id = i.appointment_id
+			LEFT JOIN branches b ON b.id = i.branch_id
+			CROSS JOIN LATERAL (
+				SELECT (COALESCE(a.date_time, i.issued_at) AT TIME ZONE COALESCE(b.time_zone, current_setting('TimeZone')))::date
+					AS service_date
+			) d
+			JOIN LATERAL (
+				SELECT id, copay_amount, copay_rate
+				FROM insurance_policies
+				WHERE patient_id = i.patient_id AND payer_id = $1
+					AND coverage_from <= d.service_date AND (coverage_to IS NULL OR coverage_to >= d.service_date)
+				LIMIT 1
+			) p ON true
+			LEFT JOIN LATERAL (
+				SELECT sum(total) AS total FROM invoices WHERE credited_invoice_id = i.id
+			) cn ON true
+		WHERE i.kind = 'invoice' AND i.status IN ('issued', 'partially_paid') AND i.currency = $2 AND i.issued_at < $3
+			AND NOT EXISTS (SELECT 1 FROM claims c WHERE c.invoice_id = i.id AND c.status <> 'rejected')
+		ORDER BY i.issued_at, i.id
+		FOR UPDATE OF i
+		`, payerID, currency, until)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*Claim
+	for rows.Next() {
+		var balance, copayAmount int64
+		var copayRate int
+		var serviceDate time.Time
+		c := &Claim{PayerID: payerID, Currency: currency, Status: ClaimSubmitted}
+		if err := rows.Scan(&c.InvoiceID, &c.Total, &balance, &serviceDate, &c.PolicyID, &copayAmount, &copayRate); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.ServiceDate = serviceDate.Format("2006-01-02")
+
+		policy := InsurancePolicy{CopayAmount: copayAmount, CopayRate: copayRate}
+		c.Copay = policy.Copay(c.Total)
+		c.Claimed = min(c.Total-c.Copay, balance)
+		if c.Claimed > 0 {
+			pending = append(pending, c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, ErrNothingToClaim
+	}
+
+	year := time.Now().Year()
+	batch := &ClaimBatch{PayerID: payerID, CreatedBy: createdBy, Claims: pending}
+	batch.Number, err = nextNumber(ctx, tx, claimLockNamespace, "claim_batches", fmt.Sprintf("CB-%d-", year))
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO claim_batches (number, payer_id, created_by)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+		`, batch.Number, payerID, createdBy).Scan(&batch.ID, &batch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range pending {
+		c.BatchID = batch.ID
+		c.Number, err = nextNumber(ctx, tx, claimLockNamespace, "claims", fmt.Sprintf("CLM-%d-", year))
+		if err != nil {
+			return nil, err
+		}
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO claims (number, batch_id, payer_id, policy_id, invoice_id, status, service_date, currency, total,
+				copay, claimed)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id, created_at, updated_at
+			`, c.Number, c.BatchID, c.PayerID, c.PolicyID, c.InvoiceID, c.Status, c.ServiceDate, c.Currency, c.Total,
+			c.Copay, c.Claimed).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return batch, tx.Commit()
+}
+
+// GetBatch returns a batch with its claims.
+func (m ClaimModel) GetBatch(id int64) (*ClaimBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b ClaimBatch
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT id, created_at, number, payer_id, created_by FROM claim_batches WHERE id = $1
+		`, id).Scan(&b.ID, &b.CreatedAt, &b.Number, &b.PayerID, &b.CreatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	b.Claims, err = m.GetAll(ClaimFilter{BatchID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// GetAllBatches returns the batches of a payer, or of all payers, newest first.
+func (m ClaimModel) GetAllBatches(payerID int64) ([]*ClaimBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT id, created_at, number, payer_id, created_by
+		FROM claim_batches
+		WHERE $1 = 0 OR payer_id = $1
+		ORDER BY created_at DESC, id DESC
+		`, payerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := []*ClaimBatch{}
+	for rows.Next() {
+		var b ClaimBatch
+		if err := rows.Scan(&b.ID, &b.CreatedAt, &b.Number, &b.PayerID, &b.CreatedBy); err != nil {
+			return nil, err
+		}
+		batches = append(batches, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return batches, nil
+}
+
+// BatchFile returns a batch as it is written to the payer's file, with the format of the file.
+func (m ClaimModel) BatchFile(id int64) (*claims.Batch, *claims.Format, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var payer Payer
+	var format []byte
+	b := &claims.Batch{}
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT b.number, b.created_at, p.code, p.name, p.currency, p.batch_format
+		FROM claim_batches b
+			JOIN payers p ON p.id = b.payer_id
+		WHERE b.id = $1
+		`, id).Scan(&b.Number, &b.CreatedAt, &b.PayerCode, &b.PayerName, &b.Currency, &format)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	if format != nil {
+		if err := json.Unmarshal(format, &payer.BatchFormat); err != nil {
+			return nil, nil, err
+		}
+	}
+	b.Digits = CurrencyDigits(b.Currency)
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT c.id, c.number, i.number, c.service_date, i.patient_id, pt.first_name, pt.last_name, po.member_number,
+			po.group_number, c.total, c.copay, c.claimed
+		FROM claims c
+			JOIN invoices i ON i.id = c.invoice_id
+			JOIN patients pt ON pt.id = i.patient_id
+			JOIN insurance_policies po ON po.id = c.policy_id
+		WHERE c.batch_id = $1
+		ORDER BY c.number
+		`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*claims.Claim)
+	for rows.Next() {
+		var claimID int64
+		c := &claims.Claim{}
+		err := rows.Scan(&claimID, &c.Number, &c.InvoiceNumber, &c.ServiceDate, &c.PatientID, &c.FirstName,
+			&c.LastName, &c.MemberNumber, &c.GroupNumber, &c.Total, &c.Copay, &c.Claimed)
+		if err != nil {
+			return nil, nil, err
+		}
+		b.Claims = append(b.Claims, c)
+		byID[claimID] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	lines, err := m.DB.QueryContext(ctx, `
+		SELECT c.id, l.position, COALESCE(s.code, ''), l.description, l.quantity, l.unit_price, l.total
+		FROM claims c
+			JOIN invoice_lines l ON l.invoice_id = c.invoice_id
+			LEFT JOIN services s ON s.id = l.service_id
+		WHERE c.batch_id = $1
+		ORDER BY c.id, l.position
+		`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lines.Close()
+
+	for lines.Next() {
+		var claimID int64
+		l := &claims.Line{}
+		if err := lines.Scan(&claimID, &l.Position, &l.Code, &l.Description, &l.Quantity, &l.UnitPrice, &l.Total); err != nil {
+			return nil, nil, err
+		}
+		if c := byID[claimID]; c != nil {
+			c.Lines = append(c.Lines, l)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return b, payer.Format(), nil
+}
+
+// Get returns a claim.
+func (m ClaimModel) Get(id int64) (*Claim, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var c Claim
+	err := scanClaim(m.DB.QueryRowContext(ctx, `SELECT `+claimColumns+` FROM claims WHERE id = $1`, id), &c)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// ClaimFilter narrows down a list of claims. Zero values match everything.
+type ClaimFilter struct {
+	PayerID   int64
+	BatchID   int64
+	InvoiceID int64
+	Status    string
+	Limit     int
+	Offset    int
+}
+
+// GetAll returns the claims matching the filter, newest first.
+func (m ClaimModel) GetAll(f ClaimFilter) ([]*Claim, error) {
+	query := `
+		SELECT ` + claimColumns + `
+		FROM claims
+		WHERE ($1 = 0 OR payer_id = $1)
+			AND ($2 = 0 OR batch_id = $2)
+			AND ($3 = 0 OR invoice_id = $3)
+			AND ($4 = '' OR status = $4)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5 OFFSET $6
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var limit interface{}
+	if f.Limit > 0 {
+		limit = f.Limit
+	}
+	rows, err := m.DB.QueryContext(ctx, query, f.PayerID, f.BatchID, f.InvoiceID, f.Status, limit, f.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*Claim{}
+	for rows.Next() {
+		var c Claim
+		if err := scanClaim(rows, &c); err != nil {
+			return nil, err
+		}
+		list = append(list, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// UpdateStatus records that the payer accepted or rejected a claim. Claims are paid by
+// remittances.
+func (m ClaimModel) UpdateStatus(id int64, status, reason string) (*Claim, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var c Claim
+	err = scanClaim(tx.QueryRowContext(ctx, `SELECT `+claimColumns+` FROM claims WHERE id = $1 FOR UPDATE`, id), &c)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	switch {
+	case status == ClaimAccepted && c.Status == ClaimSubmitted:
+	case status == ClaimRejected && (c.Status == ClaimSubmitted || c.Status == ClaimAccepted):
+	default:
+		return nil, ErrClaimStatus
+	}
+
+	err = scanClaim(tx.QueryRowContext(ctx, `
+		UPDATE claims
+		SET status = $2, rejection_reason = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+claimColumns, id, status, reason), &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, tx.Commit()
+}
+
+// InsertRemittance records a payer's remittance and reconciles it: every item is matched to
+// the payer's claim with its number, and either pays it, which records a payment of the
+// claim's invoice, or rejects it. Items which can't be applied are kept with an Error.
+func (m ClaimModel) InsertRemittance(r *Remittance) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r.Total = 0
+	for _, item := range r.Items {
+		r.Total += item.Paid
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO remittances (payer_id, reference, received_at, total, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+		`, r.PayerID, r.Reference, r.ReceivedAt, r.Total, r.CreatedBy).Scan(&r.ID, &r.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateRemittance
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	r.Applied = 0
+	for _, item := range r.Items {
+		if err := applyRemittanceItem(ctx, tx, r, item); err != nil {
+			return err
+		}
+		if item.Error == "" {
+			r.Applied += item.Paid
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO remittance_items (remittance_id, claim_number, claim_id, paid, reason, payment_id, error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+			`, r.ID, item.ClaimNumber, item.ClaimID, item.Paid, item.Reason, item.PaymentID, item.Error).Scan(&item.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// applyRemittanceItem pays or rejects the claim of item. If that isn't possible, it says why
+// in item.Error.
+func applyRemittanceItem(ctx context.Context, tx *sql.Tx, r *Remittance, item *RemittanceItem) error {
+	var c Claim
+	err := scanClaim(tx.QueryRowContext(ctx, `
+		SELECT `+claimColumns+` FROM claims WHERE payer_id = $1 AND number = $2 FOR UPDATE
+		`, r.PayerID, item.ClaimNumber), &c)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			item.Error = "the payer has no claim with this number"
+			return nil
+		}
+		return err
+	}
+	item.ClaimID = &c.ID
+
+	switch {
+	case c.Status == ClaimPaid:
+		item.Error = "the claim has already been paid"
+		return nil
+	case c.Status == ClaimRejected:
+		item.Error = "the claim has been rejected"
+		return nil
+	case item.Paid > c.Claimed:
+		item.Error = "the payer paid more than was claimed"
+		return nil
+	}
+
+	if item.Paid == 0 {
+		reason := item.Reason
+		if reason == "" {
+			reason = "rejected in remittance " + r.Reference
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE claims SET status = 'rejected', rejection_reason = $2, updated_at = NOW() WHERE id = $1
+			`, c.ID, reason)
+		return err
+	}
+
+	inv, err := lockInvoice(ctx, tx, c.InvoiceID)
+	if err != nil {
+		return err
+	}
+	if (inv.Status != InvoiceIssued && inv.Status != InvoicePartiallyPaid) || item.Paid > inv.Balance {
+		item.Error = "the payer paid more than is owed on the invoice"
+		return nil
+	}
+
+	payment := &Payment{
+		InvoiceID:  inv.ID,
+		Method:     PaymentInsurance,
+		Amount:     item.Paid,
+		Reference:  r.Reference,
+		PaidAt:     r.ReceivedAt,
+		ReceivedBy: r.CreatedBy,
+	}
+	if err := insertPayment(ctx, tx, inv, payment); err != nil {
+		return err
+	}
+	item.PaymentID = &payment.ID
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE claims SET status = 'paid', paid = $2, rejection_reason = $3, updated_at = NOW() WHERE id = $1
+		`, c.ID, item.Paid, item.Reason)
+	return err
+}
+
+// GetRemittance returns a remittance with its items.
+func (m ClaimModel) GetRemittance(id int64) (*Remittance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var r Remittance
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT id, created_at, payer_id, reference, received_at, total, created_by FROM remittances WHERE id = $1
+		`, id).Scan(&r.ID, &r.CreatedAt, &r.PayerID, &r.Reference, &r.ReceivedAt, &r.Total, &r.CreatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT id, claim_number, claim_id, paid, reason, payment_id, error
+		FROM remittance_items
+		WHERE remittance_id = $1
+		ORDER BY id
+		`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item RemittanceItem
+		err := rows.Scan(&item.ID, &item.ClaimNumber, &item.ClaimID, &item.Paid, &item.Reason, &item.PaymentID,
+			&item.Error)
+		if err != nil {
+			return nil, err
+		}
+		if item.Error == "" {
+			r.Applied += item.Paid
+		}
+		r.Items = append(r.Items, &item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
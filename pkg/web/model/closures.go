@@ -0,0 +1,319 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/ical"
+	"GoClinic/pkg/web/rrule"
+	"GoClinic/pkg/web/validator"
+)
+
+// Closure is a period in which no appointments can be booked: a clinic-wide closure such as a
+// public holiday if DoctorID is nil, or a doctor's leave.
+type Closure struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	DoctorID  *int64    `json:"doctor_id,omitempty"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Reason    string    `json:"reason,omitempty"`
+	SourceUID *string   `json:"source_uid,omitempty"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+}
+
+type ClosureModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateClosure(v *validator.Validator, c *Closure) {
+	v.Check(c.DoctorID == nil || *c.DoctorID > 0, "doctor_id", "must be a positive integer")
+	v.Check(!c.StartsAt.IsZero(), "starts_at", "must be provided")
+	v.Check(!c.EndsAt.IsZero(), "ends_at", "must be provided")
+	v.Check(c.EndsAt.After(c.StartsAt), "ends_at", "must be after starts_at")
+	v.Check(c.EndsAt.Sub(c.StartsAt) <= 366*24*time.Hour, "ends_at", "must not be more than a year after starts_at")
+	v.Check(len(c.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+const closureColumns = `id, created_at, updated_at, doctor_id, starts_at, ends_at, reason, source_uid, created_by`
+
+func scanClosure(row interface{ Scan(...interface{}) error }, c *Closure) error {
+	return row.Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt, &c.DoctorID, &c.StartsAt, &c.EndsAt, &c.Reason, &c.SourceUID,
+		&c.CreatedBy)
+}
+
+// Insert adds a closure. If the doctor doesn't exist ErrRecordNotFound is returned.
+func (m ClosureModel) Insert(c *Closure) error {
+	query := `
+		INSERT INTO closures (doctor_id, starts_at, ends_at, reason, source_uid, created_by)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE $1::bigint IS NULL OR EXISTS (SELECT 1 FROM doctors WHERE id = $1)
+		RETURNING id, created_at, updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, c.DoctorID, c.StartsAt, c.EndsAt, c.Reason, c.SourceUID, c.CreatedBy).Scan(
+		&c.ID, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+func (m ClosureModel) Get(id int64) (*Closure, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var c Closure
+	err := scanClosure(m.DB.QueryRowContext(ctx, `SELECT `+closureColumns+` FROM closures WHERE id = $1`, id), &c)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+// GetAll returns the closures overlapping from and to, ordered by start. A non-zero doctorID
+// limits them to the doctor's leave and the clinic-wide closures, and a non-zero branchID to
+// the leave of the branch's doctors and the clinic-wide closures. Zero times are unbounded.
+func (m ClosureModel) GetAll(doctorID, branchID int64, from, to time.Time) ([]*Closure, error) {
+	query := `
+		SELECT ` + closureColumns + `
+		FROM closures
+		WHERE ($1 = 0 OR doctor_id IS NULL OR doctor_id = $1)
+			AND ($2::timestamptz IS NULL OR ends_at > $2)
+			AND ($3::timestamptz IS NULL OR starts_at < $3)
+			AND ($4 = 0 OR doctor_id IS NULL
+				OR doctor_id IN (SELECT doctor_id FROM doctor_branches WHERE branch_id = $4))
+		ORDER BY starts_at, id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, doctorID, nullTime(from), nullTime(to), branchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	closures := []*Closure{}
+	for rows.Next() {
+		var c Closure
+		if err := scanClosure(rows, &c); err != nil {
+			return nil, err
+		}
+		closures = append(closures, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return closures, nil
+}
+
+func (m ClosureModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM closures WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Import saves closures read from a calendar in one transaction. Closures whose SourceUID was
+// imported before are updated. It returns how many closures were added and updated.
+func (m ClosureModel) Import(closures []*Closure) (inserted, updated int, err error) {
+	query := `
+		INSERT INTO closures (doctor_id, starts_at, ends_at, reason, source_uid, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant_id, source_uid) DO UPDATE
+		SET doctor_id = EXCLUDED.doctor_id, starts_at = EXCLUDED.starts_at, ends_at = EXCLUDED.ends_at,
+			reason = EXCLUDED.reason, updated_at = NOW()
+		RETURNING id, created_at, updated_at, xmax = 0
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+
+	for _, c := range closures {
+		var isNew bool
+		err := stmt.QueryRowContext(ctx, c.DoctorID, c.StartsAt, c.EndsAt, c.Reason, c.SourceUID, c.CreatedBy).Scan(
+			&c.ID, &c.CreatedAt, &c.UpdatedAt, &isNew)
+		if err != nil {
+			return 0, 0, err
+		}
+		if isNew {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+
+	return inserted, updated, tx.Commit()
+}
+
+// Affected returns the appointments which aren't cancelled and overlap any of closures, in
+// time order: the appointments which have to be rescheduled.
+func (m ClosureModel) Affected(closures ...*Closure) ([]*Appointment, error) {
+	query := `
+		SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence, branch_id, room_id, duration_minutes
+		FROM appointments
+		WHERE status <> 'cancelled' AND ($1::bigint IS NULL OR doctor_id = $1)
+			AND date_time < $3 AND date_time + make_interval(mins => duration_minutes) > $2
+		ORDER BY date_time, id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	seen := make(map[string]bool)
+	appointments := []*Appointment{}
+	for _, c := range closures {
+		rows, err := m.DB.QueryContext(ctx, query, c.DoctorID, c.StartsAt, c.EndsAt)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var a Appointment
+			if err := rows.Scan(&a.Id, &a.CreatedAt, &a.UpdatedAt, &a.DateTime, &a.DoctorID, &a.PatientID, &a.Status,
+				&a.SeriesID, &a.Occurrence, &a.BranchID, &a.RoomID, &a.DurationMinutes); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[a.Id] {
+				seen[a.Id] = true
+				appointments = append(appointments, &a)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return appointments, nil
+}
+
+// maxImportedOccurrences limits how many closures one recurring calendar event can produce.
+const maxImportedOccurrences = 500
+
+// ClosuresFromCalendar reads the events of an iCalendar file, such as a public holiday
+// calendar, as closures. All-day events and floating times are in loc. Recurring events are
+// expanded until until, and occurrences which have already ended are left out. Cancelled
+// events and events with rules which aren't supported are skipped, with the reason in skipped.
+func ClosuresFromCalendar(r io.Reader, loc *time.Location, until time.Time) (closures []*Closure, skipped []string,
+	err error) {
+	events, err := ical.Decode(r, loc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	for _, e := range events {
+		name := e.Summary
+		if name == "" {
+			name = e.UID
+		}
+		switch {
+		case e.Status == ical.StatusCancelled:
+			skipped = append(skipped, fmt.Sprintf("%s: cancelled", name))
+			continue
+		case !e.End.After(e.Start):
+			skipped = append(skipped, fmt.Sprintf("%s: has no duration", name))
+			continue
+		}
+
+		uid := e.UID
+		if uid == "" {
+			uid = ical.FormatTime(e.Start) + "/" + e.Summary
+		}
+		// All-day events keep whole days across daylight saving time changes.
+		days := int(e.End.Sub(e.Start).Round(24*time.Hour) / (24 * time.Hour))
+		end := func(start time.Time) time.Time {
+			if e.AllDay {
+				return start.AddDate(0, 0, days)
+			}
+			return start.Add(e.End.Sub(e.Start))
+		}
+
+		starts := []time.Time{e.Start}
+		if e.RRule != "" {
+			rule, err := rrule.Parse(e.RRule, loc)
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %s", name, err))
+				continue
+			}
+			if rule.Until.IsZero() || rule.Until.After(until) {
+				rule.Until = until
+			}
+			starts = rule.All(e.Start, maxImportedOccurrences)
+		}
+
+		for _, start := range starts {
+			if !end(start).After(now) || start.After(until) {
+				continue
+			}
+			sourceUID := uid
+			if e.RRule != "" {
+				sourceUID += "/" + start.Format("20060102T150405")
+			}
+			closures = append(closures, &Closure{
+				StartsAt:  start,
+				EndsAt:    end(start),
+				Reason:    strings.TrimSpace(e.Summary),
+				SourceUID: &sourceUID,
+			})
+		}
+	}
+
+	return closures, skipped, nil
+}
+
+// nullTime passes zero times to the database as NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
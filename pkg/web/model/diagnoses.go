@@ -0,0 +1,176 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/fieldcrypt"
+	"GoClinic/pkg/web/validator"
+)
+
+// Diagnosis kinds. An appointment has at most one primary diagnosis and any number of
+// secondary ones.
+const (
+	DiagnosisPrimary   = "primary"
+	DiagnosisSecondary = "secondary"
+)
+
+// ErrDuplicatePrimaryDiagnosis is returned when a second primary diagnosis is attached to the
+// same appointment.
+var ErrDuplicatePrimaryDiagnosis = errors.New("duplicate primary diagnosis")
+
+// Diagnosis is an ICD-10 coded diagnosis recorded during an appointment. The appointment is
+// the encounter in which the diagnosis was made.
+type Diagnosis struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	AppointmentID int64     `json:"appointment_id"`
+	PatientID     int64     `json:"patient_id"`
+	Code          string    `json:"code"`
+	Description   string    `json:"description"`
+	Kind          string    `json:"kind"`
+	Notes         string    `json:"notes"`
+}
+
+type DiagnosisModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Fields   *fieldcrypt.Cipher
+}
+
+func ValidateDiagnosis(v *validator.Validator, diagnosis *Diagnosis) {
+	v.Check(diagnosis.Code != "", "code", "must be provided")
+	v.Check(validator.In(diagnosis.Kind, DiagnosisPrimary, DiagnosisSecondary), "kind", "must be primary or secondary")
+	v.Check(len(diagnosis.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+}
+
+// Insert attaches a diagnosis to an appointment. The patient is taken from the appointment so
+// the two can never disagree. If the appointment doesn't exist ErrRecordNotFound is returned.
+func (m DiagnosisModel) Insert(diagnosis *Diagnosis) error {
+	query := `
+		WITH inserted AS (
+			INSERT INTO diagnoses (appointment_id, patient_id, code, kind, notes)
+			SELECT a.id, a.patient_id, $2, $3, $4
+			FROM appointments a
+			WHERE a.id = $1
+			RETURNING id, created_at, patient_id, code
+		)
+		SELECT inserted.id, inserted.created_at, inserted.patient_id, icd10_codes.description
+		FROM inserted
+			INNER JOIN icd10_codes ON icd10_codes.code = inserted.code
+		`
+
+	diagnosis.Code = NormalizeICD10Code(diagnosis.Code)
+	notes, err := m.Fields.Encrypt(diagnosis.Notes)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{diagnosis.AppointmentID, diagnosis.Code, diagnosis.Kind, notes}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&diagnosis.ID, &diagnosis.CreatedAt, &diagnosis.PatientID, &diagnosis.Description)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "diagnoses_one_primary_idx":
+			return ErrDuplicatePrimaryDiagnosis
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a diagnosis.
+func (m DiagnosisModel) Delete(id int64) error {
+	query := `
+		DELETE FROM diagnoses
+		WHERE id = $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForAppointment returns the diagnoses of an appointment, primary diagnosis first.
+func (m DiagnosisModel) GetForAppointment(appointmentID int64) ([]*Diagnosis, error) {
+	query := `
+		SELECT d.id, d.created_at, d.appointment_id, d.patient_id, d.code, c.description, d.kind, d.notes
+		FROM diagnoses d
+			INNER JOIN icd10_codes c ON c.code = d.code
+		WHERE d.appointment_id = $1
+		ORDER BY d.kind = 'primary' DESC, d.id
+		`
+
+	return m.query(query, appointmentID)
+}
+
+// GetProblemHistory returns every diagnosis ever recorded for a patient in chronological order
+// of the appointments they were made at, which may have been recorded later.
+func (m DiagnosisModel) GetProblemHistory(patientID int64) ([]*Diagnosis, error) {
+	query := `
+		SELECT d.id, d.created_at, d.appointment_id, d.patient_id, d.code, c.description, d.kind, d.notes
+		FROM diagnoses d
+			INNER JOIN icd10_codes c ON c.code = d.code
+			INNER JOIN appointments a ON a.id = d.appointment_id
+		WHERE d.patient_id = $1
+		ORDER BY a.date_time, d.kind = 'primary' DESC, d.id
+		`
+
+	return m.query(query, patientID)
+}
+
+func (m DiagnosisModel) query(query string, args ...interface{}) ([]*Diagnosis, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diagnoses := []*Diagnosis{}
+	for rows.Next() {
+		var d Diagnosis
+		err := rows.Scan(&d.ID, &d.CreatedAt, &d.AppointmentID, &d.PatientID, &d.Code, &d.Description, &d.Kind, &d.Notes)
+		if err != nil {
+			return nil, err
+		}
+		if err := decryptAll(m.Fields, &d.Notes); err != nil {
+			return nil, err
+		}
+		diagnoses = append(diagnoses, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return diagnoses, nil
+}
@@ -3,9 +3,12 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
+
+	"GoClinic/pkg/web/validator"
 )
 
 type Doctor struct {
@@ -18,6 +21,17 @@ type Doctor struct {
 	Phone      string `json:"phone"`
 }
 
+// ValidateDoctor checks a doctor's details.
+func ValidateDoctor(v *validator.Validator, d *Doctor) {
+	v.Check(d.FirstName != "", "first_name", "must be provided")
+	v.Check(len(d.FirstName) <= 200, "first_name", "must not be more than 200 bytes long")
+	v.Check(d.LastName != "", "last_name", "must be provided")
+	v.Check(len(d.LastName) <= 200, "last_name", "must not be more than 200 bytes long")
+	v.Check(d.Speciality != "", "speciality", "must be provided")
+	v.Check(len(d.Speciality) <= 200, "speciality", "must not be more than 200 bytes long")
+	v.Check(len(d.Phone) <= 50, "phone", "must not be more than 50 bytes long")
+}
+
 type DoctorModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
@@ -52,6 +66,9 @@ func (m DoctorModel) Get(id int) (*Doctor, error) {
 	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(&doctor.Id, &doctor.CreatedAt, &doctor.UpdatedAt, &doctor.FirstName, &doctor.LastName, &doctor.Speciality, &doctor.Phone)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, err
 	}
 	return &doctor, nil
@@ -90,6 +107,7 @@ func (m DoctorModel) GetAllSortedByName(filters Filters) ([]*Doctor, error) {
 		`
         SELECT id, created_at, updated_at, first_name, last_name, speciality, phone
         FROM doctors
+        WHERE $1 = 0 OR id IN (SELECT doctor_id FROM doctor_branches WHERE branch_id = $1)
         ORDER BY %s %s`,
 		filters.sortColumn(),
 		filters.sortDirection(),
@@ -97,7 +115,7 @@ func (m DoctorModel) GetAllSortedByName(filters Filters) ([]*Doctor, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, filters.BranchID)
 	if err != nil {
 		return nil, err
 	}
@@ -118,18 +136,21 @@ func (m DoctorModel) GetAllSortedByName(filters Filters) ([]*Doctor, error) {
 	return doctors, nil
 }
 
-func (m DoctorModel) GetFilteredByText(filterText string) ([]*Doctor, error) {
+// GetFilteredByText returns the doctors whose name contains filterText. A non-zero branchID
+// limits them to the doctors working at the branch.
+func (m DoctorModel) GetFilteredByText(filterText string, branchID int) ([]*Doctor, error) {
 	query := `
         SELECT id, created_at, updated_at, first_name, last_name, speciality, phone
         FROM doctors
-        WHERE first_name LIKE '%' || $1 || '%' OR last_name LIKE '%' || $1 || '%'
+        WHERE (first_name LIKE '%' || $1 || '%' OR last_name LIKE '%' || $1 || '%')
+            AND ($2 = 0 OR id IN (SELECT doctor_id FROM doctor_branches WHERE branch_id = $2))
         ORDER BY first_name, last_name
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, filterText)
+	rows, err := m.DB.QueryContext(ctx, query, filterText, branchID)
 	if err != nil {
 		return nil, err
 	}
@@ -150,10 +171,13 @@ func (m DoctorModel) GetFilteredByText(filterText string) ([]*Doctor, error) {
 	return doctors, nil
 }
 
-func (m DoctorModel) GetPaginatedDoctors(limit, offset int) ([]*Doctor, error) {
+// GetPaginatedDoctors returns a page of doctors ordered by ID. A non-zero branchID limits them
+// to the doctors working at the branch.
+func (m DoctorModel) GetPaginatedDoctors(limit, offset, branchID int) ([]*Doctor, error) {
 	query := `
         SELECT id, created_at, updated_at, first_name, last_name, speciality, phone
         FROM doctors
+        WHERE $3 = 0 OR id IN (SELECT doctor_id FROM doctor_branches WHERE branch_id = $3)
         ORDER BY id
         LIMIT $1
         OFFSET $2
@@ -162,7 +186,7 @@ func (m DoctorModel) GetPaginatedDoctors(limit, offset int) ([]*Doctor, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, limit, offset)
+	rows, err := m.DB.QueryContext(ctx, query, limit, offset, branchID)
 	if err != nil {
 		return nil, err
 	}
@@ -184,3 +208,73 @@ func (m DoctorModel) GetPaginatedDoctors(limit, offset int) ([]*Doctor, error) {
 
 	return doctors, nil
 }
+
+// Search returns the doctors whose first or last name starts with name, case-insensitively, and
+// whose ID is id. An empty name or a zero id match everything.
+func (m DoctorModel) Search(name string, id, limit, offset int) ([]*Doctor, error) {
+	query := `
+        SELECT id, created_at, updated_at, first_name, last_name, speciality, phone
+        FROM doctors
+        WHERE ($1 = '' OR first_name ILIKE $1 || '%' OR last_name ILIKE $1 || '%')
+            AND ($2 = 0 OR id = $2)
+        ORDER BY last_name, first_name, id
+        LIMIT $3
+        OFFSET $4
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(name), id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	doctors := []*Doctor{}
+	for rows.Next() {
+		var doctor Doctor
+		if err := rows.Scan(&doctor.Id, &doctor.CreatedAt, &doctor.UpdatedAt, &doctor.FirstName, &doctor.LastName, &doctor.Speciality, &doctor.Phone); err != nil {
+			return nil, err
+		}
+		doctors = append(doctors, &doctor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return doctors, nil
+}
+
+// GetByName returns the doctors with the given first and last name, ignoring case.
+func (m DoctorModel) GetByName(firstName, lastName string) ([]*Doctor, error) {
+	query := `
+        SELECT id, created_at, updated_at, first_name, last_name, speciality, phone
+        FROM doctors
+        WHERE lower(first_name) = lower($1) AND lower(last_name) = lower($2)
+        ORDER BY id
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, firstName, lastName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	doctors := []*Doctor{}
+	for rows.Next() {
+		var doctor Doctor
+		if err := rows.Scan(&doctor.Id, &doctor.CreatedAt, &doctor.UpdatedAt, &doctor.FirstName, &doctor.LastName, &doctor.Speciality, &doctor.Phone); err != nil {
+			return nil, err
+		}
+		doctors = append(doctors, &doctor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return doctors, nil
+}
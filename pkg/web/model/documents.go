@@ -0,0 +1,219 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Document is a file attached to a patient record, and optionally to one of the patient's
+// appointments. The contents live in the document storage under a key derived from the tenant
+// and SHA256, so identical files are stored once per clinic however often they are uploaded.
+type Document struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	TenantID      int64     `json:"-"`
+	PatientID     int64     `json:"patient_id"`
+	AppointmentID *int64    `json:"appointment_id,omitempty"`
+	Filename      string    `json:"filename"`
+	ContentType   string    `json:"content_type"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	UploadedBy    *int64    `json:"uploaded_by,omitempty"`
+}
+
+type DocumentModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// StorageKey is the key the document contents are stored under.
+func (d *Document) StorageKey() string {
+	return DocumentStorageKey(d.TenantID, d.SHA256)
+}
+
+// DocumentStorageKey returns the key of the contents with the given SHA256 in a tenant's
+// documents. Tenants don't share contents: whether they are still referred to can only be
+// checked on the tenant's own connections, which don't see the documents of other tenants.
+func DocumentStorageKey(tenantID int64, sha256 string) string {
+	return fmt.Sprintf("documents/%d/%s/%s", tenantID, sha256[:2], sha256)
+}
+
+// LegacyDocumentStorageKey returns the key contents were stored under before they were kept
+// apart per tenant. clinicctl documents-move moves them to DocumentStorageKey.
+func LegacyDocumentStorageKey(sha256 string) string {
+	return "documents/" + sha256[:2] + "/" + sha256
+}
+
+const documentColumns = `id, created_at, tenant_id, patient_id, appointment_id, filename, content_type, size, sha256, uploaded_by`
+
+func scanDocument(row interface{ Scan(...interface{}) error }, d *Document) error {
+	return row.Scan(&d.ID, &d.CreatedAt, &d.TenantID, &d.PatientID, &d.AppointmentID, &d.Filename, &d.ContentType,
+		&d.Size, &d.SHA256, &d.UploadedBy)
+}
+
+func (m DocumentModel) Insert(d *Document) error {
+	query := `
+		INSERT INTO documents (patient_id, appointment_id, filename, content_type, size, sha256, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, tenant_id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, d.PatientID, d.AppointmentID, d.Filename, d.ContentType,
+		d.Size, d.SHA256, d.UploadedBy).Scan(&d.ID, &d.CreatedAt, &d.TenantID)
+}
+
+func (m DocumentModel) Get(id int64) (*Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var d Document
+
+	err := scanDocument(m.DB.QueryRowContext(ctx, `SELECT `+documentColumns+` FROM documents WHERE id = $1`, id), &d)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &d, nil
+}
+
+// FindDuplicate returns the document with the same contents already attached to the same
+// patient and appointment, or ErrRecordNotFound if there is none.
+func (m DocumentModel) FindDuplicate(d *Document) (*Document, error) {
+	query := `
+		SELECT ` + documentColumns + `
+		FROM documents
+		WHERE patient_id = $1 AND appointment_id IS NOT DISTINCT FROM $2 AND sha256 = $3
+		ORDER BY id
+		LIMIT 1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var dup Document
+
+	err := scanDocument(m.DB.QueryRowContext(ctx, query, d.PatientID, d.AppointmentID, d.SHA256), &dup)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &dup, nil
+}
+
+// GetForPatient returns all documents of a patient, including the ones attached to
+// appointments, newest first.
+func (m DocumentModel) GetForPatient(patientID int64) ([]*Document, error) {
+	return m.list(`WHERE patient_id = $1`, patientID)
+}
+
+// GetForAppointment returns the documents attached to an appointment, newest first.
+func (m DocumentModel) GetForAppointment(appointmentID int64) ([]*Document, error) {
+	return m.list(`WHERE appointment_id = $1`, appointmentID)
+}
+
+func (m DocumentModel) list(where string, arg interface{}) ([]*Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+documentColumns+` FROM documents `+where+` ORDER BY created_at DESC, id DESC`, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := []*Document{}
+	for rows.Next() {
+		var d Document
+		if err := scanDocument(rows, &d); err != nil {
+			return nil, err
+		}
+		documents = append(documents, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// Contents returns one document for each distinct contents of the tenant, to find every key
+// the tenant's documents are stored under.
+func (m DocumentModel) Contents() ([]*Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (sha256) `+documentColumns+`
+		FROM documents
+		ORDER BY sha256, id
+		`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := []*Document{}
+	for rows.Next() {
+		var d Document
+		if err := scanDocument(rows, &d); err != nil {
+			return nil, err
+		}
+		documents = append(documents, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// Delete removes a document and reports whether it was the last one of its tenant referring
+// to its contents, in which case the caller should remove them from the storage as well.
+func (m DocumentModel) Delete(d *Document) (orphaned bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM documents WHERE id = $1`, d.ID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected == 0 {
+		return false, ErrRecordNotFound
+	}
+
+	var referenced bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM documents WHERE sha256 = $1)`, d.SHA256).Scan(&referenced)
+	if err != nil {
+		return false, err
+	}
+
+	return !referenced, tx.Commit()
+}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Drug is an entry of the locally imported drug catalogue.
+type Drug struct {
+	ID              int64  `json:"id"`
+	Name            string `json:"name"`
+	Form            string `json:"form"`
+	Strength        string `json:"strength"`
+	ActiveSubstance string `json:"active_substance"`
+	ATCCode         string `json:"atc_code"`
+}
+
+// Substances returns the normalized active substances of the drug. Catalogue entries without a
+// substance fall back to the drug name.
+func (d *Drug) Substances() []string {
+	if substances := SplitSubstances(d.ActiveSubstance); len(substances) > 0 {
+		return substances
+	}
+	return []string{NormalizeSubstance(d.Name)}
+}
+
+type DrugModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Get retrieves a single drug of the catalogue.
+func (m DrugModel) Get(id int64) (*Drug, error) {
+	query := `
+		SELECT id, name, form, strength, active_substance, atc_code
+		FROM drugs
+		WHERE id = $1
+		`
+
+	var d Drug
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&d.ID, &d.Name, &d.Form, &d.Strength, &d.ActiveSubstance, &d.ATCCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &d, nil
+}
+
+// Search finds drugs whose name or active substance starts with the given text.
+func (m DrugModel) Search(text string, limit int) ([]*Drug, error) {
+	query := `
+		SELECT id, name, form, strength, active_substance, atc_code
+		FROM drugs
+		WHERE lower(name) LIKE $1 || '%' OR lower(active_substance) LIKE $1 || '%'
+		ORDER BY name, form, strength
+		LIMIT $2
+		`
+
+	prefix := escapeLike(strings.ToLower(strings.TrimSpace(text)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drugs := []*Drug{}
+	for rows.Next() {
+		var d Drug
+		if err := rows.Scan(&d.ID, &d.Name, &d.Form, &d.Strength, &d.ActiveSubstance, &d.ATCCode); err != nil {
+			return nil, err
+		}
+		drugs = append(drugs, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return drugs, nil
+}
+
+// Import loads drugs into the catalogue. Drugs are identified by name, form and strength, so
+// re-importing an updated catalogue refreshes the substance and ATC code of existing entries
+// while keeping their IDs, which prescriptions refer to.
+func (m DrugModel) Import(drugs []*Drug) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TEMP TABLE drugs_import (name text, form text, strength text, active_substance text, atc_code text)
+		ON COMMIT DROP
+		`)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("drugs_import", "name", "form", "strength", "active_substance", "atc_code"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, d := range drugs {
+		_, err := stmt.ExecContext(ctx,
+			strings.TrimSpace(d.Name),
+			strings.TrimSpace(d.Form),
+			strings.TrimSpace(d.Strength),
+			strings.TrimSpace(d.ActiveSubstance),
+			strings.ToUpper(strings.TrimSpace(d.ATCCode)),
+		)
+		if err != nil {
+			stmt.Close()
+			return 0, err
+		}
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return 0, err
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO drugs (name, form, strength, active_substance, atc_code)
+		SELECT DISTINCT ON (name, form, strength) name, form, strength, active_substance, atc_code
+		FROM drugs_import
+		WHERE name <> '' AND form <> ''
+		ORDER BY name, form, strength
+		ON CONFLICT (name, form, strength) DO UPDATE
+		SET active_substance = EXCLUDED.active_substance, atc_code = EXCLUDED.atc_code
+		`)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/fieldcrypt"
+)
+
+// EncryptedField is a column whose values the models encrypt before they are written and
+// decrypt after they are read. Index names the column of the field's blind index, if it has
+// one, and Normalize how values are normalized before they are indexed.
+type EncryptedField struct {
+	Table     string
+	Column    string
+	Index     string
+	Normalize func(string) string
+}
+
+// EncryptedFields are the columns which hold sensitive patient data.
+var EncryptedFields = []EncryptedField{
+	{Table: "patients", Column: "phone"},
+	{Table: "patients", Column: "national_id", Index: "national_id_index", Normalize: NormalizeNationalID},
+	{Table: "diagnoses", Column: "notes"},
+	{Table: "lab_orders", Column: "notes"},
+	{Table: "hl7_messages", Column: "raw"},
+}
+
+// blindIndexField is the name a field's blind index is computed under.
+func blindIndexField(table, column string) string {
+	return table + "." + column
+}
+
+// NormalizeNationalID removes the spaces, dashes and dots people write national IDs with and
+// upper-cases them, so that they are indexed and looked up the same however they were typed.
+func NormalizeNationalID(id string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "", ".", "").Replace(id))
+}
+
+// WithCipher returns the models with the cipher which encrypts the EncryptedFields. Without
+// one, they are written in plaintext.
+func (m Models) WithCipher(c *fieldcrypt.Cipher) Models {
+	m.Patients.Fields = c
+	m.Diagnoses.Fields = c
+	m.LabOrders.Fields = c
+	m.HL7Messages.Fields = c
+	m.Reminders.Fields = c
+	m.Imports.Fields = c
+	m.Exports.Fields = c
+	m.Encryption.Fields = c
+	return m
+}
+
+// decryptAll decrypts the values pointed to.
+func decryptAll(c *fieldcrypt.Cipher, values ...*string) error {
+	for _, value := range values {
+		plaintext, err := c.Decrypt(*value)
+		if err != nil {
+			return err
+		}
+		*value = plaintext
+	}
+	return nil
+}
+
+type EncryptionModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Fields   *fieldcrypt.Cipher
+}
+
+// Reencrypt encrypts the values of a field which are in plaintext or encrypted with an older
+// key under the current key, and recomputes the field's blind indexes. The rows are read in
+// batches of batchSize, each updated in a transaction of its own, so it can be interrupted and
+// run again. A value which changes in the meantime is left for the next run. It returns how many
+// rows were updated.
+func (m EncryptionModel) Reencrypt(f EncryptedField, batchSize int) (int64, error) {
+	if m.Fields == nil {
+		return 0, fmt.Errorf("no encryption keys configured")
+	}
+
+	selectBatch := fmt.Sprintf(`SELECT id, %s FROM %s WHERE id > $1 ORDER BY id LIMIT $2`,
+		pq.QuoteIdentifier(f.Column), pq.QuoteIdentifier(f.Table))
+	update := fmt.Sprintf(`UPDATE %s SET %s = $2 WHERE id = $1 AND %[2]s = $3`,
+		pq.QuoteIdentifier(f.Table), pq.QuoteIdentifier(f.Column))
+	if f.Index != "" {
+		update = fmt.Sprintf(`
+			UPDATE %s SET %s = $2, %s = NULLIF($4, '')
+			WHERE id = $1 AND %[2]s = $3 AND (%[2]s <> $2 OR %[3]s IS DISTINCT FROM NULLIF($4, ''))`,
+			pq.QuoteIdentifier(f.Table), pq.QuoteIdentifier(f.Column), pq.QuoteIdentifier(f.Index))
+	}
+
+	var updated, lastID int64
+	for {
+		n, last, err := m.reencryptBatch(f, selectBatch, update, lastID, batchSize)
+		if err != nil {
+			return updated, err
+		}
+		updated += n
+		if last == 0 {
+			return updated, nil
+		}
+		lastID = last
+	}
+}
+
+// reencryptBatch re-encrypts the batch of rows after lastID. It returns the ID of the last row
+// read, or 0 if there were none.
+func (m EncryptionModel) reencryptBatch(f EncryptedField, selectBatch, update string, lastID int64,
+	batchSize int) (int64, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, selectBatch, lastID, batchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	type row struct {
+		id    int64
+		value string
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.value); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(batch) == 0 {
+		return 0, 0, nil
+	}
+
+	var updated int64
+	for _, r := range batch {
+		value, changed, err := m.Fields.Reencrypt(r.value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("row %d: %w", r.id, err)
+		}
+		args := []interface{}{r.id, value, r.value}
+		if f.Index != "" {
+			// Blind indexes are recomputed even if the value didn't change, in case they were
+			// written without keys.
+			plaintext, err := m.Fields.Decrypt(value)
+			if err != nil {
+				return 0, 0, err
+			}
+			index := ""
+			if plaintext != "" {
+				index = m.Fields.BlindIndex(blindIndexField(f.Table, f.Column), f.Normalize(plaintext))
+			}
+			args = append(args, index)
+		} else if !changed {
+			continue
+		}
+
+		res, err := tx.ExecContext(ctx, update, args...)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, 0, err
+		}
+		updated += n
+	}
+
+	return updated, batch[len(batch)-1].id, tx.Commit()
+}
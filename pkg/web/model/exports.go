@@ -0,0 +1,310 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"GoClinic/pkg/web/fieldcrypt"
+	"GoClinic/pkg/web/validator"
+)
+
+// What can be exported.
+const (
+	ExportPatients     = "patients"
+	ExportDoctors      = "doctors"
+	ExportAppointments = "appointments"
+)
+
+// Export formats.
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportXLSX   = "xlsx"
+)
+
+// Export statuses.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// ExportFilter narrows down the rows of an export, like the filters of the list endpoints.
+// Zero values match everything. Text matches the names of patients and doctors and the time
+// of appointments; DoctorID, PatientID, From and To only apply to appointments.
+type ExportFilter struct {
+	Text      string     `json:"filter,omitempty"`
+	BranchID  int64      `json:"branch_id,omitempty"`
+	DoctorID  int64      `json:"doctor_id,omitempty"`
+	PatientID int64      `json:"patient_id,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+}
+
+// exportEntity is the query which streams the rows of an entity, with the names of its
+// columns and the positions of those which are encrypted. args returns the query's arguments
+// for a filter.
+type exportEntity struct {
+	columns   []string
+	encrypted []int
+	query     string
+	args      func(f ExportFilter) []interface{}
+}
+
+var exportEntities = map[string]exportEntity{
+	ExportPatients: {
+		columns:   []string{"id", "created_at", "updated_at", "first_name", "last_name", "phone", "email"},
+		encrypted: []int{5},
+		query: `
+			SELECT id, created_at, updated_at, first_name, last_name, phone, email
+			FROM patients
+			WHERE (first_name LIKE '%' || $1 || '%' OR last_name LIKE '%' || $1 || '%')
+				AND ($2 = 0 OR id IN (SELECT patient_id FROM appointments WHERE branch_id = $2))
+			ORDER BY id`,
+		args: func(f ExportFilter) []interface{} {
+			return []interface{}{f.Text, f.BranchID}
+		},
+	},
+	ExportDoctors: {
+		columns: []string{"id", "created_at", "updated_at", "first_name", "last_name", "speciality", "phone"},
+		query: `
+			SELECT id, created_at, updated_at, first_name, last_name, speciality, phone
+			FROM doctors
+			WHERE (first_name LIKE '%' || $1 || '%' OR last_name LIKE '%' || $1 || '%')
+				AND ($2 = 0 OR id IN (SELECT doctor_id FROM doctor_branches WHERE branch_id = $2))
+			ORDER BY id`,
+		args: func(f ExportFilter) []interface{} {
+			return []interface{}{f.Text, f.BranchID}
+		},
+	},
+	ExportAppointments: {
+		columns: []string{"id", "created_at", "updated_at", "date_time", "doctor_id", "patient_id", "status",
+			"series_id", "occurrence", "branch_id", "room_id", "duration_minutes"},
+		query: `
+			SELECT id, created_at, updated_at, date_time, doctor_id, patient_id, status, series_id, occurrence,
+				branch_id, room_id, duration_minutes
+			FROM appointments
+			WHERE date_time::text LIKE '%' || $1 || '%'
+				AND ($2 = 0 OR branch_id = $2)
+				AND ($3 = 0 OR doctor_id = $3)
+				AND ($4 = 0 OR patient_id = $4)
+				AND ($5::timestamptz IS NULL OR date_time >= $5)
+				AND ($6::timestamptz IS NULL OR date_time < $6)
+			ORDER BY id`,
+		args: func(f ExportFilter) []interface{} {
+			return []interface{}{f.Text, f.BranchID, f.DoctorID, f.PatientID, f.From, f.To}
+		},
+	},
+}
+
+// ExportColumns returns the names of the columns of an entity's export, or nil if the entity
+// can't be exported.
+func ExportColumns(entity string) []string {
+	return exportEntities[entity].columns
+}
+
+type Export struct {
+	ID         int64        `json:"id"`
+	CreatedAt  time.Time    `json:"created_at"`
+	Entity     string       `json:"entity"`
+	Format     string       `json:"format"`
+	Filter     ExportFilter `json:"filter"`
+	Status     string       `json:"status"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Rows       int64        `json:"rows"`
+	Size       int64        `json:"size"`
+	StorageKey string       `json:"-"`
+	Error      string       `json:"error,omitempty"`
+	CreatedBy  *int64       `json:"created_by"`
+}
+
+// ValidateExport checks what is exported and how.
+func ValidateExport(v *validator.Validator, e *Export) {
+	_, ok := exportEntities[e.Entity]
+	v.Check(ok, "entity", "must be patients, doctors or appointments")
+	v.Check(validator.In(e.Format, ExportCSV, ExportNDJSON, ExportXLSX), "format", "must be csv, ndjson or xlsx")
+	v.Check(len(e.Filter.Text) <= 200, "filter", "must not be more than 200 bytes long")
+	v.Check(e.Filter.BranchID >= 0, "branch_id", "must be a positive integer")
+	v.Check(e.Filter.DoctorID >= 0, "doctor_id", "must be a positive integer")
+	v.Check(e.Filter.PatientID >= 0, "patient_id", "must be a positive integer")
+	if e.Filter.From != nil && e.Filter.To != nil {
+		v.Check(e.Filter.To.After(*e.Filter.From), "to", "must be after from")
+	}
+}
+
+type ExportModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Fields   *fieldcrypt.Cipher
+}
+
+// Stream runs the query of an entity's export and calls fn with each row as it is read, so
+// that only one row is held in memory. The cells are strings, int64s, times or nil. Stream
+// stops at the first error of fn and returns it. The query runs until ctx is done.
+func (m ExportModel) Stream(ctx context.Context, entity string, f ExportFilter, fn func(row []interface{}) error) error {
+	e, ok := exportEntities[entity]
+	if !ok {
+		return errors.New("unknown export entity " + entity)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, e.query, e.args(f)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cells := make([]interface{}, len(e.columns))
+	dest := make([]interface{}, len(e.columns))
+	for i := range cells {
+		dest[i] = &cells[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, cell := range cells {
+			if b, ok := cell.([]byte); ok {
+				cells[i] = string(b)
+			}
+		}
+		for _, i := range e.encrypted {
+			if s, ok := cells[i].(string); ok {
+				if cells[i], err = m.Fields.Decrypt(s); err != nil {
+					return err
+				}
+			}
+		}
+		if err := fn(cells); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Count returns how many rows an entity's export has with a filter.
+func (m ExportModel) Count(ctx context.Context, entity string, f ExportFilter) (int64, error) {
+	e, ok := exportEntities[entity]
+	if !ok {
+		return 0, errors.New("unknown export entity " + entity)
+	}
+
+	var n int64
+	err := m.DB.QueryRowContext(ctx, `SELECT count(*) FROM (`+e.query+`) rows`, e.args(f)...).Scan(&n)
+	return n, err
+}
+
+const exportColumns = `id, created_at, entity, format, filter, status, started_at, finished_at, rows, size, storage_key,
+	error, created_by`
+
+func scanExport(row interface{ Scan(...interface{}) error }, e *Export) error {
+	var filter []byte
+	err := row.Scan(&e.ID, &e.CreatedAt, &e.Entity, &e.Format, &filter, &e.Status, &e.StartedAt, &e.FinishedAt,
+		&e.Rows, &e.Size, &e.StorageKey, &e.Error, &e.CreatedBy)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(filter, &e.Filter)
+}
+
+// Insert queues an export for a worker.
+func (m ExportModel) Insert(e *Export) error {
+	filter, err := json.Marshal(e.Filter)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO exports (entity, format, filter, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + exportColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanExport(m.DB.QueryRowContext(ctx, query, e.Entity, e.Format, filter, e.CreatedBy), e)
+}
+
+func (m ExportModel) Get(id int64) (*Export, error) {
+	query := `SELECT ` + exportColumns + ` FROM exports WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var e Export
+	if err := scanExport(m.DB.QueryRowContext(ctx, query, id), &e); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Claim marks the oldest pending export as running and returns it, or nil if there is none.
+// Exports which have been running for longer than stale are claimed again, since the worker
+// running them must have stopped. Several workers may claim exports at the same time.
+func (m ExportModel) Claim(stale time.Duration) (*Export, error) {
+	query := `
+		UPDATE exports
+		SET status = 'running', started_at = NOW()
+		WHERE id = (
+			SELECT id FROM exports
+			WHERE status = 'pending' OR (status = 'running' AND started_at < NOW() - make_interval(secs => $1))
+			ORDER BY created_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING ` + exportColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var e Export
+	if err := scanExport(m.DB.QueryRowContext(ctx, query, stale.Seconds()), &e); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Finish records the outcome of a running export: its status, the rows and bytes written and
+// where the file is stored, or the error. A pending status puts it back in the queue.
+func (m ExportModel) Finish(e *Export) error {
+	query := `
+		UPDATE exports
+		SET status = $2, rows = $3, size = $4, storage_key = $5, error = $6,
+			finished_at = CASE WHEN $2 IN ('done', 'failed') THEN NOW() END,
+			started_at = CASE WHEN $2 = 'pending' THEN NULL ELSE started_at END
+		WHERE id = $1
+		RETURNING finished_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, e.ID, e.Status, e.Rows, e.Size, e.StorageKey, e.Error).Scan(&e.FinishedAt)
+}
+
+// Delete removes an export and returns it, so that its file can be removed from the storage.
+func (m ExportModel) Delete(id int64) (*Export, error) {
+	query := `DELETE FROM exports WHERE id = $1 RETURNING ` + exportColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var e Export
+	if err := scanExport(m.DB.QueryRowContext(ctx, query, id), &e); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,209 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"GoClinic/pkg/web/fieldcrypt"
+)
+
+// Resources which can have external identifiers.
+const (
+	ExternalPatient     = "patient"
+	ExternalDoctor      = "doctor"
+	ExternalAppointment = "appointment"
+)
+
+// ExternalIDModel maps identifiers assigned by other systems to our own record IDs.
+type ExternalIDModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Get returns our ID of the record another system knows as value, or ErrRecordNotFound.
+func (m ExternalIDModel) Get(resource, system, value string) (int, error) {
+	query := `
+		SELECT local_id
+		FROM external_ids
+		WHERE resource = $1 AND system = $2 AND value = $3
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query, resource, system, value).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// Set records that another system knows our record localID as value.
+func (m ExternalIDModel) Set(resource, system, value string, localID int) error {
+	query := `
+		INSERT INTO external_ids (resource, system, value, local_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, resource, system, value) DO UPDATE SET local_id = EXCLUDED.local_id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, resource, system, value, localID)
+	return err
+}
+
+// HL7 message processing statuses.
+const (
+	HL7Received  = "received"
+	HL7Processed = "processed"
+	HL7Failed    = "failed"
+	HL7Rejected  = "rejected"
+)
+
+// HL7Message is a raw HL7 v2 message as it was received, kept so that it can be replayed. Its
+// PID segment carries the patient's phone number and national ID, so Raw is one of the
+// EncryptedFields.
+type HL7Message struct {
+	ID          int64      `json:"id"`
+	ReceivedAt  time.Time  `json:"received_at"`
+	Sender      string     `json:"sender"`
+	ControlID   string     `json:"control_id"`
+	MessageType string     `json:"message_type"`
+	Raw         string     `json:"raw"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+type HL7MessageModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Fields   *fieldcrypt.Cipher
+}
+
+// Insert stores a received message. A message resent with the control ID of an earlier one
+// replaces it, so that a sender's retry of a failed message is processed again.
+func (m HL7MessageModel) Insert(msg *HL7Message) error {
+	query := `
+		INSERT INTO hl7_messages (sender, control_id, message_type, raw)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, sender, control_id) WHERE control_id <> '' DO UPDATE
+		SET raw = EXCLUDED.raw, message_type = EXCLUDED.message_type, received_at = NOW(),
+			status = 'received', error = '', processed_at = NULL
+		RETURNING id, received_at, status
+		`
+
+	raw, err := m.Fields.Encrypt(msg.Raw)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, msg.Sender, msg.ControlID, msg.MessageType, raw).Scan(
+		&msg.ID, &msg.ReceivedAt, &msg.Status)
+}
+
+// SetResult records the outcome of processing a message.
+func (m HL7MessageModel) SetResult(msg *HL7Message) error {
+	query := `
+		UPDATE hl7_messages
+		SET status = $1, error = $2, processed_at = NOW()
+		WHERE id = $3
+		RETURNING processed_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, msg.Status, msg.Error, msg.ID).Scan(&msg.ProcessedAt)
+}
+
+const hl7MessageColumns = `id, received_at, sender, control_id, message_type, raw, status, error, processed_at`
+
+func scanHL7Message(row interface{ Scan(...interface{}) error }, msg *HL7Message) error {
+	return row.Scan(&msg.ID, &msg.ReceivedAt, &msg.Sender, &msg.ControlID, &msg.MessageType, &msg.Raw,
+		&msg.Status, &msg.Error, &msg.ProcessedAt)
+}
+
+func (m HL7MessageModel) Get(id int64) (*HL7Message, error) {
+	return m.getBy(`id = $1`, id)
+}
+
+// GetByControlID returns the message a sender sent with the given control ID.
+func (m HL7MessageModel) GetByControlID(sender, controlID string) (*HL7Message, error) {
+	return m.getBy(`sender = $1 AND control_id = $2 AND control_id <> ''`, sender, controlID)
+}
+
+func (m HL7MessageModel) getBy(where string, args ...interface{}) (*HL7Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var msg HL7Message
+
+	err := scanHL7Message(m.DB.QueryRowContext(ctx, `SELECT `+hl7MessageColumns+` FROM hl7_messages WHERE `+where, args...), &msg)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if err := decryptAll(m.Fields, &msg.Raw); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// GetAll returns the latest messages, optionally only those with the given status.
+func (m HL7MessageModel) GetAll(status string, limit int) ([]*HL7Message, error) {
+	query := `
+		SELECT ` + hl7MessageColumns + `
+		FROM hl7_messages
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY received_at DESC, id DESC
+		LIMIT $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*HL7Message{}
+	for rows.Next() {
+		var msg HL7Message
+		if err := scanHL7Message(rows, &msg); err != nil {
+			return nil, err
+		}
+		if err := decryptAll(m.Fields, &msg.Raw); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ICD10Code is a single entry of the ICD-10 code catalogue.
+type ICD10Code struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// ICD10Model wraps the icd10_codes catalogue table.
+type ICD10Model struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// NormalizeICD10Code upper-cases a code and strips surrounding whitespace so "j45.9 " and "J45.9"
+// refer to the same catalogue entry.
+func NormalizeICD10Code(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Get retrieves a single code from the catalogue.
+func (m ICD10Model) Get(code string) (*ICD10Code, error) {
+	query := `
+		SELECT code, description
+		FROM icd10_codes
+		WHERE code = $1
+		`
+
+	var c ICD10Code
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, NormalizeICD10Code(code)).Scan(&c.Code, &c.Description)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+// Search looks up codes either by code prefix ("J45" matches "J45.0", "J45.9", ...) or by a
+// full-text match over the code descriptions. Prefix matches are ranked first.
+func (m ICD10Model) Search(text string, limit int) ([]*ICD10Code, error) {
+	query := `
+		SELECT code, description
+		FROM icd10_codes
+		WHERE code LIKE $1 || '%' OR search @@ plainto_tsquery('english', $2)
+		ORDER BY code LIKE $1 || '%' DESC, ts_rank(search, plainto_tsquery('english', $2)) DESC, code
+		LIMIT $3
+		`
+
+	// Escape the LIKE wildcards so a user typed "%" or "_" is matched literally.
+	prefix := escapeLike(NormalizeICD10Code(text))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, prefix, text, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []*ICD10Code{}
+	for rows.Next() {
+		var c ICD10Code
+		if err := rows.Scan(&c.Code, &c.Description); err != nil {
+			return nil, err
+		}
+		codes = append(codes, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Import loads the given codes into the catalogue, inserting new codes and updating the
+// description of the ones which already exist. The whole import runs in a single transaction:
+// rows are streamed into a temporary table with COPY and merged from there.
+func (m ICD10Model) Import(codes []*ICD10Code) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TEMP TABLE icd10_import (line integer, code text, description text) ON COMMIT DROP`)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("icd10_import", "line", "code", "description"))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, c := range codes {
+		if _, err := stmt.ExecContext(ctx, i, NormalizeICD10Code(c.Code), strings.TrimSpace(c.Description)); err != nil {
+			stmt.Close()
+			return 0, err
+		}
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return 0, err
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, err
+	}
+
+	// DISTINCT ON keeps the last description, by line, if a source file lists the same code
+	// twice, which would otherwise make ON CONFLICT fail for touching a row more than once.
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO icd10_codes (code, description)
+		SELECT DISTINCT ON (code) code, description
+		FROM icd10_import
+		WHERE code <> '' AND description <> ''
+		ORDER BY code, line DESC
+		ON CONFLICT (code) DO UPDATE SET description = EXCLUDED.description
+		`)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
@@ -0,0 +1,413 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/fieldcrypt"
+	"GoClinic/pkg/web/validator"
+)
+
+const (
+	ImportPatients = "patients"
+	ImportDoctors  = "doctors"
+)
+
+// ImportBatchSize is how many valid rows are copied into the database at a time.
+const ImportBatchSize = 1000
+
+// importErrorsShown is how many row errors are returned with an import; the error report has
+// all of them.
+const importErrorsShown = 50
+
+// ErrImportHeader is returned when the header row of an import file lacks a required column
+// or names an unknown one.
+var ErrImportHeader = errors.New("invalid header row")
+
+// importKind describes what can be imported: the columns of the file, in the order they are
+// copied, and how a row is validated. The columns are those of the table of the same name.
+// encrypted are the positions of the columns which are encrypted before they are copied.
+type importKind struct {
+	columns   []string
+	required  []string
+	encrypted []int
+	validate  func(v *validator.Validator, values []string)
+}
+
+var importKinds = map[string]importKind{
+	ImportPatients: {
+		columns:   []string{"first_name", "last_name", "phone", "email"},
+		required:  []string{"first_name", "last_name"},
+		encrypted: []int{2},
+		validate: func(v *validator.Validator, values []string) {
+			ValidatePatient(v, &Patient{FirstName: values[0], LastName: values[1], Phone: values[2], Email: values[3]})
+		},
+	},
+	ImportDoctors: {
+		columns:  []string{"first_name", "last_name", "speciality", "phone"},
+		required: []string{"first_name", "last_name", "speciality"},
+		validate: func(v *validator.Validator, values []string) {
+			ValidateDoctor(v, &Doctor{FirstName: values[0], LastName: values[1], Speciality: values[2], Phone: values[3]})
+		},
+	},
+}
+
+// Import is a bulk import of patients or doctors from a CSV file. Rows counts the data rows of
+// the file, Imported those which were inserted, none in a dry run, and Invalid those which
+// weren't valid. Errors are the first of the row errors.
+type Import struct {
+	ID        int64          `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	Kind      string         `json:"kind"`
+	Filename  string         `json:"filename,omitempty"`
+	DryRun    bool           `json:"dry_run"`
+	Rows      int            `json:"rows"`
+	Imported  int            `json:"imported"`
+	Invalid   int            `json:"invalid"`
+	CreatedBy *int64         `json:"created_by,omitempty"`
+	Errors    []*ImportError `json:"errors,omitempty"`
+}
+
+// ImportError is why a row of an import file was rejected. Line is the line of the file the
+// row starts on; Field is empty for rows which couldn't be read.
+type ImportError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Fields   *fieldcrypt.Cipher
+}
+
+// Run reads the CSV file r, which must have a header row naming its columns, and imports the
+// valid rows of imp.Kind unless imp.DryRun is set. Row errors don't stop the import; they are
+// recorded with it. The valid rows are copied in batches, all in one transaction, so the import
+// is saved in full or not at all. ErrImportHeader is returned for an unusable header.
+func (m ImportModel) Run(imp *Import, r io.Reader) error {
+	kind, ok := importKinds[imp.Kind]
+	if !ok {
+		return fmt.Errorf("unknown import kind %q", imp.Kind)
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrImportHeader, err)
+	}
+	positions, err := importPositions(kind, header)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO imports (kind, filename, dry_run, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+		`, imp.Kind, imp.Filename, imp.DryRun, imp.CreatedBy).Scan(&imp.ID, &imp.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	// Row-level security doesn't allow COPY into the tenant's tables, so the rows are copied
+	// into a temporary table first.
+	if !imp.DryRun {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`CREATE TEMP TABLE %s_import (%s text) ON COMMIT DROP`, imp.Kind,
+			strings.Join(kind.columns, " text, ")))
+		if err != nil {
+			return err
+		}
+	}
+
+	var (
+		batch  [][]string
+		errs   []*ImportError
+		values = make([]string, len(kind.columns))
+	)
+	imp.Rows, imp.Imported, imp.Invalid, imp.Errors = 0, 0, 0, nil
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			imp.Rows++
+			imp.Invalid++
+			errs = append(errs, &ImportError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+			continue
+		case err != nil:
+			return err
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		imp.Rows++
+		line, _ := cr.FieldPos(0)
+
+		for i, pos := range positions {
+			values[i] = ""
+			if pos >= 0 && pos < len(record) {
+				values[i] = strings.TrimSpace(record[pos])
+			}
+		}
+
+		v := validator.New()
+		if kind.validate(v, values); !v.Valid() {
+			imp.Invalid++
+			fields := make([]string, 0, len(v.Errors))
+			for field := range v.Errors {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				errs = append(errs, &ImportError{Line: line, Field: field, Message: v.Errors[field]})
+			}
+		} else if !imp.DryRun {
+			row := append([]string(nil), values...)
+			for _, i := range kind.encrypted {
+				if row[i], err = m.Fields.Encrypt(row[i]); err != nil {
+					return err
+				}
+			}
+			batch = append(batch, row)
+		}
+
+		if len(batch) >= ImportBatchSize {
+			if err := copyImportBatch(ctx, tx, imp, kind, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		if len(errs) >= ImportBatchSize {
+			if err := insertImportErrors(ctx, tx, imp, errs); err != nil {
+				return err
+			}
+			errs = errs[:0]
+		}
+	}
+
+	if err := copyImportBatch(ctx, tx, imp, kind, batch); err != nil {
+		return err
+	}
+	if err := insertImportErrors(ctx, tx, imp, errs); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE imports SET rows = $2, imported = $3, invalid = $4 WHERE id = $1`,
+		imp.ID, imp.Rows, imp.Imported, imp.Invalid)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// importPositions returns the positions in the header of the columns of kind, -1 for those it
+// lacks.
+func importPositions(kind importKind, header []string) ([]int, error) {
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+
+	positions := make([]int, len(kind.columns))
+	known := make(map[string]bool)
+	for i, name := range kind.columns {
+		known[name] = true
+		positions[i] = -1
+		if pos, ok := columns[name]; ok {
+			positions[i] = pos
+		}
+	}
+	for _, name := range kind.required {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing %q column", ErrImportHeader, name)
+		}
+	}
+	for name := range columns {
+		if !known[name] {
+			return nil, fmt.Errorf("%w: unknown %q column, must be one of %s", ErrImportHeader, name,
+				strings.Join(kind.columns, ", "))
+		}
+	}
+	return positions, nil
+}
+
+// copyImportBatch copies valid rows into the temporary table and moves them to the table of
+// the import's kind.
+func copyImportBatch(ctx context.Context, tx *sql.Tx, imp *Import, kind importKind, batch [][]string) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(imp.Kind+"_import", kind.columns...))
+	if err != nil {
+		return err
+	}
+	args := make([]interface{}, len(kind.columns))
+	for _, values := range batch {
+		for i, value := range values {
+			args[i] = value
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	columns := strings.Join(kind.columns, ", ")
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM %[1]s_import`, imp.Kind,
+		columns))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	imp.Imported += int(n)
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`TRUNCATE %s_import`, imp.Kind))
+	return err
+}
+
+// insertImportErrors records row errors of an import, and keeps the first ones in imp.Errors.
+func insertImportErrors(ctx context.Context, tx *sql.Tx, imp *Import, errs []*ImportError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	lines := make([]int64, len(errs))
+	fields := make([]string, len(errs))
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i], fields[i], messages[i] = int64(e.Line), e.Field, e.Message
+		if len(imp.Errors) < importErrorsShown {
+			imp.Errors = append(imp.Errors, e)
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO import_errors (import_id, line, field, message)
+		SELECT $1, unnest($2::integer[]), unnest($3::text[]), unnest($4::text[])
+		`, imp.ID, pq.Array(lines), pq.Array(fields), pq.Array(messages))
+	return err
+}
+
+// Get returns an import with its first row errors.
+func (m ImportModel) Get(id int64) (*Import, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var imp Import
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT id, created_at, kind, filename, dry_run, rows, imported, invalid, created_by
+		FROM imports
+		WHERE id = $1
+		`, id).Scan(&imp.ID, &imp.CreatedAt, &imp.Kind, &imp.Filename, &imp.DryRun, &imp.Rows, &imp.Imported,
+		&imp.Invalid, &imp.CreatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT line, field, message FROM import_errors WHERE import_id = $1 ORDER BY line, field LIMIT $2
+		`, id, importErrorsShown)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e ImportError
+		if err := rows.Scan(&e.Line, &e.Field, &e.Message); err != nil {
+			return nil, err
+		}
+		imp.Errors = append(imp.Errors, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &imp, nil
+}
+
+// WriteErrorReport writes every row error of an import to w as CSV, with the columns line,
+// field and message. ErrRecordNotFound is returned if there is no such import.
+func (m ImportModel) WriteErrorReport(id int64, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var exists bool
+	if err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM imports WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT line, field, message FROM import_errors WHERE import_id = $1 ORDER BY line, field
+		`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"line", "field", "message"})
+	for rows.Next() {
+		var e ImportError
+		if err := rows.Scan(&e.Line, &e.Field, &e.Message); err != nil {
+			return err
+		}
+		cw.Write([]string{fmt.Sprint(e.Line), e.Field, e.Message})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
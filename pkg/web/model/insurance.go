@@ -0,0 +1,441 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/claims"
+	"GoClinic/pkg/web/validator"
+)
+
+// ErrPayerInUse is returned when a payer which has policies or claims is deleted. It can be
+// deactivated instead.
+var ErrPayerInUse = errors.New("payer has policies or claims, deactivate it instead")
+
+// ErrPolicyInUse is returned when a policy which has been claimed against is deleted.
+var ErrPolicyInUse = errors.New("policy has claims and can't be deleted")
+
+// ErrPolicyOverlap is returned when a patient's policies with the same payer cover the same
+// day.
+var ErrPolicyOverlap = errors.New("patient already has a policy with this payer for part of this period")
+
+// Payer is an insurer, or another third party which pays for patients' care. BatchFormat is
+// the layout of its claim batch files; without one claims.DefaultFormat is used.
+type Payer struct {
+	ID          int64          `json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	Code        string         `json:"code"`
+	Name        string         `json:"name"`
+	Currency    string         `json:"currency"`
+	BatchFormat *claims.Format `json:"batch_format,omitempty"`
+	Active      bool           `json:"active"`
+}
+
+// Format returns the payer's batch file format.
+func (p *Payer) Format() *claims.Format {
+	if p.BatchFormat != nil {
+		return p.BatchFormat
+	}
+	return claims.DefaultFormat
+}
+
+// InsurancePolicy is a patient's cover by a payer from CoverageFrom until CoverageTo, or
+// indefinitely. The patient pays CopayAmount, in minor units of the payer's currency, and
+// CopayRate, in hundredths of a percent, of the rest of what is billed.
+type InsurancePolicy struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	PatientID    int64     `json:"patient_id"`
+	PayerID      int64     `json:"payer_id"`
+	MemberNumber string    `json:"member_number"`
+	GroupNumber  string    `json:"group_number"`
+	CoverageFrom string    `json:"coverage_from"`
+	CoverageTo   *string   `json:"coverage_to"`
+	CopayAmount  int64     `json:"copay_amount"`
+	CopayRate    int       `json:"copay_rate"`
+}
+
+// Copay returns the part of total the patient pays.
+func (p *InsurancePolicy) Copay(total int64) int64 {
+	copay := min(p.CopayAmount, total)
+	return copay + VATAmount(total-copay, p.CopayRate)
+}
+
+type InsuranceModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidatePayer(v *validator.Validator, p *Payer) {
+	v.Check(p.Code != "", "code", "must be provided")
+	v.Check(len(p.Code) <= 50, "code", "must not be more than 50 bytes long")
+	v.Check(p.Name != "", "name", "must be provided")
+	v.Check(len(p.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(validator.Matches(p.Currency, CurrencyRX), "currency", "must be an ISO 4217 code such as EUR")
+	if p.BatchFormat != nil {
+		for key, message := range p.BatchFormat.Validate() {
+			v.AddError("batch_format."+key, message)
+		}
+	}
+}
+
+func ValidateInsurancePolicy(v *validator.Validator, p *InsurancePolicy) {
+	v.Check(p.PayerID > 0, "payer_id", "must be provided")
+	v.Check(p.MemberNumber != "", "member_number", "must be provided")
+	v.Check(len(p.MemberNumber) <= 100, "member_number", "must not be more than 100 bytes long")
+	v.Check(len(p.GroupNumber) <= 100, "group_number", "must not be more than 100 bytes long")
+	_, err := time.Parse("2006-01-02", p.CoverageFrom)
+	v.Check(err == nil, "coverage_from", "must be a date such as 2024-07-01")
+	if p.CoverageTo != nil {
+		_, err := time.Parse("2006-01-02", *p.CoverageTo)
+		v.Check(err == nil, "coverage_to", "must be a date such as 2024-12-31")
+		v.Check(*p.CoverageTo >= p.CoverageFrom, "coverage_to", "must not be before coverage_from")
+	}
+	v.Check(p.CopayAmount >= 0, "copay_amount", "must not be negative")
+	v.Check(p.CopayRate >= 0 && p.CopayRate <= 10000, "copay_rate", "must be between 0 and 10000 hundredths of a percent")
+}
+
+const payerColumns = `id, created_at, updated_at, code, name, currency, batch_format, active`
+
+func scanPayer(row interface{ Scan(...interface{}) error }, p *Payer) error {
+	var format []byte
+	err := row.Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.Code, &p.Name, &p.Currency, &format, &p.Active)
+	if err != nil {
+		return err
+	}
+	p.BatchFormat = nil
+	if format != nil {
+		p.BatchFormat = &claims.Format{}
+		return json.Unmarshal(format, p.BatchFormat)
+	}
+	return nil
+}
+
+// batchFormatValue returns the payer's format as it is stored.
+func batchFormatValue(p *Payer) (interface{}, error) {
+	if p.BatchFormat == nil {
+		return nil, nil
+	}
+	return json.Marshal(p.BatchFormat)
+}
+
+const policyColumns = `
+	id, created_at, updated_at, patient_id, payer_id, member_number, group_number,
+	to_char(coverage_from, 'YYYY-MM-DD'), to_char(coverage_to, 'YYYY-MM-DD'), copay_amount, copay_rate`
+
+func scanPolicy(row interface{ Scan(...interface{}) error }, p *InsurancePolicy) error {
+	return row.Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.PatientID, &p.PayerID, &p.MemberNumber, &p.GroupNumber,
+		&p.CoverageFrom, &p.CoverageTo, &p.CopayAmount, &p.CopayRate)
+}
+
+// InsertPayer adds a payer.
+func (m InsuranceModel) InsertPayer(p *Payer) error {
+	format, err := batchFormatValue(p)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, `
+		INSERT INTO payers (code, name, currency, batch_format, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+		`, p.Code, p.Name, p.Currency, format, p.Active).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+// GetPayer returns a payer.
+func (m InsuranceModel) GetPayer(id int64) (*Payer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p Payer
+	err := scanPayer(m.DB.QueryRowContext(ctx, `SELECT `+payerColumns+` FROM payers WHERE id = $1`, id), &p)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// GetAllPayers returns the payers ordered by name, optionally only the active ones.
+func (m InsuranceModel) GetAllPayers(activeOnly bool) ([]*Payer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT `+payerColumns+`
+		FROM payers
+		WHERE NOT $1 OR active
+		ORDER BY name, id
+		`, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payers := []*Payer{}
+	for rows.Next() {
+		var p Payer
+		if err := scanPayer(rows, &p); err != nil {
+			return nil, err
+		}
+		payers = append(payers, &p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payers, nil
+}
+
+// UpdatePayer changes a payer. Batches which have been generated are written in the new
+// format from then on.
+func (m InsuranceModel) UpdatePayer(p *Payer) error {
+	format, err := batchFormatValue(p)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, `
+		UPDATE payers
+		SET code = $2, name = $3, currency = $4, batch_format = $5, active = $6, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+		`, p.ID, p.Code, p.Name, p.Currency, format, p.Active).Scan(&p.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordNotFound
+	case isUniqueViolation(err):
+		return ErrDuplicateName
+	default:
+		return err
+	}
+}
+
+// DeletePayer removes a payer. ErrPayerInUse is returned if it has policies or claims.
+func (m InsuranceModel) DeletePayer(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM payers WHERE id = $1`, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrPayerInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// InsertPolicy adds a policy of a patient. ErrRecordNotFound is returned if the patient or the
+// payer doesn't exist, and ErrPolicyOverlap if the patient already has a policy with the payer
+// for part of its coverage.
+func (m InsuranceModel) InsertPolicy(p *InsurancePolicy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkPolicyOverlap(ctx, tx, p); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO insurance_policies (patient_id, payer_id, member_number, group_number, coverage_from, coverage_to,
+			copay_amount, copay_rate)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at
+		`, p.PatientID, p.PayerID, p.MemberNumber, p.GroupNumber, p.CoverageFrom, p.CoverageTo, p.CopayAmount,
+		p.CopayRate).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkPolicyOverlap locks the patient of p, so that the patient's policies can't change
+// until tx ends, and returns ErrPolicyOverlap if another of them with the same payer covers a
+// day p covers.
+func checkPolicyOverlap(ctx context.Context, tx *sql.Tx, p *InsurancePolicy) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM patients WHERE id = $1 FOR UPDATE`, p.PatientID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	var overlaps bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM insurance_policies
+			WHERE patient_id = $1 AND payer_id = $2 AND id <> $3
+				AND daterange(coverage_from, coverage_to, '[]') && daterange($4::date, $5::date, '[]')
+		)
+		`, p.PatientID, p.PayerID, p.ID, p.CoverageFrom, p.CoverageTo).Scan(&overlaps)
+	if err != nil {
+		return err
+	}
+	if overlaps {
+		return ErrPolicyOverlap
+	}
+	return nil
+}
+
+// GetPolicy returns a policy.
+func (m InsuranceModel) GetPolicy(id int64) (*InsurancePolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p InsurancePolicy
+	err := scanPolicy(m.DB.QueryRowContext(ctx, `SELECT `+policyColumns+` FROM insurance_policies WHERE id = $1`, id), &p)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// PatientPolicies returns the policies of a patient, latest coverage first.
+func (m InsuranceModel) PatientPolicies(patientID int64) ([]*InsurancePolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT `+policyColumns+`
+		FROM insurance_policies
+		WHERE patient_id = $1
+		ORDER BY coverage_from DESC, id
+		`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []*InsurancePolicy{}
+	for rows.Next() {
+		var p InsurancePolicy
+		if err := scanPolicy(rows, &p); err != nil {
+			return nil, err
+		}
+		policies = append(policies, &p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// UpdatePolicy changes a policy. Claims which have been made keep the copay they were made
+// with.
+func (m InsuranceModel) UpdatePolicy(p *InsurancePolicy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkPolicyOverlap(ctx, tx, p); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE insurance_policies
+		SET payer_id = $2, member_number = $3, group_number = $4, coverage_from = $5, coverage_to = $6,
+			copay_amount = $7, copay_rate = $8, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+		`, p.ID, p.PayerID, p.MemberNumber, p.GroupNumber, p.CoverageFrom, p.CoverageTo, p.CopayAmount,
+		p.CopayRate).Scan(&p.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeletePolicy removes a policy. ErrPolicyInUse is returned if claims were made under it.
+func (m InsuranceModel) DeletePolicy(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM insurance_policies WHERE id = $1`, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrPolicyInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
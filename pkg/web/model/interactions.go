@@ -0,0 +1,125 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Interaction is a known interaction between two active substances.
+type Interaction struct {
+	ID          int64  `json:"id"`
+	SubstanceA  string `json:"substance_a"`
+	SubstanceB  string `json:"substance_b"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+type InteractionModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Import loads interactions into the local interaction table, replacing the severity and
+// description of pairs which are already known.
+func (m InteractionModel) Import(interactions []*Interaction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TEMP TABLE interactions_import (substance_a text, substance_b text, severity text, description text)
+		ON COMMIT DROP
+		`)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("interactions_import", "substance_a", "substance_b", "severity", "description"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, in := range interactions {
+		// Store every pair in a canonical order, see the CHECK constraint on the table.
+		a, b := NormalizeSubstance(in.SubstanceA), NormalizeSubstance(in.SubstanceB)
+		if a > b {
+			a, b = b, a
+		}
+		if _, err := stmt.ExecContext(ctx, a, b, in.Severity, in.Description); err != nil {
+			stmt.Close()
+			return 0, err
+		}
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return 0, err
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO drug_interactions (substance_a, substance_b, severity, description)
+		SELECT DISTINCT ON (substance_a, substance_b) substance_a, substance_b, severity, description
+		FROM interactions_import
+		WHERE substance_a <> '' AND substance_a < substance_b
+		ORDER BY substance_a, substance_b
+		ON CONFLICT (substance_a, substance_b) DO UPDATE
+		SET severity = EXCLUDED.severity, description = EXCLUDED.description
+		`)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+// Between returns the known interactions between any substance of the first list and any
+// substance of the second one.
+func (m InteractionModel) Between(substances, others []string) ([]*Interaction, error) {
+	query := `
+		SELECT id, substance_a, substance_b, severity, description
+		FROM drug_interactions
+		WHERE (substance_a = ANY($1) AND substance_b = ANY($2))
+			OR (substance_a = ANY($2) AND substance_b = ANY($1))
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(substances), pq.Array(others))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var interactions []*Interaction
+	for rows.Next() {
+		var in Interaction
+		if err := rows.Scan(&in.ID, &in.SubstanceA, &in.SubstanceB, &in.Severity, &in.Description); err != nil {
+			return nil, err
+		}
+		interactions = append(interactions, &in)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return interactions, nil
+}
@@ -0,0 +1,967 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// Invoice statuses. Drafts can be changed; issuing one gives it a number. Issued invoices are
+// partially paid or paid once part or all of their total is settled by payments or credit
+// notes. Only drafts and issued invoices which nothing has settled yet can be voided.
+const (
+	InvoiceDraft         = "draft"
+	InvoiceIssued        = "issued"
+	InvoicePartiallyPaid = "partially_paid"
+	InvoicePaid          = "paid"
+	InvoiceVoid          = "void"
+)
+
+// Kinds of invoices. A credit note corrects an invoice: it takes lines of the invoice, or
+// parts of them, back.
+const (
+	KindInvoice    = "invoice"
+	KindCreditNote = "credit_note"
+)
+
+// PaymentMethods are the ways a payment or a refund can be made.
+var PaymentMethods = []string{"cash", "card", "transfer"}
+
+// PaymentInsurance is the method of payments made by payers, which are recorded from their
+// remittances.
+const PaymentInsurance = "insurance"
+
+// invoiceLockNamespace is the first key of the advisory lock which serializes the numbering of
+// a tenant's invoices; the second key is the tenant's ID.
+const invoiceLockNamespace = 4
+
+// ErrAlreadyInvoiced is returned when an appointment which has an invoice is invoiced again,
+// or its services are changed after the invoice was issued.
+var ErrAlreadyInvoiced = errors.New("appointment has already been invoiced")
+
+// ErrAppointmentNotCompleted is returned when an appointment which is cancelled or hasn't
+// taken place yet is invoiced.
+var ErrAppointmentNotCompleted = errors.New("only appointments which have taken place can be invoiced")
+
+// ErrEmptyInvoice is returned when an invoice without lines is issued, or an invoice is made
+// for an appointment without services.
+var ErrEmptyInvoice = errors.New("invoice has no lines")
+
+// ErrNotDraft is returned when an invoice which has been issued or voided is changed or issued.
+var ErrNotDraft = errors.New("only draft invoices can be changed or issued")
+
+// ErrCannotVoid is returned when an invoice which has been settled in part, or a credit note,
+// is voided. A credit note corrects it instead.
+var ErrCannotVoid = errors.New("only drafts and issued invoices without payments or credit notes can be voided")
+
+// ErrNotPayable is returned when a payment is made for an invoice which isn't issued or is
+// already paid, or when it is more than the balance.
+var ErrNotPayable = errors.New("payment must not be more than the balance of an issued invoice")
+
+// ErrNotCreditable is returned when a credit note is issued for a draft, a void invoice or a
+// credit note, or takes back more than what remains of a line.
+var ErrNotCreditable = errors.New("only what remains of the lines of an issued invoice can be credited")
+
+// Invoice is an invoice or a credit note. Amounts are in minor units of Currency. Settled is
+// the part of Total which has been settled and Balance what remains: for an invoice, what the
+// patient owes; for a credit note, what is still to be refunded.
+type Invoice struct {
+	ID                int64          `json:"id"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	Kind              string         `json:"kind"`
+	Number            string         `json:"number,omitempty"`
+	Status            string         `json:"status"`
+	PatientID         int64          `json:"patient_id"`
+	AppointmentID     *int64         `json:"appointment_id,omitempty"`
+	BranchID          *int64         `json:"branch_id,omitempty"`
+	CreditedInvoiceID *int64         `json:"credited_invoice_id,omitempty"`
+	Currency          string         `json:"currency"`
+	Discount          int64          `json:"discount"`
+	Net               int64          `json:"net"`
+	VAT               int64          `json:"vat"`
+	Total             int64          `json:"total"`
+	Settled           int64          `json:"settled"`
+	Balance           int64          `json:"balance"`
+	Notes             string         `json:"notes"`
+	IssuedAt          *time.Time     `json:"issued_at,omitempty"`
+	DueDate           *string        `json:"due_date,omitempty"`
+	VoidedAt          *time.Time     `json:"voided_at,omitempty"`
+	VoidReason        string         `json:"void_reason,omitempty"`
+	CreatedBy         *int64         `json:"created_by,omitempty"`
+	Lines             []*InvoiceLine `json:"lines,omitempty"`
+	Payments          []*Payment     `json:"payments,omitempty"`
+	CreditNotes       []*Invoice     `json:"credit_notes,omitempty"`
+}
+
+// InvoiceLine is a line of an invoice. Discount is taken off Quantity * UnitPrice, which leaves
+// Net; VAT is the tax on Net at VATRate, in hundredths of a percent.
+type InvoiceLine struct {
+	ID             int64  `json:"id"`
+	Position       int    `json:"position"`
+	ServiceID      *int64 `json:"service_id,omitempty"`
+	CreditedLineID *int64 `json:"credited_line_id,omitempty"`
+	Description    string `json:"description"`
+	Quantity       int    `json:"quantity"`
+	UnitPrice      int64  `json:"unit_price"`
+	Discount       int64  `json:"discount"`
+	VATRate        int    `json:"vat_rate"`
+	Net            int64  `json:"net"`
+	VAT            int64  `json:"vat"`
+	Total          int64  `json:"total"`
+}
+
+// Payment is a payment of an invoice or, for a credit note, a refund.
+type Payment struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	InvoiceID  int64     `json:"invoice_id"`
+	Method     string    `json:"method"`
+	Amount     int64     `json:"amount"`
+	Reference  string    `json:"reference"`
+	PaidAt     time.Time `json:"paid_at"`
+	ReceivedBy *int64    `json:"received_by,omitempty"`
+	ShiftID    *int64    `json:"shift_id,omitempty"`
+}
+
+// CreditLine says how much of a line of an invoice a credit note takes back.
+type CreditLine struct {
+	LineID   int64 `json:"line_id"`
+	Quantity int   `json:"quantity"`
+}
+
+// Compute sets the net amount, VAT and total of the line.
+func (l *InvoiceLine) Compute() {
+	l.Net = int64(l.Quantity)*l.UnitPrice - l.Discount
+	l.VAT = VATAmount(l.Net, l.VATRate)
+	l.Total = l.Net + l.VAT
+}
+
+// computeTotals numbers the lines and sums them up.
+func (inv *Invoice) computeTotals() {
+	inv.Discount, inv.Net, inv.VAT, inv.Total = 0, 0, 0, 0
+	for i, l := range inv.Lines {
+		l.Position = i + 1
+		inv.Discount += l.Discount
+		inv.Net += l.Net
+		inv.VAT += l.VAT
+		inv.Total += l.Total
+	}
+}
+
+// settledStatus returns the status of an issued invoice with the given total and settled
+// amount.
+func settledStatus(total, settled int64) string {
+	switch {
+	case settled >= total:
+		return InvoicePaid
+	case settled > 0:
+		return InvoicePartiallyPaid
+	default:
+		return InvoiceIssued
+	}
+}
+
+// maxUnitPrice keeps the amounts of invoices far from overflowing.
+const maxUnitPrice = 1_000_000_000_00
+
+type InvoiceModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateInvoice(v *validator.Validator, inv *Invoice) {
+	v.Check(inv.PatientID > 0, "patient_id", "must be provided")
+	v.Check(inv.AppointmentID == nil || *inv.AppointmentID > 0, "appointment_id", "must be a positive integer")
+	v.Check(validator.Matches(inv.Currency, CurrencyRX), "currency", "must be an ISO 4217 code such as EUR")
+	v.Check(len(inv.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+	if inv.DueDate != nil {
+		_, err := time.Parse("2006-01-02", *inv.DueDate)
+		v.Check(err == nil, "due_date", "must be a date such as 2024-07-01")
+	}
+	v.Check(len(inv.Lines) <= 100, "lines", "must not contain more than 100 lines")
+	for i, l := range inv.Lines {
+		ValidateInvoiceLine(v, fmt.Sprintf("lines[%d].", i), l)
+	}
+}
+
+// ValidateInvoiceLine checks a line; prefix is put before the keys of the errors.
+func ValidateInvoiceLine(v *validator.Validator, prefix string, l *InvoiceLine) {
+	v.Check(l.Description != "", prefix+"description", "must be provided")
+	v.Check(len(l.Description) <= 500, prefix+"description", "must not be more than 500 bytes long")
+	v.Check(l.Quantity > 0, prefix+"quantity", "must be greater than zero")
+	v.Check(l.Quantity <= 1000, prefix+"quantity", "must not be more than 1000")
+	v.Check(l.UnitPrice >= 0, prefix+"unit_price", "must not be negative")
+	v.Check(l.UnitPrice <= maxUnitPrice, prefix+"unit_price", "is too large")
+	v.Check(l.Discount >= 0, prefix+"discount", "must not be negative")
+	if l.UnitPrice >= 0 && l.UnitPrice <= maxUnitPrice && l.Quantity > 0 && l.Quantity <= 1000 {
+		v.Check(l.Discount <= int64(l.Quantity)*l.UnitPrice, prefix+"discount", "must not be more than the amount of the line")
+	}
+	v.Check(l.VATRate >= 0 && l.VATRate <= 10000, prefix+"vat_rate", "must be between 0 and 10000 hundredths of a percent")
+}
+
+func ValidatePayment(v *validator.Validator, p *Payment) {
+	v.Check(validator.In(p.Method, PaymentMethods...), "method", "must be one of "+strings.Join(PaymentMethods, ", "))
+	v.Check(p.Amount > 0, "amount", "must be greater than zero")
+	v.Check(len(p.Reference) <= 200, "reference", "must not be more than 200 bytes long")
+	v.Check(!p.PaidAt.After(time.Now().Add(time.Minute)), "paid_at", "must not be in the future")
+}
+
+const invoiceColumns = `
+	id, created_at, updated_at, kind, COALESCE(number, ''), status, patient_id, appointment_id, branch_id,
+	credited_invoice_id, currency, discount, net, vat, total, settled, notes, issued_at,
+	to_char(due_date, 'YYYY-MM-DD'), voided_at, void_reason, created_by`
+
+func scanInvoice(row interface{ Scan(...interface{}) error }, inv *Invoice) error {
+	err := row.Scan(&inv.ID, &inv.CreatedAt, &inv.UpdatedAt, &inv.Kind, &inv.Number, &inv.Status, &inv.PatientID,
+		&inv.AppointmentID, &inv.BranchID, &inv.CreditedInvoiceID, &inv.Currency, &inv.Discount, &inv.Net, &inv.VAT,
+		&inv.Total, &inv.Settled, &inv.Notes, &inv.IssuedAt, &inv.DueDate, &inv.VoidedAt, &inv.VoidReason,
+		&inv.CreatedBy)
+	inv.Balance = inv.Total - inv.Settled
+	return err
+}
+
+const invoiceLineColumns = `
+	id, position, service_id, credited_line_id, description, quantity, unit_price, discount, vat_rate, net, vat, total`
+
+func scanInvoiceLine(row interface{ Scan(...interface{}) error }, l *InvoiceLine) error {
+	return row.Scan(&l.ID, &l.Position, &l.ServiceID, &l.CreditedLineID, &l.Description, &l.Quantity, &l.UnitPrice,
+		&l.Discount, &l.VATRate, &l.Net, &l.VAT, &l.Total)
+}
+
+const paymentColumns = `id, created_at, invoice_id, method, amount, reference, paid_at, received_by, shift_id`
+
+func scanPayment(row interface{ Scan(...interface{}) error }, p *Payment) error {
+	return row.Scan(&p.ID, &p.CreatedAt, &p.InvoiceID, &p.Method, &p.Amount, &p.Reference, &p.PaidAt, &p.ReceivedBy,
+		&p.ShiftID)
+}
+
+// InsertForAppointment makes a draft invoice of the services of an appointment which has taken
+// place, at the prices they were booked for.
+func (m InvoiceModel) InsertForAppointment(appointmentID int64, createdBy *int64) (*Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv := &Invoice{AppointmentID: &appointmentID, CreatedBy: createdBy}
+	if err := checkInvoicedAppointment(ctx, tx, inv); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT x.service_id, s.code || ' ' || s.name, x.amount, x.currency, x.vat_rate
+		FROM appointment_services x
+			JOIN services s ON s.id = x.service_id
+		WHERE x.appointment_id = $1
+		ORDER BY x.position
+		`, appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var serviceID int64
+		l := &InvoiceLine{Quantity: 1, ServiceID: &serviceID}
+		if err := rows.Scan(&serviceID, &l.Description, &l.UnitPrice, &inv.Currency, &l.VATRate); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		l.Compute()
+		inv.Lines = append(inv.Lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(inv.Lines) == 0 {
+		return nil, ErrEmptyInvoice
+	}
+
+	if err := insertInvoice(ctx, tx, inv); err != nil {
+		return nil, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// Insert saves a draft invoice with its lines. If it is for an appointment, the appointment
+// must have taken place and belong to the patient.
+func (m InvoiceModel) Insert(inv *Invoice) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if inv.AppointmentID != nil {
+		patientID := inv.PatientID
+		if err := checkInvoicedAppointment(ctx, tx, inv); err != nil {
+			return err
+		}
+		if inv.PatientID != patientID {
+			return ErrRecordNotFound
+		}
+	} else {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM patients WHERE id = $1)`, inv.PatientID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRecordNotFound
+		}
+	}
+
+	for _, l := range inv.Lines {
+		l.Compute()
+	}
+	if err := insertInvoice(ctx, tx, inv); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkInvoicedAppointment locks the appointment of inv and fills in its patient and branch.
+// ErrAppointmentNotCompleted or ErrAlreadyInvoiced are returned if it can't be invoiced.
+func checkInvoicedAppointment(ctx context.Context, tx *sql.Tx, inv *Invoice) error {
+	var status string
+	var dateTime time.Time
+	err := tx.QueryRowContext(ctx, `
+		SELECT patient_id, branch_id, status, date_time FROM appointments WHERE id = $1 FOR UPDATE
+		`, *inv.AppointmentID).Scan(&inv.PatientID, &inv.BranchID, &status, &dateTime)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if status == AppointmentCancelled || dateTime.After(time.Now()) {
+		return ErrAppointmentNotCompleted
+	}
+
+	var invoiced bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM invoices WHERE appointment_id = $1 AND kind = 'invoice' AND status <> 'void')
+		`, *inv.AppointmentID).Scan(&invoiced)
+	if err != nil {
+		return err
+	}
+	if invoiced {
+		return ErrAlreadyInvoiced
+	}
+	return nil
+}
+
+// insertInvoice saves inv, which hasn't been saved yet, with its lines in tx.
+func insertInvoice(ctx context.Context, tx *sql.Tx, inv *Invoice) error {
+	inv.computeTotals()
+	if inv.Kind == "" {
+		inv.Kind = KindInvoice
+	}
+	if inv.Status == "" {
+		inv.Status = InvoiceDraft
+	}
+	inv.Balance = inv.Total - inv.Settled
+
+	var number *string
+	if inv.Number != "" {
+		number = &inv.Number
+	}
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO invoices (kind, number, status, patient_id, appointment_id, branch_id, credited_invoice_id, currency,
+			discount, net, vat, total, settled, notes, issued_at, due_date, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id, created_at, updated_at
+		`, inv.Kind, number, inv.Status, inv.PatientID, inv.AppointmentID, inv.BranchID, inv.CreditedInvoiceID,
+		inv.Currency, inv.Discount, inv.Net, inv.VAT, inv.Total, inv.Settled, inv.Notes, inv.IssuedAt, inv.DueDate,
+		inv.CreatedBy).Scan(&inv.ID, &inv.CreatedAt, &inv.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrAlreadyInvoiced
+	}
+	if err != nil {
+		return err
+	}
+
+	return insertInvoiceLines(ctx, tx, inv)
+}
+
+func insertInvoiceLines(ctx context.Context, tx *sql.Tx, inv *Invoice) error {
+	for _, l := range inv.Lines {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO invoice_lines (invoice_id, position, service_id, credited_line_id, description, quantity,
+				unit_price, discount, vat_rate, net, vat, total)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING id
+			`, inv.ID, l.Position, l.ServiceID, l.CreditedLineID, l.Description, l.Quantity, l.UnitPrice, l.Discount,
+			l.VATRate, l.Net, l.VAT, l.Total).Scan(&l.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns an invoice or a credit note with its lines and payments, and for an invoice its
+// credit notes.
+func (m InvoiceModel) Get(id int64) (*Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inv Invoice
+	err := scanInvoice(m.DB.QueryRowContext(ctx, `SELECT `+invoiceColumns+` FROM invoices WHERE id = $1`, id), &inv)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if inv.Lines, err = m.lines(ctx, id); err != nil {
+		return nil, err
+	}
+	if inv.Payments, err = m.Payments(id); err != nil {
+		return nil, err
+	}
+	if inv.Kind == KindInvoice {
+		inv.CreditNotes, err = m.GetAll(InvoiceFilter{CreditedInvoiceID: id, Limit: 100})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &inv, nil
+}
+
+func (m InvoiceModel) lines(ctx context.Context, invoiceID int64) ([]*InvoiceLine, error) {
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT `+invoiceLineColumns+` FROM invoice_lines WHERE invoice_id = $1 ORDER BY position
+		`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []*InvoiceLine{}
+	for rows.Next() {
+		var l InvoiceLine
+		if err := scanInvoiceLine(rows, &l); err != nil {
+			return nil, err
+		}
+		lines = append(lines, &l)
+	}
+	return lines, rows.Err()
+}
+
+// Payments returns the payments of an invoice, or the refunds of a credit note, in the order
+// they were made.
+func (m InvoiceModel) Payments(invoiceID int64) ([]*Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT `+paymentColumns+` FROM payments WHERE invoice_id = $1 ORDER BY paid_at, id
+		`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*Payment{}
+	for rows.Next() {
+		var p Payment
+		if err := scanPayment(rows, &p); err != nil {
+			return nil, err
+		}
+		payments = append(payments, &p)
+	}
+	return payments, rows.Err()
+}
+
+// InvoiceFilter narrows down a list of invoices. Zero values match everything; From and To
+// limit the time the invoices were created.
+type InvoiceFilter struct {
+	PatientID         int64
+	AppointmentID     int64
+	CreditedInvoiceID int64
+	Kind              string
+	Status            string
+	From              time.Time
+	To                time.Time
+	Limit             int
+	Offset            int
+}
+
+// GetAll returns the invoices and credit notes matching the filter without their lines,
+// newest first.
+func (m InvoiceModel) GetAll(f InvoiceFilter) ([]*Invoice, error) {
+	query := `
+		SELECT ` + invoiceColumns + `
+		FROM invoices
+		WHERE ($1 = 0 OR patient_id = $1)
+			AND ($2 = 0 OR appointment_id = $2)
+			AND ($3 = 0 OR credited_invoice_id = $3)
+			AND ($4 = '' OR kind = $4)
+			AND ($5 = '' OR status = $5)
+			AND ($6::timestamptz IS NULL OR created_at >= $6)
+			AND ($7::timestamptz IS NULL OR created_at < $7)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $8
+		OFFSET $9
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.PatientID, f.AppointmentID, f.CreditedInvoiceID, f.Kind, f.Status,
+		nullTime(f.From), nullTime(f.To), f.Limit, f.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []*Invoice{}
+	for rows.Next() {
+		var inv Invoice
+		if err := scanInvoice(rows, &inv); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, &inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invoices, nil
+}
+
+// lockInvoice returns the invoice with the given ID, locked for the rest of tx.
+func lockInvoice(ctx context.Context, tx *sql.Tx, id int64) (*Invoice, error) {
+	var inv Invoice
+	err := scanInvoice(tx.QueryRowContext(ctx, `SELECT `+invoiceColumns+` FROM invoices WHERE id = $1 FOR UPDATE`, id),
+		&inv)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &inv, nil
+}
+
+// UpdateDraft replaces the lines, notes and due date of a draft invoice. ErrNotDraft is
+// returned if it has been issued or voided.
+func (m InvoiceModel) UpdateDraft(inv *Invoice) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockInvoice(ctx, tx, inv.ID)
+	if err != nil {
+		return err
+	}
+	if current.Status != InvoiceDraft {
+		return ErrNotDraft
+	}
+
+	for _, l := range inv.Lines {
+		l.Compute()
+	}
+	inv.computeTotals()
+	inv.Balance = inv.Total
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE invoices
+		SET currency = $2, discount = $3, net = $4, vat = $5, total = $6, notes = $7, due_date = $8, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+		`, inv.ID, inv.Currency, inv.Discount, inv.Net, inv.VAT, inv.Total, inv.Notes, inv.DueDate).Scan(&inv.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM invoice_lines WHERE invoice_id = $1`, inv.ID); err != nil {
+		return err
+	}
+	if err := insertInvoiceLines(ctx, tx, inv); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nextInvoiceNumber returns the next number of the tenant's documents of the kind in the year,
+// e.g. INV-2024-000042 or CN-2024-000003. The numbering stays locked for the rest of tx.
+func nextInvoiceNumber(ctx context.Context, tx *sql.Tx, kind string, year int) (string, error) {
+	prefix := fmt.Sprintf("INV-%d-", year)
+	if kind == KindCreditNote {
+		prefix = fmt.Sprintf("CN-%d-", year)
+	}
+	return nextNumber(ctx, tx, invoiceLockNamespace, "invoices", prefix)
+}
+
+// nextNumber returns the number following the highest number with the prefix in the number
+// column of table, under an advisory lock of the namespace held for the rest of tx.
+func nextNumber(ctx context.Context, tx *sql.Tx, namespace int, table, prefix string) (string, error) {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, current_tenant_id()::integer)`, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	var next int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(max(substring(number FROM length($1::text) + 1)::integer), 0) + 1
+		FROM `+table+`
+		WHERE tenant_id = current_tenant_id() AND number LIKE $2
+		`, prefix, escapeLike(prefix)+"%").Scan(&next)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%06d", prefix, next), nil
+}
+
+// Issue gives a draft invoice its number. An invoice of nothing is paid once issued.
+func (m InvoiceModel) Issue(id int64) (*Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := lockInvoice(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != InvoiceDraft {
+		return nil, ErrNotDraft
+	}
+
+	var lines int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM invoice_lines WHERE invoice_id = $1`, id).Scan(&lines); err != nil {
+		return nil, err
+	}
+	if lines == 0 {
+		return nil, ErrEmptyInvoice
+	}
+
+	now := time.Now()
+	number, err := nextInvoiceNumber(ctx, tx, inv.Kind, now.Year())
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanInvoice(tx.QueryRowContext(ctx, `
+		UPDATE invoices
+		SET number = $2, status = $3, issued_at = $4, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+invoiceColumns, id, number, settledStatus(inv.Total, 0), now), inv)
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// Void voids a draft, or an issued invoice which nothing has settled.
+func (m InvoiceModel) Void(id int64, reason string) (*Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := lockInvoice(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Kind != KindInvoice || inv.Settled > 0 || (inv.Status != InvoiceDraft && inv.Status != InvoiceIssued) {
+		return nil, ErrCannotVoid
+	}
+
+	err = scanInvoice(tx.QueryRowContext(ctx, `
+		UPDATE invoices
+		SET status = 'void', voided_at = NOW(), void_reason = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+invoiceColumns, id, reason), inv)
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// InsertPayment records a payment of an issued invoice or a refund of a credit note and
+// updates its status. ErrNotPayable is returned if it is more than the balance.
+func (m InvoiceModel) InsertPayment(p *Payment) (*Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := lockInvoice(ctx, tx, p.InvoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != InvoiceIssued && inv.Status != InvoicePartiallyPaid {
+		return nil, ErrNotPayable
+	}
+	if p.Amount > inv.Balance {
+		return nil, ErrNotPayable
+	}
+
+	if err := insertPayment(ctx, tx, inv, p); err != nil {
+		return nil, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// insertPayment saves a payment of the locked invoice inv and settles it. Payments made at the
+// till belong to the open shift of the cashier who received them, if there is one.
+func insertPayment(ctx context.Context, tx *sql.Tx, inv *Invoice, p *Payment) error {
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO payments (invoice_id, method, amount, reference, paid_at, received_by, shift_id)
+		VALUES ($1, $2, $3, $4, $5, $6, (
+			SELECT id FROM cashier_shifts WHERE user_id = $6 AND closed_at IS NULL AND $2 <> $7 FOR SHARE
+		))
+		RETURNING id, created_at, shift_id
+		`, p.InvoiceID, p.Method, p.Amount, p.Reference, p.PaidAt, p.ReceivedBy, PaymentInsurance).Scan(&p.ID,
+		&p.CreatedAt, &p.ShiftID)
+	if err != nil {
+		return err
+	}
+
+	return settleInvoice(ctx, tx, inv, p.Amount)
+}
+
+// settleInvoice adds amount to what has been settled of inv and updates its status.
+func settleInvoice(ctx context.Context, tx *sql.Tx, inv *Invoice, amount int64) error {
+	settled := inv.Settled + amount
+	return scanInvoice(tx.QueryRowContext(ctx, `
+		UPDATE invoices
+		SET settled = $2, status = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+invoiceColumns, inv.ID, settled, settledStatus(inv.Total, settled)), inv)
+}
+
+// creditRemainder is an invoice line together with what the credit notes issued so far have
+// taken back of it.
+type creditRemainder struct {
+	line                                       InvoiceLine
+	quantity                                   int
+	discount, net, vat                         int64
+	creditedQuantity                           int
+	creditedDiscount, creditedNet, creditedVAT int64
+}
+
+// credit returns a credit note line which takes back quantity units of the line and counts them
+// as credited. The discount is taken back in proportion to the quantity. The last part of a line
+// takes what remains, so that rounding doesn't leave cents behind.
+func (r *creditRemainder) credit(quantity int) (*InvoiceLine, error) {
+	if quantity <= 0 || quantity > r.quantity-r.creditedQuantity {
+		return nil, ErrNotCreditable
+	}
+
+	l := &InvoiceLine{
+		ServiceID:      r.line.ServiceID,
+		CreditedLineID: &r.line.ID,
+		Description:    r.line.Description,
+		Quantity:       quantity,
+		UnitPrice:      r.line.UnitPrice,
+		VATRate:        r.line.VATRate,
+	}
+	if quantity == r.quantity-r.creditedQuantity {
+		l.Discount = r.discount - r.creditedDiscount
+		l.Net = r.net - r.creditedNet
+		l.VAT = r.vat - r.creditedVAT
+	} else {
+		l.Discount = r.discount * int64(quantity) / int64(r.quantity)
+		l.Net = int64(quantity)*l.UnitPrice - l.Discount
+		l.VAT = VATAmount(l.Net, l.VATRate)
+	}
+	l.Total = l.Net + l.VAT
+
+	r.creditedQuantity += quantity
+	r.creditedDiscount += l.Discount
+	r.creditedNet += l.Net
+	r.creditedVAT += l.VAT
+	return l, nil
+}
+
+// InsertCreditNote issues a credit note for an issued invoice which takes back the given
+// quantities of its lines, or everything which hasn't been credited yet if lines is empty. The
+// credit note settles the invoice's balance as far as it goes; the rest is to be refunded.
+func (m InvoiceModel) InsertCreditNote(invoiceID int64, lines []CreditLine, notes string,
+	createdBy *int64) (*Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := lockInvoice(ctx, tx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Kind != KindInvoice || inv.Status == InvoiceDraft || inv.Status == InvoiceVoid {
+		return nil, ErrNotCreditable
+	}
+
+	// What remains of each line is what the credit notes issued so far haven't taken back.
+	rows, err := tx.QueryContext(ctx, `
+		SELECT l.id, l.service_id, l.description, l.quantity, l.unit_price, l.discount, l.vat_rate, l.net, l.vat,
+			COALESCE(sum(c.quantity), 0), COALESCE(sum(c.discount), 0), COALESCE(sum(c.net), 0), COALESCE(sum(c.vat), 0)
+		FROM invoice_lines l
+			LEFT JOIN invoice_lines c ON c.credited_line_id = l.id
+		WHERE l.invoice_id = $1
+		GROUP BY l.id
+		ORDER BY l.position
+		`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	remainders := make(map[int64]*creditRemainder)
+	var order []int64
+	for rows.Next() {
+		var r creditRemainder
+		if err := rows.Scan(&r.line.ID, &r.line.ServiceID, &r.line.Description, &r.quantity, &r.line.UnitPrice,
+			&r.discount, &r.line.VATRate, &r.net, &r.vat, &r.creditedQuantity, &r.creditedDiscount, &r.creditedNet,
+			&r.creditedVAT); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		remainders[r.line.ID] = &r
+		order = append(order, r.line.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 {
+		for _, id := range order {
+			if r := remainders[id]; r.quantity > r.creditedQuantity {
+				lines = append(lines, CreditLine{LineID: id, Quantity: r.quantity - r.creditedQuantity})
+			}
+		}
+		if len(lines) == 0 {
+			return nil, ErrNotCreditable
+		}
+	}
+
+	now := time.Now()
+	cn := &Invoice{
+		Kind:              KindCreditNote,
+		Status:            InvoiceIssued,
+		PatientID:         inv.PatientID,
+		AppointmentID:     inv.AppointmentID,
+		BranchID:          inv.BranchID,
+		CreditedInvoiceID: &inv.ID,
+		Currency:          inv.Currency,
+		Notes:             notes,
+		IssuedAt:          &now,
+		CreatedBy:         createdBy,
+	}
+	for _, cl := range lines {
+		r, ok := remainders[cl.LineID]
+		if !ok {
+			return nil, ErrNotCreditable
+		}
+		l, err := r.credit(cl.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		cn.Lines = append(cn.Lines, l)
+	}
+
+	cn.Number, err = nextInvoiceNumber(ctx, tx, KindCreditNote, now.Year())
+	if err != nil {
+		return nil, err
+	}
+	cn.computeTotals()
+	cn.Settled = min(cn.Total, inv.Balance)
+	cn.Status = settledStatus(cn.Total, cn.Settled)
+	if err := insertInvoice(ctx, tx, cn); err != nil {
+		return nil, err
+	}
+
+	if err := settleInvoice(ctx, tx, inv, cn.Settled); err != nil {
+		return nil, err
+	}
+
+	return cn, tx.Commit()
+}
+
+// CurrencyDigits returns the number of decimal digits of the minor unit of currency.
+func CurrencyDigits(currency string) int {
+	switch currency {
+	case "JPY", "KRW", "VND", "CLP", "ISK", "UGX", "XAF", "XOF":
+		return 0
+	case "BHD", "IQD", "JOD", "KWD", "LYD", "OMR", "TND":
+		return 3
+	default:
+		return 2
+	}
+}
+
+// FormatAmount formats an amount in minor units of currency, e.g. 12345 EUR as "123.45 EUR".
+func FormatAmount(amount int64, currency string) string {
+	return DecimalAmount(amount, currency) + " " + currency
+}
+
+// DecimalAmount formats an amount in minor units of currency as a decimal number, e.g. 12345
+// EUR as "123.45".
+func DecimalAmount(amount int64, currency string) string {
+	digits := CurrencyDigits(currency)
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	if digits == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	unit := int64(1)
+	for i := 0; i < digits; i++ {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, digits, amount%unit)
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVATAmount(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		rate   int
+		want   int64
+	}{
+		{"no VAT", 12345, 0, 0},
+		{"nothing to tax", 0, 2000, 0},
+		{"exact", 10000, 2000, 2000},
+		{"rounds down below half a cent", 1234, 700, 86},
+		{"rounds half a cent up", 1050, 1900, 200},
+		{"rounds up above half a cent", 999, 2000, 200},
+		{"smallest amount rounding up", 1, 5000, 1},
+		{"smallest amount rounding down", 1, 4999, 0},
+		{"fractional rate", 10000, 1250, 1250},
+		{"large amount", 9_999_999_999, 2000, 2_000_000_000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VATAmount(tt.amount, tt.rate); got != tt.want {
+				t.Errorf("VATAmount(%d, %d) = %d, want %d", tt.amount, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvoiceTotals(t *testing.T) {
+	inv := &Invoice{Lines: []*InvoiceLine{
+		{Quantity: 3, UnitPrice: 1999, Discount: 500, VATRate: 2000},
+		{Quantity: 1, UnitPrice: 4550, VATRate: 700},
+		{Quantity: 2, UnitPrice: 1000, Discount: 2000, VATRate: 2000},
+	}}
+	for _, l := range inv.Lines {
+		l.Compute()
+	}
+	inv.computeTotals()
+
+	lines := []struct{ net, vat, total int64 }{
+		{5497, 1099, 6596},
+		{4550, 319, 4869},
+		{0, 0, 0},
+	}
+	for i, want := range lines {
+		l := inv.Lines[i]
+		if l.Position != i+1 || l.Net != want.net || l.VAT != want.vat || l.Total != want.total {
+			t.Errorf("line %d = position %d, net %d, VAT %d, total %d; want position %d, net %d, VAT %d, total %d",
+				i, l.Position, l.Net, l.VAT, l.Total, i+1, want.net, want.vat, want.total)
+		}
+	}
+
+	// VAT is rounded per line, so the invoice's VAT is the sum of the lines' VAT.
+	if inv.Discount != 2500 || inv.Net != 10047 || inv.VAT != 1418 || inv.Total != 11465 {
+		t.Errorf("totals = discount %d, net %d, VAT %d, total %d; want 2500, 10047, 1418, 11465",
+			inv.Discount, inv.Net, inv.VAT, inv.Total)
+	}
+}
+
+func TestCreditRemainder(t *testing.T) {
+	// newRemainder returns a line of quantity units at 1000 with a discount and 20% VAT, of which
+	// nothing has been credited yet.
+	newRemainder := func(quantity int, discount int64) *creditRemainder {
+		l := InvoiceLine{ID: 7, Description: "Physiotherapy", Quantity: quantity, UnitPrice: 1000, Discount: discount, VATRate: 2000}
+		l.Compute()
+		return &creditRemainder{line: l, quantity: quantity, discount: l.Discount, net: l.Net, vat: l.VAT}
+	}
+
+	type part struct{ discount, net, vat int64 }
+	tests := []struct {
+		name     string
+		quantity int
+		discount int64
+		credits  []int
+		want     []part
+	}{
+		{
+			name: "whole line at once", quantity: 3, discount: 100, credits: []int{3},
+			want: []part{{100, 2900, 580}},
+		},
+		{
+			name: "one unit at a time", quantity: 3, discount: 100, credits: []int{1, 1, 1},
+			want: []part{{33, 967, 193}, {33, 967, 193}, {34, 966, 194}},
+		},
+		{
+			name: "most of the line, then the rest", quantity: 3, discount: 100, credits: []int{2, 1},
+			want: []part{{66, 1934, 387}, {34, 966, 193}},
+		},
+		{
+			name: "without discount", quantity: 4, discount: 0, credits: []int{1, 3},
+			want: []part{{0, 1000, 200}, {0, 3000, 600}},
+		},
+		{
+			name: "discount smaller than the quantity", quantity: 7, discount: 5, credits: []int{1, 1, 5},
+			want: []part{{0, 1000, 200}, {0, 1000, 200}, {5, 4995, 999}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRemainder(tt.quantity, tt.discount)
+
+			var discount, net, vat int64
+			for i, q := range tt.credits {
+				l, err := r.credit(q)
+				if err != nil {
+					t.Fatalf("credit %d: %v", i, err)
+				}
+				got := part{l.Discount, l.Net, l.VAT}
+				if got != tt.want[i] {
+					t.Errorf("credit %d = %+v, want %+v", i, got, tt.want[i])
+				}
+				if l.Quantity != q || l.Total != l.Net+l.VAT || l.CreditedLineID == nil || *l.CreditedLineID != 7 {
+					t.Errorf("credit %d = %+v, want quantity %d of line 7 with total = net + VAT", i, l, q)
+				}
+				discount, net, vat = discount+l.Discount, net+l.Net, vat+l.VAT
+			}
+
+			// Crediting the whole line takes back exactly what was invoiced.
+			if discount != r.discount || net != r.net || vat != r.vat {
+				t.Errorf("credited discount %d, net %d, VAT %d; invoiced %d, %d, %d",
+					discount, net, vat, r.discount, r.net, r.vat)
+			}
+			if _, err := r.credit(1); !errors.Is(err, ErrNotCreditable) {
+				t.Errorf("credit after the whole line: error = %v, want ErrNotCreditable", err)
+			}
+		})
+	}
+
+	t.Run("invalid quantities", func(t *testing.T) {
+		r := newRemainder(2, 0)
+		for _, q := range []int{0, -1, 3} {
+			if _, err := r.credit(q); !errors.Is(err, ErrNotCreditable) {
+				t.Errorf("credit(%d) error = %v, want ErrNotCreditable", q, err)
+			}
+		}
+		if r.creditedQuantity != 0 {
+			t.Errorf("rejected credits changed the credited quantity to %d", r.creditedQuantity)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/fieldcrypt"
+	"GoClinic/pkg/web/validator"
+	"github.com/lib/pq"
+)
+
+// Lab order statuses, in the order an order moves through them.
+const (
+	LabOrderOrdered   = "ordered"
+	LabOrderCollected = "collected"
+	LabOrderResulted  = "resulted"
+	LabOrderReviewed  = "reviewed"
+)
+
+// Result flags, computed from the reference range when a result is entered.
+const (
+	LabFlagLow    = "low"
+	LabFlagNormal = "normal"
+	LabFlagHigh   = "high"
+)
+
+// ErrInvalidStatusTransition is returned when a record is moved to a status which can't follow
+// its current one.
+var ErrInvalidStatusTransition = errors.New("invalid status transition")
+
+// labOrderTransitions lists the statuses each status may move to. Results may be corrected
+// while an order is resulted, which is why resulted can follow itself.
+var labOrderTransitions = map[string][]string{
+	LabOrderOrdered:   {LabOrderCollected},
+	LabOrderCollected: {LabOrderResulted},
+	LabOrderResulted:  {LabOrderResulted, LabOrderReviewed},
+}
+
+// LabOrder is a set of laboratory tests ordered during an appointment.
+type LabOrder struct {
+	ID            int64        `json:"id"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+	AppointmentID int64        `json:"appointment_id"`
+	PatientID     int64        `json:"patient_id"`
+	DoctorID      int64        `json:"doctor_id"`
+	Tests         []string     `json:"tests"`
+	Notes         string       `json:"notes"`
+	Status        string       `json:"status"`
+	CollectedAt   *time.Time   `json:"collected_at,omitempty"`
+	ResultedAt    *time.Time   `json:"resulted_at,omitempty"`
+	ReviewedAt    *time.Time   `json:"reviewed_at,omitempty"`
+	ReviewedBy    *int64       `json:"reviewed_by,omitempty"`
+	Results       []*LabResult `json:"results"`
+}
+
+// LabResult is the measured value of a single analyte.
+type LabResult struct {
+	ID         int64     `json:"id"`
+	OrderID    int64     `json:"order_id"`
+	Analyte    string    `json:"analyte"`
+	Value      float64   `json:"value"`
+	Unit       string    `json:"unit"`
+	RefLow     *float64  `json:"ref_low,omitempty"`
+	RefHigh    *float64  `json:"ref_high,omitempty"`
+	Flag       string    `json:"flag"`
+	ResultedAt time.Time `json:"resulted_at"`
+	// CollectedAt is when the sample was taken. It is only set in the result history.
+	CollectedAt *time.Time `json:"collected_at,omitempty"`
+}
+
+type LabOrderModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Fields   *fieldcrypt.Cipher
+}
+
+// NormalizeAnalyte lower-cases an analyte name so results of the same analyte line up in the
+// history regardless of how they were typed in.
+func NormalizeAnalyte(analyte string) string {
+	return strings.ToLower(strings.Join(strings.Fields(analyte), " "))
+}
+
+// SampleTime is when the measured sample was taken, or when the result was entered if the
+// order was never marked as collected.
+func (r *LabResult) SampleTime() time.Time {
+	if r.CollectedAt != nil {
+		return *r.CollectedAt
+	}
+	return r.ResultedAt
+}
+
+// ComputeFlag sets the flag of a result from its reference range. Results without a range are
+// considered normal.
+func (r *LabResult) ComputeFlag() {
+	switch {
+	case r.RefLow != nil && r.Value < *r.RefLow:
+		r.Flag = LabFlagLow
+	case r.RefHigh != nil && r.Value > *r.RefHigh:
+		r.Flag = LabFlagHigh
+	default:
+		r.Flag = LabFlagNormal
+	}
+}
+
+func ValidateLabOrder(v *validator.Validator, order *LabOrder) {
+	v.Check(len(order.Tests) > 0, "tests", "must contain at least one test")
+	v.Check(validator.Unique(order.Tests), "tests", "must not contain duplicate values")
+	for _, t := range order.Tests {
+		v.Check(strings.TrimSpace(t) != "", "tests", "must not contain empty values")
+	}
+	v.Check(len(order.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+}
+
+func ValidateLabResult(v *validator.Validator, result *LabResult) {
+	v.Check(result.Analyte != "", "analyte", "must be provided")
+	v.Check(len(result.Unit) <= 50, "unit", "must not be more than 50 bytes long")
+	if result.RefLow != nil && result.RefHigh != nil {
+		v.Check(*result.RefLow <= *result.RefHigh, "ref_low", "must not be greater than ref_high")
+	}
+}
+
+// Insert creates an order for an appointment; the patient and doctor are taken from the
+// appointment. If the appointment doesn't exist ErrRecordNotFound is returned.
+func (m LabOrderModel) Insert(order *LabOrder) error {
+	query := `
+		INSERT INTO lab_orders (appointment_id, patient_id, doctor_id, tests, notes)
+		SELECT a.id, a.patient_id, a.doctor_id, $2, $3
+		FROM appointments a
+		WHERE a.id = $1
+		RETURNING id, created_at, updated_at, patient_id, doctor_id, status
+		`
+
+	notes, err := m.Fields.Encrypt(order.Notes)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, order.AppointmentID, pq.Array(order.Tests), notes).Scan(
+		&order.ID, &order.CreatedAt, &order.UpdatedAt, &order.PatientID, &order.DoctorID, &order.Status,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	order.Results = []*LabResult{}
+	return nil
+}
+
+const labOrderColumns = `id, created_at, updated_at, appointment_id, patient_id, doctor_id, tests, notes, status,
+	collected_at, resulted_at, reviewed_at, reviewed_by`
+
+func scanLabOrder(row interface{ Scan(...interface{}) error }, o *LabOrder) error {
+	return row.Scan(
+		&o.ID, &o.CreatedAt, &o.UpdatedAt, &o.AppointmentID, &o.PatientID, &o.DoctorID, pq.Array(&o.Tests),
+		&o.Notes, &o.Status, &o.CollectedAt, &o.ResultedAt, &o.ReviewedAt, &o.ReviewedBy,
+	)
+}
+
+// Get retrieves an order together with its results.
+func (m LabOrderModel) Get(id int64) (*LabOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order LabOrder
+
+	err := scanLabOrder(m.DB.QueryRowContext(ctx, `SELECT `+labOrderColumns+` FROM lab_orders WHERE id = $1`, id), &order)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if err := decryptAll(m.Fields, &order.Notes); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT id, order_id, analyte, value, unit, ref_low, ref_high, flag, resulted_at
+		FROM lab_results
+		WHERE order_id = $1
+		ORDER BY analyte
+		`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	order.Results = []*LabResult{}
+	for rows.Next() {
+		var r LabResult
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.Analyte, &r.Value, &r.Unit, &r.RefLow, &r.RefHigh, &r.Flag, &r.ResultedAt); err != nil {
+			return nil, err
+		}
+		order.Results = append(order.Results, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// GetForPatient returns the orders of a patient, newest first, without their results.
+func (m LabOrderModel) GetForPatient(patientID int64) ([]*LabOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT `+labOrderColumns+`
+		FROM lab_orders
+		WHERE patient_id = $1
+		ORDER BY created_at DESC, id DESC
+		`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*LabOrder{}
+	for rows.Next() {
+		var o LabOrder
+		if err := scanLabOrder(rows, &o); err != nil {
+			return nil, err
+		}
+		if err := decryptAll(m.Fields, &o.Notes); err != nil {
+			return nil, err
+		}
+		orders = append(orders, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// SetStatus moves an order to the collected or reviewed status. The update only succeeds if the
+// order still has the status it was read with, otherwise ErrEditConflict is returned.
+func (m LabOrderModel) SetStatus(order *LabOrder, status string, userID int64) error {
+	if !validator.In(status, labOrderTransitions[order.Status]...) || status == LabOrderResulted {
+		return ErrInvalidStatusTransition
+	}
+
+	query := `
+		UPDATE lab_orders
+		SET status = $1,
+			collected_at = CASE WHEN $1 = 'collected' THEN NOW() ELSE collected_at END,
+			reviewed_at = CASE WHEN $1 = 'reviewed' THEN NOW() ELSE reviewed_at END,
+			reviewed_by = CASE WHEN $1 = 'reviewed' THEN $2 ELSE reviewed_by END,
+			updated_at = NOW()
+		WHERE id = $3 AND status = $4
+		RETURNING updated_at, collected_at, reviewed_at, reviewed_by
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, status, userID, order.ID, order.Status).Scan(
+		&order.UpdatedAt, &order.CollectedAt, &order.ReviewedAt, &order.ReviewedBy,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	order.Status = status
+	return nil
+}
+
+// AddResults stores results for an order and moves it to the resulted status. Entering a result
+// for an analyte which already has one replaces it, so results can be corrected until the order
+// is reviewed. Flags are computed from the reference ranges.
+func (m LabOrderModel) AddResults(order *LabOrder, results []*LabResult) error {
+	if !validator.In(LabOrderResulted, labOrderTransitions[order.Status]...) {
+		return ErrInvalidStatusTransition
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE lab_orders
+		SET status = 'resulted', resulted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = $2
+		RETURNING updated_at, resulted_at
+		`, order.ID, order.Status).Scan(&order.UpdatedAt, &order.ResultedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	for _, r := range results {
+		r.OrderID = order.ID
+		r.Analyte = NormalizeAnalyte(r.Analyte)
+		r.ComputeFlag()
+
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO lab_results (order_id, analyte, value, unit, ref_low, ref_high, flag)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (order_id, analyte) DO UPDATE
+			SET value = EXCLUDED.value, unit = EXCLUDED.unit, ref_low = EXCLUDED.ref_low,
+				ref_high = EXCLUDED.ref_high, flag = EXCLUDED.flag, resulted_at = NOW()
+			RETURNING id, resulted_at
+			`, r.OrderID, r.Analyte, r.Value, r.Unit, r.RefLow, r.RefHigh, r.Flag,
+		).Scan(&r.ID, &r.ResultedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	order.Status = LabOrderResulted
+	return nil
+}
+
+// GetResultHistory returns a patient's results grouped by analyte and unit, each group in the
+// order the samples were taken. It is optionally limited to one analyte and a range of sample
+// times. A zero from or to leaves that end of the range open.
+func (m LabOrderModel) GetResultHistory(patientID int64, analyte string, from, to time.Time) ([]*LabResult, error) {
+	query := `
+		SELECT r.id, r.order_id, r.analyte, r.value, r.unit, r.ref_low, r.ref_high, r.flag, r.resulted_at,
+			o.collected_at
+		FROM lab_results r
+			INNER JOIN lab_orders o ON o.id = r.order_id
+		WHERE o.patient_id = $1
+			AND ($2 = '' OR r.analyte = $2)
+			AND ($3::timestamptz IS NULL OR COALESCE(o.collected_at, r.resulted_at) >= $3)
+			AND ($4::timestamptz IS NULL OR COALESCE(o.collected_at, r.resulted_at) < $4)
+		ORDER BY r.analyte, r.unit, COALESCE(o.collected_at, r.resulted_at), r.id
+		`
+
+	nullTime := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, NormalizeAnalyte(analyte), nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*LabResult{}
+	for rows.Next() {
+		var r LabResult
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.Analyte, &r.Value, &r.Unit, &r.RefLow, &r.RefHigh, &r.Flag, &r.ResultedAt,
+			&r.CollectedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
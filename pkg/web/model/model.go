@@ -4,15 +4,43 @@ import (
 	"database/sql"
 	"log"
 	"os"
+	"strings"
 )
 
 type Models struct {
-	Patients     PatientModel
-	Doctors      DoctorModel
-	Appointments AppointmentModel
-	Users        UserModel
-	Tokens       TokenModel
-	Permissions  PermissionModel
+	Patients      PatientModel
+	Doctors       DoctorModel
+	Appointments  AppointmentModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	ICD10         ICD10Model
+	Diagnoses     DiagnosisModel
+	Drugs         DrugModel
+	Prescriptions PrescriptionModel
+	Allergies     AllergyModel
+	Interactions  InteractionModel
+	LabOrders     LabOrderModel
+	Documents     DocumentModel
+	ExternalIDs   ExternalIDModel
+	HL7Messages   HL7MessageModel
+	CalendarFeeds CalendarFeedModel
+	Reminders     ReminderModel
+	Waitlist      WaitlistModel
+	Series        AppointmentSeriesModel
+	Closures      ClosureModel
+	Queue         QueueModel
+	Branches      BranchModel
+	Tenants       TenantModel
+	Services      ServiceModel
+	Invoices      InvoiceModel
+	Insurance     InsuranceModel
+	Claims        ClaimModel
+	Shifts        ShiftModel
+	Reports       ReportModel
+	Imports       ImportModel
+	Exports       ExportModel
+	Encryption    EncryptionModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -49,5 +77,145 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		ICD10: ICD10Model{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Diagnoses: DiagnosisModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Drugs: DrugModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Prescriptions: PrescriptionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Allergies: AllergyModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Interactions: InteractionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		LabOrders: LabOrderModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Documents: DocumentModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		ExternalIDs: ExternalIDModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		HL7Messages: HL7MessageModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		CalendarFeeds: CalendarFeedModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Reminders: ReminderModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Waitlist: WaitlistModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Series: AppointmentSeriesModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Closures: ClosureModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Queue: QueueModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Branches: BranchModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Tenants: TenantModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Services: ServiceModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Invoices: InvoiceModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Insurance: InsuranceModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Claims: ClaimModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Shifts: ShiftModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Reports: ReportModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Imports: ImportModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Exports: ExportModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Encryption: EncryptionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
 	}
 }
+
+// escapeLike escapes the LIKE wildcards in s so it can be used as a literal prefix.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
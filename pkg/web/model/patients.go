@@ -3,10 +3,14 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"time"
+
+	"GoClinic/pkg/web/fieldcrypt"
+	"GoClinic/pkg/web/validator"
 )
 
 type Patient struct {
@@ -16,12 +20,32 @@ type Patient struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 	Phone     string `json:"phone"`
+	Email     string `json:"email"`
+	// NationalID is encrypted at rest and looked up by its blind index.
+	NationalID string `json:"nationalId"`
+}
+
+// ErrDuplicateNationalID is returned when a patient is given the national ID of another.
+var ErrDuplicateNationalID = errors.New("another patient has this national ID")
+
+// ValidatePatient checks a patient's details. The email address is optional.
+func ValidatePatient(v *validator.Validator, p *Patient) {
+	v.Check(p.FirstName != "", "first_name", "must be provided")
+	v.Check(len(p.FirstName) <= 200, "first_name", "must not be more than 200 bytes long")
+	v.Check(p.LastName != "", "last_name", "must be provided")
+	v.Check(len(p.LastName) <= 200, "last_name", "must not be more than 200 bytes long")
+	v.Check(len(p.Phone) <= 50, "phone", "must not be more than 50 bytes long")
+	v.Check(len(p.NationalID) <= 50, "national_id", "must not be more than 50 bytes long")
+	if p.Email != "" {
+		ValidateEmail(v, p.Email)
+	}
 }
 
 type PatientModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	Fields   *fieldcrypt.Cipher
 }
 
 type Filters struct {
@@ -30,26 +54,62 @@ type Filters struct {
 	Sort          string
 	SortDirection string
 	SortSafelist  []string
+	// BranchID limits the records to those of a branch if it isn't zero.
+	BranchID int
 }
 
 func (m PatientModel) Insert(patient *Patient) error {
 	// Insert a new patient into the database.
 	query := `
-		INSERT INTO patients (first_name, last_name, phone) 
-		VALUES ($1, $2, $3) 
+		INSERT INTO patients (first_name, last_name, phone, email, national_id, national_id_index) 
+		VALUES ($1, $2, $3, $4, $5, $6) 
 		RETURNING id, created_at, updated_at
 		`
-	args := []interface{}{patient.FirstName, patient.LastName, patient.Phone}
+	phone, nationalID, index, err := m.encrypt(patient)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{patient.FirstName, patient.LastName, phone, patient.Email, nationalID, index}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&patient.Id, &patient.CreatedAt, &patient.UpdatedAt)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&patient.Id, &patient.CreatedAt, &patient.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateNationalID
+	}
+	return err
+}
+
+// encrypt returns the encrypted phone number and national ID of a patient, and the blind index
+// of the national ID, nil without one.
+func (m PatientModel) encrypt(patient *Patient) (phone, nationalID string, index *string, err error) {
+	if phone, err = m.Fields.Encrypt(patient.Phone); err != nil {
+		return "", "", nil, err
+	}
+	if nationalID, err = m.Fields.Encrypt(patient.NationalID); err != nil {
+		return "", "", nil, err
+	}
+	if patient.NationalID != "" {
+		i := m.Fields.BlindIndex(blindIndexField("patients", "national_id"), NormalizeNationalID(patient.NationalID))
+		index = &i
+	}
+	return phone, nationalID, index, nil
+}
+
+// scan reads a patient's columns, as selected by the queries above, and decrypts them.
+func (m PatientModel) scan(row interface{ Scan(...interface{}) error }, patient *Patient) error {
+	err := row.Scan(&patient.Id, &patient.CreatedAt, &patient.UpdatedAt, &patient.FirstName, &patient.LastName,
+		&patient.Phone, &patient.Email, &patient.NationalID)
+	if err != nil {
+		return err
+	}
+	return decryptAll(m.Fields, &patient.Phone, &patient.NationalID)
 }
 
 func (m PatientModel) Get(id int) (*Patient, error) {
 	// Retrieve a specific patient based on his ID.
 	query := `
-		SELECT id, created_at, updated_at, first_name, last_name, phone
+		SELECT id, created_at, updated_at, first_name, last_name, phone, email, national_id
 		FROM patients
 		WHERE id = $1
 		`
@@ -58,8 +118,11 @@ func (m PatientModel) Get(id int) (*Patient, error) {
 	defer cancel()
 
 	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&patient.Id, &patient.CreatedAt, &patient.UpdatedAt, &patient.FirstName, &patient.LastName, &patient.Phone)
+	err := m.scan(row, &patient)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, err
 	}
 	return &patient, nil
@@ -69,15 +132,47 @@ func (m PatientModel) Update(patient *Patient) error {
 	// Update a specific patient in the database.
 	query := `
 		UPDATE patients
-		SET first_name = $1, last_name = $2, phone = $3
-		WHERE id = $4
+		SET first_name = $1, last_name = $2, phone = $3, email = $4, national_id = $5, national_id_index = $6,
+			updated_at = NOW()
+		WHERE id = $7
 		RETURNING updated_at
 		`
-	args := []interface{}{patient.FirstName, patient.LastName, patient.Phone, patient.Id}
+	phone, nationalID, index, err := m.encrypt(patient)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{patient.FirstName, patient.LastName, phone, patient.Email, nationalID, index, patient.Id}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&patient.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateNationalID
+	}
+	return err
+}
+
+// GetByNationalID returns the patient with the given national ID, however it is written. The
+// lookup goes by the ID's blind index, so it doesn't decrypt other patients' IDs.
+func (m PatientModel) GetByNationalID(nationalID string) (*Patient, error) {
+	query := `
+		SELECT id, created_at, updated_at, first_name, last_name, phone, email, national_id
+		FROM patients
+		WHERE national_id_index = $1
+		`
+	index := m.Fields.BlindIndex(blindIndexField("patients", "national_id"), NormalizeNationalID(nationalID))
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&patient.UpdatedAt)
+	var patient Patient
+	if err := m.scan(m.DB.QueryRowContext(ctx, query, index), &patient); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &patient, nil
 }
 
 func (m PatientModel) Delete(id int) error {
@@ -112,8 +207,9 @@ func (f Filters) sortDirection() string {
 func (m PatientModel) GetAllSortedByName(filters Filters) ([]*Patient, error) {
 	query := fmt.Sprintf(
 		`
-        SELECT id, created_at, updated_at, first_name, last_name, phone
+        SELECT id, created_at, updated_at, first_name, last_name, phone, email, national_id
         FROM patients
+        WHERE $1 = 0 OR id IN (SELECT patient_id FROM appointments WHERE branch_id = $1)
         ORDER BY %s %s`,
 		filters.sortColumn(),
 		filters.sortDirection(),
@@ -121,7 +217,7 @@ func (m PatientModel) GetAllSortedByName(filters Filters) ([]*Patient, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, filters.BranchID)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +226,7 @@ func (m PatientModel) GetAllSortedByName(filters Filters) ([]*Patient, error) {
 	var patients []*Patient
 	for rows.Next() {
 		var patient Patient
-		if err := rows.Scan(&patient.Id, &patient.CreatedAt, &patient.UpdatedAt, &patient.FirstName, &patient.LastName, &patient.Phone); err != nil {
+		if err := m.scan(rows, &patient); err != nil {
 			return nil, err
 		}
 		patients = append(patients, &patient)
@@ -142,18 +238,21 @@ func (m PatientModel) GetAllSortedByName(filters Filters) ([]*Patient, error) {
 	return patients, nil
 }
 
-func (m PatientModel) GetFilteredByText(filterText string) ([]*Patient, error) {
+// GetFilteredByText returns the patients whose name contains filterText. A non-zero branchID
+// limits them to the patients with appointments at the branch.
+func (m PatientModel) GetFilteredByText(filterText string, branchID int) ([]*Patient, error) {
 	query := `
-        SELECT id, created_at, updated_at, first_name, last_name, phone
+        SELECT id, created_at, updated_at, first_name, last_name, phone, email, national_id
         FROM patients
-        WHERE first_name LIKE '%' || $1 || '%' OR last_name LIKE '%' || $1 || '%'
+        WHERE (first_name LIKE '%' || $1 || '%' OR last_name LIKE '%' || $1 || '%')
+            AND ($2 = 0 OR id IN (SELECT patient_id FROM appointments WHERE branch_id = $2))
         ORDER BY first_name, last_name
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, filterText)
+	rows, err := m.DB.QueryContext(ctx, query, filterText, branchID)
 	if err != nil {
 		return nil, err
 	}
@@ -162,7 +261,7 @@ func (m PatientModel) GetFilteredByText(filterText string) ([]*Patient, error) {
 	var patients []*Patient
 	for rows.Next() {
 		var patient Patient
-		if err := rows.Scan(&patient.Id, &patient.CreatedAt, &patient.UpdatedAt, &patient.FirstName, &patient.LastName, &patient.Phone); err != nil {
+		if err := m.scan(rows, &patient); err != nil {
 			return nil, err
 		}
 		patients = append(patients, &patient)
@@ -174,10 +273,13 @@ func (m PatientModel) GetFilteredByText(filterText string) ([]*Patient, error) {
 	return patients, nil
 }
 
-func (m PatientModel) GetPaginatedPatients(limit, offset int) ([]*Patient, error) {
+// GetPaginatedPatients returns a page of patients ordered by ID. A non-zero branchID limits
+// them to the patients with appointments at the branch.
+func (m PatientModel) GetPaginatedPatients(limit, offset, branchID int) ([]*Patient, error) {
 	query := `
-        SELECT id, created_at, updated_at, first_name, last_name, phone
+        SELECT id, created_at, updated_at, first_name, last_name, phone, email, national_id
         FROM patients
+        WHERE $3 = 0 OR id IN (SELECT patient_id FROM appointments WHERE branch_id = $3)
         ORDER BY id
         LIMIT $1
         OFFSET $2
@@ -186,7 +288,7 @@ func (m PatientModel) GetPaginatedPatients(limit, offset int) ([]*Patient, error
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, limit, offset)
+	rows, err := m.DB.QueryContext(ctx, query, limit, offset, branchID)
 	if err != nil {
 		return nil, err
 	}
@@ -196,7 +298,7 @@ func (m PatientModel) GetPaginatedPatients(limit, offset int) ([]*Patient, error
 
 	for rows.Next() {
 		var patient Patient
-		if err := rows.Scan(&patient.Id, &patient.CreatedAt, &patient.UpdatedAt, &patient.FirstName, &patient.LastName, &patient.Phone); err != nil {
+		if err := m.scan(rows, &patient); err != nil {
 			return nil, err
 		}
 		patients = append(patients, &patient)
@@ -208,3 +310,40 @@ func (m PatientModel) GetPaginatedPatients(limit, offset int) ([]*Patient, error
 
 	return patients, nil
 }
+
+// Search returns the patients whose first or last name starts with name, case-insensitively, and
+// whose ID is id. An empty name or a zero id match everything.
+func (m PatientModel) Search(name string, id, limit, offset int) ([]*Patient, error) {
+	query := `
+        SELECT id, created_at, updated_at, first_name, last_name, phone, email, national_id
+        FROM patients
+        WHERE ($1 = '' OR first_name ILIKE $1 || '%' OR last_name ILIKE $1 || '%')
+            AND ($2 = 0 OR id = $2)
+        ORDER BY last_name, first_name, id
+        LIMIT $3
+        OFFSET $4
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(name), id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	patients := []*Patient{}
+	for rows.Next() {
+		var patient Patient
+		if err := m.scan(rows, &patient); err != nil {
+			return nil, err
+		}
+		patients = append(patients, &patient)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return patients, nil
+}
@@ -0,0 +1,361 @@
+package model
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// Prescription is a drug prescribed to a patient by a doctor, optionally during an appointment.
+// The drug name and form are copied from the catalogue so that a later catalogue import can't
+// change what was prescribed.
+type Prescription struct {
+	ID               int64     `json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	PatientID        int64     `json:"patient_id"`
+	DoctorID         int64     `json:"doctor_id"`
+	AppointmentID    *int64    `json:"appointment_id,omitempty"`
+	DrugID           int64     `json:"drug_id"`
+	DrugName         string    `json:"drug_name"`
+	Form             string    `json:"form"`
+	Dose             string    `json:"dose"`
+	Frequency        string    `json:"frequency"`
+	DurationDays     int       `json:"duration_days"`
+	Quantity         int       `json:"quantity"`
+	VerificationCode string    `json:"verification_code"`
+	Signature        []byte    `json:"-"`
+}
+
+// Conflict kinds found when checking a new prescription.
+const (
+	ConflictAllergy     = "allergy"
+	ConflictInteraction = "interaction"
+)
+
+// PrescriptionConflict describes why a drug may be unsafe for a patient: either the patient is
+// allergic to it, or it interacts with another prescription the patient is still taking.
+type PrescriptionConflict struct {
+	Kind           string `json:"kind"`
+	Substance      string `json:"substance"`
+	Severity       string `json:"severity"`
+	Description    string `json:"description"`
+	PrescriptionID int64  `json:"prescription_id,omitempty"`
+}
+
+// PrescriptionConflicts checks a drug against a patient's allergies and against the active
+// substances of the prescriptions the patient is still taking, keyed by prescription ID, given
+// the known interactions between the drug's substances and those.
+func PrescriptionConflicts(drug *Drug, allergies []*Allergy, active map[int64][]string, interactions []*Interaction) []PrescriptionConflict {
+	conflicts := []PrescriptionConflict{}
+
+	for _, allergy := range allergies {
+		if allergy.Matches(drug) {
+			conflicts = append(conflicts, PrescriptionConflict{
+				Kind:        ConflictAllergy,
+				Substance:   allergy.Substance,
+				Severity:    allergy.Severity,
+				Description: allergy.Reaction,
+			})
+		}
+	}
+
+	ids := make([]int64, 0, len(active))
+	for id := range active {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	substances := drug.Substances()
+	for _, id := range ids {
+		others := active[id]
+		for _, in := range interactions {
+			between := validator.In(in.SubstanceA, substances...) && validator.In(in.SubstanceB, others...) ||
+				validator.In(in.SubstanceB, substances...) && validator.In(in.SubstanceA, others...)
+			if !between {
+				continue
+			}
+			conflicts = append(conflicts, PrescriptionConflict{
+				Kind:           ConflictInteraction,
+				Substance:      in.SubstanceA + " / " + in.SubstanceB,
+				Severity:       in.Severity,
+				Description:    in.Description,
+				PrescriptionID: id,
+			})
+		}
+	}
+
+	return conflicts
+}
+
+// PrescriptionOverride is the audit record of a prescription issued despite conflicts.
+type PrescriptionOverride struct {
+	ID             int64                  `json:"id"`
+	CreatedAt      time.Time              `json:"created_at"`
+	PrescriptionID int64                  `json:"prescription_id"`
+	UserID         int64                  `json:"user_id"`
+	Reason         string                 `json:"reason"`
+	Conflicts      []PrescriptionConflict `json:"conflicts"`
+}
+
+type PrescriptionModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidatePrescription(v *validator.Validator, p *Prescription) {
+	v.Check(p.PatientID > 0, "patient_id", "must be provided")
+	v.Check(p.DoctorID > 0, "doctor_id", "must be provided")
+	v.Check(p.DrugID > 0, "drug_id", "must be provided")
+	v.Check(p.Dose != "", "dose", "must be provided")
+	v.Check(len(p.Dose) <= 100, "dose", "must not be more than 100 bytes long")
+	v.Check(p.Frequency != "", "frequency", "must be provided")
+	v.Check(len(p.Frequency) <= 100, "frequency", "must not be more than 100 bytes long")
+	v.Check(p.DurationDays > 0, "duration_days", "must be greater than zero")
+	v.Check(p.DurationDays <= 365, "duration_days", "must not be more than 365")
+	v.Check(p.Quantity > 0, "quantity", "must be greater than zero")
+}
+
+// signingPayload is the canonical representation of the prescription which is covered by the
+// signature. Any change to these fields after signing invalidates the prescription.
+func (p *Prescription) signingPayload() []byte {
+	return []byte(fmt.Sprintf("%s|%d|%d|%d|%s|%s|%s|%s|%d|%d",
+		p.VerificationCode, p.PatientID, p.DoctorID, p.DrugID, p.DrugName, p.Form,
+		p.Dose, p.Frequency, p.DurationDays, p.Quantity))
+}
+
+// Sign assigns a new random verification code to the prescription and signs it with an
+// HMAC-SHA256 of its contents using the given key.
+func (p *Prescription) Sign(key []byte) error {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return err
+	}
+	p.VerificationCode = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(p.signingPayload())
+	p.Signature = mac.Sum(nil)
+
+	return nil
+}
+
+// Verify reports whether the stored signature matches the prescription contents.
+func (p *Prescription) Verify(key []byte) bool {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(p.signingPayload())
+	return hmac.Equal(mac.Sum(nil), p.Signature)
+}
+
+// Insert stores a signed prescription. If the prescription was issued despite conflicts, the
+// override is recorded in the same transaction so there is never a prescription without its
+// audit record.
+func (m PrescriptionModel) Insert(p *Prescription, override *PrescriptionOverride) error {
+	query := `
+		INSERT INTO prescriptions (patient_id, doctor_id, appointment_id, drug_id, drug_name, form,
+			dose, frequency, duration_days, quantity, verification_code, signature)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{
+		p.PatientID, p.DoctorID, p.AppointmentID, p.DrugID, p.DrugName, p.Form,
+		p.Dose, p.Frequency, p.DurationDays, p.Quantity, p.VerificationCode, p.Signature,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if override != nil {
+		conflicts, err := json.Marshal(override.Conflicts)
+		if err != nil {
+			return err
+		}
+
+		override.PrescriptionID = p.ID
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO prescription_overrides (prescription_id, user_id, reason, conflicts)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at
+			`, override.PrescriptionID, override.UserID, override.Reason, conflicts,
+		).Scan(&override.ID, &override.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetActiveSubstances returns the active substances of the prescriptions a patient is currently
+// taking, that is the ones whose duration hasn't run out yet, keyed by prescription ID.
+func (m PrescriptionModel) GetActiveSubstances(patientID int64) (map[int64][]string, error) {
+	query := `
+		SELECT p.id, d.name, d.active_substance
+		FROM prescriptions p
+			INNER JOIN drugs d ON d.id = p.drug_id
+		WHERE p.patient_id = $1
+			AND p.created_at + p.duration_days * INTERVAL '1 day' > NOW()
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	active := make(map[int64][]string)
+	for rows.Next() {
+		var d Drug
+		var id int64
+		if err := rows.Scan(&id, &d.Name, &d.ActiveSubstance); err != nil {
+			return nil, err
+		}
+		active[id] = d.Substances()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return active, nil
+}
+
+// GetOverrides returns the recorded prescribing overrides in a time range, newest first.
+func (m PrescriptionModel) GetOverrides(from, to time.Time) ([]*PrescriptionOverride, error) {
+	query := `
+		SELECT id, created_at, prescription_id, user_id, reason, conflicts
+		FROM prescription_overrides
+		WHERE created_at >= $1 AND created_at < $2
+		ORDER BY created_at DESC, id DESC
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []*PrescriptionOverride{}
+	for rows.Next() {
+		var o PrescriptionOverride
+		var conflicts []byte
+		if err := rows.Scan(&o.ID, &o.CreatedAt, &o.PrescriptionID, &o.UserID, &o.Reason, &conflicts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(conflicts, &o.Conflicts); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
+
+// Get retrieves a prescription by its ID.
+func (m PrescriptionModel) Get(id int64) (*Prescription, error) {
+	return m.getBy("id", id)
+}
+
+// GetByVerificationCode retrieves a prescription by the code printed on it.
+func (m PrescriptionModel) GetByVerificationCode(code string) (*Prescription, error) {
+	return m.getBy("verification_code", code)
+}
+
+func (m PrescriptionModel) getBy(column string, value interface{}) (*Prescription, error) {
+	query := fmt.Sprintf(`
+		SELECT id, created_at, patient_id, doctor_id, appointment_id, drug_id, drug_name, form,
+			dose, frequency, duration_days, quantity, verification_code, signature
+		FROM prescriptions
+		WHERE %s = $1
+		`, column)
+
+	var p Prescription
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, value).Scan(
+		&p.ID, &p.CreatedAt, &p.PatientID, &p.DoctorID, &p.AppointmentID, &p.DrugID, &p.DrugName, &p.Form,
+		&p.Dose, &p.Frequency, &p.DurationDays, &p.Quantity, &p.VerificationCode, &p.Signature,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+// GetForPatient returns all prescriptions of a patient, newest first.
+func (m PrescriptionModel) GetForPatient(patientID int64) ([]*Prescription, error) {
+	query := `
+		SELECT id, created_at, patient_id, doctor_id, appointment_id, drug_id, drug_name, form,
+			dose, frequency, duration_days, quantity, verification_code, signature
+		FROM prescriptions
+		WHERE patient_id = $1
+		ORDER BY created_at DESC, id DESC
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prescriptions := []*Prescription{}
+	for rows.Next() {
+		var p Prescription
+		err := rows.Scan(
+			&p.ID, &p.CreatedAt, &p.PatientID, &p.DoctorID, &p.AppointmentID, &p.DrugID, &p.DrugName, &p.Form,
+			&p.Dose, &p.Frequency, &p.DurationDays, &p.Quantity, &p.VerificationCode, &p.Signature,
+		)
+		if err != nil {
+			return nil, err
+		}
+		prescriptions = append(prescriptions, &p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return prescriptions, nil
+}
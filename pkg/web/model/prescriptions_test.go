@@ -0,0 +1,66 @@
+package model
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestPrescriptionSignature(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	signed := func(t *testing.T) *Prescription {
+		p := &Prescription{
+			PatientID: 12, DoctorID: 3, DrugID: 481, DrugName: "Amoxil", Form: "capsule",
+			Dose: "500 mg", Frequency: "3 times a day", DurationDays: 7, Quantity: 21,
+		}
+		if err := p.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	p := signed(t)
+	if !regexp.MustCompile(`^[A-Z2-7]{26}$`).MatchString(p.VerificationCode) {
+		t.Errorf("VerificationCode = %q, want 26 base32 characters", p.VerificationCode)
+	}
+	if !p.Verify(key) {
+		t.Error("Verify() = false for an untouched prescription")
+	}
+	if other := signed(t); other.VerificationCode == p.VerificationCode {
+		t.Error("two prescriptions got the same verification code")
+	}
+
+	tests := []struct {
+		name   string
+		change func(p *Prescription)
+	}{
+		{"verification code", func(p *Prescription) { p.VerificationCode = "AAAAAAAAAAAAAAAAAAAAAAAAAA" }},
+		{"patient", func(p *Prescription) { p.PatientID = 13 }},
+		{"doctor", func(p *Prescription) { p.DoctorID = 4 }},
+		{"drug", func(p *Prescription) { p.DrugID = 482 }},
+		{"drug name", func(p *Prescription) { p.DrugName = "Augmentin" }},
+		{"form", func(p *Prescription) { p.Form = "tablet" }},
+		{"dose", func(p *Prescription) { p.Dose = "1000 mg" }},
+		{"frequency", func(p *Prescription) { p.Frequency = "4 times a day" }},
+		{"duration", func(p *Prescription) { p.DurationDays = 14 }},
+		{"quantity", func(p *Prescription) { p.Quantity = 42 }},
+		{"signature", func(p *Prescription) { p.Signature[0] ^= 1 }},
+		{"missing signature", func(p *Prescription) { p.Signature = nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := signed(t)
+			tt.change(p)
+			if p.Verify(key) {
+				t.Error("Verify() = true after changing the prescription")
+			}
+		})
+	}
+
+	t.Run("other key", func(t *testing.T) {
+		if signed(t).Verify([]byte("another key")) {
+			t.Error("Verify() = true with a different key")
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// ErrLegalHold is returned when a patient under legal hold is anonymized.
+var ErrLegalHold = errors.New("patient is under legal hold")
+
+// ErrAnonymized is returned when a patient who has already been anonymized is anonymized again.
+var ErrAnonymized = errors.New("patient has already been anonymized")
+
+// ErrUpcomingAppointments is returned when a patient with upcoming appointments is anonymized.
+// They have to be cancelled first.
+var ErrUpcomingAppointments = errors.New("patient has upcoming appointments")
+
+// ErrOutstandingBalance is returned when a patient with invoices which aren't settled is
+// anonymized: the clinic still has to collect them.
+var ErrOutstandingBalance = errors.New("patient has invoices which are not settled")
+
+// anonymizedName is the first name of anonymized patients. Their last name is a random
+// pseudonym, which tells them apart in lists without saying who they were.
+const anonymizedName = "Anonymized"
+
+// PatientPrivacy is a patient's legal hold and whether they have been anonymized. A legal hold
+// with a reason and no Until lasts until it is lifted.
+type PatientPrivacy struct {
+	PatientID       int64      `json:"patient_id"`
+	LegalHoldReason string     `json:"legal_hold_reason,omitempty"`
+	LegalHoldUntil  *string    `json:"legal_hold_until,omitempty"`
+	UnderLegalHold  bool       `json:"under_legal_hold"`
+	AnonymizedAt    *time.Time `json:"anonymized_at,omitempty"`
+	AnonymizedBy    *int64     `json:"anonymized_by,omitempty"`
+}
+
+func ValidateLegalHold(v *validator.Validator, p *PatientPrivacy) {
+	v.Check(p.LegalHoldReason != "", "reason", "must be provided")
+	v.Check(len(p.LegalHoldReason) <= 500, "reason", "must not be more than 500 bytes long")
+	if p.LegalHoldUntil != nil {
+		_, err := time.Parse("2006-01-02", *p.LegalHoldUntil)
+		v.Check(err == nil, "until", "must be a date such as 2030-12-31")
+	}
+}
+
+const privacyColumns = `
+	id, legal_hold_reason, to_char(legal_hold_until, 'YYYY-MM-DD'),
+	legal_hold_reason <> '' AND (legal_hold_until IS NULL OR legal_hold_until >= CURRENT_DATE),
+	anonymized_at, anonymized_by`
+
+func scanPrivacy(row interface{ Scan(...interface{}) error }, p *PatientPrivacy) error {
+	err := row.Scan(&p.PatientID, &p.LegalHoldReason, &p.LegalHoldUntil, &p.UnderLegalHold, &p.AnonymizedAt,
+		&p.AnonymizedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+// Privacy returns a patient's legal hold and whether they have been anonymized.
+func (m PatientModel) Privacy(id int64) (*PatientPrivacy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p PatientPrivacy
+	err := scanPrivacy(m.DB.QueryRowContext(ctx, `SELECT `+privacyColumns+` FROM patients WHERE id = $1`, id), &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SetLegalHold puts a patient under legal hold, or lifts it if the reason is empty.
+func (m PatientModel) SetLegalHold(p *PatientPrivacy) error {
+	query := `
+		UPDATE patients
+		SET legal_hold_reason = $2, legal_hold_until = $3
+		WHERE id = $1
+		RETURNING ` + privacyColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if p.LegalHoldReason == "" {
+		p.LegalHoldUntil = nil
+	}
+	return scanPrivacy(m.DB.QueryRowContext(ctx, query, p.PatientID, p.LegalHoldReason, p.LegalHoldUntil), p)
+}
+
+// Anonymize irreversibly removes what identifies a patient, unless they are under legal hold,
+// have upcoming appointments or owe the clinic money. Their name is replaced by a pseudonym and
+// their contact details, insurance numbers, the free-text notes about them, their documents,
+// calendar feeds and the identifiers other systems use for them are removed. Appointments,
+// diagnosis codes, prescriptions, allergies, lab results and invoices are kept for the
+// statistics and the accounts. It returns the storage keys of the document contents which no
+// other document refers to any more, to be removed from the storage.
+func (m PatientModel) Anonymize(id int64, anonymizedBy int64) (*PatientPrivacy, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var p PatientPrivacy
+	err = scanPrivacy(tx.QueryRowContext(ctx, `SELECT `+privacyColumns+` FROM patients WHERE id = $1 FOR UPDATE`, id), &p)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case p.AnonymizedAt != nil:
+		return nil, nil, ErrAnonymized
+	case p.UnderLegalHold:
+		return nil, nil, ErrLegalHold
+	}
+
+	var upcoming, outstanding bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM appointments
+				WHERE patient_id = $1 AND status IN ('scheduled', 'confirmed') AND date_time > NOW()),
+			EXISTS (SELECT 1 FROM invoices
+				WHERE patient_id = $1 AND kind = 'invoice' AND status IN ('issued', 'partially_paid'))
+		`, id).Scan(&upcoming, &outstanding)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case upcoming:
+		return nil, nil, ErrUpcomingAppointments
+	case outstanding:
+		return nil, nil, ErrOutstandingBalance
+	}
+
+	err = scanPrivacy(tx.QueryRowContext(ctx, `
+		UPDATE patients
+		SET first_name = $2, last_name = substr(md5(random()::text), 1, 12), phone = '', email = '',
+			national_id = '', national_id_index = NULL, anonymized_at = NOW(), anonymized_by = $3
+		WHERE id = $1
+		RETURNING `+privacyColumns, id, anonymizedName, anonymizedBy), &p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	statements := []string{
+		`UPDATE diagnoses SET notes = '' WHERE patient_id = $1`,
+		`UPDATE lab_orders SET notes = '' WHERE patient_id = $1`,
+		`UPDATE waitlist_entries SET notes = '' WHERE patient_id = $1`,
+		`UPDATE queue_tickets SET name = '' WHERE patient_id = $1`,
+		`UPDATE insurance_policies SET member_number = '', group_number = '' WHERE patient_id = $1`,
+		`DELETE FROM calendar_feeds WHERE patient_id = $1`,
+		`DELETE FROM external_ids WHERE resource = 'patient' AND local_id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, id); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The reference check only sees this tenant's documents, which is enough since the contents
+	// are stored under a key of their tenant.
+	rows, err := tx.QueryContext(ctx, `
+		WITH deleted AS (
+			DELETE FROM documents WHERE patient_id = $1 RETURNING tenant_id, sha256
+		)
+		SELECT DISTINCT tenant_id, sha256 FROM deleted
+		`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	var deleted []Document
+	for rows.Next() {
+		var d Document
+		if err := rows.Scan(&d.TenantID, &d.SHA256); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		deleted = append(deleted, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var orphaned []string
+	for _, d := range deleted {
+		var referenced bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM documents WHERE sha256 = $1)`, d.SHA256).Scan(&referenced)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !referenced {
+			orphaned = append(orphaned, d.StorageKey())
+		}
+	}
+
+	return &p, orphaned, tx.Commit()
+}
@@ -0,0 +1,334 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// Queue ticket statuses. A ticket is "waiting" from check-in until a doctor calls it, and
+// "no_show" if the patient didn't come when called or left before.
+const (
+	TicketWaiting = "waiting"
+	TicketCalled  = "called"
+	TicketDone    = "done"
+	TicketNoShow  = "no_show"
+)
+
+// QueueChannel is the PostgreSQL notification channel on which the ID of every issued or
+// changed queue ticket is published.
+const QueueChannel = "queue_tickets"
+
+// ticketTransitions lists the statuses a ticket can be set to from each status. A called
+// ticket can be sent back to the waiting patients, e.g. when the patient stepped out.
+var ticketTransitions = map[string][]string{
+	TicketWaiting: {TicketCalled, TicketNoShow},
+	TicketCalled:  {TicketWaiting, TicketDone, TicketNoShow},
+}
+
+// ErrTicketTransition is returned when a ticket can't be set to a status from its current one.
+var ErrTicketTransition = errors.New("queue ticket can't change to this status")
+
+// ErrAlreadyCheckedIn is returned when a ticket is issued for an appointment which already has
+// one.
+var ErrAlreadyCheckedIn = errors.New("appointment is already checked in")
+
+// queueLockNamespace is the first key of the advisory lock which serializes the numbering of
+// the tickets of a day at all branches; the second key is the day as YYYYMMDD.
+const queueLockNamespace = 2
+
+// QueueTicket is a patient's place in the queue of a doctor at a branch on a day. Walk-ins
+// without a patient record are only known by Name. DoctorName and PatientName are read from
+// the doctor and patient records.
+type QueueTicket struct {
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Date          string     `json:"date"`
+	BranchID      *int64     `json:"branch_id,omitempty"`
+	Number        int        `json:"number"`
+	DoctorID      int64      `json:"doctor_id"`
+	DoctorName    string     `json:"doctor_name"`
+	PatientID     *int64     `json:"patient_id,omitempty"`
+	AppointmentID *int64     `json:"appointment_id,omitempty"`
+	Name          string     `json:"name,omitempty"`
+	PatientName   string     `json:"patient_name"`
+	Status        string     `json:"status"`
+	CalledAt      *time.Time `json:"called_at,omitempty"`
+	DoneAt        *time.Time `json:"done_at,omitempty"`
+	CreatedBy     *int64     `json:"created_by,omitempty"`
+}
+
+type QueueModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateQueueTicket(v *validator.Validator, t *QueueTicket) {
+	if t.AppointmentID != nil {
+		v.Check(*t.AppointmentID > 0, "appointment_id", "must be a positive integer")
+		return
+	}
+	v.Check(t.DoctorID > 0, "doctor_id", "must be provided")
+	v.Check(t.PatientID == nil || *t.PatientID > 0, "patient_id", "must be a positive integer")
+	v.Check(t.PatientID != nil || t.Name != "", "name", "must be provided unless patient_id is")
+	v.Check(len(t.Name) <= 200, "name", "must not be more than 200 bytes long")
+}
+
+// QueueDate returns the queue day of t in loc, e.g. 2024-06-14.
+func QueueDate(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("2006-01-02")
+}
+
+const queueTicketColumns = `
+	t.id, t.created_at, t.updated_at, to_char(t.queue_date, 'YYYY-MM-DD'), t.branch_id, t.number, t.doctor_id,
+	d.first_name || ' ' || d.last_name, t.patient_id, t.appointment_id, t.name,
+	COALESCE(p.first_name || ' ' || p.last_name, t.name), t.status, t.called_at, t.done_at, t.created_by`
+
+const queueTicketTables = `
+	queue_tickets t
+	JOIN doctors d ON d.id = t.doctor_id
+	LEFT JOIN patients p ON p.id = t.patient_id`
+
+func scanQueueTicket(row interface{ Scan(...interface{}) error }, t *QueueTicket) error {
+	return row.Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt, &t.Date, &t.BranchID, &t.Number, &t.DoctorID, &t.DoctorName,
+		&t.PatientID, &t.AppointmentID, &t.Name, &t.PatientName, &t.Status, &t.CalledAt, &t.DoneAt, &t.CreatedBy)
+}
+
+// Issue checks a patient in on day and gives them the next ticket number of the day at the
+// ticket's branch. A ticket for an appointment takes the doctor and patient from it.
+// ErrRecordNotFound is returned if the appointment, doctor or patient doesn't exist or the
+// appointment is cancelled, and ErrBranchMismatch if the doctor doesn't work at the branch.
+func (m QueueModel) Issue(t *QueueTicket, day string) error {
+	date, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if t.AppointmentID != nil {
+		var patientID int64
+		err := tx.QueryRowContext(ctx, `
+			SELECT doctor_id, patient_id FROM appointments WHERE id = $1 AND status <> 'cancelled'
+			`, *t.AppointmentID).Scan(&t.DoctorID, &patientID)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		t.PatientID = &patientID
+	}
+
+	if t.BranchID != nil {
+		var works bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM doctor_branches WHERE doctor_id = $1 AND branch_id = $2)
+			`, t.DoctorID, *t.BranchID).Scan(&works)
+		if err != nil {
+			return err
+		}
+		if !works {
+			return ErrBranchMismatch
+		}
+	}
+
+	key, _ := strconv.Atoi(date.Format("20060102"))
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, queueLockNamespace, key); err != nil {
+		return err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO queue_tickets (queue_date, branch_id, number, doctor_id, patient_id, appointment_id, name, created_by)
+		SELECT $1, $7::bigint, COALESCE((
+				SELECT MAX(number) FROM queue_tickets WHERE queue_date = $1 AND branch_id IS NOT DISTINCT FROM $7
+			), 0) + 1, $2, $3, $4, $5, $6
+		WHERE EXISTS (SELECT 1 FROM doctors WHERE id = $2)
+			AND ($3::bigint IS NULL OR EXISTS (SELECT 1 FROM patients WHERE id = $3))
+		RETURNING id
+		`, day, t.DoctorID, t.PatientID, t.AppointmentID, t.Name, t.CreatedBy, t.BranchID).Scan(&id)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Constraint == "queue_tickets_appointment_idx":
+			return ErrAlreadyCheckedIn
+		default:
+			return err
+		}
+	}
+
+	if err := scanQueueTicket(tx.QueryRowContext(ctx, `SELECT `+queueTicketColumns+` FROM `+queueTicketTables+`
+		WHERE t.id = $1`, id), t); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m QueueModel) Get(id int64) (*QueueTicket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t QueueTicket
+	err := scanQueueTicket(m.DB.QueryRowContext(ctx, `SELECT `+queueTicketColumns+` FROM `+queueTicketTables+`
+		WHERE t.id = $1`, id), &t)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// GetAll returns the tickets of day in the order they were issued. A non-zero doctorID limits
+// them to the doctor's queue, and a non-zero branchID to the tickets of the branch.
+func (m QueueModel) GetAll(day string, doctorID, branchID int64) ([]*QueueTicket, error) {
+	query := `
+		SELECT ` + queueTicketColumns + `
+		FROM ` + queueTicketTables + `
+		WHERE t.queue_date = $1 AND ($2 = 0 OR t.doctor_id = $2) AND ($3 = 0 OR t.branch_id = $3)
+		ORDER BY t.branch_id, t.number
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, day, doctorID, branchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tickets := []*QueueTicket{}
+	for rows.Next() {
+		var t QueueTicket
+		if err := scanQueueTicket(rows, &t); err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
+
+// SetStatus changes the status of a ticket and returns it. ErrTicketTransition is returned if
+// the ticket can't change to status from its current one.
+func (m QueueModel) SetStatus(id int64, status string) (*QueueTicket, error) {
+	var from []string
+	for s, to := range ticketTransitions {
+		for _, t := range to {
+			if t == status {
+				from = append(from, s)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `
+		UPDATE queue_tickets
+		SET status = $2, updated_at = NOW(),
+			called_at = CASE WHEN $2 = 'called' THEN NOW() ELSE called_at END,
+			done_at = CASE WHEN $2 IN ('done', 'no_show') THEN NOW() END
+		WHERE id = $1 AND status = ANY($3)
+		`, id, status, pq.Array(from))
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrTicketTransition
+	}
+
+	return t, nil
+}
+
+// CallNext finishes the tickets the doctor has called on day and calls the doctor's next
+// waiting ticket, which it returns. A non-zero branchID limits both to the branch.
+// ErrRecordNotFound is returned if nobody is waiting.
+func (m QueueModel) CallNext(doctorID, branchID int64, day string) (*QueueTicket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE queue_tickets SET status = 'done', done_at = NOW(), updated_at = NOW()
+		WHERE queue_date = $1 AND doctor_id = $2 AND ($3 = 0 OR branch_id = $3) AND status = 'called'
+		`, day, doctorID, branchID)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE queue_tickets SET status = 'called', called_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM queue_tickets
+			WHERE queue_date = $1 AND doctor_id = $2 AND ($3 = 0 OR branch_id = $3) AND status = 'waiting'
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id
+		`, day, doctorID, branchID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Finishing the called tickets still counts when nobody is waiting.
+			if err := tx.Commit(); err != nil {
+				return nil, err
+			}
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return m.Get(id)
+}
@@ -0,0 +1,200 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/fieldcrypt"
+)
+
+// Reminder statuses. A reminder is "sending" from the moment it is claimed until the outcome
+// is recorded; one that stays "sending" was interrupted by a crash and is not retried, since
+// it may already have been delivered.
+const (
+	ReminderSending = "sending"
+	ReminderSent    = "sent"
+	ReminderFailed  = "failed"
+	ReminderSkipped = "skipped"
+)
+
+// Reminder is a reminder of an appointment sent over one channel, together with what is needed
+// to write it.
+type Reminder struct {
+	AppointmentID int64      `json:"appointment_id"`
+	DateTime      time.Time  `json:"date_time"`
+	OffsetMinutes int        `json:"offset_minutes"`
+	Channel       string     `json:"channel"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	Error         string     `json:"error,omitempty"`
+	ClaimedAt     time.Time  `json:"claimed_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+
+	Patient struct {
+		FirstName, LastName, Phone, Email string
+	} `json:"-"`
+	Doctor struct {
+		FirstName, LastName, Speciality string
+	} `json:"-"`
+}
+
+type ReminderModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Fields   *fieldcrypt.Cipher
+}
+
+// reminderDetails selects a claimed reminder together with its patient and doctor.
+const reminderDetails = `
+	SELECT c.appointment_id, c.date_time, c.offset_minutes, c.channel, c.status, c.attempts,
+		c.claimed_at, p.first_name, p.last_name, p.phone, p.email, d.first_name, d.last_name,
+		d.speciality
+	FROM claimed c
+	INNER JOIN appointments a ON a.id = c.appointment_id
+	INNER JOIN patients p ON p.id = a.patient_id
+	INNER JOIN doctors d ON d.id = a.doctor_id
+	ORDER BY c.date_time
+	`
+
+// ClaimDue claims up to limit reminders which are due, so that no other replica sends them.
+// For each appointment only the smallest offset which has passed is due: a reminder 24 hours
+// ahead is dropped if the server only notices the appointment 2 hours before it. Failed
+// reminders are claimed again once retryAfter has passed, up to maxAttempts times.
+func (m ReminderModel) ClaimDue(offsets []int, channels []string, maxAttempts int, retryAfter time.Duration,
+	limit int) ([]*Reminder, error) {
+	claimNew := `
+		WITH due AS (
+			SELECT a.id, a.date_time, o.m, c.channel
+			FROM appointments a
+			CROSS JOIN unnest($1::int[]) AS o(m)
+			CROSS JOIN unnest($2::text[]) AS c(channel)
+			WHERE a.status IN ('scheduled', 'confirmed')
+				AND a.date_time > NOW()
+				AND a.date_time - make_interval(mins => o.m) <= NOW()
+				AND NOT EXISTS (
+					SELECT 1 FROM unnest($1::int[]) AS smaller(m)
+					WHERE smaller.m < o.m AND a.date_time - make_interval(mins => smaller.m) <= NOW())
+				AND NOT EXISTS (
+					SELECT 1 FROM appointment_reminders r
+					WHERE r.appointment_id = a.id AND r.date_time = a.date_time
+						AND r.offset_minutes = o.m AND r.channel = c.channel)
+			ORDER BY a.date_time
+			LIMIT $3
+		), claimed AS (
+			INSERT INTO appointment_reminders (appointment_id, date_time, offset_minutes, channel)
+			SELECT id, date_time, m, channel FROM due
+			ON CONFLICT DO NOTHING
+			RETURNING *
+		)` + reminderDetails
+
+	claimRetries := `
+		WITH claimed AS (
+			UPDATE appointment_reminders r
+			SET status = 'sending', attempts = r.attempts + 1, error = '', claimed_at = NOW()
+			FROM appointments a
+			WHERE a.id = r.appointment_id AND a.date_time = r.date_time
+				AND a.status IN ('scheduled', 'confirmed') AND a.date_time > NOW()
+				AND r.status = 'failed' AND r.attempts < $1
+				AND r.claimed_at < NOW() - make_interval(secs => $2)
+			RETURNING r.*
+		)` + reminderDetails
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reminders, err := m.query(ctx, claimNew, pq.Array(offsets), pq.Array(channels), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := m.query(ctx, claimRetries, maxAttempts, retryAfter.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	return append(reminders, retries...), nil
+}
+
+func (m ReminderModel) query(ctx context.Context, query string, args ...interface{}) ([]*Reminder, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []*Reminder
+	for rows.Next() {
+		var r Reminder
+		err := rows.Scan(&r.AppointmentID, &r.DateTime, &r.OffsetMinutes, &r.Channel, &r.Status, &r.Attempts,
+			&r.ClaimedAt, &r.Patient.FirstName, &r.Patient.LastName, &r.Patient.Phone, &r.Patient.Email,
+			&r.Doctor.FirstName, &r.Doctor.LastName, &r.Doctor.Speciality)
+		if err != nil {
+			return nil, err
+		}
+		if err := decryptAll(m.Fields, &r.Patient.Phone); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
+
+// Finish records the outcome of sending a claimed reminder.
+func (m ReminderModel) Finish(r *Reminder) error {
+	query := `
+		UPDATE appointment_reminders
+		SET status = $1, error = $2, sent_at = CASE WHEN $1 = 'sent' THEN NOW() END
+		WHERE appointment_id = $3 AND date_time = $4 AND offset_minutes = $5 AND channel = $6
+		RETURNING sent_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, r.Status, r.Error, r.AppointmentID, r.DateTime, r.OffsetMinutes,
+		r.Channel).Scan(&r.SentAt)
+}
+
+// GetForAppointment returns the reminders of an appointment.
+func (m ReminderModel) GetForAppointment(appointmentID int64) ([]*Reminder, error) {
+	query := `
+		SELECT appointment_id, date_time, offset_minutes, channel, status, attempts, error, claimed_at, sent_at
+		FROM appointment_reminders
+		WHERE appointment_id = $1
+		ORDER BY claimed_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*Reminder{}
+	for rows.Next() {
+		var r Reminder
+		err := rows.Scan(&r.AppointmentID, &r.DateTime, &r.OffsetMinutes, &r.Channel, &r.Status, &r.Attempts,
+			&r.Error, &r.ClaimedAt, &r.SentAt)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
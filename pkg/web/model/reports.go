@@ -0,0 +1,153 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// RevenueDimensions are what revenue can be grouped by.
+var RevenueDimensions = []string{"day", "doctor", "service", "method"}
+
+// RevenueFilter selects the revenue of the local days From to To (YYYY-MM-DD, inclusive),
+// optionally of one branch, grouped by GroupBy. The days are those of the time zone of each
+// invoice's branch, or of the server for invoices without one.
+type RevenueFilter struct {
+	From     string
+	To       string
+	BranchID int64
+	GroupBy  []string
+}
+
+func ValidateRevenueFilter(v *validator.Validator, f RevenueFilter) {
+	ValidateReportDays(v, f.From, f.To)
+	v.Check(f.BranchID >= 0, "branch_id", "must be a positive integer")
+	v.Check(validator.Unique(f.GroupBy), "group_by", "must not contain duplicate values")
+	for _, d := range f.GroupBy {
+		v.Check(validator.In(d, RevenueDimensions...), "group_by", "must be one of "+strings.Join(RevenueDimensions, ", "))
+	}
+	v.Check(!validator.In("method", f.GroupBy...) || !validator.In("service", f.GroupBy...), "group_by",
+		"method can't be combined with service, since payments settle whole invoices")
+}
+
+// RevenueRow is the revenue of a group. Only the fields of the dimensions grouped by are set.
+// Grouped by method the revenue is what was collected, payments less refunds, and Count is the
+// number of payments; otherwise it is what was invoiced, invoices less credit notes, with its
+// net and VAT, and Count is the number of documents.
+type RevenueRow struct {
+	Day       string `json:"day,omitempty"`
+	DoctorID  *int64 `json:"doctor_id,omitempty"`
+	Doctor    string `json:"doctor,omitempty"`
+	ServiceID *int64 `json:"service_id,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Currency  string `json:"currency"`
+	Count     int64  `json:"count"`
+	Net       *int64 `json:"net,omitempty"`
+	VAT       *int64 `json:"vat,omitempty"`
+	Total     int64  `json:"total"`
+}
+
+type ReportModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Revenue returns the revenue of the filter's period per group and currency, ordered by the
+// dimensions in the order they were given. Only issued documents count; drafts and void ones
+// don't.
+func (m ReportModel) Revenue(f RevenueFilter) ([]*RevenueRow, error) {
+	collected := validator.In("method", f.GroupBy...)
+
+	// The doctor of a credit note is that of the appointment of the invoice it credits.
+	joins := `
+			LEFT JOIN invoices o ON o.id = i.credited_invoice_id
+			LEFT JOIN appointments a ON a.id = COALESCE(i.appointment_id, o.appointment_id)
+			LEFT JOIN doctors d ON d.id = a.doctor_id
+			LEFT JOIN branches b ON b.id = i.branch_id`
+	from := `invoices i
+			JOIN invoice_lines l ON l.invoice_id = i.id
+			LEFT JOIN services s ON s.id = l.service_id` + joins
+	at := "i.issued_at"
+	amounts := `count(DISTINCT i.id), sum(x.sign * l.net), sum(x.sign * l.vat), sum(x.sign * l.total)`
+	if collected {
+		from = `payments p
+			JOIN invoices i ON i.id = p.invoice_id` + joins
+		at = "p.paid_at"
+		amounts = `count(*), NULL::bigint, NULL::bigint, sum(x.sign * p.amount)`
+	}
+
+	var columns, groups []string
+	for _, d := range f.GroupBy {
+		switch d {
+		case "day":
+			columns = append(columns, "x.day::text")
+			groups = append(groups, "x.day")
+		case "doctor":
+			columns = append(columns, "a.doctor_id", "COALESCE(d.first_name || ' ' || d.last_name, '')")
+			groups = append(groups, "a.doctor_id", "d.first_name", "d.last_name")
+		case "service":
+			columns = append(columns, "l.service_id", "COALESCE(s.name, '')")
+			groups = append(groups, "l.service_id", "s.name")
+		case "method":
+			columns = append(columns, "p.method")
+			groups = append(groups, "p.method")
+		}
+	}
+	columns = append(columns, "i.currency")
+	order := append(append([]string{}, groups...), "i.currency")
+	groups = append(groups, "i.currency")
+
+	query := `
+		SELECT ` + strings.Join(append(columns, amounts), ", ") + `
+		FROM ` + from + `,
+			LATERAL (SELECT CASE WHEN i.kind = 'credit_note' THEN -1 ELSE 1 END AS sign,
+				(` + at + ` AT TIME ZONE COALESCE(b.time_zone, current_setting('TimeZone')))::date AS day) x
+		WHERE i.status IN ('issued', 'partially_paid', 'paid')
+			AND x.day BETWEEN $1 AND $2
+			AND ($3 = 0 OR i.branch_id = $3)
+		GROUP BY ` + strings.Join(groups, ", ") + `
+		ORDER BY ` + strings.Join(order, ", ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.From, f.To, f.BranchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []*RevenueRow{}
+	for rows.Next() {
+		var row RevenueRow
+		var dest []interface{}
+		for _, d := range f.GroupBy {
+			switch d {
+			case "day":
+				dest = append(dest, &row.Day)
+			case "doctor":
+				dest = append(dest, &row.DoctorID, &row.Doctor)
+			case "service":
+				dest = append(dest, &row.ServiceID, &row.Service)
+			case "method":
+				dest = append(dest, &row.Method)
+			}
+		}
+		dest = append(dest, &row.Currency, &row.Count, &row.Net, &row.VAT, &row.Total)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		report = append(report, &row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,657 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// ErrServiceInUse is returned when a service which has been booked is deleted. It can be
+// deactivated instead.
+var ErrServiceInUse = errors.New("service has been booked, deactivate it instead")
+
+// ErrServiceUnavailable is returned when a service booked for an appointment doesn't exist, is
+// inactive or is limited to a speciality other than the doctor's.
+var ErrServiceUnavailable = errors.New("service doesn't exist, is inactive or isn't offered by the doctor")
+
+// ErrDuplicateService is returned when the same service is booked twice for an appointment.
+// Book it once, with the longer service if more time is needed.
+var ErrDuplicateService = errors.New("service is listed more than once")
+
+// ErrNoPrice is returned when a service has no price on the day of an appointment.
+var ErrNoPrice = errors.New("service has no price on the day of the appointment")
+
+// ErrCurrencyMismatch is returned when the services of an appointment are priced in different
+// currencies.
+var ErrCurrencyMismatch = errors.New("services are priced in different currencies")
+
+// ErrDuplicatePrice is returned when a service already has a price of the same kind from the
+// same day.
+var ErrDuplicatePrice = errors.New("service already has a price from this day for this branch and doctor")
+
+// ErrPriceInEffect is returned when a price which has taken effect is deleted. It is part of
+// the price history; a new price replaces it.
+var ErrPriceInEffect = errors.New("price has taken effect and can only be replaced by a new one")
+
+// CurrencyRX matches ISO 4217 currency codes.
+var CurrencyRX = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Service is something the clinic offers, such as a consultation or an ECG. VATRate is in
+// hundredths of a percent. Price and Currency are the list price in effect today, if any;
+// Prices is the price history with the overrides for branches and doctors.
+type Service struct {
+	ID              int64           `json:"id"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	Code            string          `json:"code"`
+	Name            string          `json:"name"`
+	Speciality      string          `json:"speciality"`
+	DurationMinutes int             `json:"duration_minutes"`
+	VATRate         int             `json:"vat_rate"`
+	Active          bool            `json:"active"`
+	Price           *int64          `json:"price"`
+	Currency        string          `json:"currency,omitempty"`
+	Prices          []*ServicePrice `json:"prices,omitempty"`
+}
+
+// ServicePrice is the price of a service from EffectiveFrom until the next price of the same
+// kind. Without a branch and a doctor it is a list price, otherwise an override at the branch,
+// for the doctor or both. Amount is in minor units of Currency.
+type ServicePrice struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	ServiceID     int64     `json:"service_id"`
+	BranchID      *int64    `json:"branch_id,omitempty"`
+	DoctorID      *int64    `json:"doctor_id,omitempty"`
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	EffectiveFrom string    `json:"effective_from"`
+}
+
+// AppointmentService is a service booked for an appointment, with the duration, price and VAT
+// rate it had when it was booked.
+type AppointmentService struct {
+	ServiceID       int64  `json:"service_id"`
+	Code            string `json:"code"`
+	Name            string `json:"name"`
+	DurationMinutes int    `json:"duration_minutes"`
+	Amount          int64  `json:"amount"`
+	Currency        string `json:"currency"`
+	VATRate         int    `json:"vat_rate"`
+}
+
+// AppointmentCost is what an appointment costs in minor units of Currency: Net is the sum of
+// the prices of its services and VAT the tax on them.
+type AppointmentCost struct {
+	AppointmentID   int64                 `json:"appointment_id"`
+	DurationMinutes int                   `json:"duration_minutes"`
+	Services        []*AppointmentService `json:"services"`
+	Currency        string                `json:"currency,omitempty"`
+	Net             int64                 `json:"net"`
+	VAT             int64                 `json:"vat"`
+	Total           int64                 `json:"total"`
+}
+
+// VATAmount returns the VAT at rate, in hundredths of a percent, on amount, rounded half up to
+// a minor unit.
+func VATAmount(amount int64, rate int) int64 {
+	return (amount*int64(rate) + 5000) / 10000
+}
+
+type ServiceModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateService(v *validator.Validator, s *Service) {
+	v.Check(s.Code != "", "code", "must be provided")
+	v.Check(len(s.Code) <= 50, "code", "must not be more than 50 bytes long")
+	v.Check(s.Name != "", "name", "must be provided")
+	v.Check(len(s.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(s.Speciality) <= 100, "speciality", "must not be more than 100 bytes long")
+	v.Check(s.DurationMinutes > 0, "duration_minutes", "must be greater than zero")
+	v.Check(s.DurationMinutes <= 12*60, "duration_minutes", "must not be more than 12 hours")
+	v.Check(s.VATRate >= 0 && s.VATRate <= 10000, "vat_rate", "must be between 0 and 10000 hundredths of a percent")
+}
+
+func ValidateServicePrice(v *validator.Validator, p *ServicePrice) {
+	v.Check(p.Amount >= 0, "amount", "must not be negative")
+	v.Check(validator.Matches(p.Currency, CurrencyRX), "currency", "must be an ISO 4217 code such as EUR")
+	_, err := time.Parse("2006-01-02", p.EffectiveFrom)
+	v.Check(err == nil, "effective_from", "must be a date such as 2024-07-01")
+	v.Check(p.BranchID == nil || *p.BranchID > 0, "branch_id", "must be a positive integer")
+	v.Check(p.DoctorID == nil || *p.DoctorID > 0, "doctor_id", "must be a positive integer")
+}
+
+// serviceColumns selects a service with its list price of today from serviceTables.
+const serviceColumns = `
+	s.id, s.created_at, s.updated_at, s.code, s.name, s.speciality, s.duration_minutes, s.vat_rate, s.active,
+	p.amount, p.currency`
+
+const serviceTables = `
+	services s
+	LEFT JOIN LATERAL (
+		SELECT amount, currency
+		FROM service_prices
+		WHERE service_id = s.id AND branch_id IS NULL AND doctor_id IS NULL AND effective_from <= CURRENT_DATE
+		ORDER BY effective_from DESC
+		LIMIT 1
+	) p ON true`
+
+func scanService(row interface{ Scan(...interface{}) error }, s *Service) error {
+	var currency sql.NullString
+	err := row.Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt, &s.Code, &s.Name, &s.Speciality, &s.DurationMinutes,
+		&s.VATRate, &s.Active, &s.Price, &currency)
+	s.Currency = currency.String
+	return err
+}
+
+const servicePriceColumns = `
+	id, created_at, service_id, branch_id, doctor_id, amount, currency, to_char(effective_from, 'YYYY-MM-DD')`
+
+func scanServicePrice(row interface{ Scan(...interface{}) error }, p *ServicePrice) error {
+	return row.Scan(&p.ID, &p.CreatedAt, &p.ServiceID, &p.BranchID, &p.DoctorID, &p.Amount, &p.Currency,
+		&p.EffectiveFrom)
+}
+
+// Insert adds a service to the catalogue with its list price from price.EffectiveFrom.
+func (m ServiceModel) Insert(s *Service, price *ServicePrice) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO services (code, name, speciality, duration_minutes, vat_rate, active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+		`, s.Code, s.Name, s.Speciality, s.DurationMinutes, s.VATRate, s.Active).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	if err != nil {
+		return err
+	}
+
+	price.ServiceID = s.ID
+	if err := insertServicePrice(ctx, tx, price); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if price.EffectiveFrom <= time.Now().Format("2006-01-02") {
+		s.Price = &price.Amount
+		s.Currency = price.Currency
+	}
+	s.Prices = []*ServicePrice{price}
+	return nil
+}
+
+// Get returns a service with its price history.
+func (m ServiceModel) Get(id int64) (*Service, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s Service
+	err := scanService(m.DB.QueryRowContext(ctx, `SELECT `+serviceColumns+` FROM `+serviceTables+` WHERE s.id = $1`, id),
+		&s)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	s.Prices, err = m.Prices(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// ServiceFilter narrows down the catalogue. Zero values match everything.
+type ServiceFilter struct {
+	// Speciality limits the services to those of the speciality and those of every speciality.
+	Speciality string
+	// DoctorID limits the services to those the doctor can be booked for.
+	DoctorID   int64
+	ActiveOnly bool
+}
+
+// GetAll returns the services matching the filter ordered by code.
+func (m ServiceModel) GetAll(f ServiceFilter) ([]*Service, error) {
+	query := `
+		SELECT ` + serviceColumns + `
+		FROM ` + serviceTables + `
+		WHERE ($1 = '' OR s.speciality = '' OR lower(s.speciality) = lower($1))
+			AND ($2 = 0 OR s.speciality = '' OR lower(s.speciality) = (SELECT lower(speciality) FROM doctors WHERE id = $2))
+			AND (NOT $3 OR s.active)
+		ORDER BY s.code, s.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.Speciality, f.DoctorID, f.ActiveOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []*Service{}
+	for rows.Next() {
+		var s Service
+		if err := scanService(rows, &s); err != nil {
+			return nil, err
+		}
+		services = append(services, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return services, nil
+}
+
+// Update changes a service. Its prices are changed by adding new ones, and appointments which
+// have been booked keep the duration and price the service had.
+func (m ServiceModel) Update(s *Service) error {
+	query := `
+		UPDATE services
+		SET code = $2, name = $3, speciality = $4, duration_minutes = $5, vat_rate = $6, active = $7, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, s.ID, s.Code, s.Name, s.Speciality, s.DurationMinutes, s.VATRate,
+		s.Active).Scan(&s.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordNotFound
+	case isUniqueViolation(err):
+		return ErrDuplicateName
+	default:
+		return err
+	}
+}
+
+// Delete removes a service with its prices. ErrServiceInUse is returned if it has been booked.
+func (m ServiceModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM services WHERE id = $1`, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrServiceInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Prices returns the price history of a service, newest first, with the list prices before
+// the overrides.
+func (m ServiceModel) Prices(serviceID int64) ([]*ServicePrice, error) {
+	query := `
+		SELECT ` + servicePriceColumns + `
+		FROM service_prices
+		WHERE service_id = $1
+		ORDER BY doctor_id IS NOT NULL, branch_id IS NOT NULL, branch_id, doctor_id, effective_from DESC
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []*ServicePrice{}
+	for rows.Next() {
+		var p ServicePrice
+		if err := scanServicePrice(rows, &p); err != nil {
+			return nil, err
+		}
+		prices = append(prices, &p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// InsertPrice adds a price to the history of its service. ErrRecordNotFound is returned if the
+// service, branch or doctor doesn't exist.
+func (m ServiceModel) InsertPrice(p *ServicePrice) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertServicePrice(ctx, tx, p); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertServicePrice(ctx context.Context, tx *sql.Tx, p *ServicePrice) error {
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO service_prices (service_id, branch_id, doctor_id, amount, currency, effective_from)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+		`, p.ServiceID, p.BranchID, p.DoctorID, p.Amount, p.Currency, p.EffectiveFrom).Scan(&p.ID, &p.CreatedAt)
+	var pqErr *pq.Error
+	switch {
+	case isUniqueViolation(err):
+		return ErrDuplicatePrice
+	case errors.As(err, &pqErr) && pqErr.Code == "23503":
+		return ErrRecordNotFound
+	default:
+		return err
+	}
+}
+
+// DeletePrice removes a price which hasn't taken effect yet. ErrPriceInEffect is returned for
+// the others.
+func (m ServiceModel) DeletePrice(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// If nothing was deleted, the second query tells whether the price exists.
+	var inEffect bool
+	err := m.DB.QueryRowContext(ctx, `
+		WITH deleted AS (
+			DELETE FROM service_prices WHERE id = $1 AND effective_from > CURRENT_DATE RETURNING id
+		)
+		SELECT EXISTS (SELECT 1 FROM service_prices WHERE id = $1)
+		WHERE NOT EXISTS (SELECT 1 FROM deleted)
+		`, id).Scan(&inEffect)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	case inEffect:
+		return ErrPriceInEffect
+	default:
+		return ErrRecordNotFound
+	}
+}
+
+// Quote returns the price of a service on day for a doctor at a branch: the most specific
+// price in effect that day, preferring overrides for both the doctor and the branch to those
+// for the doctor, then those for the branch and last the list price. A zero doctorID or
+// branchID only matches prices which aren't limited to one.
+func (m ServiceModel) Quote(serviceID, doctorID, branchID int64, day string) (*ServicePrice, error) {
+	query := `
+		SELECT ` + servicePriceColumns + `
+		FROM service_prices
+		WHERE service_id = $1 AND effective_from <= $4::date
+			AND (doctor_id IS NULL OR doctor_id = $2) AND (branch_id IS NULL OR branch_id = $3)
+		ORDER BY doctor_id IS NULL, branch_id IS NULL, effective_from DESC
+		LIMIT 1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p ServicePrice
+	err := scanServicePrice(m.DB.QueryRowContext(ctx, query, serviceID, doctorID, branchID, day), &p)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoPrice
+		default:
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+// servicesDuration returns how long an appointment for the services lasts.
+// ErrServiceUnavailable is returned if one of them doesn't exist or is inactive, and
+// ErrDuplicateService if one is listed twice, which the count of the services found would
+// otherwise report as missing.
+func servicesDuration(ctx context.Context, tx *sql.Tx, serviceIDs []int64) (int, error) {
+	seen := make(map[int64]bool, len(serviceIDs))
+	for _, id := range serviceIDs {
+		if seen[id] {
+			return 0, ErrDuplicateService
+		}
+		seen[id] = true
+	}
+
+	var found, minutes int
+	err := tx.QueryRowContext(ctx, `
+		SELECT count(*), COALESCE(sum(duration_minutes), 0) FROM services WHERE id = ANY($1) AND active
+		`, pq.Array(serviceIDs)).Scan(&found, &minutes)
+	if err != nil {
+		return 0, err
+	}
+	if found != len(serviceIDs) {
+		return 0, ErrServiceUnavailable
+	}
+	return minutes, nil
+}
+
+// insertAppointmentServices records the services of appointment a, which has been saved in tx,
+// with the prices they have for its doctor at its branch on its day in the branch's time zone.
+func insertAppointmentServices(ctx context.Context, tx *sql.Tx, a *Appointment, serviceIDs []int64) error {
+	if len(serviceIDs) == 0 {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT s.id, s.duration_minutes, s.vat_rate, p.amount, p.currency
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS ids (service_id, position)
+			JOIN appointments a ON a.id = $1
+			JOIN doctors d ON d.id = a.doctor_id
+			LEFT JOIN branches b ON b.id = a.branch_id
+			JOIN services s ON s.id = ids.service_id AND s.active
+				AND (s.speciality = '' OR lower(s.speciality) = lower(d.speciality))
+			LEFT JOIN LATERAL (
+				SELECT amount, currency
+				FROM service_prices
+				WHERE service_id = s.id
+					AND effective_from <= (a.date_time AT TIME ZONE COALESCE(b.time_zone, current_setting('TimeZone')))::date
+					AND (doctor_id IS NULL OR doctor_id = a.doctor_id)
+					AND (branch_id IS NULL OR branch_id = a.branch_id)
+				ORDER BY doctor_id IS NULL, branch_id IS NULL, effective_from DESC
+				LIMIT 1
+			) p ON true
+		ORDER BY ids.position
+		`, a.Id, pq.Array(serviceIDs))
+	if err != nil {
+		return err
+	}
+
+	type line struct {
+		serviceID int64
+		minutes   int
+		vatRate   int
+		amount    *int64
+		currency  sql.NullString
+	}
+	var lines []line
+	for rows.Next() {
+		var l line
+		if err := rows.Scan(&l.serviceID, &l.minutes, &l.vatRate, &l.amount, &l.currency); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(lines) != len(serviceIDs) {
+		return ErrServiceUnavailable
+	}
+	for i, l := range lines {
+		if l.amount == nil {
+			return ErrNoPrice
+		}
+		if l.currency.String != lines[0].currency.String {
+			return ErrCurrencyMismatch
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO appointment_services (appointment_id, service_id, position, duration_minutes, amount, currency, vat_rate)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			`, a.Id, l.serviceID, i+1, l.minutes, *l.amount, l.currency.String, l.vatRate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetServices replaces the services of appointment a and derives its duration from them; without
+// services it lasts DefaultAppointmentDuration. Unless a is cancelled, the doctor and the room
+// must be free for the new duration. The new services are priced as when they are booked.
+// ErrAlreadyInvoiced is returned once the appointment's invoice has been issued.
+func (m AppointmentModel) SetServices(a *Appointment, serviceIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The invoice of an appointment is made of its services, so they are kept once it is issued.
+	var invoiced bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM invoices WHERE appointment_id = $1 AND kind = 'invoice' AND status NOT IN ('draft', 'void')
+		)
+		`, a.Id).Scan(&invoiced)
+	if err != nil {
+		return err
+	}
+	if invoiced {
+		return ErrAlreadyInvoiced
+	}
+
+	a.DurationMinutes = int(DefaultAppointmentDuration / time.Minute)
+	if len(serviceIDs) > 0 {
+		if a.DurationMinutes, err = servicesDuration(ctx, tx, serviceIDs); err != nil {
+			return err
+		}
+	}
+
+	if a.Status != AppointmentCancelled {
+		if err := lockDoctorSlot(ctx, tx, a.DoctorID, a.DateTime, a.Id, 0, a.DurationMinutes); err != nil {
+			return err
+		}
+	}
+	if err := placeAppointment(ctx, tx, a); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE appointments SET duration_minutes = $2, updated_at = NOW() WHERE id = $1 RETURNING updated_at
+		`, a.Id, a.DurationMinutes).Scan(&a.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM appointment_services WHERE appointment_id = $1`, a.Id); err != nil {
+		return err
+	}
+	if err := insertAppointmentServices(ctx, tx, a, serviceIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Cost returns the services of an appointment and what they cost.
+func (m AppointmentModel) Cost(a *Appointment) (*AppointmentCost, error) {
+	query := `
+		SELECT s.id, s.code, s.name, x.duration_minutes, x.amount, x.currency, x.vat_rate
+		FROM appointment_services x
+			JOIN services s ON s.id = x.service_id
+		WHERE x.appointment_id = $1
+		ORDER BY x.position
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, a.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cost := &AppointmentCost{DurationMinutes: int(a.Duration() / time.Minute), Services: []*AppointmentService{}}
+	cost.AppointmentID, _ = strconv.ParseInt(a.Id, 10, 64)
+	for rows.Next() {
+		var s AppointmentService
+		if err := rows.Scan(&s.ServiceID, &s.Code, &s.Name, &s.DurationMinutes, &s.Amount, &s.Currency,
+			&s.VATRate); err != nil {
+			return nil, err
+		}
+		cost.Services = append(cost.Services, &s)
+		cost.Currency = s.Currency
+		cost.Net += s.Amount
+		cost.VAT += VATAmount(s.Amount, s.VATRate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	cost.Total = cost.Net + cost.VAT
+
+	return cost, nil
+}
@@ -0,0 +1,424 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// ErrShiftOpen is returned when a cashier who has an open shift opens another one.
+var ErrShiftOpen = errors.New("cashier already has an open shift")
+
+// ErrShiftClosed is returned when a shift which has been closed is closed again.
+var ErrShiftClosed = errors.New("shift has already been closed")
+
+// CashierShift is a cashier's shift at a till. OpeningFloat is the cash in Currency the till
+// started with. Totals are what the shift's payments came to per method and currency, and once
+// the shift is closed what was counted.
+type CashierShift struct {
+	ID           int64         `json:"id"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UserID       int64         `json:"user_id"`
+	BranchID     *int64        `json:"branch_id,omitempty"`
+	Currency     string        `json:"currency"`
+	OpeningFloat int64         `json:"opening_float"`
+	OpenedAt     time.Time     `json:"opened_at"`
+	ClosedAt     *time.Time    `json:"closed_at,omitempty"`
+	ClosedBy     *int64        `json:"closed_by,omitempty"`
+	Notes        string        `json:"notes"`
+	Totals       []*ShiftTotal `json:"totals"`
+}
+
+// ShiftTotal is what a shift expected of a payment method in a currency: for cash in the
+// shift's currency the opening float, plus the payments less the refunds. Counted is what the
+// cashier counted on closing, if anything, and Difference the discrepancy, counted less
+// expected.
+type ShiftTotal struct {
+	Method     string `json:"method"`
+	Currency   string `json:"currency"`
+	Expected   int64  `json:"expected"`
+	Counted    *int64 `json:"counted,omitempty"`
+	Difference *int64 `json:"difference,omitempty"`
+}
+
+// ShiftCount is what a cashier counted of a payment method in a currency on closing a shift.
+type ShiftCount struct {
+	Method   string `json:"method"`
+	Currency string `json:"currency"`
+	Amount   int64  `json:"amount"`
+}
+
+// ShiftDiscrepancy is a total of a closed shift which wasn't counted as expected.
+type ShiftDiscrepancy struct {
+	ShiftID  int64     `json:"shift_id"`
+	UserID   int64     `json:"user_id"`
+	UserName string    `json:"user_name"`
+	BranchID *int64    `json:"branch_id,omitempty"`
+	Branch   string    `json:"branch,omitempty"`
+	OpenedAt time.Time `json:"opened_at"`
+	ClosedAt time.Time `json:"closed_at"`
+	ShiftTotal
+}
+
+type ShiftModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateShift(v *validator.Validator, s *CashierShift) {
+	v.Check(s.BranchID == nil || *s.BranchID > 0, "branch_id", "must be a positive integer")
+	v.Check(validator.Matches(s.Currency, CurrencyRX), "currency", "must be an ISO 4217 code such as EUR")
+	v.Check(s.OpeningFloat >= 0, "opening_float", "must not be negative")
+}
+
+func ValidateShiftCounts(v *validator.Validator, counts []ShiftCount) {
+	seen := make(map[string]bool)
+	for i, c := range counts {
+		prefix := fmt.Sprintf("counts[%d].", i)
+		v.Check(validator.In(c.Method, PaymentMethods...), prefix+"method", "must be a payment method")
+		v.Check(validator.Matches(c.Currency, CurrencyRX), prefix+"currency", "must be an ISO 4217 code such as EUR")
+		v.Check(c.Amount >= 0, prefix+"amount", "must not be negative")
+		v.Check(!seen[c.Method+c.Currency], prefix+"method", "must not be counted twice in the same currency")
+		seen[c.Method+c.Currency] = true
+	}
+}
+
+const shiftColumns = `
+	id, created_at, user_id, branch_id, currency, opening_float, opened_at, closed_at, closed_by, notes`
+
+func scanShift(row interface{ Scan(...interface{}) error }, s *CashierShift) error {
+	return row.Scan(&s.ID, &s.CreatedAt, &s.UserID, &s.BranchID, &s.Currency, &s.OpeningFloat, &s.OpenedAt,
+		&s.ClosedAt, &s.ClosedBy, &s.Notes)
+}
+
+// Open opens a shift for a cashier. ErrShiftOpen is returned if the cashier already has one.
+func (m ShiftModel) Open(s *CashierShift) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, `
+		INSERT INTO cashier_shifts (user_id, branch_id, currency, opening_float, notes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, opened_at
+		`, s.UserID, s.BranchID, s.Currency, s.OpeningFloat, s.Notes).Scan(&s.ID, &s.CreatedAt, &s.OpenedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case isUniqueViolation(err):
+			return ErrShiftOpen
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	s.Totals = []*ShiftTotal{{Method: "cash", Currency: s.Currency, Expected: s.OpeningFloat}}
+	return nil
+}
+
+// Get returns a shift with its totals, which are up to date while it is open.
+func (m ShiftModel) Get(id int64) (*CashierShift, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s CashierShift
+	err := scanShift(m.DB.QueryRowContext(ctx, `SELECT `+shiftColumns+` FROM cashier_shifts WHERE id = $1`, id), &s)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := m.totals(ctx, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Current returns the open shift of a cashier, or ErrRecordNotFound.
+func (m ShiftModel) Current(userID int64) (*CashierShift, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s CashierShift
+	err := scanShift(m.DB.QueryRowContext(ctx, `
+		SELECT `+shiftColumns+` FROM cashier_shifts WHERE user_id = $1 AND closed_at IS NULL
+		`, userID), &s)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := m.totals(ctx, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// totals fills in the totals of s: those stored when it was closed, or else what is expected
+// so far.
+func (m ShiftModel) totals(ctx context.Context, s *CashierShift) error {
+	if s.ClosedAt == nil {
+		var err error
+		s.Totals, err = expectedShiftTotals(ctx, m.DB, s)
+		return err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT method, currency, expected, counted
+		FROM cashier_shift_totals
+		WHERE shift_id = $1
+		ORDER BY currency, method
+		`, s.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s.Totals = []*ShiftTotal{}
+	for rows.Next() {
+		var t ShiftTotal
+		if err := rows.Scan(&t.Method, &t.Currency, &t.Expected, &t.Counted); err != nil {
+			return err
+		}
+		if t.Counted != nil {
+			difference := *t.Counted - t.Expected
+			t.Difference = &difference
+		}
+		s.Totals = append(s.Totals, &t)
+	}
+	return rows.Err()
+}
+
+// expectedShiftTotals returns what the payments of a shift came to per method and currency,
+// with the opening float added to the cash in the shift's currency. Refunds, the payments of
+// credit notes, are taken off.
+func expectedShiftTotals(ctx context.Context, db interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}, s *CashierShift) ([]*ShiftTotal, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.method, i.currency, sum(CASE WHEN i.kind = 'credit_note' THEN -p.amount ELSE p.amount END)
+		FROM payments p
+			JOIN invoices i ON i.id = p.invoice_id
+		WHERE p.shift_id = $1
+		GROUP BY p.method, i.currency
+		ORDER BY i.currency, p.method
+		`, s.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []*ShiftTotal{}
+	var cash *ShiftTotal
+	for rows.Next() {
+		var t ShiftTotal
+		if err := rows.Scan(&t.Method, &t.Currency, &t.Expected); err != nil {
+			return nil, err
+		}
+		if t.Method == "cash" && t.Currency == s.Currency {
+			cash = &t
+		}
+		totals = append(totals, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if cash == nil {
+		cash = &ShiftTotal{Method: "cash", Currency: s.Currency}
+		totals = append([]*ShiftTotal{cash}, totals...)
+	}
+	cash.Expected += s.OpeningFloat
+	return totals, nil
+}
+
+// Close closes a shift with what was counted. A count for a method and currency nothing was
+// expected of is kept as an unexpected amount.
+func (m ShiftModel) Close(id int64, counts []ShiftCount, notes string, closedBy *int64) (*CashierShift, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var s CashierShift
+	err = scanShift(tx.QueryRowContext(ctx, `SELECT `+shiftColumns+` FROM cashier_shifts WHERE id = $1 FOR UPDATE`, id),
+		&s)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if s.ClosedAt != nil {
+		return nil, ErrShiftClosed
+	}
+
+	s.Totals, err = expectedShiftTotals(ctx, tx, &s)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		var total *ShiftTotal
+		for _, t := range s.Totals {
+			if t.Method == c.Method && t.Currency == c.Currency {
+				total = t
+			}
+		}
+		if total == nil {
+			total = &ShiftTotal{Method: c.Method, Currency: c.Currency}
+			s.Totals = append(s.Totals, total)
+		}
+		counted := c.Amount
+		difference := counted - total.Expected
+		total.Counted, total.Difference = &counted, &difference
+	}
+
+	for _, t := range s.Totals {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO cashier_shift_totals (shift_id, method, currency, expected, counted)
+			VALUES ($1, $2, $3, $4, $5)
+			`, id, t.Method, t.Currency, t.Expected, t.Counted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if notes != "" {
+		if s.Notes != "" {
+			s.Notes += "\n"
+		}
+		s.Notes += notes
+	}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE cashier_shifts SET closed_at = NOW(), closed_by = $2, notes = $3 WHERE id = $1 RETURNING closed_at
+		`, id, closedBy, s.Notes).Scan(&s.ClosedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.ClosedBy = closedBy
+
+	return &s, tx.Commit()
+}
+
+// ShiftFilter narrows down a list of shifts. Zero values match everything; From and To limit
+// the time the shifts were opened.
+type ShiftFilter struct {
+	UserID   int64
+	BranchID int64
+	OpenOnly bool
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+// GetAll returns the shifts matching the filter without their totals, latest first.
+func (m ShiftModel) GetAll(f ShiftFilter) ([]*CashierShift, error) {
+	query := `
+		SELECT ` + shiftColumns + `
+		FROM cashier_shifts
+		WHERE ($1 = 0 OR user_id = $1)
+			AND ($2 = 0 OR branch_id = $2)
+			AND (NOT $3 OR closed_at IS NULL)
+			AND ($4::timestamptz IS NULL OR opened_at >= $4)
+			AND ($5::timestamptz IS NULL OR opened_at < $5)
+		ORDER BY opened_at DESC, id DESC
+		LIMIT $6 OFFSET $7
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.UserID, f.BranchID, f.OpenOnly, nullTime(f.From), nullTime(f.To),
+		f.Limit, f.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shifts := []*CashierShift{}
+	for rows.Next() {
+		var s CashierShift
+		if err := scanShift(rows, &s); err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shifts, nil
+}
+
+// Discrepancies returns the totals of the shifts closed in the local days from and to
+// (YYYY-MM-DD, inclusive) which weren't counted as expected, or weren't counted at all, in the
+// order the shifts were closed. The days are those of the shift's branch time zone.
+func (m ShiftModel) Discrepancies(from, to string, branchID, userID int64) ([]*ShiftDiscrepancy, error) {
+	query := `
+		SELECT s.id, s.user_id, u.name, s.branch_id, COALESCE(b.name, ''), s.opened_at, s.closed_at,
+			t.method, t.currency, t.expected, t.counted
+		FROM cashier_shifts s
+			JOIN cashier_shift_totals t ON t.shift_id = s.id
+			JOIN users u ON u.id = s.user_id
+			LEFT JOIN branches b ON b.id = s.branch_id
+		WHERE s.closed_at IS NOT NULL
+			AND (s.closed_at AT TIME ZONE COALESCE(b.time_zone, current_setting('TimeZone')))::date BETWEEN $1 AND $2
+			AND ($3 = 0 OR s.branch_id = $3)
+			AND ($4 = 0 OR s.user_id = $4)
+			AND t.counted IS DISTINCT FROM t.expected
+		ORDER BY s.closed_at, s.id, t.currency, t.method
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to, branchID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discrepancies := []*ShiftDiscrepancy{}
+	for rows.Next() {
+		var d ShiftDiscrepancy
+		err := rows.Scan(&d.ShiftID, &d.UserID, &d.UserName, &d.BranchID, &d.Branch, &d.OpenedAt, &d.ClosedAt,
+			&d.Method, &d.Currency, &d.Expected, &d.Counted)
+		if err != nil {
+			return nil, err
+		}
+		if d.Counted != nil {
+			difference := *d.Counted - d.Expected
+			d.Difference = &difference
+		}
+		discrepancies = append(discrepancies, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return discrepancies, nil
+}
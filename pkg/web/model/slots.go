@@ -0,0 +1,149 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OpeningHours are the hours at which appointments can be booked, the same in every week.
+// Open and Close are offsets from midnight.
+type OpeningHours struct {
+	Days  []time.Weekday
+	Open  time.Duration
+	Close time.Duration
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseOpeningHours parses opening hours such as "Mon-Fri 08:00-18:00" or "Mon,Wed,Fri
+// 09:00-13:00".
+func ParseOpeningHours(s string) (OpeningHours, error) {
+	var hours OpeningHours
+
+	days, times, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		return hours, fmt.Errorf("invalid opening hours %q, must be like Mon-Fri 08:00-18:00", s)
+	}
+
+	for _, part := range strings.Split(days, ",") {
+		first, last, isRange := strings.Cut(strings.ToLower(part), "-")
+		from, ok1 := weekdayNames[first]
+		to, ok2 := weekdayNames[last]
+		if !isRange {
+			to, ok2 = from, ok1
+		}
+		if !ok1 || !ok2 {
+			return hours, fmt.Errorf("invalid days %q in opening hours", part)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			hours.Days = append(hours.Days, d)
+			if d == to {
+				break
+			}
+		}
+	}
+
+	opens, closes, ok := strings.Cut(strings.TrimSpace(times), "-")
+	o, err1 := time.Parse("15:04", opens)
+	c, err2 := time.Parse("15:04", closes)
+	if !ok || err1 != nil || err2 != nil || !c.After(o) {
+		return hours, fmt.Errorf("invalid times %q in opening hours", times)
+	}
+	hours.Open = time.Duration(o.Hour())*time.Hour + time.Duration(o.Minute())*time.Minute
+	hours.Close = time.Duration(c.Hour())*time.Hour + time.Duration(c.Minute())*time.Minute
+
+	return hours, nil
+}
+
+// Slot is a bookable appointment time.
+type Slot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// FreeSlots returns the times between from and to at which an appointment with the doctor can
+// be booked: every DefaultAppointmentDuration within the opening hours in loc which hasn't
+// passed and isn't taken by an appointment, held for a waitlisted patient or closed.
+func (m AppointmentModel) FreeSlots(doctorID int, from, to time.Time, hours OpeningHours,
+	loc *time.Location) ([]Slot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT date_time, date_time + make_interval(mins => duration_minutes)
+		FROM appointments
+		WHERE doctor_id = $1 AND status <> 'cancelled'
+			AND date_time < $3 AND date_time + make_interval(mins => duration_minutes) > $2
+		UNION ALL
+		SELECT date_time, date_time + make_interval(mins => $4)
+		FROM waitlist_offers
+		WHERE doctor_id = $1 AND status = 'pending' AND expires_at > NOW()
+			AND date_time < $3 AND date_time + make_interval(mins => $4) > $2
+		UNION ALL
+		SELECT starts_at, ends_at
+		FROM closures
+		WHERE (doctor_id IS NULL OR doctor_id = $1) AND starts_at < $3 AND ends_at > $2
+		ORDER BY 1
+		`, doctorID, from, to, int(DefaultAppointmentDuration/time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var busy []Slot
+	for rows.Next() {
+		var b Slot
+		if err := rows.Scan(&b.Start, &b.End); err != nil {
+			return nil, err
+		}
+		busy = append(busy, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	open := make(map[time.Weekday]bool)
+	for _, d := range hours.Days {
+		open[d] = true
+	}
+
+	now := time.Now()
+	slots := []Slot{}
+	first := from.In(loc)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !open[day.Weekday()] {
+			continue
+		}
+		// Opening hours are wall clock times, also on days when the clocks change.
+		opening := time.Date(day.Year(), day.Month(), day.Day(), 0, int(hours.Open/time.Minute), 0, 0, loc)
+		closing := time.Date(day.Year(), day.Month(), day.Day(), 0, int(hours.Close/time.Minute), 0, 0, loc)
+		for start := opening; !start.Add(DefaultAppointmentDuration).After(closing); start = start.Add(DefaultAppointmentDuration) {
+			end := start.Add(DefaultAppointmentDuration)
+			if start.Before(from) || end.After(to) || start.Before(now) {
+				continue
+			}
+			free := true
+			for _, b := range busy {
+				if b.Start.Before(end) && b.End.After(start) {
+					free = false
+					break
+				}
+			}
+			if free {
+				slots = append(slots, Slot{Start: start, End: end})
+			}
+		}
+	}
+
+	return slots, nil
+}
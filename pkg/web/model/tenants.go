@@ -0,0 +1,152 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// DefaultTenant is the slug of the tenant which owns the data created before there were
+// tenants.
+const DefaultTenant = "default"
+
+// SlugRX matches the slugs of tenants.
+var SlugRX = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Tenant is a clinic hosted by the deployment. The rows of every other table except users'
+// tokens and permissions belong to a tenant, and are only visible on the tenant's
+// connections.
+type Tenant struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+}
+
+// TenantModel works on the tenants table, which isn't scoped to a tenant.
+type TenantModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateTenant(v *validator.Validator, t *Tenant) {
+	v.Check(t.Name != "", "name", "must be provided")
+	v.Check(len(t.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(validator.Matches(t.Slug, SlugRX), "slug", "must contain only lowercase letters, digits and dashes")
+	v.Check(len(t.Slug) <= 63, "slug", "must not be more than 63 bytes long")
+}
+
+// BypassesRLS returns the role the connections log in as and whether it bypasses row-level
+// security, as superusers and roles with BYPASSRLS do, even on tables which force it. Tenants
+// must not be connected with such a role, or every tenant sees the rows of every other.
+func (m TenantModel) BypassesRLS() (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var (
+		role   string
+		bypass bool
+	)
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT rolname, rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user
+		`).Scan(&role, &bypass)
+	return role, bypass, err
+}
+
+// TenantDSN returns the data source name of connections which only see the data of a tenant.
+// They set app.tenant_id, which the row-level security policies of the tenant tables check.
+// Options given in dsn are replaced.
+func TenantDSN(dsn string, tenantID int64) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		if dsn, err = pq.ParseURL(dsn); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s options='-c app.tenant_id=%d'", dsn, tenantID), nil
+}
+
+const tenantColumns = `id, created_at, name, slug`
+
+func scanTenant(row interface{ Scan(...interface{}) error }, t *Tenant) error {
+	return row.Scan(&t.ID, &t.CreatedAt, &t.Name, &t.Slug)
+}
+
+func (m TenantModel) Insert(t *Tenant) error {
+	query := `
+		INSERT INTO tenants (name, slug)
+		VALUES ($1, $2)
+		RETURNING id, created_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, t.Name, t.Slug).Scan(&t.ID, &t.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+func (m TenantModel) Get(id int64) (*Tenant, error) {
+	return m.get(`SELECT `+tenantColumns+` FROM tenants WHERE id = $1`, id)
+}
+
+func (m TenantModel) GetBySlug(slug string) (*Tenant, error) {
+	return m.get(`SELECT `+tenantColumns+` FROM tenants WHERE slug = $1`, slug)
+}
+
+func (m TenantModel) get(query string, arg interface{}) (*Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t Tenant
+	err := scanTenant(m.DB.QueryRowContext(ctx, query, arg), &t)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// GetAll returns all tenants ordered by ID.
+func (m TenantModel) GetAll() ([]*Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+tenantColumns+` FROM tenants ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []*Tenant{}
+	for rows.Next() {
+		var t Tenant
+		if err := scanTenant(rows, &t); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
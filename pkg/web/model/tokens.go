@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"log"
 	"time"
 
@@ -16,6 +17,9 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	// ScopeAppointmentAction tokens belong to an appointment rather than a user and let a
+	// patient confirm or cancel it from a link, once.
+	ScopeAppointmentAction = "appointment_action"
 )
 
 type (
@@ -27,6 +31,9 @@ type (
 		UserID    int64     `json:"-"`
 		Expiry    time.Time `json:"expiry"`
 		Scope     string    `json:"-"`
+		// AppointmentID and UsedAt are only set for ScopeAppointmentAction tokens.
+		AppointmentID *int64     `json:"-"`
+		UsedAt        *time.Time `json:"-"`
 	}
 
 	// TokenModel struct wraps a sql.DB connection pool and allows us to work with the Token struct
@@ -80,6 +87,55 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
+// NewForAppointment creates a single-use appointment action token which expires at expiry.
+func (m TokenModel) NewForAppointment(appointmentID int64, expiry time.Time) (*Token, error) {
+	token, err := generateToken(0, time.Until(expiry), ScopeAppointmentAction)
+	if err != nil {
+		return nil, err
+	}
+	token.AppointmentID = &appointmentID
+
+	query := `
+		INSERT INTO tokens (hash, appointment_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, token.Hash, appointmentID, token.Expiry, token.Scope)
+	return token, err
+}
+
+// GetForAppointmentAction returns an appointment action token whether or not it has been used
+// or has expired, so that callers can tell the patient why a link no longer works.
+func (m TokenModel) GetForAppointmentAction(tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT appointment_id, expiry, used_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := Token{Plaintext: tokenPlaintext, Hash: tokenHash[:], Scope: ScopeAppointmentAction}
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeAppointmentAction).Scan(
+		&token.AppointmentID, &token.Expiry, &token.UsedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Create a Token instance containing the user ID, expiry, and scope information.
 	// Notice that we add the provided ttl (time-to-live) duration parameter to the
@@ -123,6 +179,18 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// newSecretToken returns a random token in the same format as generateToken, together with the
+// SHA-256 hash to store instead of it, for secrets kept outside the tokens table.
+func newSecretToken() (string, []byte, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", nil, err
+	}
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+	return plaintext, hash[:], nil
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
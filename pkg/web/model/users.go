@@ -28,6 +28,7 @@ var AnonymousUser = &User{}
 type User struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
+	TenantID  int64     `json:"tenant_id"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
@@ -91,12 +92,12 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 // if our table already contains the same email address and if so return ErrDuplicateEmail error.
 func (m UserModel) Insert(user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (tenant_id, name, email, password_hash, activated)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at, version
 		`
 
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []interface{}{user.TenantID, user.Name, user.Email, user.Password.hash, user.Activated}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -124,7 +125,7 @@ func (m UserModel) Insert(user *User) error {
 // or none at all, upon which we return a ErrRecordNotFound error).
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, tenant_id, name, email, password_hash, activated, version
 		FROM users
 		WHERE email = $1
 		`
@@ -137,6 +138,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.CreatedAt,
+		&user.TenantID,
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
@@ -202,7 +204,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	query := `
 		SELECT 
-			users.id, users.created_at, users.name, users.email, 
+			users.id, users.created_at, users.tenant_id, users.name, users.email, 
 			users.password_hash, users.activated, users.version
 		FROM       users
         INNER JOIN tokens
@@ -230,6 +232,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
 		&user.CreatedAt,
+		&user.TenantID,
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
@@ -0,0 +1,550 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"GoClinic/pkg/web/validator"
+)
+
+// Waitlist entry statuses. An entry is "offered" while one of its offers is pending and goes
+// back to "waiting" if the patient declines or lets the hold expire.
+const (
+	WaitlistWaiting = "waiting"
+	WaitlistOffered = "offered"
+	WaitlistBooked  = "booked"
+	WaitlistRemoved = "removed"
+)
+
+// Waitlist offer statuses. A "withdrawn" offer could not be accepted because the slot had been
+// booked in the meantime.
+const (
+	OfferPending   = "pending"
+	OfferAccepted  = "accepted"
+	OfferDeclined  = "declined"
+	OfferExpired   = "expired"
+	OfferWithdrawn = "withdrawn"
+)
+
+// WaitlistEntry is a patient waiting for an appointment with a doctor, or with any doctor of a
+// speciality, between Earliest and Latest. Entries with a higher priority are offered slots
+// first, and entries with the same priority in the order they were added.
+type WaitlistEntry struct {
+	ID            int64            `json:"id"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+	PatientID     int64            `json:"patient_id"`
+	DoctorID      *int64           `json:"doctor_id,omitempty"`
+	Speciality    string           `json:"speciality,omitempty"`
+	Earliest      time.Time        `json:"earliest"`
+	Latest        time.Time        `json:"latest"`
+	Priority      int              `json:"priority"`
+	Notes         string           `json:"notes,omitempty"`
+	Status        string           `json:"status"`
+	AppointmentID *int64           `json:"appointment_id,omitempty"`
+	CreatedBy     *int64           `json:"created_by,omitempty"`
+	Offers        []*WaitlistOffer `json:"offers,omitempty"`
+}
+
+// WaitlistOffer is a freed slot held for the patient of a waitlist entry until ExpiresAt.
+type WaitlistOffer struct {
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	EntryID       int64      `json:"entry_id"`
+	PatientID     int64      `json:"patient_id"`
+	DoctorID      int64      `json:"doctor_id"`
+	DateTime      time.Time  `json:"date_time"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	Status        string     `json:"status"`
+	RespondedAt   *time.Time `json:"responded_at,omitempty"`
+	AppointmentID *int64     `json:"appointment_id,omitempty"`
+	// Token is only known right after the offer has been made.
+	Token string `json:"-"`
+}
+
+type WaitlistModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateWaitlistEntry(v *validator.Validator, e *WaitlistEntry) {
+	v.Check(e.PatientID > 0, "patient_id", "must be provided")
+	v.Check(e.DoctorID != nil || e.Speciality != "", "doctor_id", "must be provided unless speciality is")
+	v.Check(e.DoctorID == nil || *e.DoctorID > 0, "doctor_id", "must be a positive integer")
+	v.Check(len(e.Speciality) <= 100, "speciality", "must not be more than 100 bytes long")
+	v.Check(!e.Earliest.IsZero(), "earliest", "must be provided")
+	v.Check(!e.Latest.IsZero(), "latest", "must be provided")
+	v.Check(e.Latest.After(e.Earliest), "latest", "must be after earliest")
+	v.Check(e.Latest.After(time.Now()), "latest", "must be in the future")
+	v.Check(e.Priority >= 0 && e.Priority <= 100, "priority", "must be between 0 and 100")
+	v.Check(len(e.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+}
+
+// Insert adds an entry to the waitlist. If the patient or doctor doesn't exist
+// ErrRecordNotFound is returned.
+func (m WaitlistModel) Insert(e *WaitlistEntry) error {
+	query := `
+		INSERT INTO waitlist_entries (patient_id, doctor_id, speciality, earliest, latest, priority, notes, created_by)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8
+		WHERE EXISTS (SELECT 1 FROM patients WHERE id = $1)
+			AND ($2::bigint IS NULL OR EXISTS (SELECT 1 FROM doctors WHERE id = $2))
+		RETURNING id, created_at, updated_at, status
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	e.Speciality = strings.TrimSpace(e.Speciality)
+	err := m.DB.QueryRowContext(ctx, query, e.PatientID, e.DoctorID, e.Speciality, e.Earliest, e.Latest, e.Priority,
+		e.Notes, e.CreatedBy).Scan(&e.ID, &e.CreatedAt, &e.UpdatedAt, &e.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+const waitlistEntryColumns = `id, created_at, updated_at, patient_id, doctor_id, speciality, earliest, latest,
+	priority, notes, status, appointment_id, created_by`
+
+func scanWaitlistEntry(row interface{ Scan(...interface{}) error }, e *WaitlistEntry) error {
+	return row.Scan(&e.ID, &e.CreatedAt, &e.UpdatedAt, &e.PatientID, &e.DoctorID, &e.Speciality, &e.Earliest,
+		&e.Latest, &e.Priority, &e.Notes, &e.Status, &e.AppointmentID, &e.CreatedBy)
+}
+
+// Get returns an entry together with the offers made for it.
+func (m WaitlistModel) Get(id int64) (*WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var e WaitlistEntry
+	err := scanWaitlistEntry(m.DB.QueryRowContext(ctx, `SELECT `+waitlistEntryColumns+` FROM waitlist_entries WHERE id = $1`, id), &e)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+waitlistOfferColumns+`
+		FROM waitlist_offers o
+		INNER JOIN waitlist_entries e ON e.id = o.entry_id
+		WHERE o.entry_id = $1
+		ORDER BY o.created_at, o.id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o WaitlistOffer
+		if err := scanWaitlistOffer(rows, &o); err != nil {
+			return nil, err
+		}
+		e.Offers = append(e.Offers, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// GetAll returns the entries with the given status, or all of them if status is empty, in the
+// order in which they are offered slots. A non-zero doctorID limits the result to entries
+// which a slot of that doctor could be offered to, and a non-zero branchID to entries which a
+// slot of a doctor of the branch could be offered to.
+func (m WaitlistModel) GetAll(status string, doctorID, branchID int64) ([]*WaitlistEntry, error) {
+	query := `
+		SELECT ` + waitlistEntryColumns + `
+		FROM waitlist_entries e
+		WHERE ($1 = '' OR status = $1)
+			AND ($2 = 0 OR e.doctor_id = $2 OR (e.doctor_id IS NULL
+				AND lower(e.speciality) = (SELECT lower(speciality) FROM doctors WHERE id = $2)))
+			AND ($3 = 0 OR e.doctor_id IN (SELECT doctor_id FROM doctor_branches WHERE branch_id = $3)
+				OR (e.doctor_id IS NULL AND lower(e.speciality) IN (
+					SELECT lower(d.speciality)
+					FROM doctors d
+					JOIN doctor_branches db ON db.doctor_id = d.id
+					WHERE db.branch_id = $3)))
+		ORDER BY priority DESC, created_at, id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, doctorID, branchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*WaitlistEntry{}
+	for rows.Next() {
+		var e WaitlistEntry
+		if err := scanWaitlistEntry(rows, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Remove takes an entry off the waitlist, withdrawing its pending offer if there is one. It
+// returns the withdrawn offer, if any, so that the slot can be offered to someone else.
+func (m WaitlistModel) Remove(id int64) (*WaitlistOffer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE waitlist_entries
+		SET status = 'removed', updated_at = NOW()
+		WHERE id = $1 AND status IN ('waiting', 'offered')
+		`, id)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	var offer WaitlistOffer
+	err = scanWaitlistOffer(tx.QueryRowContext(ctx, `
+		WITH o AS (
+			UPDATE waitlist_offers
+			SET status = 'withdrawn', responded_at = NOW()
+			WHERE entry_id = $1 AND status = 'pending'
+			RETURNING *
+		)
+		SELECT `+waitlistOfferColumns+`
+		FROM o
+		INNER JOIN waitlist_entries e ON e.id = o.entry_id
+		`, id), &offer)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, tx.Commit()
+	case err != nil:
+		return nil, err
+	}
+
+	return &offer, tx.Commit()
+}
+
+const waitlistOfferColumns = `o.id, o.created_at, o.entry_id, e.patient_id, o.doctor_id, o.date_time, o.expires_at,
+	o.status, o.responded_at, o.appointment_id`
+
+func scanWaitlistOffer(row interface{ Scan(...interface{}) error }, o *WaitlistOffer) error {
+	return row.Scan(&o.ID, &o.CreatedAt, &o.EntryID, &o.PatientID, &o.DoctorID, &o.DateTime, &o.ExpiresAt, &o.Status,
+		&o.RespondedAt, &o.AppointmentID)
+}
+
+// Offer holds the slot of doctorID at start for the first waitlist entry it suits, until hold
+// has passed or the slot starts. Entries which were offered the slot before are skipped. It
+// returns ErrSlotTaken if the slot is booked or already on offer, ErrDoctorUnavailable if it is
+// closed, and ErrRecordNotFound if nobody is waiting for it.
+func (m WaitlistModel) Offer(doctorID int64, start time.Time, hold time.Duration) (*WaitlistOffer, error) {
+	if !start.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The slot must be free and not held by another offer. Bookings take the same lock and
+	// treat the pending offer as taking the slot until it is answered or expires.
+	if err := lockDoctorSlot(ctx, tx, int(doctorID), start.Format(time.RFC3339), "0", 0,
+		int(DefaultAppointmentDuration/time.Minute)); err != nil {
+		return nil, err
+	}
+
+	var entryID, patientID int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT e.id, e.patient_id
+		FROM waitlist_entries e
+		WHERE e.status = 'waiting' AND e.earliest <= $2 AND e.latest >= $2
+			AND (e.doctor_id = $1 OR (e.doctor_id IS NULL
+				AND lower(e.speciality) = (SELECT lower(speciality) FROM doctors WHERE id = $1)))
+			AND NOT EXISTS (
+				SELECT 1 FROM waitlist_offers o
+				WHERE o.doctor_id = $1 AND o.date_time = $2 AND (o.entry_id = e.id OR o.status = 'pending'))
+			AND NOT EXISTS (
+				SELECT 1 FROM appointments a
+				WHERE a.patient_id = e.patient_id AND a.date_time = $2 AND a.status <> 'cancelled')
+		ORDER BY e.priority DESC, e.created_at, e.id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+		`, doctorID, start).Scan(&entryID, &patientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+
+	offer := &WaitlistOffer{EntryID: entryID, PatientID: patientID, DoctorID: doctorID, DateTime: start, Token: token}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO waitlist_offers (entry_id, doctor_id, date_time, expires_at, token_hash)
+		VALUES ($1, $2, $3, LEAST(NOW() + make_interval(secs => $4), $3), $5)
+		RETURNING id, created_at, expires_at, status
+		`, entryID, doctorID, start, hold.Seconds(), hash).Scan(&offer.ID, &offer.CreatedAt, &offer.ExpiresAt, &offer.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE waitlist_entries SET status = 'offered', updated_at = NOW() WHERE id = $1`, entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return offer, tx.Commit()
+}
+
+// GetOfferForToken returns the offer of an accept/decline link, whatever its status.
+func (m WaitlistModel) GetOfferForToken(token string) (*WaitlistOffer, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var offer WaitlistOffer
+	err := scanWaitlistOffer(m.DB.QueryRowContext(ctx, `
+		SELECT `+waitlistOfferColumns+`
+		FROM waitlist_offers o
+		INNER JOIN waitlist_entries e ON e.id = o.entry_id
+		WHERE o.token_hash = $1
+		`, hash[:]), &offer)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &offer, nil
+}
+
+// Accept books the slot of a pending offer for the patient. It returns ErrTokenUsed if the
+// offer has already been answered, ErrTokenExpired if the hold has expired, and ErrSlotTaken
+// or ErrDoctorUnavailable if the slot was booked or closed in the meantime, in which case the
+// offer is withdrawn.
+func (m WaitlistModel) Accept(token string) (*WaitlistOffer, *Appointment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	offer, err := m.lockPendingOffer(ctx, tx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = lockDoctorSlot(ctx, tx, int(offer.DoctorID), offer.DateTime.Format(time.RFC3339), "0", offer.ID,
+		int(DefaultAppointmentDuration/time.Minute))
+	if errors.Is(err, ErrSlotTaken) || errors.Is(err, ErrDoctorUnavailable) {
+		if err := m.closeOffer(ctx, tx, offer, OfferWithdrawn, WaitlistWaiting); err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+		return offer, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	appointment := &Appointment{DoctorID: int(offer.DoctorID), PatientID: int(offer.PatientID),
+		DateTime: offer.DateTime.Format(time.RFC3339)}
+	if err := placeAppointment(ctx, tx, appointment); err != nil {
+		return nil, nil, err
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO appointments (date_time, doctor_id, patient_id, branch_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, date_time, status, duration_minutes
+		`, offer.DateTime, offer.DoctorID, offer.PatientID, appointment.BranchID).Scan(&appointment.Id, &appointment.CreatedAt,
+		&appointment.UpdatedAt, &appointment.DateTime, &appointment.Status, &appointment.DurationMinutes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE waitlist_offers
+		SET status = 'accepted', responded_at = NOW(), appointment_id = $2
+		WHERE id = $1
+		RETURNING status, responded_at, appointment_id
+		`, offer.ID, appointment.Id).Scan(&offer.Status, &offer.RespondedAt, &offer.AppointmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE waitlist_entries
+		SET status = 'booked', appointment_id = $2, updated_at = NOW()
+		WHERE id = $1
+		`, offer.EntryID, appointment.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return offer, appointment, tx.Commit()
+}
+
+// Decline gives up a pending offer; the patient stays on the waitlist for other slots. It
+// returns ErrTokenUsed if the offer has already been answered and ErrTokenExpired if the
+// hold has expired.
+func (m WaitlistModel) Decline(token string) (*WaitlistOffer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	offer, err := m.lockPendingOffer(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.closeOffer(ctx, tx, offer, OfferDeclined, WaitlistWaiting); err != nil {
+		return nil, err
+	}
+
+	return offer, tx.Commit()
+}
+
+func (m WaitlistModel) lockPendingOffer(ctx context.Context, tx *sql.Tx, token string) (*WaitlistOffer, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	var offer WaitlistOffer
+	err := scanWaitlistOffer(tx.QueryRowContext(ctx, `
+		SELECT `+waitlistOfferColumns+`
+		FROM waitlist_offers o
+		INNER JOIN waitlist_entries e ON e.id = o.entry_id
+		WHERE o.token_hash = $1
+		FOR UPDATE OF o
+		`, hash[:]), &offer)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	switch {
+	case offer.Status == OfferExpired, offer.Status == OfferPending && !time.Now().Before(offer.ExpiresAt):
+		return nil, ErrTokenExpired
+	case offer.Status != OfferPending:
+		return nil, ErrTokenUsed
+	}
+
+	return &offer, nil
+}
+
+// closeOffer ends a pending offer with status and puts its entry into entryStatus.
+func (m WaitlistModel) closeOffer(ctx context.Context, tx *sql.Tx, offer *WaitlistOffer, status, entryStatus string) error {
+	err := tx.QueryRowContext(ctx, `
+		UPDATE waitlist_offers
+		SET status = $2, responded_at = NOW()
+		WHERE id = $1
+		RETURNING status, responded_at
+		`, offer.ID, status).Scan(&offer.Status, &offer.RespondedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE waitlist_entries
+		SET status = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'offered'
+		`, offer.EntryID, entryStatus)
+	return err
+}
+
+// ExpireOffers ends the pending offers whose hold has expired and returns them, so that their
+// slots can be offered to the next patients. Concurrent callers each get different offers.
+func (m WaitlistModel) ExpireOffers() ([]*WaitlistOffer, error) {
+	query := `
+		WITH o AS (
+			UPDATE waitlist_offers
+			SET status = 'expired', responded_at = NOW()
+			WHERE status = 'pending' AND expires_at <= NOW()
+			RETURNING *
+		), entries AS (
+			UPDATE waitlist_entries e
+			SET status = 'waiting', updated_at = NOW()
+			FROM o
+			WHERE e.id = o.entry_id AND e.status = 'offered'
+		)
+		SELECT ` + waitlistOfferColumns + `
+		FROM o
+		INNER JOIN waitlist_entries e ON e.id = o.entry_id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []*WaitlistOffer
+	for rows.Next() {
+		var o WaitlistOffer
+		if err := scanWaitlistOffer(rows, &o); err != nil {
+			return nil, err
+		}
+		offers = append(offers, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return offers, nil
+}
@@ -0,0 +1,31 @@
+// Package notify delivers short messages to patients over email or SMS.
+package notify
+
+import (
+	"context"
+	"errors"
+)
+
+// Channels a Notifier can deliver over.
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// ErrNoRecipient is returned when a message has no address for the notifier's channel.
+var ErrNoRecipient = errors.New("notify: no recipient")
+
+// Message is a notification. Subject is only used by channels which have one.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages over one channel.
+type Notifier interface {
+	// Channel returns the channel the notifier delivers over, e.g. ChannelSMS.
+	Channel() string
+	// Send delivers msg. An error means that the message may not have been delivered.
+	Send(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPSMS sends text messages through an HTTP gateway. Each message is POSTed to URL as
+//
+//	{"from": "...", "to": "+15551234567", "message": "..."}
+//
+// with the token, if any, as a bearer token. Any 2xx response counts as accepted, which is
+// what most gateways and simple stub servers agree on.
+type HTTPSMS struct {
+	URL    string
+	Token  string
+	From   string
+	Client *http.Client
+}
+
+func (s *HTTPSMS) Channel() string { return ChannelSMS }
+
+func (s *HTTPSMS) Send(ctx context.Context, msg Message) error {
+	if msg.To == "" {
+		return ErrNoRecipient
+	}
+
+	body, err := json.Marshal(map[string]string{"from": s.From, "to": msg.To, "message": msg.Body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notify: sms gateway returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,373 @@
+package pdf
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// The DejaVu Sans fonts cover Latin, Greek and Cyrillic including the Kazakh letters, so patient
+// and doctor names print as entered. See fonts/LICENSE.
+var (
+	//go:embed fonts/DejaVuSansCondensed.ttf
+	regularTTF []byte
+	//go:embed fonts/DejaVuSansCondensed-Bold.ttf
+	boldTTF []byte
+)
+
+var (
+	regularFont = sync.OnceValue(func() *font { return mustParseFont("DejaVuSansCondensed", regularTTF) })
+	boldFont    = sync.OnceValue(func() *font { return mustParseFont("DejaVuSansCondensed-Bold", boldTTF) })
+)
+
+var errBadFont = errors.New("pdf: malformed TrueType font")
+
+// font is a parsed TrueType font. Only the tables needed to subset the font and to describe it
+// in a PDF are kept.
+type font struct {
+	name       string
+	tables     map[string][]byte
+	unitsPerEm int
+	bbox       [4]int
+	ascent     int
+	descent    int
+	capHeight  int
+	advances   []int
+	loca       []int
+	cmap       map[rune]uint16
+}
+
+func mustParseFont(name string, data []byte) *font {
+	f, err := parseFont(name, data)
+	if err != nil {
+		panic(fmt.Sprintf("%s: %v", name, err))
+	}
+	return f
+}
+
+func parseFont(name string, data []byte) (*font, error) {
+	if len(data) < 12 {
+		return nil, errBadFont
+	}
+
+	f := &font{name: name, tables: make(map[string][]byte)}
+	numTables := int(u16(data, 4))
+	for i := 0; i < numTables; i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, errBadFont
+		}
+		off, length := int(u32(data, rec+8)), int(u32(data, rec+12))
+		if off+length > len(data) {
+			return nil, errBadFont
+		}
+		f.tables[string(data[rec:rec+4])] = data[off : off+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "loca", "glyf"} {
+		if f.tables[tag] == nil {
+			return nil, fmt.Errorf("pdf: font has no %s table", tag)
+		}
+	}
+
+	head := f.tables["head"]
+	f.unitsPerEm = int(u16(head, 18))
+	f.bbox = [4]int{int(i16(head, 36)), int(i16(head, 38)), int(i16(head, 40)), int(i16(head, 42))}
+	longLoca := i16(head, 50) == 1
+
+	hhea := f.tables["hhea"]
+	f.ascent, f.descent = int(i16(hhea, 4)), int(i16(hhea, 6))
+	f.capHeight = f.ascent
+	if os2 := f.tables["OS/2"]; len(os2) >= 90 && u16(os2, 0) >= 2 {
+		f.capHeight = int(i16(os2, 88))
+	}
+
+	numGlyphs := int(u16(f.tables["maxp"], 4))
+	numHMetrics := int(u16(hhea, 34))
+	hmtx, loca := f.tables["hmtx"], f.tables["loca"]
+	if numHMetrics == 0 || len(hmtx) < 4*numHMetrics {
+		return nil, errBadFont
+	}
+	f.advances = make([]int, numGlyphs)
+	for g := range f.advances {
+		f.advances[g] = int(u16(hmtx, 4*min(g, numHMetrics-1)))
+	}
+
+	f.loca = make([]int, numGlyphs+1)
+	for g := range f.loca {
+		if longLoca {
+			if 4*g+4 > len(loca) {
+				return nil, errBadFont
+			}
+			f.loca[g] = int(u32(loca, 4*g))
+		} else {
+			if 2*g+2 > len(loca) {
+				return nil, errBadFont
+			}
+			f.loca[g] = 2 * int(u16(loca, 2*g))
+		}
+	}
+	if f.loca[numGlyphs] > len(f.tables["glyf"]) {
+		return nil, errBadFont
+	}
+
+	// Subsets don't need a character map, their text refers to glyph IDs.
+	f.cmap = make(map[rune]uint16)
+	if data := f.tables["cmap"]; data != nil {
+		cmap, err := parseCmap(data, numGlyphs)
+		if err != nil {
+			return nil, err
+		}
+		f.cmap = cmap
+	}
+	return f, nil
+}
+
+// parseCmap reads the Unicode character map, preferring the full repertoire subtable
+// (format 12) over the basic multilingual plane one (format 4).
+func parseCmap(data []byte, numGlyphs int) (map[rune]uint16, error) {
+	if len(data) < 4 {
+		return nil, errBadFont
+	}
+
+	var bmp, full []byte
+	for i := 0; i < int(u16(data, 2)); i++ {
+		rec := 4 + 8*i
+		if rec+8 > len(data) {
+			return nil, errBadFont
+		}
+		platform, encoding, off := u16(data, rec), u16(data, rec+2), int(u32(data, rec+4))
+		if off+4 > len(data) {
+			return nil, errBadFont
+		}
+		sub := data[off:]
+		switch format := u16(sub, 0); {
+		case format == 12 && (platform == 3 && encoding == 10 || platform == 0):
+			full = sub
+		case format == 4 && (platform == 3 && encoding == 1 || platform == 0):
+			bmp = sub
+		}
+	}
+
+	m := make(map[rune]uint16)
+	add := func(r rune, g int) {
+		if g > 0 && g < numGlyphs {
+			m[r] = uint16(g)
+		}
+	}
+
+	switch {
+	case full != nil:
+		if len(full) < 16 {
+			return nil, errBadFont
+		}
+		n := int(u32(full, 12))
+		if 16+12*n > len(full) {
+			return nil, errBadFont
+		}
+		for i := 0; i < n; i++ {
+			start, end, gid := u32(full, 16+12*i), u32(full, 20+12*i), u32(full, 24+12*i)
+			for c := start; c <= end && c <= 0x10FFFF; c++ {
+				add(rune(c), int(gid+c-start))
+			}
+		}
+	case bmp != nil:
+		if len(bmp) < 14 {
+			return nil, errBadFont
+		}
+		segX2 := int(u16(bmp, 6))
+		if 16+4*segX2 > len(bmp) {
+			return nil, errBadFont
+		}
+		for i := 0; i < segX2; i += 2 {
+			end, start := int(u16(bmp, 14+i)), int(u16(bmp, 16+segX2+i))
+			delta := int(u16(bmp, 16+2*segX2+i))
+			rangeAt := 16 + 3*segX2 + i
+			rangeOffset := int(u16(bmp, rangeAt))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				if rangeOffset == 0 {
+					add(rune(c), (c+delta)&0xFFFF)
+					continue
+				}
+				at := rangeAt + rangeOffset + 2*(c-start)
+				if at+2 > len(bmp) {
+					return nil, errBadFont
+				}
+				if g := int(u16(bmp, at)); g != 0 {
+					add(rune(c), (g+delta)&0xFFFF)
+				}
+			}
+		}
+	default:
+		return nil, errors.New("pdf: font has no Unicode character map")
+	}
+	return m, nil
+}
+
+// glyph returns the outline data of glyph g.
+func (f *font) glyph(g int) []byte {
+	return f.tables["glyf"][f.loca[g]:f.loca[g+1]]
+}
+
+// width returns the advance width of glyph g in thousandths of the font size.
+func (f *font) width(g int) int {
+	return (f.advances[g]*1000 + f.unitsPerEm/2) / f.unitsPerEm
+}
+
+// scale converts a value in font units to thousandths of the font size.
+func (f *font) scale(v int) int {
+	return v * 1000 / f.unitsPerEm
+}
+
+// subset returns a TrueType font which only contains the outlines of the given glyphs, plus the
+// glyphs they are composed of and the .notdef glyph. Glyph IDs are kept, so the text can refer
+// to glyphs by their ID in the original font.
+func (f *font) subset(glyphs map[uint16]rune) []byte {
+	keep := make(map[int]bool)
+	queue := []int{0}
+	for g := range glyphs {
+		queue = append(queue, int(g))
+	}
+	for len(queue) > 0 {
+		g := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if keep[g] {
+			continue
+		}
+		keep[g] = true
+		queue = append(queue, f.components(g)...)
+	}
+
+	numGlyphs := len(f.advances)
+	var glyf bytes.Buffer
+	loca := make([]byte, 4*(numGlyphs+1))
+	for g := 0; g < numGlyphs; g++ {
+		binary.BigEndian.PutUint32(loca[4*g:], uint32(glyf.Len()))
+		if keep[g] {
+			glyf.Write(f.glyph(g))
+			glyf.Write(make([]byte, (4-glyf.Len()%4)%4))
+		}
+	}
+	binary.BigEndian.PutUint32(loca[4*numGlyphs:], uint32(glyf.Len()))
+
+	// The subset always uses long offsets, and the checksum adjustment is recomputed below.
+	head := append([]byte(nil), f.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)
+	binary.BigEndian.PutUint16(head[50:], 1)
+
+	tables := map[string][]byte{
+		"glyf": glyf.Bytes(),
+		"head": head,
+		"hhea": f.tables["hhea"],
+		"hmtx": f.tables["hmtx"],
+		"loca": loca,
+		"maxp": f.tables["maxp"],
+	}
+	// The hinting programs are small and some viewers render badly without them.
+	for _, tag := range []string{"cvt ", "fpgm", "prep"} {
+		if t := f.tables[tag]; t != nil {
+			tables[tag] = t
+		}
+	}
+	return writeFont(tables)
+}
+
+// components returns the glyphs a composite glyph is built from.
+func (f *font) components(g int) []int {
+	data := f.glyph(g)
+	if len(data) < 10 || i16(data, 0) >= 0 {
+		return nil
+	}
+
+	const (
+		argsAreWords   = 0x0001
+		haveScale      = 0x0008
+		moreComponents = 0x0020
+		haveXYScale    = 0x0040
+		haveTwoByTwo   = 0x0080
+	)
+
+	var result []int
+	for at := 10; at+4 <= len(data); {
+		flags := u16(data, at)
+		if c := int(u16(data, at+2)); c < len(f.advances) {
+			result = append(result, c)
+		}
+		at += 4
+		if flags&argsAreWords != 0 {
+			at += 4
+		} else {
+			at += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			at += 2
+		case flags&haveXYScale != 0:
+			at += 4
+		case flags&haveTwoByTwo != 0:
+			at += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return result
+}
+
+// writeFont assembles a TrueType file from its tables.
+func writeFont(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []uint32{0x00010000})
+	binary.Write(&b, binary.BigEndian, []uint16{uint16(n), uint16(searchRange), uint16(entrySelector), uint16(16*n - searchRange)})
+
+	off := 12 + 16*n
+	headAt := 0
+	for _, tag := range tags {
+		t := tables[tag]
+		if tag == "head" {
+			headAt = off
+		}
+		b.WriteString(tag)
+		binary.Write(&b, binary.BigEndian, []uint32{checksum(t), uint32(off), uint32(len(t))})
+		off += (len(t) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		t := tables[tag]
+		b.Write(t)
+		b.Write(make([]byte, (4-len(t)%4)%4))
+	}
+
+	out := b.Bytes()
+	binary.BigEndian.PutUint32(out[headAt+8:], 0xB1B0AFBA-checksum(out))
+	return out
+}
+
+func checksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
+
+func u16(b []byte, off int) uint16 { return binary.BigEndian.Uint16(b[off:]) }
+func u32(b []byte, off int) uint32 { return binary.BigEndian.Uint32(b[off:]) }
+func i16(b []byte, off int) int16  { return int16(binary.BigEndian.Uint16(b[off:])) }
@@ -0,0 +1,281 @@
+// Package pdf writes simple single-column PDF documents: text in the embedded DejaVu Sans fonts,
+// lines and filled rectangles on A4 pages. It is meant for printouts such as prescriptions and
+// receipts, not for general typesetting.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// A4 page size in PDF points (1/72 inch).
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Document is a PDF document under construction.
+type Document struct {
+	title string
+	pages []*Page
+	fonts [2]fontUsage
+	err   error
+}
+
+// fontUsage records the glyphs a document uses from one of the fonts, together with the
+// character each of them stands for.
+type fontUsage struct {
+	font   *font
+	glyphs map[uint16]rune
+}
+
+// Page is a single page. Coordinates have their origin in the bottom left corner.
+type Page struct {
+	doc     *Document
+	content bytes.Buffer
+}
+
+// New returns an empty document with the given title in its metadata.
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+// AddPage appends a new A4 page to the document.
+func (d *Document) AddPage() *Page {
+	p := &Page{doc: d}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws a single line of text with its baseline starting at (x, y). Characters which the
+// font doesn't have a glyph for make WriteTo fail rather than printing something else.
+func (p *Page) Text(x, y, size float64, bold bool, s string) {
+	i := 0
+	if bold {
+		i = 1
+	}
+	u := &p.doc.fonts[i]
+	if u.font == nil {
+		u.font = regularFont()
+		if bold {
+			u.font = boldFont()
+		}
+		u.glyphs = make(map[uint16]rune)
+	}
+
+	var glyphs strings.Builder
+	for _, r := range s {
+		g, ok := u.font.cmap[r]
+		if !ok {
+			if p.doc.err == nil {
+				p.doc.err = fmt.Errorf("pdf: %s has no glyph for %q (%U)", u.font.name, r, r)
+			}
+			continue
+		}
+		if _, ok := u.glyphs[g]; !ok {
+			u.glyphs[g] = r
+		}
+		fmt.Fprintf(&glyphs, "%04X", g)
+	}
+	fmt.Fprintf(&p.content, "BT /F%d %.2f Tf %.2f %.2f Td <%s> Tj ET\n", i+1, size, x, y, glyphs.String())
+}
+
+// Line draws a straight line of the given width.
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// Rect draws a black filled rectangle with its bottom left corner at (x, y).
+func (p *Page) Rect(x, y, w, h float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f %.2f re f\n", x, y, w, h)
+}
+
+// WriteTo serializes the document. Nothing is written if some text can't be printed.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	var offsets []int64
+	obj := func(body string) {
+		offsets = append(offsets, cw.n)
+		fmt.Fprintf(cw, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	fmt.Fprint(cw, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 and 2 are the catalog and the page tree. Each page then takes two objects, the
+	// page itself followed by its content stream, and each font used takes five.
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 3+2*i)
+	}
+
+	next := 3 + 2*len(d.pages)
+	var resources []string
+	for i, u := range d.fonts {
+		if u.font != nil {
+			resources = append(resources, fmt.Sprintf("/F%d %d 0 R", i+1, next))
+			next += 5
+		}
+	}
+
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, strings.Join(resources, " "), 4+2*i))
+		obj(stream("", p.content.Bytes()))
+	}
+
+	for _, u := range d.fonts {
+		if u.font != nil {
+			u.writeObjects(obj, len(offsets)+1)
+		}
+	}
+
+	obj(fmt.Sprintf("<< /Title %s /Producer (GoClinic) >>", textString(d.title)))
+	info := len(offsets)
+
+	xref := cw.n
+	fmt.Fprintf(cw, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(cw, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(cw, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, info, xref)
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.(*bufio.Writer).Flush()
+}
+
+// writeObjects writes the five objects describing a font, numbered from first on: the Type 0
+// font used by the pages, its CID font, the font descriptor, the embedded subset and the map
+// back to Unicode used for copying and searching text. Text refers to glyphs by their ID in
+// the font, hence the Identity-H encoding and CID to glyph mapping.
+func (u fontUsage) writeObjects(obj func(string), first int) {
+	f := u.font
+	name := subsetTag(u.glyphs) + "+" + f.name
+
+	ids := make([]int, 0, len(u.glyphs))
+	for g := range u.glyphs {
+		ids = append(ids, int(g))
+	}
+	sort.Ints(ids)
+
+	var widths strings.Builder
+	for _, g := range ids {
+		fmt.Fprintf(&widths, "%d [%d] ", g, f.width(g))
+	}
+
+	stemV := 80
+	if strings.HasSuffix(f.name, "-Bold") {
+		stemV = 140
+	}
+
+	obj(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H "+
+		"/DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", name, first+1, first+4))
+	obj(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s "+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+		"/FontDescriptor %d 0 R /W [%s] /CIDToGIDMap /Identity >>", name, first+2, strings.TrimSpace(widths.String())))
+	obj(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] "+
+		"/ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV %d /FontFile2 %d 0 R >>",
+		name, f.scale(f.bbox[0]), f.scale(f.bbox[1]), f.scale(f.bbox[2]), f.scale(f.bbox[3]),
+		f.scale(f.ascent), f.scale(f.descent), f.scale(f.capHeight), stemV, first+3))
+
+	ttf := f.subset(u.glyphs)
+	var packed bytes.Buffer
+	zw := zlib.NewWriter(&packed)
+	zw.Write(ttf)
+	zw.Close()
+	obj(stream(fmt.Sprintf("/Length1 %d /Filter /FlateDecode ", len(ttf)), packed.Bytes()))
+
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	// A bfchar section may hold at most 100 entries.
+	for len(ids) > 0 {
+		n := min(len(ids), 100)
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", n)
+		for _, g := range ids[:n] {
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", g, utf16Hex(string(u.glyphs[uint16(g)])))
+		}
+		cmap.WriteString("endbfchar\n")
+		ids = ids[n:]
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	obj(stream("", []byte(cmap.String())))
+}
+
+// stream returns a stream object with the given extra dictionary entries.
+func stream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s/Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// subsetTag derives the six letter prefix which marks an embedded font as a subset from the
+// glyphs it contains, so that different subsets of the same font get different names.
+func subsetTag(glyphs map[uint16]rune) string {
+	ids := make([]int, 0, len(glyphs))
+	for g := range glyphs {
+		ids = append(ids, int(g))
+	}
+	sort.Ints(ids)
+
+	h := fnv.New32a()
+	for _, g := range ids {
+		h.Write([]byte{byte(g >> 8), byte(g)})
+	}
+	sum := h.Sum32()
+
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + byte(sum%26)
+		sum /= 26
+	}
+	return string(tag)
+}
+
+// textString encodes s as a PDF text string in UTF-16 with a byte order mark.
+func textString(s string) string {
+	return "<FEFF" + utf16Hex(s) + ">"
+}
+
+func utf16Hex(s string) string {
+	var b strings.Builder
+	for _, c := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", c)
+	}
+	return b.String()
+}
+
+// countingWriter keeps track of the number of bytes written so far, which is needed for the
+// cross-reference table, and remembers the first write error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
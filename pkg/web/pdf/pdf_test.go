@@ -0,0 +1,241 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestWriteTo(t *testing.T) {
+	doc := New("Рецепт № 7")
+	page := doc.AddPage()
+	page.Text(56, 770, 20, true, "Рецепт")
+	page.Text(56, 740, 11, false, "Әлия Қасымова, (Ұлан) Іңкәр Өтегенқызы")
+	page.Line(56, 730, 539, 730, 0.5)
+	doc.AddPage().Text(56, 770, 11, false, "Ғүлнар Һ. Smith-Müller")
+
+	var out bytes.Buffer
+	n, err := doc.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("WriteTo() = %d, wrote %d bytes", n, out.Len())
+	}
+
+	f := readPDF(t, out.Bytes())
+
+	want := []string{"Рецепт", "Әлия Қасымова, (Ұлан) Іңкәр Өтегенқызы", "Ғүлнар Һ. Smith-Müller"}
+	if got := f.text(t); !slices.Equal(got, want) {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+
+	if info := f.object(t, len(f.offsets)); !strings.Contains(info, "/Title "+textString("Рецепт № 7")) {
+		t.Errorf("info = %s, want the title as UTF-16", info)
+	}
+}
+
+func TestWriteToUnsupportedCharacter(t *testing.T) {
+	doc := New("Prescription")
+	page := doc.AddPage()
+	page.Text(56, 770, 11, false, "Patient")
+	page.Text(56, 750, 11, false, "王芳")
+
+	var out bytes.Buffer
+	_, err := doc.WriteTo(&out)
+	if err == nil || !strings.Contains(err.Error(), "U+738B") {
+		t.Errorf("WriteTo() error = %v, want an error about U+738B", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("WriteTo() wrote %d bytes despite the error", out.Len())
+	}
+}
+
+func TestSubset(t *testing.T) {
+	f := regularFont()
+
+	// Ё and й are composite glyphs in DejaVu Sans, made of a base letter and an accent.
+	used := make(map[uint16]rune)
+	for _, r := range "Әйа Ё" {
+		used[f.cmap[r]] = r
+	}
+
+	data := f.subset(used)
+	if sum := checksum(data); sum != 0xB1B0AFBA {
+		t.Errorf("file checksum = %#x, want 0xB1B0AFBA", sum)
+	}
+
+	sub, err := parseFont(f.name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.advances) != len(f.advances) {
+		t.Fatalf("subset has %d glyphs, want %d", len(sub.advances), len(f.advances))
+	}
+
+	var composites int
+	for g, r := range used {
+		if !bytes.Equal(sub.glyph(int(g)), f.glyph(int(g))) {
+			t.Errorf("glyph for %q differs from the original", r)
+		}
+		for _, c := range f.components(int(g)) {
+			composites++
+			if !bytes.Equal(sub.glyph(c), f.glyph(c)) {
+				t.Errorf("component %d of %q differs from the original", c, r)
+			}
+		}
+	}
+	if composites == 0 {
+		t.Error("no composite glyphs were tested")
+	}
+
+	if len(sub.glyph(0)) == 0 {
+		t.Error(".notdef glyph was dropped")
+	}
+	if g := f.cmap['Z']; len(sub.glyph(int(g))) != 0 {
+		t.Error("unused glyph for 'Z' was kept")
+	}
+}
+
+// pdfFile gives access to the objects of a written document through its cross-reference table.
+type pdfFile struct {
+	data    []byte
+	offsets []int
+}
+
+func readPDF(t *testing.T, data []byte) *pdfFile {
+	t.Helper()
+
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n0 ")) {
+		t.Fatalf("startxref %d doesn't point to the cross-reference table", xref)
+	}
+
+	f := &pdfFile{data: data}
+	lines := strings.Split(string(data[xref:]), "\n")
+	size, _ := strconv.Atoi(strings.Fields(lines[1])[1])
+	for i := 1; i < size; i++ {
+		off, _ := strconv.Atoi(lines[2+i][:10])
+		prefix := strconv.Itoa(i) + " 0 obj\n"
+		if !bytes.HasPrefix(data[off:], []byte(prefix)) {
+			t.Fatalf("xref entry %d points to %q", i, data[off:min(off+20, len(data))])
+		}
+		f.offsets = append(f.offsets, off)
+	}
+	return f
+}
+
+// object returns the body of object n.
+func (f *pdfFile) object(t *testing.T, n int) string {
+	t.Helper()
+	body := f.data[f.offsets[n-1]:]
+	body = body[bytes.IndexByte(body, '\n')+1:]
+	end := bytes.Index(body, []byte("\nendobj\n"))
+	if end < 0 {
+		t.Fatalf("object %d isn't terminated", n)
+	}
+	return string(body[:end])
+}
+
+// stream returns the decoded data of stream object n and checks its length.
+func (f *pdfFile) stream(t *testing.T, n int) []byte {
+	t.Helper()
+	obj := f.object(t, n)
+	m := regexp.MustCompile(`(?s)/Length (\d+) >>\nstream\n(.*)\nendstream$`).FindStringSubmatch(obj)
+	if m == nil {
+		t.Fatalf("object %d isn't a stream", n)
+	}
+	if length, _ := strconv.Atoi(m[1]); length != len(m[2]) {
+		t.Fatalf("object %d has /Length %d, but %d bytes of data", n, length, len(m[2]))
+	}
+	data := []byte(m[2])
+	if strings.Contains(obj, "/FlateDecode") {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return data
+}
+
+// ref returns the object number following key in obj.
+func ref(t *testing.T, obj, key string) int {
+	t.Helper()
+	m := regexp.MustCompile(regexp.QuoteMeta(key) + ` \[?(\d+) 0 R`).FindStringSubmatch(obj)
+	if m == nil {
+		t.Fatalf("no %s in %s", key, obj)
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// text extracts the lines of text on all pages, mapping the glyphs back to characters through
+// the ToUnicode map of their font, the way a viewer does when copying text.
+func (f *pdfFile) text(t *testing.T) []string {
+	t.Helper()
+
+	var lines []string
+	pages := f.object(t, 2)
+	for _, kid := range regexp.MustCompile(`(\d+) 0 R`).FindAllStringSubmatch(pages, -1) {
+		n, _ := strconv.Atoi(kid[1])
+		page := f.object(t, n)
+
+		content := f.stream(t, ref(t, page, "/Contents"))
+		for _, m := range regexp.MustCompile(`/(F\d) [\d.]+ Tf [\d.]+ [\d.]+ Td <([0-9A-F]*)> Tj`).FindAllSubmatch(content, -1) {
+			font := f.object(t, ref(t, page, "/"+string(m[1])))
+			if !strings.Contains(font, "/Encoding /Identity-H") {
+				t.Errorf("font %s = %s, want Identity-H encoding", m[1], font)
+			}
+
+			cid := f.object(t, ref(t, font, "/DescendantFonts"))
+			descriptor := f.object(t, ref(t, cid, "/FontDescriptor"))
+			ttf := f.stream(t, ref(t, descriptor, "/FontFile2"))
+			if _, err := parseFont("embedded", ttf); err != nil {
+				t.Errorf("embedded font: %v", err)
+			}
+
+			toUnicode := make(map[string][]uint16)
+			cmap := f.stream(t, ref(t, font, "/ToUnicode"))
+			for _, e := range regexp.MustCompile(`<([0-9A-F]{4})> <([0-9A-F]+)>`).FindAllSubmatch(cmap, -1) {
+				b, _ := hex.DecodeString(string(e[2]))
+				for i := 0; i+1 < len(b); i += 2 {
+					toUnicode[string(e[1])] = append(toUnicode[string(e[1])], uint16(b[i])<<8|uint16(b[i+1]))
+				}
+			}
+
+			var units []uint16
+			for i := 0; i+4 <= len(m[2]); i += 4 {
+				gid := string(m[2][i : i+4])
+				if !regexp.MustCompile(`[\[ ]` + strconv.Itoa(hexInt(gid)) + ` \[\d+\]`).MatchString(cid) {
+					t.Errorf("no width for glyph %s", gid)
+				}
+				units = append(units, toUnicode[gid]...)
+			}
+			lines = append(lines, string(utf16.Decode(units)))
+		}
+	}
+	return lines
+}
+
+func hexInt(s string) int {
+	n, _ := strconv.ParseInt(s, 16, 32)
+	return int(n)
+}
@@ -0,0 +1,316 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		version int
+	}{
+		{"empty", "", 1},
+		{"version 1 capacity", strings.Repeat("a", 14), 1},
+		{"one byte over version 1", strings.Repeat("a", 15), 2},
+		{"verification URL", "https://clinic.example.com/api/v1/prescriptions/verify/MZXW6YTBOI3DCNZQ?tenant=almaty-central", 6},
+		{"version 7 with version information", strings.Repeat("x", 120), 7},
+		{"version 9 capacity", strings.Repeat("b", 180), 9},
+		{"version 10 with 16 bit length", strings.Repeat("c", 181), 10},
+		{"largest supported", strings.Repeat("z", 213), 10},
+		{"binary", "\x00\xff\x80Әлия", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Encode([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Size != 17+4*tt.version {
+				t.Fatalf("Size = %d, want %d for version %d", c.Size, 17+4*tt.version, tt.version)
+			}
+			if got := decode(t, c); got != tt.data {
+				t.Errorf("decoded %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(bytes.Repeat([]byte("z"), 214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode() error = %v, want ErrTooLong", err)
+	}
+}
+
+// decode reads a symbol back the way a scanner would, using only the layout rules of the
+// specification: it checks the finder and timing patterns, reads and error checks the format
+// and version information, removes the mask, reads the codewords in zigzag order, checks the
+// Reed-Solomon codes of every block and parses the byte mode segment.
+func decode(t *testing.T, c *Code) string {
+	t.Helper()
+	n := c.Size
+	at := func(x, y int) bool { return c.Modules[y][x] }
+
+	for _, corner := range [][2]int{{0, 0}, {n - 7, 0}, {0, n - 7}} {
+		for dy := 0; dy < 7; dy++ {
+			for dx := 0; dx < 7; dx++ {
+				ring := max(abs(dx-3), abs(dy-3))
+				if at(corner[0]+dx, corner[1]+dy) != (ring != 2) {
+					t.Fatalf("finder pattern at %v is damaged", corner)
+				}
+			}
+		}
+	}
+	for i := 8; i < n-8; i++ {
+		if at(i, 6) != (i%2 == 0) || at(6, i) != (i%2 == 0) {
+			t.Fatalf("timing pattern is damaged at %d", i)
+		}
+	}
+	if !at(8, n-8) {
+		t.Fatal("dark module is missing")
+	}
+
+	// Both copies of the format information must be valid BCH codewords and agree.
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= bit(at(8, i)) << i
+	}
+	first |= bit(at(8, 7))<<6 | bit(at(8, 8))<<7 | bit(at(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		first |= bit(at(14-i, 8)) << i
+	}
+	for i := 0; i < 8; i++ {
+		second |= bit(at(n-1-i, 8)) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(at(8, n-15+i)) << i
+	}
+	if first != second {
+		t.Fatalf("format information copies differ: %015b and %015b", first, second)
+	}
+	format := first ^ 0x5412
+	if bchRemainder(format, 0x537, 10) != 0 {
+		t.Fatalf("format information %015b fails its BCH check", format)
+	}
+	if level := format >> 13; level != 0 {
+		t.Fatalf("error correction level bits = %02b, want 00 for M", level)
+	}
+	mask := format >> 10 & 7
+
+	version := (n - 17) / 4
+	if version >= 7 {
+		var a, b int
+		for i := 0; i < 18; i++ {
+			a |= bit(at(n-11+i%3, i/3)) << i
+			b |= bit(at(i/3, n-11+i%3)) << i
+		}
+		if a != b || a>>12 != version || bchRemainder(a, 0x1F25, 12) != 0 {
+			t.Fatalf("version information %018b and %018b are invalid for version %d", a, b, version)
+		}
+	}
+
+	function := functionModules(version)
+
+	// Read the codewords in the zigzag order, removing the mask on the way.
+	var codewords []byte
+	var cur byte
+	var bits int
+	for right := n - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < n; vert++ {
+			y := vert
+			if upward {
+				y = n - 1 - vert
+			}
+			for x := right; x >= right-1; x-- {
+				if function[y][x] {
+					continue
+				}
+				cur = cur<<1 | byte(bit(at(x, y) != masked(mask, x, y)))
+				if bits++; bits == 8 {
+					codewords = append(codewords, cur)
+					cur, bits = 0, 0
+				}
+			}
+		}
+	}
+
+	total := numRawDataModules(version) / 8
+	if len(codewords) != total {
+		t.Fatalf("read %d codewords, want %d", len(codewords), total)
+	}
+
+	// Undo the interleaving. The first blocks are one data codeword shorter than the rest.
+	numBlocks, eccLen := numErrorCorrBlocks[version], eccCodewordsPerBlock[version]
+	shortLen := total / numBlocks
+	numShort := numBlocks - total%numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < shortLen-eccLen+1; i++ {
+		for j := range blocks {
+			if i < shortLen-eccLen || j >= numShort {
+				blocks[j] = append(blocks[j], codewords[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for j := range blocks {
+		data = append(data, blocks[j]...)
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+
+	// A block without errors evaluates to zero at the first eccLen powers of the generator.
+	for j, block := range blocks {
+		x := byte(1)
+		for i := 0; i < eccLen; i++ {
+			var sum byte
+			for _, b := range block {
+				sum = gfMultiply(sum, x) ^ b
+			}
+			if sum != 0 {
+				t.Fatalf("block %d has syndrome %d = %#x", j, i, sum)
+			}
+			x = gfMultiply(x, 2)
+		}
+	}
+
+	// Parse the byte mode segment and check the padding after it.
+	r := bitReader{data: data}
+	if mode := r.read(4); mode != 0x4 {
+		t.Fatalf("mode = %#x, want byte mode", mode)
+	}
+	length := r.read(charCountBits(version))
+	var payload []byte
+	for i := 0; i < length; i++ {
+		payload = append(payload, byte(r.read(8)))
+	}
+	if rest := len(data)*8 - r.pos; rest > 0 {
+		if term := r.read(min(4, rest)); term != 0 {
+			t.Fatalf("terminator = %#x, want 0", term)
+		}
+		r.read((8 - r.pos%8) % 8)
+		for pad := 0xEC; r.pos < len(data)*8; pad ^= 0xEC ^ 0x11 {
+			if got := r.read(8); got != pad {
+				t.Fatalf("pad byte = %#x, want %#x", got, pad)
+			}
+		}
+	}
+	return string(payload)
+}
+
+// functionModules marks the modules which don't carry data: finder patterns with their
+// separators, timing patterns, alignment patterns, format and version information.
+func functionModules(version int) [][]bool {
+	n := 17 + 4*version
+	f := make([][]bool, n)
+	for i := range f {
+		f[i] = make([]bool, n)
+	}
+	fill := func(x0, y0, w, h int) {
+		for y := max(y0, 0); y < min(y0+h, n); y++ {
+			for x := max(x0, 0); x < min(x0+w, n); x++ {
+				f[y][x] = true
+			}
+		}
+	}
+
+	// Finder patterns, separators and the format information next to them.
+	fill(0, 0, 9, 9)
+	fill(n-8, 0, 8, 9)
+	fill(0, n-8, 9, 8)
+	fill(6, 0, 1, n)
+	fill(0, 6, n, 1)
+
+	// Alignment pattern centers, as listed in annex E of the specification.
+	centers := [][]int{nil, nil,
+		{6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+		{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+	}[version]
+	for _, cy := range centers {
+		for _, cx := range centers {
+			if cx == 6 && cy == 6 || cx == 6 && cy == n-7 || cx == n-7 && cy == 6 {
+				continue // taken by a finder pattern
+			}
+			fill(cx-2, cy-2, 5, 5)
+		}
+	}
+
+	if version >= 7 {
+		fill(n-11, 0, 3, 6)
+		fill(0, n-11, 6, 3)
+	}
+	return f
+}
+
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return y*x%2+y*x%3 == 0
+	case 6:
+		return (y*x%2+y*x%3)%2 == 0
+	default:
+		return ((y+x)%2+y*x%3)%2 == 0
+	}
+}
+
+// bchRemainder divides a codeword by the generator polynomial of the given degree.
+func bchRemainder(codeword, generator, degree int) int {
+	for i := bitLen(codeword) - 1; i >= degree; i-- {
+		if codeword>>i&1 != 0 {
+			codeword ^= generator << (i - degree)
+		}
+	}
+	return codeword
+}
+
+func bitLen(x int) int {
+	n := 0
+	for ; x > 0; x >>= 1 {
+		n++
+	}
+	return n
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | int(r.data[r.pos>>3]>>(7-uint(r.pos&7))&1)
+		r.pos++
+	}
+	return v
+}
@@ -0,0 +1,271 @@
+// Package rrule parses and expands the recurrence rules of RFC 5545 section 3.3.10, as far as
+// they are needed for appointment series and holiday calendars: DAILY, WEEKLY, MONTHLY and
+// YEARLY rules with INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and BYMONTH.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequencies.
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+// Rule is a parsed recurrence rule.
+type Rule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	ByMonth    []time.Month
+	WeekStart  time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Parse parses a rule such as "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=12". An optional "RRULE:" prefix is
+// ignored. UNTIL values without a time zone are taken to be in loc.
+func Parse(s string, loc *time.Location) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, errors.New("empty rule")
+	}
+
+	r := &Rule{Interval: 1, WeekStart: time.Monday}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			if value != Daily && value != Weekly && value != Monthly && value != Yearly {
+				return nil, fmt.Errorf("unsupported FREQ %s, must be DAILY, WEEKLY, MONTHLY or YEARLY", value)
+			}
+			r.Freq = value
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err != nil || r.Interval < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %s", value)
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err != nil || r.Count < 1 {
+				return nil, fmt.Errorf("invalid COUNT %s", value)
+			}
+		case "UNTIL":
+			r.Until, err = parseUntil(value, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UNTIL %s", value)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, ok := weekdays[day]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY value %s", day)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY value %s", day)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(month)
+				if err != nil || n < 1 || n > 12 {
+					return nil, fmt.Errorf("invalid BYMONTH value %s", month)
+				}
+				r.ByMonth = append(r.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			wd, ok := weekdays[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid WKST %s", value)
+			}
+			r.WeekStart = wd
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", name)
+		}
+	}
+
+	switch {
+	case r.Freq == "":
+		return nil, errors.New("FREQ is required")
+	case r.Count != 0 && !r.Until.IsZero():
+		return nil, errors.New("COUNT and UNTIL must not both be given")
+	case (r.Freq == Monthly || r.Freq == Yearly) && len(r.ByDay) > 0:
+		return nil, fmt.Errorf("BYDAY is not supported with FREQ=%s", r.Freq)
+	case r.Freq != Monthly && r.Freq != Yearly && len(r.ByMonthDay) > 0:
+		return nil, errors.New("BYMONTHDAY is only supported with FREQ=MONTHLY or YEARLY")
+	case r.Freq != Yearly && len(r.ByMonth) > 0:
+		return nil, errors.New("BYMONTH is only supported with FREQ=YEARLY")
+	}
+
+	return r, nil
+}
+
+// Bounded reports whether the rule ends, with COUNT or UNTIL.
+func (r *Rule) Bounded() bool {
+	return r.Count != 0 || !r.Until.IsZero()
+}
+
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("20060102", value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// A date includes the whole day.
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+// All returns the start times of the occurrences of the rule from start onwards, at most max of
+// them. Occurrences keep the wall clock time of start in its location, also across daylight
+// saving time changes. As in RFC 5545, start itself is only an occurrence if it matches the
+// rule.
+func (r *Rule) All(start time.Time, max int) []time.Time {
+	var times []time.Time
+	add := func(t time.Time) bool {
+		if t.Before(start) {
+			return true
+		}
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		times = append(times, t)
+		return len(times) < max && (r.Count == 0 || len(times) < r.Count)
+	}
+
+	// at returns the time of day of start on the given date.
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+
+	// Periods which can't contain an occurrence any more end the expansion, so that a rule like
+	// BYMONTHDAY=31 with COUNT can't loop forever.
+	const maxEmptyPeriods = 1000
+	empty := 0
+
+	for period := 0; empty < maxEmptyPeriods; period++ {
+		before := len(times)
+		var candidates []time.Time
+
+		switch r.Freq {
+		case Daily:
+			day := at(start.Year(), start.Month(), start.Day()+period*r.Interval)
+			if len(r.ByDay) == 0 || containsWeekday(r.ByDay, day.Weekday()) {
+				candidates = append(candidates, day)
+			}
+		case Weekly:
+			// The week containing start begins on WeekStart.
+			offset := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+			weekStart := at(start.Year(), start.Month(), start.Day()-offset+7*period*r.Interval)
+			days := r.ByDay
+			if len(days) == 0 {
+				days = []time.Weekday{start.Weekday()}
+			}
+			for _, wd := range days {
+				d := (int(wd) - int(r.WeekStart) + 7) % 7
+				candidates = append(candidates, at(weekStart.Year(), weekStart.Month(), weekStart.Day()+d))
+			}
+		case Monthly:
+			candidates = r.monthDays(start.Year(), start.Month()+time.Month(period*r.Interval), start, at)
+		case Yearly:
+			months := r.ByMonth
+			if len(months) == 0 {
+				months = []time.Month{start.Month()}
+			}
+			for _, month := range months {
+				candidates = append(candidates, r.monthDays(start.Year()+period*r.Interval, month, start, at)...)
+			}
+		}
+
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+		for i, t := range candidates {
+			if i > 0 && t.Equal(candidates[i-1]) {
+				continue
+			}
+			if !add(t) {
+				return times
+			}
+		}
+
+		if len(times) == before {
+			empty++
+		} else {
+			empty = 0
+		}
+	}
+
+	return times
+}
+
+// monthDays returns the BYMONTHDAY days, or the day of start, of a month. Days which don't
+// exist in the month are skipped.
+func (r *Rule) monthDays(year int, month time.Month, start time.Time,
+	at func(int, time.Month, int) time.Time) []time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, start.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	days := r.ByMonthDay
+	if len(days) == 0 {
+		days = []int{start.Day()}
+	}
+
+	var times []time.Time
+	for _, d := range days {
+		if d < 0 {
+			d = lastDay + 1 + d
+		}
+		if d >= 1 && d <= lastDay {
+			times = append(times, at(first.Year(), first.Month(), d))
+		}
+	}
+	return times
+}
+
+func containsWeekday(days []time.Weekday, wd time.Weekday) bool {
+	for _, d := range days {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+
+	tests := []struct {
+		rule    string
+		wantErr bool
+	}{
+		{"FREQ=WEEKLY;BYDAY=MO,TH;COUNT=12", false},
+		{"RRULE:freq=daily;interval=2", false},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,-1;UNTIL=20241231", false},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29", false},
+		{"", true},
+		{"COUNT=3", true},
+		{"FREQ=HOURLY", true},
+		{"FREQ=DAILY;INTERVAL=0", true},
+		{"FREQ=DAILY;COUNT=-1", true},
+		{"FREQ=DAILY;COUNT=3;UNTIL=20240101", true},
+		{"FREQ=DAILY;COUNT=3;COUNT=4", true},
+		{"FREQ=WEEKLY;BYDAY=1MO", true},
+		{"FREQ=MONTHLY;BYDAY=MO", true},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", true},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", true},
+		{"FREQ=MONTHLY;BYMONTH=1", true},
+		{"FREQ=DAILY;UNTIL=2024-01-01", true},
+		{"FREQ=DAILY;BYSETPOS=1", true},
+		{"FREQ=DAILY;COUNT", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := Parse(tt.rule, berlin)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+
+	tests := []struct {
+		until string
+		want  time.Time
+	}{
+		// A date includes the whole day in the rule's time zone.
+		{"20240703", time.Date(2024, 7, 3, 23, 59, 59, 0, berlin)},
+		{"20240703T090000", time.Date(2024, 7, 3, 9, 0, 0, 0, berlin)},
+		{"20240703T070000Z", time.Date(2024, 7, 3, 7, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		r, err := Parse("FREQ=DAILY;UNTIL="+tt.until, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Until.Equal(tt.want) {
+			t.Errorf("UNTIL=%s gives %v, want %v", tt.until, r.Until, tt.want)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	newYork := mustLoad(t, "America/New_York")
+
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		max   int
+		want  []string
+	}{
+		{
+			name:  "weekly by day with count",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=5",
+			start: time.Date(2024, 7, 1, 9, 0, 0, 0, berlin),
+			want: []string{
+				"2024-07-01 09:00 +0200", "2024-07-04 09:00 +0200", "2024-07-08 09:00 +0200",
+				"2024-07-11 09:00 +0200", "2024-07-15 09:00 +0200",
+			},
+		},
+		{
+			name:  "start is not an occurrence and isn't counted",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,FR;COUNT=3",
+			start: time.Date(2024, 7, 3, 9, 0, 0, 0, berlin),
+			want:  []string{"2024-07-05 09:00 +0200", "2024-07-08 09:00 +0200", "2024-07-12 09:00 +0200"},
+		},
+		{
+			name:  "every other week",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=3",
+			start: time.Date(2024, 7, 1, 9, 0, 0, 0, berlin),
+			want:  []string{"2024-07-01 09:00 +0200", "2024-07-15 09:00 +0200", "2024-07-29 09:00 +0200"},
+		},
+		{
+			// RFC 5545 section 3.3.10: the week start changes which days belong to a week.
+			name:  "week starting on Monday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			start: time.Date(1997, 8, 5, 9, 0, 0, 0, newYork),
+			want: []string{
+				"1997-08-05 09:00 -0400", "1997-08-10 09:00 -0400", "1997-08-19 09:00 -0400", "1997-08-24 09:00 -0400",
+			},
+		},
+		{
+			name:  "week starting on Sunday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			start: time.Date(1997, 8, 5, 9, 0, 0, 0, newYork),
+			want: []string{
+				"1997-08-05 09:00 -0400", "1997-08-17 09:00 -0400", "1997-08-19 09:00 -0400", "1997-08-31 09:00 -0400",
+			},
+		},
+		{
+			name:  "daily on weekdays",
+			rule:  "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;COUNT=4",
+			start: time.Date(2024, 7, 4, 8, 30, 0, 0, berlin),
+			want:  []string{"2024-07-04 08:30 +0200", "2024-07-05 08:30 +0200", "2024-07-08 08:30 +0200", "2024-07-09 08:30 +0200"},
+		},
+		{
+			name:  "until a date includes that day",
+			rule:  "FREQ=DAILY;UNTIL=20240703",
+			start: time.Date(2024, 7, 1, 9, 0, 0, 0, berlin),
+			want:  []string{"2024-07-01 09:00 +0200", "2024-07-02 09:00 +0200", "2024-07-03 09:00 +0200"},
+		},
+		{
+			name:  "until an occurrence in UTC includes it",
+			rule:  "FREQ=DAILY;UNTIL=20240703T070000Z",
+			start: time.Date(2024, 7, 1, 9, 0, 0, 0, berlin),
+			want:  []string{"2024-07-01 09:00 +0200", "2024-07-02 09:00 +0200", "2024-07-03 09:00 +0200"},
+		},
+		{
+			name:  "until a second before an occurrence excludes it",
+			rule:  "FREQ=DAILY;UNTIL=20240703T065959Z",
+			start: time.Date(2024, 7, 1, 9, 0, 0, 0, berlin),
+			want:  []string{"2024-07-01 09:00 +0200", "2024-07-02 09:00 +0200"},
+		},
+		{
+			name:  "until before start",
+			rule:  "FREQ=DAILY;UNTIL=20240630",
+			start: time.Date(2024, 7, 1, 9, 0, 0, 0, berlin),
+			want:  nil,
+		},
+		{
+			name:  "unbounded rule stops at max",
+			rule:  "FREQ=DAILY;INTERVAL=3",
+			start: time.Date(2024, 7, 1, 9, 0, 0, 0, berlin),
+			max:   3,
+			want:  []string{"2024-07-01 09:00 +0200", "2024-07-04 09:00 +0200", "2024-07-07 09:00 +0200"},
+		},
+		{
+			name:  "max below count",
+			rule:  "FREQ=DAILY;COUNT=10",
+			start: time.Date(2024, 7, 1, 9, 0, 0, 0, berlin),
+			max:   2,
+			want:  []string{"2024-07-01 09:00 +0200", "2024-07-02 09:00 +0200"},
+		},
+		{
+			name:  "daily across the spring daylight saving change",
+			rule:  "FREQ=DAILY;COUNT=3",
+			start: time.Date(2024, 3, 30, 9, 0, 0, 0, berlin),
+			want:  []string{"2024-03-30 09:00 +0100", "2024-03-31 09:00 +0200", "2024-04-01 09:00 +0200"},
+		},
+		{
+			name:  "weekly across the autumn daylight saving change",
+			rule:  "FREQ=WEEKLY;BYDAY=SU;COUNT=3",
+			start: time.Date(2024, 10, 20, 10, 0, 0, 0, berlin),
+			want:  []string{"2024-10-20 10:00 +0200", "2024-10-27 10:00 +0100", "2024-11-03 10:00 +0100"},
+		},
+		{
+			name:  "weekly across the US daylight saving change",
+			rule:  "FREQ=WEEKLY;BYDAY=TU,TH;COUNT=4",
+			start: time.Date(2024, 3, 5, 17, 30, 0, 0, newYork),
+			want: []string{
+				"2024-03-05 17:30 -0500", "2024-03-07 17:30 -0500", "2024-03-12 17:30 -0400", "2024-03-14 17:30 -0400",
+			},
+		},
+		{
+			name:  "monthly skips months without the day",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=31;COUNT=4",
+			start: time.Date(2024, 1, 31, 9, 0, 0, 0, berlin),
+			want: []string{
+				"2024-01-31 09:00 +0100", "2024-03-31 09:00 +0200", "2024-05-31 09:00 +0200", "2024-07-31 09:00 +0200",
+			},
+		},
+		{
+			name:  "last day of the month",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3",
+			start: time.Date(2024, 1, 15, 9, 0, 0, 0, berlin),
+			want:  []string{"2024-01-31 09:00 +0100", "2024-02-29 09:00 +0100", "2024-03-31 09:00 +0200"},
+		},
+		{
+			name:  "yearly on the 29th of February",
+			rule:  "FREQ=YEARLY;COUNT=2",
+			start: time.Date(2024, 2, 29, 9, 0, 0, 0, berlin),
+			want:  []string{"2024-02-29 09:00 +0100", "2028-02-29 09:00 +0100"},
+		},
+		{
+			name:  "yearly in several months",
+			rule:  "FREQ=YEARLY;BYMONTH=1,7;BYMONTHDAY=1;COUNT=3",
+			start: time.Date(2024, 3, 1, 0, 0, 0, 0, berlin),
+			want:  []string{"2024-07-01 00:00 +0200", "2025-01-01 00:00 +0100", "2025-07-01 00:00 +0200"},
+		},
+		{
+			name:  "day which never exists ends the expansion",
+			rule:  "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30;COUNT=1",
+			start: time.Date(2024, 1, 1, 9, 0, 0, 0, berlin),
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse(tt.rule, tt.start.Location())
+			if err != nil {
+				t.Fatal(err)
+			}
+			max := tt.max
+			if max == 0 {
+				max = 200
+			}
+
+			var got []string
+			for _, occ := range r.All(tt.start, max) {
+				got = append(got, occ.Format("2006-01-02 15:04 -0700"))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("All() = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("occurrence %d = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}